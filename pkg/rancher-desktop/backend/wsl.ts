@@ -127,6 +127,10 @@ export default class WSLBackend extends events.EventEmitter implements VMBackend
 
           args.push('--port-forward', k8sPortForwarding);
         }
+        // The MTU must match the one network-setup gets in wsl-init.
+        if (process.env.RD_NETWORK_MTU) {
+          args.push('--mtu', process.env.RD_NETWORK_MTU);
+        }
 
         return childProcess.spawn(exe, args, {
           stdio:       ['ignore', stream, stream],
@@ -1084,7 +1088,7 @@ export default class WSLBackend extends events.EventEmitter implements VMBackend
     this.process?.kill('SIGTERM');
     const env: Record<string, string> = {
       ...process.env,
-      WSLENV:           `${ process.env.WSLENV }:DISTRO_DATA_DIRS:LOG_DIR/p:RD_DEBUG:RD_VMSWITCH_TRACE`,
+      WSLENV:           `${ process.env.WSLENV }:DISTRO_DATA_DIRS:LOG_DIR/p:RD_DEBUG:RD_VMSWITCH_TRACE:RD_VMSWITCH_CAPTURE:RD_NETWORK_MTU`,
       DISTRO_DATA_DIRS: DISTRO_DATA_DIRS.join(':'),
       LOG_DIR:          paths.logs,
     };
@@ -1095,10 +1099,20 @@ export default class WSLBackend extends events.EventEmitter implements VMBackend
     // Per-packet vm-switch tracing is opt-in and deliberately decoupled from
     // --debug (it is extremely verbose and can fill the disk). Enable it by
     // setting RD_VMSWITCH_TRACE in the environment; it can also be toggled at
-    // runtime by sending SIGUSR1 to the vm-switch process.
+    // runtime with `vm-switch ctl set trace-packets=true`.
     if (process.env.RD_VMSWITCH_TRACE) {
       env.RD_VMSWITCH_TRACE = '1';
     }
+    // Likewise, RD_VMSWITCH_CAPTURE starts the vm-switch packet capture, which
+    // can also be toggled at runtime with `vm-switch ctl set capture=true`.
+    if (process.env.RD_VMSWITCH_CAPTURE) {
+      env.RD_VMSWITCH_CAPTURE = '1';
+    }
+    // RD_NETWORK_MTU lowers the MTU of the virtual network, e.g. behind a VPN
+    // with a reduced path MTU; host-switch is started with the same value.
+    if (process.env.RD_NETWORK_MTU) {
+      env.RD_NETWORK_MTU = process.env.RD_NETWORK_MTU;
+    }
     this.process = childProcess.spawn('wsl.exe',
       ['--distribution', INSTANCE_NAME, '--exec', '/usr/local/bin/wsl-init'],
       {
@@ -0,0 +1,171 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/Masterminds/log-go"
+)
+
+// levelLogger is a log.StdLogger whose level can be changed while other
+// goroutines log, as it is when the configuration is reloaded; the Level
+// field of log.StdLogger cannot be written safely once it is in use.
+type levelLogger struct {
+	level atomic.Int64
+	std   log.StdLogger
+}
+
+var _ log.Logger = (*levelLogger)(nil)
+
+func newLevelLogger(level int) *levelLogger {
+	l := &levelLogger{std: log.StdLogger{Level: log.TraceLevel}}
+	l.SetLevel(level)
+	return l
+}
+
+// SetLevel sets the lowest level that is logged.
+func (l *levelLogger) SetLevel(level int) {
+	l.level.Store(int64(level))
+}
+
+func (l *levelLogger) enabled(level int) bool {
+	return l.level.Load() <= int64(level)
+}
+
+func (l *levelLogger) Trace(msg ...interface{}) {
+	if l.enabled(log.TraceLevel) {
+		l.std.Trace(msg...)
+	}
+}
+
+func (l *levelLogger) Tracef(template string, args ...interface{}) {
+	if l.enabled(log.TraceLevel) {
+		l.std.Tracef(template, args...)
+	}
+}
+
+func (l *levelLogger) Tracew(msg string, fields log.Fields) {
+	if l.enabled(log.TraceLevel) {
+		l.std.Tracew(msg, fields)
+	}
+}
+
+func (l *levelLogger) Debug(msg ...interface{}) {
+	if l.enabled(log.DebugLevel) {
+		l.std.Debug(msg...)
+	}
+}
+
+func (l *levelLogger) Debugf(template string, args ...interface{}) {
+	if l.enabled(log.DebugLevel) {
+		l.std.Debugf(template, args...)
+	}
+}
+
+func (l *levelLogger) Debugw(msg string, fields log.Fields) {
+	if l.enabled(log.DebugLevel) {
+		l.std.Debugw(msg, fields)
+	}
+}
+
+func (l *levelLogger) Info(msg ...interface{}) {
+	if l.enabled(log.InfoLevel) {
+		l.std.Info(msg...)
+	}
+}
+
+func (l *levelLogger) Infof(template string, args ...interface{}) {
+	if l.enabled(log.InfoLevel) {
+		l.std.Infof(template, args...)
+	}
+}
+
+func (l *levelLogger) Infow(msg string, fields log.Fields) {
+	if l.enabled(log.InfoLevel) {
+		l.std.Infow(msg, fields)
+	}
+}
+
+func (l *levelLogger) Warn(msg ...interface{}) {
+	if l.enabled(log.WarnLevel) {
+		l.std.Warn(msg...)
+	}
+}
+
+func (l *levelLogger) Warnf(template string, args ...interface{}) {
+	if l.enabled(log.WarnLevel) {
+		l.std.Warnf(template, args...)
+	}
+}
+
+func (l *levelLogger) Warnw(msg string, fields log.Fields) {
+	if l.enabled(log.WarnLevel) {
+		l.std.Warnw(msg, fields)
+	}
+}
+
+func (l *levelLogger) Error(msg ...interface{}) {
+	if l.enabled(log.ErrorLevel) {
+		l.std.Error(msg...)
+	}
+}
+
+func (l *levelLogger) Errorf(template string, args ...interface{}) {
+	if l.enabled(log.ErrorLevel) {
+		l.std.Errorf(template, args...)
+	}
+}
+
+func (l *levelLogger) Errorw(msg string, fields log.Fields) {
+	if l.enabled(log.ErrorLevel) {
+		l.std.Errorw(msg, fields)
+	}
+}
+
+func (l *levelLogger) Panic(msg ...interface{}) {
+	if l.enabled(log.PanicLevel) {
+		l.std.Panic(msg...)
+	}
+}
+
+func (l *levelLogger) Panicf(template string, args ...interface{}) {
+	if l.enabled(log.PanicLevel) {
+		l.std.Panicf(template, args...)
+	}
+}
+
+func (l *levelLogger) Panicw(msg string, fields log.Fields) {
+	if l.enabled(log.PanicLevel) {
+		l.std.Panicw(msg, fields)
+	}
+}
+
+func (l *levelLogger) Fatal(msg ...interface{}) {
+	if l.enabled(log.FatalLevel) {
+		l.std.Fatal(msg...)
+	}
+}
+
+func (l *levelLogger) Fatalf(template string, args ...interface{}) {
+	if l.enabled(log.FatalLevel) {
+		l.std.Fatalf(template, args...)
+	}
+}
+
+func (l *levelLogger) Fatalw(msg string, fields log.Fields) {
+	if l.enabled(log.FatalLevel) {
+		l.std.Fatalw(msg, fields)
+	}
+}
@@ -30,144 +30,215 @@ import (
 	"time"
 
 	"github.com/Masterminds/log-go"
+	gvisorTypes "github.com/containers/gvisor-tap-vsock/pkg/types"
 	"github.com/docker/go-connections/nat"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/audit"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/config"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/containerd"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/docker"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/forwarder"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/iptables"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/kube"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/metrics"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/procnet"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/routes"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/status"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
 )
 
 const (
-	iptablesUpdateInterval = 3 * time.Second
-	procNetScanInterval    = 3 * time.Second
-	socketInterval         = 5 * time.Second
-	socketRetryTimeout     = 2 * time.Minute
-	dockerSocketFile       = "/var/run/docker.sock"
-	containerdSocketFile   = "/run/k3s/containerd/containerd.sock"
+	socketInterval       = 5 * time.Second
+	socketRetryTimeout   = 2 * time.Minute
+	dockerSocketFile     = "/var/run/docker.sock"
+	containerdSocketFile = "/run/k3s/containerd/containerd.sock"
 )
 
+// reconcileDelay gives the monitors time to report the existing
+// containers and services before the first reconciliation.
+const reconcileDelay = 30 * time.Second
+
+// wslProxyKeepAliveInterval is how often the wsl-proxy session is checked,
+// and reestablished if wsl-proxy restarted.
+const wslProxyKeepAliveInterval = 5 * time.Second
+
 func main() {
-	var (
-		debug            = flag.Bool("debug", false, "display debug output")
-		configPath       = flag.String("kubeconfig", "/etc/rancher/k3s/k3s.yaml", "path to kubeconfig")
-		enableKubernetes = flag.Bool("kubernetes", false, "enable Kubernetes service forwarding")
-		enableDocker     = flag.Bool("docker", false, "enable Docker event monitoring")
-		enableContainerd = flag.Bool("containerd", false, "enable Containerd event monitoring")
-		containerdSock   = flag.String("containerdSock",
-			containerdSocketFile,
-			"file path for Containerd socket address")
-		k8sServiceListenerAddr = flag.String("k8sServiceListenerAddr", net.IPv4zero.String(),
-			"address to bind Kubernetes services to on the host, valid options are 0.0.0.0 or 127.0.0.1")
-		adminInstall = flag.Bool("adminInstall", false, "indicates if Rancher Desktop is installed as admin or not")
-		k8sAPIPort   = flag.String("k8sAPIPort", "6443",
-			"K8sAPI port number to forward to rancher-desktop wsl-proxy as a static portMapping event")
-		tapIfaceIP = flag.String("tap-interface-ip", "192.168.127.2",
-			"IP address for the tap interface eth0 in network namespace")
-	)
+	// The flags override the values of the configuration file.
+	flagDefaults := config.Default()
+	flagDefaults.RegisterFlags(flag.CommandLine)
+	configFile := flag.String("config", config.DefaultPath,
+		"file path for the guest agent configuration in YAML or JSON, reloaded on SIGHUP; the flags override its values")
 
 	// Setup logging with debug and trace levels
-	logger := log.NewStandard()
+	logger := newLevelLogger(log.InfoLevel)
 
 	flag.Parse()
 
-	if *debug {
-		logger.Level = log.DebugLevel
+	cfg, err := config.Resolve(*configFile, flag.CommandLine)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.Debug {
+		logger.SetLevel(log.DebugLevel)
 	}
 
 	log.Current = logger
 
-	log.Infof("Starting Rancher Desktop Agent in [AdminInstall=%t] mode", *adminInstall)
+	log.Infof("Starting Rancher Desktop Agent in [AdminInstall=%t] mode", cfg.AdminInstall)
 
 	if os.Geteuid() != 0 {
 		log.Fatal("agent must run as root")
 	}
 
-	if !*enableContainerd &&
-		!*enableDocker {
-		log.Fatal("requires either -docker or -containerd enabled.")
-	}
-
-	if *enableContainerd &&
-		*enableDocker {
-		log.Fatal("requires either -docker or -containerd but not both.")
-	}
-
-	if err := runAgent(
-		*enableContainerd, *enableDocker, *enableKubernetes,
-		*containerdSock, *configPath, *k8sServiceListenerAddr,
-		*adminInstall, *k8sAPIPort, *tapIfaceIP,
-	); err != nil {
+	if err := runAgent(cfg, func() (*config.Config, error) {
+		return config.Resolve(*configFile, flag.CommandLine)
+	}, logger); err != nil {
 		log.Fatal(err)
 	}
 
 	log.Info("Rancher Desktop Agent Shutting Down")
 }
 
-func runAgent(
-	enableContainerd, enableDocker, enableKubernetes bool,
-	containerdSock, configPath, k8sServiceListenerAddr string,
-	adminInstall bool,
-	k8sAPIPort, tapIfaceIP string,
-) error {
-	bindIP := net.ParseIP(tapIfaceIP)
-	if bindIP == nil {
-		return fmt.Errorf("invalid tap interface IP %q", tapIfaceIP)
+// runAgent runs the agent with cfg until it receives SIGTERM. On SIGHUP,
+// it reloads the configuration through reload.
+func runAgent(cfg *config.Config, reload func() (*config.Config, error), logger *levelLogger) error {
+	bindIP := net.ParseIP(cfg.TapInterfaceIP)
+
+	forwardingPolicy, err := cfg.LoadPolicy()
+	if err != nil {
+		return err
+	}
+	if forwardingPolicy != nil {
+		log.Infof("enforcing the port forwarding policy from %s", policySource(cfg))
+	}
+
+	remapRange, err := tracker.ParseRemapRange(cfg.RemapPorts)
+	if err != nil {
+		return err
 	}
 
 	groupCtx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	group, ctx := errgroup.WithContext(groupCtx)
 
+	// SIGHUP is handled once the components it reconfigures are running.
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGTERM)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGHUP)
 
-	go func() {
-		s := <-sigCh
-		log.Debugf("received [%s] signal", s)
-		cancel()
-	}()
+	wslProxyForwarder := forwarder.NewWSLProxyForwarder(ctx, "/run/wsl-proxy.sock")
+	apiTracker := tracker.NewAPITracker(ctx, wslProxyForwarder, tracker.GatewayBaseURL, cfg.TapInterfaceIP, cfg.AdminInstall)
+	apiTracker.SetPolicy(forwardingPolicy)
+	apiTracker.SetRemapRange(remapRange)
+
+	// The host-switch exposes the K8s API itself through --port-forward.
+	apiTracker.Reserve(gvisorTypes.TCP, net.JoinHostPort("127.0.0.1", cfg.K8sAPIPort))
+
+	// The K8s API port is forwarded to wsl-proxy along with the tracked
+	// ports, and resynced with them.
+	var k8sAPIPortMap nat.PortMap
+	if cfg.Kubernetes {
+		port, err := nat.NewPort("tcp", cfg.K8sAPIPort)
+		if err != nil {
+			return fmt.Errorf("failed to parse port for k8s API: %w", err)
+		}
+		k8sAPIPortMap = nat.PortMap{
+			port: []nat.PortBinding{
+				{
+					HostIP:   "127.0.0.1",
+					HostPort: cfg.K8sAPIPort,
+				},
+			},
+		}
+	}
+	wslProxyForwarder.SetState(func() types.PortMapping {
+		state := apiTracker.WSLProxyState()
+		for port, portBindings := range k8sAPIPortMap {
+			state.Ports[port] = append(state.Ports[port], portBindings...)
+		}
+		return state
+	})
+	group.Go(func() error {
+		wslProxyForwarder.Maintain(wslProxyKeepAliveInterval)
+		return nil
+	})
 
-	var portTracker tracker.Tracker
+	var auditLog *audit.Log
+	if cfg.AuditLog != "" {
+		auditLog, err = audit.Open(cfg.AuditLog, int64(cfg.AuditLogMaxSize)<<20, cfg.AuditLogMaxBackups)
+		if err != nil {
+			return err
+		}
+		defer auditLog.Close()
+		apiTracker.SetAuditLog(auditLog)
+	}
+
+	reloader := newReloader(cfg, logger, apiTracker)
+
+	group.Go(func() error {
+		reconcilePorts(ctx, apiTracker, reloader.reconcileInterval)
+		return nil
+	})
+
+	group.Go(func() error {
+		apiTracker.ProcessRetries()
+		return nil
+	})
+
+	var routeTable *routes.Table
+	if cfg.Kubernetes && cfg.K8sRoutes {
+		routeTable = routes.NewTable()
+	}
+
+	if cfg.StatusSocket != "" {
+		statusServer := status.New(ctx, cfg.StatusSocket, apiTracker)
+		if routeTable != nil {
+			statusServer.SetRoutes(routeTable)
+		}
+		group.Go(func() error {
+			return statusServer.Serve()
+		})
+	}
+
+	if cfg.MetricsAddr != "" {
+		registerTrackerMetrics(apiTracker)
+		group.Go(func() error {
+			return metrics.Serve(ctx, cfg.MetricsAddr)
+		})
+	}
 
-	wslProxyForwarder := forwarder.NewWSLProxyForwarder(ctx, "/run/wsl-proxy.sock")
-	portTracker = tracker.NewAPITracker(ctx, wslProxyForwarder, tracker.GatewayBaseURL, tapIfaceIP, adminInstall)
 	// Manually register the port for K8s API, we would
 	// only want to send this manual port mapping if both
 	// of the following conditions are met:
 	// 1) if kubernetes is enabled
 	// 2) when wsl-proxy for wsl-integration is enabled
-	if enableKubernetes {
-		port, err := nat.NewPort("tcp", k8sAPIPort)
-		if err != nil {
-			return fmt.Errorf("failed to parse port for k8s API: %w", err)
-		}
+	if cfg.Kubernetes {
 		k8sAPIPortMapping := types.PortMapping{
 			Remove: false,
-			Ports: nat.PortMap{
-				port: []nat.PortBinding{
-					{
-						HostIP:   "127.0.0.1",
-						HostPort: k8sAPIPort,
-					},
-				},
-			},
+			Ports:  k8sAPIPortMap,
 		}
 		if err := wslProxyForwarder.Send(k8sAPIPortMapping); err != nil {
 			return fmt.Errorf("failed to send a static portMapping event to wsl-proxy: %w", err)
 		}
-		log.Debugf("successfully forwarded k8s API port [%s] to wsl-proxy", k8sAPIPort)
+		log.Debugf("successfully forwarded k8s API port [%s] to wsl-proxy", cfg.K8sAPIPort)
 	}
 
-	if enableContainerd {
+	// The loopback rules and the iptables scanner follow the backend that
+	// the CNI portmap plugin picks on this system.
+	netfilterBackend := iptables.DetectBackend()
+	log.Infof("using the %s backend for DNAT rules", netfilterBackend)
+	loopbackRules := iptables.NewLoopbackRules(netfilterBackend)
+
+	if cfg.Containerd {
 		group.Go(func() error {
 			for {
-				eventMonitor, err := containerd.NewEventMonitor(containerdSock, portTracker)
+				eventMonitor, err := containerd.NewEventMonitor(
+					cfg.ContainerdSock,
+					cfg.ContainerdNamespaces,
+					apiTracker.ForSource(tracker.SourceContainerd),
+					loopbackRules)
 				if err != nil {
 					return fmt.Errorf("error initializing containerd event monitor: %w", err)
 				}
@@ -189,10 +260,10 @@ func runAgent(
 		})
 	}
 
-	if enableDocker {
+	if cfg.Docker {
 		group.Go(func() error {
 			for {
-				eventMonitor, err := docker.NewEventMonitor(portTracker)
+				eventMonitor, err := docker.NewEventMonitor(apiTracker.ForSource(tracker.SourceDocker), loopbackRules)
 				if err != nil {
 					return fmt.Errorf("error initializing docker event monitor: %w", err)
 				}
@@ -211,29 +282,28 @@ func runAgent(
 		})
 	}
 
-	if enableKubernetes {
-		k8sServiceListenerIP := net.ParseIP(k8sServiceListenerAddr)
-
-		if k8sServiceListenerIP == nil || (!k8sServiceListenerIP.Equal(net.IPv4zero) && !k8sServiceListenerIP.Equal(net.IPv4(127, 0, 0, 1))) {
-			return fmt.Errorf("empty or invalid Kubernetes service listener IP address %s; "+
-				"valid options are 0.0.0.0 and 127.0.0.1", k8sServiceListenerAddr)
-		}
+	if cfg.Kubernetes {
+		k8sServiceListenerIP := net.ParseIP(cfg.K8sServiceListenerAddr)
 
 		group.Go(func() error {
 			// Watch for kube
 			err := kube.WatchForServices(ctx,
-				configPath,
+				cfg.Kubeconfig,
 				k8sServiceListenerIP,
-				portTracker)
+				apiTracker.ForSource(tracker.SourceKube),
+				cfg.K8sLoadBalancerStatus,
+				routeTable)
 			if err != nil {
 				return fmt.Errorf("kubernetes service watcher failed: %w", err)
 			}
 			return nil
 		})
 
+		iptablesScanner := iptables.NewScanner(netfilterBackend)
+		iptablesHandler := iptables.New(ctx, apiTracker.ForSource(tracker.SourceIptables), iptablesScanner,
+			k8sServiceListenerIP, time.Duration(cfg.Intervals.IptablesScan))
+		reloader.iptablesHandler = iptablesHandler
 		group.Go(func() error {
-			iptablesScanner := iptables.NewIptablesScanner()
-			iptablesHandler := iptables.New(ctx, portTracker, iptablesScanner, k8sServiceListenerIP, iptablesUpdateInterval)
 			err := iptablesHandler.ForwardPorts()
 			if err != nil {
 				return fmt.Errorf("iptables port forwarding failed: %w", err)
@@ -242,17 +312,90 @@ func runAgent(
 		})
 	}
 
-	group.Go(func() error {
-		procScanner, err := procnet.NewProcNetScanner(ctx, portTracker, bindIP, procNetScanInterval)
-		if err != nil {
-			return fmt.Errorf("scanning /proc/net/{tcp, udp} failed: %w", err)
+	procScanner, err := procnet.NewSockDiagScanner(ctx, apiTracker.ForSource(tracker.SourceProcNet), bindIP,
+		time.Duration(cfg.Intervals.SockDiagScan))
+	reloader.procScannerInterval = func(c *config.Config) config.Duration { return c.Intervals.SockDiagScan }
+	if err != nil {
+		log.Infof("%s; scanning /proc/net instead", err)
+		procScanner, err = procnet.NewProcNetScanner(ctx, apiTracker.ForSource(tracker.SourceProcNet), bindIP,
+			time.Duration(cfg.Intervals.ProcNetScan))
+		reloader.procScannerInterval = func(c *config.Config) config.Duration { return c.Intervals.ProcNetScan }
+	}
+	if err != nil {
+		return fmt.Errorf("scanning /proc/net/{tcp, udp} failed: %w", err)
+	}
+	procScanner.SetAuditLog(auditLog)
+	reloader.procScanner = procScanner
+	group.Go(procScanner.ForwardPorts)
+
+	go func() {
+		for s := range sigCh {
+			log.Debugf("received [%s] signal", s)
+			if s != syscall.SIGHUP {
+				cancel()
+				return
+			}
+			reloaded, err := reload()
+			if err != nil {
+				log.Errorf("reloading the configuration failed, keeping the current one: %s", err)
+				continue
+			}
+			if err := reloader.apply(reloaded); err != nil {
+				log.Errorf("applying the reloaded configuration failed: %s", err)
+			}
 		}
-		return procScanner.ForwardPorts()
-	})
+	}()
 
 	return group.Wait()
 }
 
+// reconcilePorts periodically reconciles the tracked port mappings with the
+// ports exposed by the host-switch, cleaning up after a previous run of
+// the agent, until the context is cancelled.
+func reconcilePorts(ctx context.Context, apiTracker *tracker.APITracker, interval func() time.Duration) {
+	timer := time.NewTimer(reconcileDelay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		if err := apiTracker.Reconcile(); err != nil {
+			log.Errorf("reconciling exposed ports failed: %s", err)
+		}
+
+		timer.Reset(interval())
+	}
+}
+
+// registerTrackerMetrics reports the port mappings held by the tracker, so
+// that a leak shows up as an ever growing number.
+func registerTrackerMetrics(apiTracker *tracker.APITracker) {
+	metrics.Default.NewGaugeFunc("guestagent_tracked_port_mappings",
+		"Number of tracked containers and services with forwarded ports.", "source",
+		func() map[string]float64 {
+			counts := make(map[string]float64)
+			for _, entry := range apiTracker.Status() {
+				if len(entry.Ports) != 0 {
+					counts[string(entry.Source)]++
+				}
+			}
+			return counts
+		})
+	metrics.Default.NewGaugeFunc("guestagent_failed_port_bindings",
+		"Number of port bindings that failed to be exposed and are retried.", "source",
+		func() map[string]float64 {
+			counts := make(map[string]float64)
+			for _, entry := range apiTracker.Status() {
+				counts[string(entry.Source)] += float64(len(entry.Errors))
+			}
+			return counts
+		})
+}
+
 func tryConnectAPI(ctx context.Context, socketFile string, verify func(context.Context) error) error {
 	socketRetry := time.NewTicker(socketInterval)
 	defer socketRetry.Stop()
@@ -0,0 +1,145 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit writes a JSON-lines record of every decision the guest
+// agent makes to expose or unexpose a port, along with its outcome, so
+// that why a port was or was not published can be found after the fact.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/log-go"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/rotate"
+)
+
+// Action is what the guest agent attempted for a binding.
+type Action string
+
+const (
+	ActionExpose   Action = "expose"
+	ActionUnexpose Action = "unexpose"
+)
+
+// Result is the outcome of an action.
+type Result string
+
+const (
+	// ResultOK means the action succeeded.
+	ResultOK Result = "ok"
+	// ResultFailed means the action failed; Error holds the reason.
+	ResultFailed Result = "failed"
+	// ResultRejected means the port forwarding policy rejected the
+	// binding; Error holds the reason.
+	ResultRejected Result = "rejected"
+	// ResultDeferred means the action is postponed, e.g. until the
+	// listener is seen by a second scan.
+	ResultDeferred Result = "deferred"
+)
+
+// Record is a line of the audit log.
+type Record struct {
+	Time time.Time `json:"time"`
+	// Component is the part of the guest agent that made the decision,
+	// e.g. "tracker" or "procnet".
+	Component string `json:"component"`
+	// Event is what triggered the decision, e.g. "add", "remove",
+	// "retry", "reconcile" or "scan".
+	Event string `json:"event"`
+	// Source is the monitor that reported the port mapping, e.g.
+	// "docker" or "kube".
+	Source string `json:"source,omitempty"`
+	// ID is the container or service ID the binding belongs to.
+	ID     string `json:"id,omitempty"`
+	Action Action `json:"action"`
+	// Port is the port and protocol in the VM, e.g. "80/tcp".
+	Port string `json:"port"`
+	// HostIP and HostPort are the requested binding.
+	HostIP   string `json:"hostIP"`
+	HostPort string `json:"hostPort"`
+	// ResolvedHostIP is the address the binding is exposed on, on the
+	// host.
+	ResolvedHostIP string `json:"resolvedHostIP,omitempty"`
+	// ExposedHostPort is the host port the binding is exposed on, when
+	// it differs from HostPort.
+	ExposedHostPort string `json:"exposedHostPort,omitempty"`
+	Result          Result `json:"result"`
+	Error           string `json:"error,omitempty"`
+}
+
+// Log is an append-only audit log file, rotated once it reaches its
+// maximum size. A nil Log discards the records.
+type Log struct {
+	mutex  sync.Mutex
+	file   *rotate.File
+	closed bool
+}
+
+// Open opens the audit log at path, appending to it if it exists. The
+// file is rotated when writing a record would take it over maxSize bytes,
+// and at most maxBackups rotated files are kept.
+func Open(path string, maxSize int64, maxBackups int) (*Log, error) {
+	file, err := rotate.Open(path, maxSize, maxBackups)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log failed: %w", err)
+	}
+	return &Log{file: file}, nil
+}
+
+// Write appends record to the log, setting its time if it is not set.
+// Errors are logged rather than returned, as they must not stop the
+// guest agent from forwarding ports.
+func (l *Log) Write(record Record) {
+	if l == nil {
+		return
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("encoding audit record failed: %s", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.closed {
+		return
+	}
+	if l.file.Full(int64(len(line))) {
+		if err := l.file.Rotate(); err != nil {
+			log.Errorf("rotating audit log failed: %s", err)
+		}
+	}
+	if _, err := l.file.Write(line); err != nil {
+		log.Errorf("writing audit log failed: %s", err)
+	}
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.closed = true
+	return l.file.Close()
+}
@@ -0,0 +1,101 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/audit"
+)
+
+func readRecords(t *testing.T, path string) []audit.Record {
+	t.Helper()
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var records []audit.Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record audit.Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+func TestWriteAppends(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := audit.Open(path, 1<<20, 1)
+	require.NoError(t, err)
+	l.Write(audit.Record{Component: "tracker", ID: "first", Action: audit.ActionExpose, Result: audit.ResultOK})
+	require.NoError(t, l.Close())
+
+	// Opening the log again appends to it.
+	l, err = audit.Open(path, 1<<20, 1)
+	require.NoError(t, err)
+	l.Write(audit.Record{Component: "tracker", ID: "second", Action: audit.ActionUnexpose, Result: audit.ResultFailed, Error: "boom"})
+	require.NoError(t, l.Close())
+
+	records := readRecords(t, path)
+	require.Len(t, records, 2)
+	assert.Equal(t, "first", records[0].ID)
+	assert.False(t, records[0].Time.IsZero())
+	assert.Equal(t, "second", records[1].ID)
+	assert.Equal(t, audit.ResultFailed, records[1].Result)
+	assert.Equal(t, "boom", records[1].Error)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestRotation(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	// Small enough for a single record per file.
+	l, err := audit.Open(path, 64, 2)
+	require.NoError(t, err)
+	defer l.Close()
+
+	for _, id := range []string{"1", "2", "3", "4"} {
+		l.Write(audit.Record{Component: "tracker", ID: id, Action: audit.ActionExpose, Result: audit.ResultOK})
+	}
+
+	assert.Equal(t, "4", readRecords(t, path)[0].ID)
+	assert.Equal(t, "3", readRecords(t, path+".1")[0].ID)
+	assert.Equal(t, "2", readRecords(t, path+".2")[0].ID)
+	// The oldest record is dropped with the backups over the limit.
+	assert.NoFileExists(t, path+".3")
+}
+
+func TestNilLog(t *testing.T) {
+	t.Parallel()
+
+	var l *audit.Log
+	l.Write(audit.Record{ID: "discarded"})
+	assert.NoError(t, l.Close())
+}
@@ -0,0 +1,317 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config holds the configuration of the guest agent, read from a
+// YAML or JSON file and overridden by the command-line flags.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/policy"
+)
+
+// DefaultPath is where the configuration file is looked up by default.
+const DefaultPath = "/etc/rancher-desktop/guestagent.yaml"
+
+var ErrInvalidConfig = errors.New("invalid guest agent configuration")
+
+// Config is the content of the configuration file, in YAML or JSON. The
+// keys are named after the command-line flags:
+//
+//	docker: true
+//	containerd: true
+//	containerdNamespaces: ["default"]
+//	kubernetes: true
+//	k8sServiceListenerAddr: 127.0.0.1
+//	portPolicy: /etc/rancher-desktop/guestagent-policy.yaml
+//	intervals:
+//	  procNetScan: 3s
+type Config struct {
+	Debug bool `json:"debug"`
+
+	Docker               bool     `json:"docker"`
+	Containerd           bool     `json:"containerd"`
+	ContainerdSock       string   `json:"containerdSock"`
+	ContainerdNamespaces []string `json:"containerdNamespaces,omitempty"`
+
+	Kubernetes             bool   `json:"kubernetes"`
+	Kubeconfig             string `json:"kubeconfig"`
+	K8sServiceListenerAddr string `json:"k8sServiceListenerAddr"`
+	K8sLoadBalancerStatus  bool   `json:"k8sLoadBalancerStatus"`
+	K8sRoutes              bool   `json:"k8sRoutes"`
+	K8sAPIPort             string `json:"k8sAPIPort"`
+
+	AdminInstall   bool   `json:"adminInstall"`
+	TapInterfaceIP string `json:"tapInterfaceIP"`
+	StatusSocket   string `json:"statusSocket"`
+	MetricsAddr    string `json:"metricsAddr"`
+
+	// PortPolicy is the path of the port forwarding policy file. It is
+	// ignored when Policy is set.
+	PortPolicy string `json:"portPolicy"`
+	// Policy is the port forwarding policy, inline.
+	Policy     *policy.Config `json:"policy,omitempty"`
+	RemapPorts string         `json:"remapPorts"`
+
+	// AuditLog is the path of the JSON-lines audit log of the expose
+	// and unexpose decisions; empty to disable it.
+	AuditLog string `json:"auditLog"`
+	// AuditLogMaxSize is the size, in MiB, at which the audit log is
+	// rotated.
+	AuditLogMaxSize int `json:"auditLogMaxSize"`
+	// AuditLogMaxBackups is the number of rotated audit logs kept.
+	AuditLogMaxBackups int `json:"auditLogMaxBackups"`
+
+	Intervals Intervals `json:"intervals"`
+}
+
+// Intervals are the polling intervals of the guest agent.
+type Intervals struct {
+	IptablesScan Duration `json:"iptablesScan"`
+	ProcNetScan  Duration `json:"procNetScan"`
+	SockDiagScan Duration `json:"sockDiagScan"`
+	Reconcile    Duration `json:"reconcile"`
+}
+
+// Duration is a time.Duration written as a string, e.g. "3s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"3s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Default returns the configuration used when neither the file nor the
+// flags set a value.
+func Default() Config {
+	return Config{
+		ContainerdSock:         "/run/k3s/containerd/containerd.sock",
+		Kubeconfig:             "/etc/rancher/k3s/k3s.yaml",
+		K8sServiceListenerAddr: net.IPv4zero.String(),
+		K8sAPIPort:             "6443",
+		TapInterfaceIP:         "192.168.127.2",
+		StatusSocket:           "/run/rancher-desktop-guestagent.sock",
+		PortPolicy:             "/etc/rancher-desktop/guestagent-policy.yaml",
+		AuditLogMaxSize:        10,
+		AuditLogMaxBackups:     3,
+		Intervals: Intervals{
+			IptablesScan: Duration(3 * time.Second),
+			ProcNetScan:  Duration(3 * time.Second),
+			SockDiagScan: Duration(1 * time.Second),
+			Reconcile:    Duration(5 * time.Minute),
+		},
+	}
+}
+
+// RegisterFlags defines the command-line flags on fs, with the values of c
+// as defaults, storing their values into c.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Debug, "debug", c.Debug, "display debug output")
+	fs.StringVar(&c.Kubeconfig, "kubeconfig", c.Kubeconfig, "path to kubeconfig")
+	fs.BoolVar(&c.Kubernetes, "kubernetes", c.Kubernetes, "enable Kubernetes service forwarding")
+	fs.BoolVar(&c.K8sLoadBalancerStatus, "k8sLoadBalancerStatus", c.K8sLoadBalancerStatus,
+		"write the host address to the status of the forwarded LoadBalancer services")
+	fs.BoolVar(&c.K8sRoutes, "k8sRoutes", c.K8sRoutes,
+		"forward the host names and ports of Kubernetes Ingresses and Gateway API HTTPRoutes")
+	fs.BoolVar(&c.Docker, "docker", c.Docker, "enable Docker event monitoring")
+	fs.BoolVar(&c.Containerd, "containerd", c.Containerd, "enable Containerd event monitoring")
+	fs.StringVar(&c.ContainerdSock, "containerdSock", c.ContainerdSock, "file path for Containerd socket address")
+	fs.Var((*listValue)(&c.ContainerdNamespaces), "containerdNamespaces",
+		"comma-separated list of the containerd namespaces to monitor; empty to monitor all of them")
+	fs.StringVar(&c.K8sServiceListenerAddr, "k8sServiceListenerAddr", c.K8sServiceListenerAddr,
+		"address to bind Kubernetes services to on the host, valid options are 0.0.0.0 or 127.0.0.1")
+	fs.BoolVar(&c.AdminInstall, "adminInstall", c.AdminInstall, "indicates if Rancher Desktop is installed as admin or not")
+	fs.StringVar(&c.K8sAPIPort, "k8sAPIPort", c.K8sAPIPort,
+		"K8sAPI port number to forward to rancher-desktop wsl-proxy as a static portMapping event")
+	fs.StringVar(&c.TapInterfaceIP, "tap-interface-ip", c.TapInterfaceIP,
+		"IP address for the tap interface eth0 in network namespace")
+	fs.StringVar(&c.StatusSocket, "statusSocket", c.StatusSocket,
+		"file path for the Unix socket serving the port mapping status API, empty to disable")
+	fs.StringVar(&c.PortPolicy, "portPolicy", c.PortPolicy,
+		"file path for the port forwarding policy; if the file does not exist, all ports are forwarded")
+	fs.StringVar(&c.RemapPorts, "remapPorts", c.RemapPorts,
+		"when a host port is taken, expose the port on the next free one (\"next\") or on a free one in a range (\"N-M\"); empty to disable")
+	fs.StringVar(&c.MetricsAddr, "metricsAddr", c.MetricsAddr,
+		"serve Prometheus metrics on /metrics at this TCP address, or Unix socket if it is a path; empty to disable")
+	fs.StringVar(&c.AuditLog, "auditLog", c.AuditLog,
+		"file path for the JSON-lines audit log of the port expose and unexpose decisions; empty to disable")
+	fs.IntVar(&c.AuditLogMaxSize, "auditLogMaxSize", c.AuditLogMaxSize, "size in MiB at which the audit log is rotated")
+	fs.IntVar(&c.AuditLogMaxBackups, "auditLogMaxBackups", c.AuditLogMaxBackups, "number of rotated audit logs to keep")
+}
+
+// Resolve returns the configuration from the file at path on top of the
+// defaults, with the flags explicitly set on flags applied over it. A
+// missing file is not an error.
+func Resolve(path string, flags *flag.FlagSet) (*Config, error) {
+	c := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("reading guest agent configuration failed: %w", err)
+		default:
+			if err := yaml.UnmarshalStrict(data, &c); err != nil {
+				return nil, fmt.Errorf("%w: %s: %w", ErrInvalidConfig, path, err)
+			}
+		}
+	}
+
+	overrides := flag.NewFlagSet("overrides", flag.ContinueOnError)
+	c.RegisterFlags(overrides)
+	var errs []error
+	flags.Visit(func(f *flag.Flag) {
+		if overrides.Lookup(f.Name) == nil {
+			return
+		}
+		if err := overrides.Set(f.Name, f.Value.String()); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", f.Name, err))
+		}
+	})
+	if len(errs) != 0 {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, errors.Join(errs...))
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Validate checks the values that would otherwise only fail once the
+// agent is running.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if !c.Docker && !c.Containerd {
+		errs = append(errs, errors.New("requires either docker or containerd enabled"))
+	}
+	if net.ParseIP(c.TapInterfaceIP) == nil {
+		errs = append(errs, fmt.Errorf("invalid tap interface IP %q", c.TapInterfaceIP))
+	}
+	if c.Kubernetes {
+		ip := net.ParseIP(c.K8sServiceListenerAddr)
+		if ip == nil || (!ip.Equal(net.IPv4zero) && !ip.Equal(net.IPv4(127, 0, 0, 1))) {
+			errs = append(errs, fmt.Errorf("empty or invalid Kubernetes service listener IP address %s; "+
+				"valid options are 0.0.0.0 and 127.0.0.1", c.K8sServiceListenerAddr))
+		}
+	}
+	if c.AuditLogMaxSize <= 0 {
+		errs = append(errs, fmt.Errorf("auditLogMaxSize must be positive, got %d", c.AuditLogMaxSize))
+	}
+	if c.AuditLogMaxBackups < 0 {
+		errs = append(errs, fmt.Errorf("auditLogMaxBackups must not be negative, got %d", c.AuditLogMaxBackups))
+	}
+	if c.Policy != nil {
+		if _, err := policy.New(*c.Policy); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	intervals := map[string]Duration{
+		"iptablesScan": c.Intervals.IptablesScan,
+		"procNetScan":  c.Intervals.ProcNetScan,
+		"sockDiagScan": c.Intervals.SockDiagScan,
+		"reconcile":    c.Intervals.Reconcile,
+	}
+	for name, interval := range intervals {
+		if interval <= 0 {
+			errs = append(errs, fmt.Errorf("interval %s must be positive, got %s", name, time.Duration(interval)))
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, errors.Join(errs...))
+	}
+	return nil
+}
+
+// LoadPolicy returns the port forwarding policy of the configuration: the
+// inline one if set, or the one in the PortPolicy file. A nil Policy
+// allows everything.
+func (c *Config) LoadPolicy() (*policy.Policy, error) {
+	if c.Policy != nil {
+		return policy.New(*c.Policy)
+	}
+	return policy.Load(c.PortPolicy)
+}
+
+// reloadable are the keys that a reload applies to the running agent.
+var reloadable = map[string]bool{
+	"debug":      true,
+	"portPolicy": true,
+	"policy":     true,
+	"remapPorts": true,
+	"intervals":  true,
+}
+
+// RestartRequired returns the keys that differ between c and other and
+// that only take effect when the agent restarts.
+func (c *Config) RestartRequired(other *Config) []string {
+	var keys []string
+	a, b := reflect.ValueOf(*c), reflect.ValueOf(*other)
+	for i := range a.NumField() {
+		key, _, _ := strings.Cut(a.Type().Field(i).Tag.Get("json"), ",")
+		if reloadable[key] {
+			continue
+		}
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// listValue is a flag.Value for a comma-separated list.
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/config"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/policy"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func parseFlags(t *testing.T, args ...string) *flag.FlagSet {
+	t.Helper()
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	defaults := config.Default()
+	defaults.RegisterFlags(flags)
+	require.NoError(t, flags.Parse(args))
+	return flags
+}
+
+func TestResolveYAML(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, "guestagent.yaml", `
+docker: true
+containerdNamespaces: [default, k8s.io]
+k8sServiceListenerAddr: 127.0.0.1
+remapPorts: next
+policy:
+  denyPorts: ["1-1023"]
+intervals:
+  procNetScan: 10s
+`)
+	cfg, err := config.Resolve(path, parseFlags(t))
+	require.NoError(t, err)
+
+	assert.True(t, cfg.Docker)
+	assert.False(t, cfg.Containerd)
+	assert.Equal(t, []string{"default", "k8s.io"}, cfg.ContainerdNamespaces)
+	assert.Equal(t, "127.0.0.1", cfg.K8sServiceListenerAddr)
+	assert.Equal(t, "next", cfg.RemapPorts)
+	assert.Equal(t, &policy.Config{DenyPorts: []string{"1-1023"}}, cfg.Policy)
+	assert.Equal(t, config.Duration(10*time.Second), cfg.Intervals.ProcNetScan)
+	// The values the file does not set keep their defaults.
+	assert.Equal(t, config.Default().Intervals.SockDiagScan, cfg.Intervals.SockDiagScan)
+	assert.Equal(t, config.Default().StatusSocket, cfg.StatusSocket)
+}
+
+func TestResolveJSON(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, "guestagent.json", `{"containerd": true, "intervals": {"reconcile": "1m"}}`)
+	cfg, err := config.Resolve(path, parseFlags(t))
+	require.NoError(t, err)
+
+	assert.True(t, cfg.Containerd)
+	assert.Equal(t, config.Duration(time.Minute), cfg.Intervals.Reconcile)
+}
+
+func TestResolveFlagsOverrideFile(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, "guestagent.yaml", `
+docker: true
+statusSocket: /run/from-file.sock
+metricsAddr: 127.0.0.1:9100
+`)
+	flags := parseFlags(t, "-statusSocket", "", "-containerd", "-containerdNamespaces", "default, k8s.io")
+	cfg, err := config.Resolve(path, flags)
+	require.NoError(t, err)
+
+	assert.True(t, cfg.Docker)
+	assert.True(t, cfg.Containerd)
+	assert.Empty(t, cfg.StatusSocket)
+	assert.Equal(t, "127.0.0.1:9100", cfg.MetricsAddr)
+	assert.Equal(t, []string{"default", "k8s.io"}, cfg.ContainerdNamespaces)
+}
+
+func TestResolveMissingFile(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := config.Resolve(filepath.Join(t.TempDir(), "missing.yaml"), parseFlags(t, "-docker"))
+	require.NoError(t, err)
+	assert.True(t, cfg.Docker)
+}
+
+func TestResolveInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"unknown key":      "docker: true\nunknown: 1\n",
+		"no engine":        "kubernetes: true\n",
+		"listener address": "docker: true\nkubernetes: true\nk8sServiceListenerAddr: 10.0.0.1\n",
+		"duration":         "docker: true\nintervals:\n  reconcile: 5\n",
+		"interval":         "docker: true\nintervals:\n  iptablesScan: 0s\n",
+		"policy":           "docker: true\npolicy:\n  denyPorts: [\"http\"]\n",
+		"tap interface ip": "docker: true\ntapInterfaceIP: eth0\n",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := config.Resolve(writeConfig(t, "guestagent.yaml", content), parseFlags(t))
+			require.ErrorIs(t, err, config.ErrInvalidConfig)
+		})
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	t.Parallel()
+
+	running := config.Default()
+	running.Docker = true
+
+	reloaded := running
+	reloaded.Debug = true
+	reloaded.RemapPorts = "next"
+	reloaded.Intervals.ProcNetScan = config.Duration(time.Minute)
+	reloaded.Policy = &policy.Config{DenyPorts: []string{"22"}}
+	assert.Empty(t, running.RestartRequired(&reloaded))
+
+	reloaded.Containerd = true
+	reloaded.StatusSocket = ""
+	assert.Equal(t, []string{"containerd", "statusSocket"}, running.RestartRequired(&reloaded))
+}
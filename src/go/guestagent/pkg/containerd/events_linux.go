@@ -15,7 +15,6 @@ limitations under the License.
 package containerd
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -25,6 +24,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -37,15 +37,17 @@ import (
 	"github.com/docker/go-connections/nat"
 	"google.golang.org/protobuf/proto"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/iptables"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/metrics"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/policy"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/utils"
 )
 
 const (
-	namespaceKey = "nerdctl/namespace"
-	portsKey     = "nerdctl/ports"
-	stateDirKey  = "nerdctl/state-dir"
-	networkKey   = "nerdctl/networks"
+	portsKey    = "nerdctl/ports"
+	stateDirKey = "nerdctl/state-dir"
+	networkKey  = "nerdctl/networks"
 )
 
 // EventMonitor monitors the Containerd API
@@ -53,14 +55,25 @@ const (
 type EventMonitor struct {
 	containerdClient *containerd.Client
 	portTracker      tracker.Tracker
+	// loopbackRules adds the DNAT rules for ports published on localhost.
+	loopbackRules iptables.LoopbackRules
+	// namespaces are the containerd namespaces to monitor; all of them
+	// when empty.
+	namespaces []string
+	// paused are the tracker IDs of the paused containers, whose port
+	// mappings are removed until they are resumed.
+	paused map[string]bool
 }
 
 // NewEventMonitor creates and returns a new Event Monitor for
 // Containerd API. Caller is responsible to make sure that
-// Docker engine is up and running.
+// Docker engine is up and running. Only the containers in the given
+// namespaces are monitored, or in all the namespaces if none are given.
 func NewEventMonitor(
 	containerdSock string,
+	monitoredNamespaces []string,
 	portTracker tracker.Tracker,
+	loopbackRules iptables.LoopbackRules,
 ) (*EventMonitor, error) {
 	client, err := containerd.New(containerdSock, containerd.WithDefaultNamespace(namespaces.Default))
 	if err != nil {
@@ -70,16 +83,23 @@ func NewEventMonitor(
 	return &EventMonitor{
 		containerdClient: client,
 		portTracker:      portTracker,
+		loopbackRules:    loopbackRules,
+		namespaces:       monitoredNamespaces,
+		paused:           make(map[string]bool),
 	}, nil
 }
 
 // MonitorPorts subscribes to event API
-// for container Create/Update/Delete events.
+// for container Create/Update/Pause/Resume/Delete events.
 func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 	subscribeFilters := []string{
 		`topic=="/tasks/start"`,
 		`topic=="/containers/update"`,
 		`topic=="/tasks/exit"`,
+		`topic=="/tasks/paused"`,
+		`topic=="/tasks/resumed"`,
+		`topic=="/containers/delete"`,
+		`topic=="/tasks/oom"`,
 	}
 	msgCh, errCh := e.containerdClient.Subscribe(ctx, subscribeFilters...)
 
@@ -94,6 +114,13 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 		case envelope := <-msgCh:
 			log.Debugf("received an event: %+v", envelope.Topic)
 
+			if !e.monitors(envelope.Namespace) {
+				log.Debugf("ignoring the event from namespace %s", envelope.Namespace)
+				continue
+			}
+			// The containers are looked up in the namespace of the event.
+			nsCtx := namespaces.WithNamespace(ctx, envelope.Namespace)
+
 			switch envelope.Topic {
 			case "/tasks/start":
 				startTask := &events.TaskStart{}
@@ -103,7 +130,7 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 					log.Errorf("failed to unmarshal container's start task: %v", err)
 				}
 
-				container, err := e.containerdClient.ContainerService().Get(ctx, startTask.ContainerID)
+				container, err := e.containerdClient.ContainerService().Get(nsCtx, startTask.ContainerID)
 				if err != nil {
 					log.Errorf("failed to get the container %s from namespace %s: %s", startTask.ContainerID, envelope.Namespace, err)
 				}
@@ -115,12 +142,12 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 				if len(ports) == 0 {
 					continue
 				}
-				err = execIptablesRules(ctx, ports, startTask.ContainerID, container.Labels[networkKey], envelope.Namespace, strconv.Itoa(int(startTask.Pid)))
+				err = e.execLoopbackRules(ctx, ports, startTask.ContainerID, container.Labels[networkKey], envelope.Namespace, strconv.Itoa(int(startTask.Pid)))
 				if err != nil {
 					log.Errorf("failed running iptable rules to update DNAT rule in CNI-HOSTPORT-DNAT chain: %v", err)
 				}
 
-				err = e.portTracker.Add(startTask.ContainerID, ports)
+				err = e.portTracker.AddWorkload(trackerID(envelope.Namespace, startTask.ContainerID), policy.Workload{Labels: container.Labels}, ports)
 				if err != nil {
 					log.Errorf("adding port mapping to tracker failed: %v", err)
 
@@ -134,7 +161,13 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 					log.Errorf("failed to unmarshal container update event: %v", err)
 				}
 
-				container, err := e.containerdClient.ContainerService().Get(ctx, cuEvent.ID)
+				id := trackerID(envelope.Namespace, cuEvent.ID)
+				if e.paused[id] {
+					log.Debugf("container %s in namespace %s is paused, its port mapping is updated on resume", cuEvent.ID, envelope.Namespace)
+					continue
+				}
+
+				container, err := e.containerdClient.ContainerService().Get(nsCtx, cuEvent.ID)
 				if err != nil {
 					log.Errorf("failed to get the container %s from namespace %s: %s", cuEvent.ID, envelope.Namespace, err)
 				}
@@ -148,15 +181,15 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 					continue
 				}
 
-				existingPortMap := e.portTracker.Get(cuEvent.ID)
+				existingPortMap := e.portTracker.Get(id)
 				if existingPortMap != nil {
 					if !reflect.DeepEqual(ports, existingPortMap) {
-						err := e.portTracker.Remove(cuEvent.ID)
+						err := e.portTracker.Remove(id)
 						if err != nil {
 							log.Errorf("failed to remove port mapping from container update event: %v", err)
 						}
 
-						err = e.portTracker.Add(cuEvent.ID, ports)
+						err = e.portTracker.AddWorkload(id, policy.Workload{Labels: container.Labels}, ports)
 						if err != nil {
 							log.Errorf("failed to add port mapping from container update event: %v", err)
 
@@ -167,7 +200,7 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 					continue
 				}
 				// Not 100% sure if we ever get here...
-				if err = e.portTracker.Add(cuEvent.ID, ports); err != nil {
+				if err = e.portTracker.AddWorkload(id, policy.Workload{Labels: container.Labels}, ports); err != nil {
 					log.Errorf("failed to add port mapping from container update event: %v", err)
 				}
 
@@ -178,49 +211,144 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 					log.Errorf("failed to unmarshal container's exit task: %v", err)
 				}
 
-				container, err := e.containerdClient.LoadContainer(ctx, exitTask.ContainerID)
+				e.removeStoppedContainer(nsCtx, envelope.Namespace, exitTask.ContainerID)
+
+			case "/tasks/oom":
+				// The OOM killer may have killed a process other than the
+				// init process, in which case the container keeps running.
+				oomTask := &events.TaskOOM{}
+				err := proto.Unmarshal(envelope.Event.GetValue(), oomTask)
 				if err != nil {
-					if errdefs.IsNotFound(err) {
-						log.Debugf("container: %s in namespace: %s not found, deleting port mapping", exitTask.ContainerID, envelope.Namespace)
-						e.removePortMapping(exitTask.ContainerID)
-						continue
-					}
-					log.Errorf("failed to get the container %s from namespace %s: %s", exitTask.ContainerID, envelope.Namespace, err)
+					log.Errorf("failed to unmarshal container's oom task: %v", err)
+				}
+
+				log.Warnf("container %s in namespace %s ran out of memory", oomTask.ContainerID, envelope.Namespace)
+				e.removeStoppedContainer(nsCtx, envelope.Namespace, oomTask.ContainerID)
+
+			case "/tasks/paused":
+				pausedTask := &events.TaskPaused{}
+				err := proto.Unmarshal(envelope.Event.GetValue(), pausedTask)
+				if err != nil {
+					log.Errorf("failed to unmarshal container's paused task: %v", err)
+				}
+
+				// A paused container does not answer on its ports, so they
+				// are no longer advertised until it is resumed.
+				id := trackerID(envelope.Namespace, pausedTask.ContainerID)
+				e.paused[id] = true
+				e.removePortMapping(id)
+
+			case "/tasks/resumed":
+				resumedTask := &events.TaskResumed{}
+				err := proto.Unmarshal(envelope.Event.GetValue(), resumedTask)
+				if err != nil {
+					log.Errorf("failed to unmarshal container's resumed task: %v", err)
+				}
+
+				id := trackerID(envelope.Namespace, resumedTask.ContainerID)
+				if !e.paused[id] {
 					continue
 				}
+				delete(e.paused, id)
 
-				tsk, err := container.Task(ctx, nil)
+				container, err := e.containerdClient.ContainerService().Get(nsCtx, resumedTask.ContainerID)
 				if err != nil {
-					if errdefs.IsNotFound(err) {
-						log.Debugf("task for container %s in namespace %s not found, deleting port mapping", exitTask.ContainerID, envelope.Namespace)
-						e.removePortMapping(exitTask.ContainerID)
-						continue
-					}
-					log.Errorf("failed to get the task for container %s: %s", exitTask.ContainerID, err)
+					log.Errorf("failed to get the container %s from namespace %s: %s", resumedTask.ContainerID, envelope.Namespace, err)
 					continue
 				}
-				status, err := tsk.Status(ctx)
+				ports, err := createPortMappingFromContainer(container.ID, container.Labels)
 				if err != nil {
-					log.Errorf("failed to get the task status for container %s: %s", exitTask.ContainerID, err)
+					log.Errorf("failed to create port mapping from container's resumed task: %v", err)
+				}
+				if len(ports) == 0 {
 					continue
 				}
 
-				if status.Status == containerd.Running {
-					log.Debugf("container %s is still running, but received exit event with status %d", exitTask.ContainerID, exitTask.ExitStatus)
-					continue
+				// The loopback rules are left in place while the container
+				// is paused, so only the port mapping is added back.
+				if err := e.portTracker.AddWorkload(id, policy.Workload{Labels: container.Labels}, ports); err != nil {
+					log.Errorf("adding port mapping to tracker failed: %v", err)
 				}
 
-				e.removePortMapping(exitTask.ContainerID)
+			case "/containers/delete":
+				deleteEvent := &events.ContainerDelete{}
+				err := proto.Unmarshal(envelope.Event.GetValue(), deleteEvent)
+				if err != nil {
+					log.Errorf("failed to unmarshal container delete event: %v", err)
+				}
+
+				// A container can be deleted without an exit event, e.g.
+				// when its task was never started or was killed forcefully.
+				e.forgetContainer(trackerID(envelope.Namespace, deleteEvent.ID))
 			}
 
 		case err := <-errCh:
 			log.Errorf("receiving container event failed: %v", err)
+			metrics.EventStreamReconnects.Inc("containerd")
 
 			return
 		}
 	}
 }
 
+// removeStoppedContainer removes the port mapping of a container whose
+// task is no longer running.
+func (e *EventMonitor) removeStoppedContainer(ctx context.Context, namespace, containerID string) {
+	id := trackerID(namespace, containerID)
+
+	container, err := e.containerdClient.LoadContainer(ctx, containerID)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			log.Debugf("container: %s in namespace: %s not found, deleting port mapping", containerID, namespace)
+			e.forgetContainer(id)
+			return
+		}
+		log.Errorf("failed to get the container %s from namespace %s: %s", containerID, namespace, err)
+		return
+	}
+
+	tsk, err := container.Task(ctx, nil)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			log.Debugf("task for container %s in namespace %s not found, deleting port mapping", containerID, namespace)
+			e.forgetContainer(id)
+			return
+		}
+		log.Errorf("failed to get the task for container %s: %s", containerID, err)
+		return
+	}
+	status, err := tsk.Status(ctx)
+	if err != nil {
+		log.Errorf("failed to get the task status for container %s: %s", containerID, err)
+		return
+	}
+
+	if status.Status == containerd.Running || status.Status == containerd.Paused {
+		log.Debugf("container %s is still %s", containerID, status.Status)
+		return
+	}
+
+	e.forgetContainer(id)
+}
+
+// forgetContainer removes the port mapping of a container that stopped or
+// no longer exists.
+func (e *EventMonitor) forgetContainer(id string) {
+	delete(e.paused, id)
+	e.removePortMapping(id)
+}
+
+// monitors reports whether the containers of the namespace are monitored.
+func (e *EventMonitor) monitors(namespace string) bool {
+	return len(e.namespaces) == 0 || slices.Contains(e.namespaces, namespace)
+}
+
+// trackerID returns the ID a container is tracked under: the same
+// container ID can be used in different namespaces.
+func trackerID(namespace, containerID string) string {
+	return namespace + "/" + containerID
+}
+
 // IsServing returns true if the client can successfully connect to the
 // containerd daemon and the healthcheck service returns the SERVING
 // response.
@@ -241,14 +369,30 @@ func (e *EventMonitor) IsServing(ctx context.Context) error {
 // startup or due to timing issues, this acts as a backup to capture all
 // previously running containers.
 func (e *EventMonitor) initializeRunningContainers(ctx context.Context) {
+	monitoredNamespaces := e.namespaces
+	if len(monitoredNamespaces) == 0 {
+		var err error
+		monitoredNamespaces, err = e.containerdClient.NamespaceService().List(ctx)
+		if err != nil {
+			log.Errorf("failed listing namespaces: %s", err)
+			return
+		}
+	}
+	for _, namespace := range monitoredNamespaces {
+		e.initializeNamespace(namespaces.WithNamespace(ctx, namespace), namespace)
+	}
+}
+
+func (e *EventMonitor) initializeNamespace(ctx context.Context, namespace string) {
 	containers, err := e.containerdClient.Containers(ctx)
 	if err != nil {
-		log.Errorf("failed getting containers: %s", err)
+		log.Errorf("failed getting containers in namespace %s: %s", namespace, err)
 		return
 	}
 	for _, c := range containers {
+		id := trackerID(namespace, c.ID())
 		// skip already added containers
-		if len(e.portTracker.Get(c.ID())) != 0 {
+		if len(e.portTracker.Get(id)) != 0 {
 			continue
 		}
 		t, err := c.Task(ctx, nil)
@@ -279,12 +423,12 @@ func (e *EventMonitor) initializeRunningContainers(ctx context.Context) {
 			continue
 		}
 
-		err = execIptablesRules(ctx, ports, c.ID(), labels[networkKey], labels[namespaceKey], strconv.Itoa(int(t.Pid())))
+		err = e.execLoopbackRules(ctx, ports, c.ID(), labels[networkKey], namespace, strconv.Itoa(int(t.Pid())))
 		if err != nil {
 			log.Errorf("failed running iptable rules to update DNAT rule in CNI-HOSTPORT-DNAT chain: %v", err)
 		}
 
-		err = e.portTracker.Add(c.ID(), ports)
+		err = e.portTracker.AddWorkload(id, policy.Workload{Labels: labels}, ports)
 		if err != nil {
 			log.Errorf("adding port mapping to tracker failed: %v", err)
 
@@ -310,9 +454,9 @@ func (e *EventMonitor) Close() error {
 	return finalErr
 }
 
-// execIptablesRules creates an additional DNAT rule to allow service exposure on
+// execLoopbackRules creates an additional DNAT rule to allow service exposure on
 // other network addresses if port binding is bound to 127.0.0.1.
-func execIptablesRules(ctx context.Context, portMappings nat.PortMap, containerID, networks, namespace, pid string) error {
+func (e *EventMonitor) execLoopbackRules(ctx context.Context, portMappings nat.PortMap, containerID, networks, namespace, pid string) error {
 	var errs []error
 
 	var containerNetworks []string
@@ -324,7 +468,7 @@ func execIptablesRules(ctx context.Context, portMappings nat.PortMap, containerI
 	for portProto, portBindings := range portMappings {
 		for _, portBinding := range portBindings {
 			if portBinding.HostIP == "127.0.0.1" {
-				err := createLoopbackIPtablesRules(
+				err := e.createLoopbackRules(
 					ctx,
 					containerNetworks,
 					containerID,
@@ -362,7 +506,14 @@ func execIptablesRules(ctx context.Context, portMappings nat.PortMap, containerI
 // After the existing rule, the following new rule is added:
 //
 //	DNAT       tcp  --  anywhere             anywhere             tcp dpt:9119 to:10.4.0.22:80.
-func createLoopbackIPtablesRules(ctx context.Context, networks []string, containerID, namespace, pid, port, protocol, destinationPort string) error {
+//
+// With the nftables backend of the CNI portmap plugin, there is no chain per container;
+// the rule is appended once to the shared hostports chain instead, after the plugin's
+// own rule:
+//
+//	ip daddr 127.0.0.1 tcp dport 9119 dnat to 10.4.0.22:80 comment "default-xxxxxx"
+//	tcp dport 9119 dnat to 10.4.0.22:80 comment "default-xxxxxx"
+func (e *EventMonitor) createLoopbackRules(ctx context.Context, networks []string, containerID, namespace, pid, port, protocol, destinationPort string) error {
 	eth0IP, err := extractIPAddress(ctx, pid)
 	if err != nil {
 		return err
@@ -371,11 +522,31 @@ func createLoopbackIPtablesRules(ctx context.Context, networks []string, contain
 	log.Debugf("found the ip address: %s for containerID: %s", eth0IP, containerID)
 	cID := fmt.Sprintf("%s-%s", namespace, containerID)
 
+	rule := iptables.LoopbackRule{
+		Protocol:      protocol,
+		DPort:         destinationPort,
+		ToDestination: fmt.Sprintf("%s:%s", eth0IP, port),
+	}
+
+	if e.loopbackRules.Backend() == iptables.BackendNftables {
+		// The plugin removes the rules commented with the CNI container ID
+		// from the hostports chain on teardown, which includes this one.
+		rule.Table = iptables.CNIHostPortTable
+		rule.Chain = iptables.CNIHostPortsChain
+		rule.Comment = cID
+		if err := e.loopbackRules.Append(ctx, rule); err != nil {
+			return err
+		}
+		log.Debugf("added the following loopback rule %+v for containerID: %s", rule, containerID)
+		return nil
+	}
+
 	var allErrs []error
 
 	// Run the rule per network
 	for _, network := range networks {
-		chainName := cnutils.MustFormatChainNameWithPrefix(network, cID, "DN-")
+		rule.Table = iptables.NATTable
+		rule.Chain = cnutils.MustFormatChainNameWithPrefix(network, cID, "DN-")
 
 		// Instead of modifying the existing rule, a new rule is added that overrides the previous one.
 		// The original rule only allows traffic from anywhere to localhost, but the new rule permits traffic
@@ -388,21 +559,10 @@ func createLoopbackIPtablesRules(ctx context.Context, networks []string, contain
 		// IMPORTANT: Unlike the Docker events API, we do not attempt to delete the rules we create. This is due
 		// to how containerd manages CNI chains. Specifically, containerd deletes the entire CNI chain (e.g., CNI-DN-xxxxxx)
 		// when a container exits or is deleted, which automatically removes any rules appended during container startup.
-		iptableCmd := exec.CommandContext(ctx,
-			"iptables",
-			"--table", "nat",
-			"--append", chainName,
-			"--protocol", protocol,
-			"--destination", "0.0.0.0/0",
-			"--jump", "DNAT",
-			"--dport", destinationPort,
-			"--to-destination", fmt.Sprintf("%s:%s", eth0IP, port))
-		var stderr bytes.Buffer
-		iptableCmd.Stderr = &stderr
-		if err := iptableCmd.Run(); err != nil {
-			allErrs = append(allErrs, fmt.Errorf("running iptables rule [%s] failed: %w - %s", iptableCmd.String(), err, stderr.String()))
+		if err := e.loopbackRules.Append(ctx, rule); err != nil {
+			allErrs = append(allErrs, err)
 		}
-		log.Debugf("running the following loopback rule [%s] in chain: %s for containerID: %s", iptableCmd.String(), chainName, containerID)
+		log.Debugf("running the following loopback rule %+v in chain: %s for containerID: %s", rule, rule.Chain, containerID)
 	}
 
 	if len(allErrs) != 0 {
@@ -478,11 +638,12 @@ func extractIPAddress(ctx context.Context, pid string) (string, error) {
 	return matches[1], nil
 }
 
+// removePortMapping removes the port mapping of a container. It does not
+// check Get first, which does not report a mapping whose bindings all
+// failed to be exposed; Remove also stops retrying those.
 func (e *EventMonitor) removePortMapping(containerID string) {
-	if portMap := e.portTracker.Get(containerID); portMap != nil {
-		if err := e.portTracker.Remove(containerID); err != nil {
-			log.Errorf("failed to remove port mapping for %s: %v", containerID, err)
-		}
+	if err := e.portTracker.Remove(containerID); err != nil {
+		log.Errorf("failed to remove port mapping for %s: %v", containerID, err)
 	}
 }
 
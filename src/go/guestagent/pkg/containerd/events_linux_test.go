@@ -0,0 +1,83 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/policy"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
+)
+
+type nopForwarder struct{}
+
+func (nopForwarder) Send(types.PortMapping) error { return nil }
+
+// A container whose bindings all failed to be exposed has no port mapping
+// according to Get, but its retries must still stop once it exits.
+func TestForgetContainerWithFailedExpose(t *testing.T) {
+	var mutex sync.Mutex
+	exposeAttempts := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services/forwarder/expose", func(w http.ResponseWriter, _ *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		exposeAttempts++
+		http.Error(w, "host-switch is not ready", http.StatusServiceUnavailable)
+	})
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	apiTracker := tracker.NewAPITracker(ctx, nopForwarder{}, testSrv.URL, "192.168.127.2", true)
+	go apiTracker.ProcessRetries()
+
+	e := &EventMonitor{
+		portTracker: apiTracker,
+		paused:      make(map[string]bool),
+	}
+	id := trackerID("default", "container")
+	err := apiTracker.AddWorkload(id, policy.Workload{}, nat.PortMap{
+		"80/tcp": []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: "80"}},
+	})
+	require.ErrorIs(t, err, tracker.ErrRetryScheduled)
+	require.Nil(t, apiTracker.Get(id))
+	require.Len(t, apiTracker.Status(), 1)
+
+	e.forgetContainer(id)
+	assert.Empty(t, apiTracker.Status())
+
+	mutex.Lock()
+	attempts := exposeAttempts
+	mutex.Unlock()
+
+	time.Sleep(2 * time.Second)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, attempts, exposeAttempts)
+}
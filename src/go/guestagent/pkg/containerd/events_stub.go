@@ -19,13 +19,14 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/iptables"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
 )
 
 type EventMonitor struct {
 }
 
-func NewEventMonitor(containerdSock string, portTracker tracker.Tracker) (*EventMonitor, error) {
+func NewEventMonitor(containerdSock string, monitoredNamespaces []string, portTracker tracker.Tracker, loopbackRules iptables.LoopbackRules) (*EventMonitor, error) {
 	panic("not implement for non-Linux")
 }
 
@@ -19,17 +19,20 @@ import (
 	"context"
 	"fmt"
 	"os/exec"
+	"reflect"
 	"strconv"
 	"strings"
 
 	"github.com/Masterminds/log-go"
-	"github.com/docker/docker/api/types"
 	containerapi "github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
 	"github.com/docker/go-connections/nat"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/iptables"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/metrics"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/policy"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/utils"
 )
@@ -39,14 +42,22 @@ import (
 type EventMonitor struct {
 	dockerClient *client.Client
 	portTracker  tracker.Tracker
-	// map of containerID to iptables rule entry to remove from DOCKER chain
-	iptablesRulesToDelete map[string]*exec.Cmd
+	// loopbackRules adds the DNAT rules for ports published on localhost.
+	loopbackRules iptables.LoopbackRules
+	// map of containerID to loopback rules to remove from the DOCKER chain,
+	// or from the agent's loopback chain with nftables
+	loopbackRulesToDelete map[string][]iptables.LoopbackRule
+	// requested are the port mappings last added to the tracker, by
+	// container ID. The tracker only reports the bindings it forwarded,
+	// without the rejected, failed or remapped ones, so a container is
+	// compared against what it requested instead.
+	requested map[string]nat.PortMap
 }
 
 // NewEventMonitor creates and returns a new Event Monitor for
 // Docker's event API. Caller is responsible to make sure that
 // Docker engine is up and running.
-func NewEventMonitor(portTracker tracker.Tracker) (*EventMonitor, error) {
+func NewEventMonitor(portTracker tracker.Tracker, loopbackRules iptables.LoopbackRules) (*EventMonitor, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return nil, err
@@ -55,19 +66,27 @@ func NewEventMonitor(portTracker tracker.Tracker) (*EventMonitor, error) {
 	return &EventMonitor{
 		dockerClient:          cli,
 		portTracker:           portTracker,
-		iptablesRulesToDelete: make(map[string]*exec.Cmd),
+		loopbackRules:         loopbackRules,
+		loopbackRulesToDelete: make(map[string][]iptables.LoopbackRule),
+		requested:             make(map[string]nat.PortMap),
 	}, nil
 }
 
 // MonitorPorts scans Docker's event stream API
-// for container start/stop events.
+// for container start/stop/update/rename events, and
+// for containers connecting to or disconnecting from networks.
 func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 	msgCh, errCh := e.dockerClient.Events(ctx, events.ListOptions{
 		Filters: filters.NewArgs(
-			filters.Arg("type", string(types.ContainerObject)),
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("type", string(events.NetworkEventType)),
 			filters.Arg("event", string(events.ActionStart)),
 			filters.Arg("event", string(events.ActionStop)),
-			filters.Arg("event", string(events.ActionDie))),
+			filters.Arg("event", string(events.ActionDie)),
+			filters.Arg("event", string(events.ActionUpdate)),
+			filters.Arg("event", string(events.ActionRename)),
+			filters.Arg("event", string(events.ActionConnect)),
+			filters.Arg("event", string(events.ActionDisconnect))),
 	})
 
 	if err := e.initializeRunningContainers(ctx); err != nil {
@@ -81,6 +100,19 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 
 			return
 		case event := <-msgCh:
+			if event.Type == events.NetworkEventType {
+				// The actor of a network event is the network; the
+				// container is one of its attributes.
+				containerID := event.Actor.Attributes["container"]
+				log.Debugf("received a network event: {Status: %+v Network: %+v ContainerID: %+v}",
+					event.Action,
+					event.Actor.ID,
+					containerID)
+				e.reconcileContainer(ctx, containerID)
+
+				continue
+			}
+
 			container, err := e.dockerClient.ContainerInspect(ctx, event.Actor.ID)
 			if err != nil {
 				log.Errorf("inspecting container [%v] failed: %s", event.Actor.ID, err)
@@ -97,7 +129,8 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 			case events.ActionStart:
 				if len(container.NetworkSettings.Ports) != 0 {
 					validatePortMapping(container.NetworkSettings.Ports)
-					err = e.portTracker.Add(container.ID, container.NetworkSettings.Ports)
+					err = e.portTracker.AddWorkload(container.ID, containerWorkload(container), container.NetworkSettings.Ports)
+					e.requested[container.ID] = container.NetworkSettings.Ports
 					if err != nil {
 						log.Errorf("adding port mapping to tracker failed: %s", err)
 					}
@@ -109,24 +142,81 @@ func (e *EventMonitor) MonitorPorts(ctx context.Context) {
 				if err != nil {
 					log.Errorf("remove port mapping from tracker failed: %s", err)
 				}
-				if deleteIptablesCmd, ok := e.iptablesRulesToDelete[container.ID]; ok {
-					log.Debugf("removing the following rules from iptables: %s", deleteIptablesCmd.String())
-					var stderr bytes.Buffer
-					deleteIptablesCmd.Stderr = &stderr
-					if err := deleteIptablesCmd.Run(); err != nil {
-						log.Errorf("deleting loopback iptables rule failed: %s [%s]", err, stderr.String())
-					}
-					delete(e.iptablesRulesToDelete, container.ID)
-				}
+				delete(e.requested, container.ID)
+				e.deleteLoopbackRules(ctx, container.ID)
+			case events.ActionUpdate, events.ActionRename:
+				e.reconcileContainer(ctx, container.ID)
 			}
 		case err := <-errCh:
 			log.Errorf("receiving container event failed: %s", err)
+			metrics.EventStreamReconnects.Inc("docker")
 
 			return
 		}
 	}
 }
 
+// reconcileContainer inspects a running container again and brings its
+// port mapping and loopback rules in line with its current ports and
+// networks, e.g. after it was connected to or disconnected from a network.
+func (e *EventMonitor) reconcileContainer(ctx context.Context, containerID string) {
+	container, err := e.dockerClient.ContainerInspect(ctx, containerID)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			log.Debugf("container [%v] no longer exists, nothing to reconcile", containerID)
+			return
+		}
+		log.Errorf("inspecting container [%v] failed: %s", containerID, err)
+
+		return
+	}
+	// A stopping container is disconnected from its networks; the stop
+	// and die events take care of it.
+	if container.State == nil || !container.State.Running {
+		return
+	}
+
+	ports := container.NetworkSettings.Ports
+	validatePortMapping(ports)
+	log.Debugf("reconciling container [%v] ports: %+v networks: %d", container.ID, ports, len(container.NetworkSettings.Networks))
+
+	if requested, ok := e.requested[container.ID]; !ok || !reflect.DeepEqual(ports, requested) {
+		if err := e.portTracker.Remove(container.ID); err != nil {
+			log.Errorf("remove port mapping from tracker failed: %s", err)
+		}
+		delete(e.requested, container.ID)
+		if len(ports) != 0 {
+			if err := e.portTracker.AddWorkload(container.ID, containerWorkload(container), ports); err != nil {
+				log.Errorf("adding port mapping to tracker failed: %s", err)
+			}
+			e.requested[container.ID] = ports
+		}
+	}
+
+	// The rules point to the address of the container in each of its
+	// networks, so they are created again for the current ones, the same
+	// way as when it starts.
+	e.deleteLoopbackRules(ctx, container.ID)
+	if len(ports) != 0 && len(container.NetworkSettings.Networks) != 0 {
+		e.createIptablesRuleForContainer(ctx, container)
+	}
+}
+
+// deleteLoopbackRules removes the loopback rules created for a container.
+func (e *EventMonitor) deleteLoopbackRules(ctx context.Context, containerID string) {
+	rules, ok := e.loopbackRulesToDelete[containerID]
+	if !ok {
+		return
+	}
+	for _, rule := range rules {
+		log.Debugf("removing the following loopback rule from %s: %+v", e.loopbackRules.Backend(), rule)
+		if err := e.loopbackRules.Delete(ctx, rule); err != nil {
+			log.Errorf("deleting loopback %s rule failed: %s", e.loopbackRules.Backend(), err)
+		}
+	}
+	delete(e.loopbackRulesToDelete, containerID)
+}
+
 // Flush clears all the container port mappings
 // out of the port tracker upon shutdown.
 func (e *EventMonitor) Flush() {
@@ -161,7 +251,9 @@ func (e *EventMonitor) initializeRunningContainers(ctx context.Context) error {
 
 				continue
 			}
-			if err := e.portTracker.Add(container.ID, portMap); err != nil {
+			err = e.portTracker.AddWorkload(container.ID, policy.Workload{Labels: container.Labels}, portMap)
+			e.requested[container.ID] = portMap
+			if err != nil {
 				log.Errorf("registering already running containers failed: %v", err)
 				continue
 			}
@@ -177,6 +269,15 @@ func (e *EventMonitor) initializeRunningContainers(ctx context.Context) error {
 	return nil
 }
 
+// containerWorkload returns the workload of an inspected container
+// for the port forwarding policy.
+func containerWorkload(container containerapi.InspectResponse) policy.Workload {
+	if container.Config == nil {
+		return policy.Workload{}
+	}
+	return policy.Workload{Labels: container.Config.Labels}
+}
+
 func createPortMapping(ports []containerapi.Port) (nat.PortMap, error) {
 	portMap := make(nat.PortMap)
 
@@ -225,6 +326,12 @@ func validatePortMapping(portMap nat.PortMap) {
 //
 // This is required because traffic is routed via the vm-switch over the tap network.
 //
+// With the nftables backend, the rule goes into the loopback chain of the guest agent's
+// own "ip rancher-desktop" table rather than into the DOCKER chain, which iptables-nft
+// owns. That chain is reached from the nat prerouting and output hooks for local
+// destinations, as the DOCKER chain is, and the rule is tagged with the container ID so
+// that it can be found again on removal.
+//
 // The existing DNAT rule is as follows:
 //
 //	DNAT       tcp  --  anywhere             localhost            tcp dpt:9119 to:10.4.0.22:80.
@@ -240,27 +347,23 @@ func (e *EventMonitor) createLoopbackIPtablesRules(ctx context.Context, containe
 			if portBinding.HostIP != "127.0.0.1" {
 				continue
 			}
-			//nolint:gosec // no security concern with the potentially tainted command arguments
-			iptableCmd := exec.CommandContext(ctx,
-				"iptables",
-				"--table", "nat",
-				"--append", "DOCKER",
-				"--protocol", portProto.Proto(),
-				"--destination", "0.0.0.0/0",
-				"--jump", "DNAT",
-				"--dport", portBinding.HostPort,
-				"--to-destination", fmt.Sprintf("%s:%s", containerIP, portProto.Port()))
-			var stderr bytes.Buffer
-			iptableCmd.Stderr = &stderr
-			if err := iptableCmd.Run(); err != nil {
-				errs = append(errs, fmt.Errorf("creating loopback rule in DOCKER chain failed: %w [%s]", err, stderr.String()))
-				log.Debugf("running the following iptables rule [%s] with the error(s):[%v]", iptableCmd.String(), errs)
+			rule := iptables.LoopbackRule{
+				Table:         iptables.NATTable,
+				Chain:         "DOCKER",
+				Protocol:      portProto.Proto(),
+				DPort:         portBinding.HostPort,
+				ToDestination: fmt.Sprintf("%s:%s", containerIP, portProto.Port()),
+				Comment:       containerID,
 			}
-			e.iptablesRulesToDelete[containerID] = iptablesDeleteLoopbackRuleCmd(
-				ctx,
-				portProto.Proto(),
-				portBinding.HostPort,
-				fmt.Sprintf("%s:%s", containerIP, portProto.Port()))
+			if e.loopbackRules.Backend() == iptables.BackendNftables {
+				rule.Table = iptables.AgentTable
+				rule.Chain = iptables.AgentLoopbackChain
+			}
+			if err := e.loopbackRules.Append(ctx, rule); err != nil {
+				errs = append(errs, fmt.Errorf("creating loopback rule in %s chain failed: %w", rule.Chain, err))
+				log.Debugf("adding the following loopback rule %+v with the error(s):[%v]", rule, errs)
+			}
+			e.loopbackRulesToDelete[containerID] = append(e.loopbackRulesToDelete[containerID], rule)
 		}
 	}
 
@@ -277,8 +380,10 @@ func (e *EventMonitor) createIptablesRuleForContainer(ctx context.Context, conta
 	// configure the loopback address for each container's assigned IP address.
 	if len(container.NetworkSettings.Networks) != 0 {
 		// delete the IPv6 rule first
-		if err := deleteComposeNetworkIPv6Rule(ctx, container.NetworkSettings.Ports); err != nil {
-			log.Errorf("removing docker compose IPv6 rule from DOCKER chain failed: %v", err)
+		if e.loopbackRules.Backend() == iptables.BackendIptables {
+			if err := deleteComposeNetworkIPv6Rule(ctx, container.NetworkSettings.Ports); err != nil {
+				log.Errorf("removing docker compose IPv6 rule from DOCKER chain failed: %v", err)
+			}
 		}
 		for networkName, network := range container.NetworkSettings.Networks {
 			err := e.createLoopbackIPtablesRules(
@@ -307,18 +412,6 @@ func (e *EventMonitor) createIptablesRuleForContainer(ctx context.Context, conta
 	}
 }
 
-func iptablesDeleteLoopbackRuleCmd(ctx context.Context, protocol, dport, toDestination string) *exec.Cmd {
-	return exec.CommandContext(ctx,
-		"iptables",
-		"--table", "nat",
-		"--delete", "DOCKER",
-		"--protocol", protocol,
-		"--destination", "0.0.0.0/0",
-		"--jump", "DNAT",
-		"--dport", dport,
-		"--to-destination", toDestination)
-}
-
 // Docker Compose, by default, creates the following rules in the DOCKER chain:
 //
 //	DNAT       tcp  --  anywhere             localhost            tcp dpt:80 to:172.18.0.2:80
@@ -335,6 +428,9 @@ func iptablesDeleteLoopbackRuleCmd(ctx context.Context, protocol, dport, toDesti
 // Note: Even if the `enable_ipv6` property is set to `false` in Docker's compose configuration,
 // Docker still creates the wildcard IPv6 rule in iptables. Therefore, we need to manually
 // remove it to avoid this issue.
+//
+// This is only done with the iptables backend: nftables can only delete a rule by its
+// handle, and Docker's rule carries no comment that would identify it.
 func deleteComposeNetworkIPv6Rule(ctx context.Context, portMappings nat.PortMap) error {
 	var errs []error
 
@@ -23,20 +23,39 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/Masterminds/log-go"
 	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/metrics"
+	guestagentTypes "github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
 )
 
 const (
+	allAPI      = "/services/forwarder/all"
 	exposeAPI   = "/services/forwarder/expose"
 	unexposeAPI = "/services/forwarder/unexpose"
 )
 
+const (
+	// batchSize is the number of requests sent in a single batch call.
+	batchSize = 64
+	// batchConcurrency bounds the host-switch calls in flight for a batch.
+	batchConcurrency = 4
+)
+
 var (
 	ErrAPI         = errors.New("error from API")
 	ErrExposeAPI   = fmt.Errorf("error from %s API", exposeAPI)
 	ErrUnexposeAPI = fmt.Errorf("error from %s API", unexposeAPI)
+	ErrAllAPI      = fmt.Errorf("error from %s API", allAPI)
+
+	// errBatchUnsupported means the host-switch predates the batch
+	// endpoints.
+	errBatchUnsupported = errors.New("batch API is not supported")
 )
 
 // APIForwarder forwards the PortMappings to /services/forwarder/expose
@@ -44,6 +63,9 @@ var (
 type APIForwarder struct {
 	baseURL    string
 	httpClient *http.Client
+	// batchUnsupported is set once the host-switch rejected a batch call,
+	// after which the batches are sent as single calls.
+	batchUnsupported atomic.Bool
 }
 
 // NewAPIForwarder returns a new instance of APIForwarder.
@@ -55,7 +77,9 @@ func NewAPIForwarder(baseURL string) *APIForwarder {
 }
 
 // Expose calls /services/forwarder/expose with a given portMappings.
-func (a *APIForwarder) Expose(exposeReq *types.ExposeRequest) error {
+func (a *APIForwarder) Expose(exposeReq *types.ExposeRequest) (err error) {
+	defer observe("expose", time.Now(), &err)
+
 	bin, err := json.Marshal(exposeReq)
 	if err != nil {
 		return err
@@ -80,7 +104,9 @@ func (a *APIForwarder) Expose(exposeReq *types.ExposeRequest) error {
 }
 
 // Unexpose calls /services/forwarder/unexpose with a given portMappings.
-func (a *APIForwarder) Unexpose(unexposeReq *types.UnexposeRequest) error {
+func (a *APIForwarder) Unexpose(unexposeReq *types.UnexposeRequest) (err error) {
+	defer observe("unexpose", time.Now(), &err)
+
 	bin, err := json.Marshal(unexposeReq)
 	if err != nil {
 		return err
@@ -104,6 +130,160 @@ func (a *APIForwarder) Unexpose(unexposeReq *types.UnexposeRequest) error {
 	return verifyResponseBody(res)
 }
 
+// ExposeBatch exposes each of the requests and returns their errors, in
+// the same order. The requests are sent in batches, or one by one if the
+// host-switch does not support batching, with at most batchConcurrency
+// calls in flight.
+func (a *APIForwarder) ExposeBatch(exposeReqs []*types.ExposeRequest) []error {
+	return sendBatch(a, "exposeBatch", guestagentTypes.ExposeBatchAPI, exposeReqs, a.Expose)
+}
+
+// UnexposeBatch is like ExposeBatch, unexposing each of the requests.
+func (a *APIForwarder) UnexposeBatch(unexposeReqs []*types.UnexposeRequest) []error {
+	return sendBatch(a, "unexposeBatch", guestagentTypes.UnexposeBatchAPI, unexposeReqs, a.Unexpose)
+}
+
+func sendBatch[T any](a *APIForwarder, operation, api string, reqs []T, single func(T) error) []error {
+	errs := make([]error, len(reqs))
+	var group errgroup.Group
+	group.SetLimit(batchConcurrency)
+
+	// A single request does not need the batch endpoint.
+	if len(reqs) == 1 || a.batchUnsupported.Load() {
+		for i, req := range reqs {
+			group.Go(func() error {
+				errs[i] = single(req)
+				return nil
+			})
+		}
+		_ = group.Wait()
+		return errs
+	}
+
+	for start := 0; start < len(reqs); start += batchSize {
+		end := min(start+batchSize, len(reqs))
+		group.Go(func() error {
+			if !a.batchUnsupported.Load() {
+				batchErrs, err := a.postBatch(operation, api, reqs[start:end], end-start)
+				if err == nil {
+					copy(errs[start:end], batchErrs)
+					return nil
+				}
+				if !errors.Is(err, errBatchUnsupported) {
+					for i := start; i < end; i++ {
+						errs[i] = err
+					}
+					return nil
+				}
+				if !a.batchUnsupported.Swap(true) {
+					log.Infof("the host-switch does not support %s, falling back to single calls", api)
+				}
+			}
+			for i := start; i < end; i++ {
+				errs[i] = single(reqs[i])
+			}
+			return nil
+		})
+	}
+	_ = group.Wait()
+	return errs
+}
+
+// postBatch calls a batch endpoint with count requests and returns the
+// error of each request.
+func (a *APIForwarder) postBatch(operation, api string, reqs any, count int) (errs []error, err error) {
+	start := time.Now()
+	defer func() {
+		if !errors.Is(err, errBatchUnsupported) {
+			observe(operation, start, &err)
+		}
+	}()
+
+	bin, err := json.Marshal(reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("sending a HTTP POST to %s API with batch request: %s", api, bin)
+	req, err := http.NewRequestWithContext(
+		context.Background(),
+		http.MethodPost,
+		a.urlBuilder(api),
+		bytes.NewReader(bin))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return nil, errBatchUnsupported
+	default:
+		return nil, verifyResponseBody(res)
+	}
+
+	var results []guestagentTypes.BatchResult
+	if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+		return nil, fmt.Errorf("decoding %s response: %w", api, err)
+	}
+	errs = make([]error, len(results))
+	for i, result := range results {
+		if result.Error != "" {
+			errs[i] = fmt.Errorf("%w: %s", ErrAPI, result.Error)
+		}
+	}
+	if len(results) != count {
+		return nil, fmt.Errorf("%w: %s returned %d results for %d requests", ErrAPI, api, len(results), count)
+	}
+	return errs, nil
+}
+
+// All calls /services/forwarder/all and returns every port
+// currently exposed by the host-switch.
+func (a *APIForwarder) All() (exposed []types.ExposeRequest, err error) {
+	defer observe("all", time.Now(), &err)
+
+	log.Debugf("sending a HTTP GET to %s API", allAPI)
+	req, err := http.NewRequestWithContext(
+		context.Background(),
+		http.MethodGet,
+		a.urlBuilder(allAPI),
+		http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, verifyResponseBody(res)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&exposed); err != nil {
+		return nil, fmt.Errorf("decoding %s response: %w", allAPI, err)
+	}
+
+	return exposed, nil
+}
+
+// observe records the latency and the outcome of a host-switch API call.
+func observe(operation string, start time.Time, err *error) {
+	metrics.HostSwitchRequestDuration.Observe(time.Since(start).Seconds(), operation)
+	if *err != nil {
+		metrics.HostSwitchRequestFailures.Inc(operation)
+	}
+}
+
 func (a *APIForwarder) urlBuilder(api string) string {
 	return a.baseURL + api
 }
@@ -0,0 +1,124 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwarder_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/forwarder"
+	guestagentTypes "github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
+)
+
+const takenLocal = "127.0.0.1:8042"
+
+func exposeRequests(count int) []*types.ExposeRequest {
+	reqs := make([]*types.ExposeRequest, count)
+	for i := range reqs {
+		reqs[i] = &types.ExposeRequest{
+			Local:    fmt.Sprintf("127.0.0.1:%d", 8000+i),
+			Remote:   fmt.Sprintf("192.168.127.2:%d", 8000+i),
+			Protocol: types.TCP,
+		}
+	}
+	return reqs
+}
+
+// exposeHandler fails to expose takenLocal, like the host-switch does when
+// the port is in use.
+func exposeHandler(calls *atomic.Int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req types.ExposeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Local == takenLocal {
+			http.Error(w, "address already in use", http.StatusInternalServerError)
+		}
+	}
+}
+
+func TestExposeBatch(t *testing.T) {
+	t.Parallel()
+
+	var batchCalls, singleCalls atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services/forwarder/expose", exposeHandler(&singleCalls))
+	mux.HandleFunc(guestagentTypes.ExposeBatchAPI, func(w http.ResponseWriter, r *http.Request) {
+		batchCalls.Add(1)
+		var reqs []types.ExposeRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&reqs))
+		results := make([]guestagentTypes.BatchResult, len(reqs))
+		for i, req := range reqs {
+			if req.Local == takenLocal {
+				results[i].Error = "address already in use"
+			}
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(results))
+	})
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	// More requests than fit in a single batch.
+	reqs := exposeRequests(100)
+	errs := forwarder.NewAPIForwarder(testSrv.URL).ExposeBatch(reqs)
+	require.Len(t, errs, len(reqs))
+	for i, err := range errs {
+		if reqs[i].Local == takenLocal {
+			require.ErrorIs(t, err, forwarder.ErrAPI)
+			assert.ErrorContains(t, err, "address already in use")
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+	assert.Equal(t, int32(2), batchCalls.Load())
+	assert.Zero(t, singleCalls.Load())
+}
+
+func TestExposeBatchFallback(t *testing.T) {
+	t.Parallel()
+
+	// A host-switch without the batch endpoints.
+	var singleCalls atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services/forwarder/expose", exposeHandler(&singleCalls))
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	apiForwarder := forwarder.NewAPIForwarder(testSrv.URL)
+	for _, count := range []int{100, 10} {
+		singleCalls.Store(0)
+		reqs := exposeRequests(count)
+		errs := apiForwarder.ExposeBatch(reqs)
+		require.Len(t, errs, count)
+		for i, err := range errs {
+			if reqs[i].Local == takenLocal {
+				assert.ErrorContains(t, err, "address already in use")
+			} else {
+				assert.NoError(t, err)
+			}
+		}
+		assert.Equal(t, int32(count), singleCalls.Load())
+	}
+}
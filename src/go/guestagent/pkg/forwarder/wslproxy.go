@@ -18,20 +18,57 @@ package forwarder
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/Masterminds/log-go"
+
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
 )
 
+// wslProxyTimeout bounds waiting for a response from wsl-proxy.
+const wslProxyTimeout = 10 * time.Second
+
+var (
+	// ErrWSLProxyBinding is returned by Send when wsl-proxy could not add
+	// or remove some of the bindings.
+	ErrWSLProxyBinding = errors.New("wsl-proxy failed to apply port bindings")
+	// ErrWSLProxyRequest is returned by Send when wsl-proxy rejected the
+	// request as a whole.
+	ErrWSLProxyRequest = errors.New("wsl-proxy rejected the request")
+
+	// errLegacyWSLProxy means wsl-proxy hung up on the hello request, as
+	// the versions that only know the one-shot format do.
+	errLegacyWSLProxy = errors.New("wsl-proxy does not support sessions")
+)
+
 // WSLProxyForwarder forwards the PortMappings to Rancher Desktop WSLProxy process in
 // the default namespace over the unix socket.
 // For more information on Rancher Desktop WSL Proxy, refer to the source code at:
 // https://github.com/rancher-sandbox/rancher-desktop/blob/main/src/go/networking/cmd/proxy/wsl_integration_linux.go
+//
+// The port mappings are sent over a long-lived session (see
+// types.WSLProxyProtocolVersion), where wsl-proxy acknowledges each binding.
+// Every time the session is established, the state set by SetState is
+// resynced. If wsl-proxy only supports the one-shot format, the forwarder
+// falls back to it, without acknowledgements.
 type WSLProxyForwarder struct {
 	ctx         context.Context
 	dialer      net.Dialer
 	proxySocket string
+
+	mutex   sync.Mutex
+	conn    net.Conn
+	encoder *json.Encoder
+	decoder *json.Decoder
+	nextID  uint64
+	legacy  bool
+	state   func() types.PortMapping
 }
 
 func NewWSLProxyForwarder(ctx context.Context, proxySocket string) *WSLProxyForwarder {
@@ -42,8 +79,183 @@ func NewWSLProxyForwarder(ctx context.Context, proxySocket string) *WSLProxyForw
 	}
 }
 
-// Send forwards the port mappings to WSL Proxy.
+// SetState sets the function returning every binding that wsl-proxy should
+// have, which is resynced when the session is established.
+func (v *WSLProxyForwarder) SetState(state func() types.PortMapping) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.state = state
+}
+
+// Send forwards the port mappings to WSL Proxy. Consecutive bindings are
+// sent as ranges on the session; the one-shot format predates ranges, so
+// they are sent one by one to an older wsl-proxy. If the session is
+// broken, Send reconnects once.
 func (v *WSLProxyForwarder) Send(portMapping types.PortMapping) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.legacy {
+		return v.sendOneShot(portMapping)
+	}
+
+	for attempt := 0; ; attempt++ {
+		if v.conn == nil {
+			err := v.connect()
+			if errors.Is(err, errLegacyWSLProxy) {
+				log.Infof("%s, falling back to the one-shot format", err)
+				v.legacy = true
+				return v.sendOneShot(portMapping)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		compacted := portMapping.Compact()
+		resp, err := v.request(types.WSLProxyUpdate, &compacted)
+		if err != nil {
+			v.disconnect()
+			if attempt == 0 {
+				log.Debugf("wsl-proxy session failed, reconnecting: %s", err)
+				continue
+			}
+			return err
+		}
+		return responseError(resp)
+	}
+}
+
+// Maintain checks the session every interval until the context is
+// cancelled, and establishes it again if it is down, so that a restarted
+// wsl-proxy gets the state resynced without waiting for the next port
+// mapping change.
+func (v *WSLProxyForwarder) Maintain(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-v.ctx.Done():
+			_ = v.Close()
+			return
+		case <-ticker.C:
+			v.ping()
+		}
+	}
+}
+
+func (v *WSLProxyForwarder) ping() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.legacy {
+		return
+	}
+	if v.conn != nil {
+		_, err := v.request(types.WSLProxyPing, nil)
+		if err == nil {
+			return
+		}
+		log.Infof("wsl-proxy session is down, reconnecting: %s", err)
+		v.disconnect()
+	}
+	err := v.connect()
+	if errors.Is(err, errLegacyWSLProxy) {
+		log.Infof("%s, falling back to the one-shot format", err)
+		v.legacy = true
+		return
+	}
+	if err != nil {
+		log.Debugf("connecting to wsl-proxy failed: %s", err)
+	}
+}
+
+// connect establishes the session and resyncs the state.
+func (v *WSLProxyForwarder) connect() error {
+	conn, err := v.dialer.DialContext(v.ctx, "unix", v.proxySocket)
+	if err != nil {
+		return err
+	}
+	v.conn = conn
+	v.encoder = json.NewEncoder(conn)
+	v.decoder = json.NewDecoder(conn)
+
+	resp, err := v.request(types.WSLProxyHello, nil)
+	if err != nil {
+		v.disconnect()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+			return errLegacyWSLProxy
+		}
+		return fmt.Errorf("wsl-proxy hello failed: %w", err)
+	}
+	if resp.Error != "" {
+		v.disconnect()
+		return fmt.Errorf("%w: %s", ErrWSLProxyRequest, resp.Error)
+	}
+	log.Infof("connected to wsl-proxy with protocol version %d", resp.Version)
+
+	if v.state == nil {
+		return nil
+	}
+	state := v.state().Compact()
+	resp, err = v.request(types.WSLProxyResync, &state)
+	if err != nil {
+		v.disconnect()
+		return fmt.Errorf("wsl-proxy resync failed: %w", err)
+	}
+	// The failed bindings are retried with the updates of their owners.
+	if err := responseError(resp); err != nil {
+		log.Warnf("wsl-proxy resync: %s", err)
+	}
+	return nil
+}
+
+// request sends a request on the session and waits for its response.
+func (v *WSLProxyForwarder) request(requestType types.WSLProxyRequestType, portMapping *types.PortMapping) (types.WSLProxyResponse, error) {
+	v.nextID++
+	req := types.WSLProxyRequest{
+		Type:        requestType,
+		ID:          v.nextID,
+		PortMapping: portMapping,
+	}
+	if requestType == types.WSLProxyHello {
+		req.Version = types.WSLProxyProtocolVersion
+	}
+
+	var resp types.WSLProxyResponse
+	if err := v.conn.SetDeadline(time.Now().Add(wslProxyTimeout)); err != nil {
+		return resp, err
+	}
+	if err := v.encoder.Encode(req); err != nil {
+		return resp, err
+	}
+	if err := v.decoder.Decode(&resp); err != nil {
+		return resp, err
+	}
+	if resp.ID != req.ID {
+		return resp, fmt.Errorf("wsl-proxy replied to request %d instead of %d", resp.ID, req.ID)
+	}
+	return resp, v.conn.SetDeadline(time.Time{})
+}
+
+func (v *WSLProxyForwarder) disconnect() {
+	if v.conn != nil {
+		_ = v.conn.Close()
+	}
+	v.conn = nil
+	v.encoder = nil
+	v.decoder = nil
+}
+
+// sendOneShot sends the port mapping in the one-shot format, where
+// wsl-proxy hangs up without a response. Any range is expanded, as such a
+// wsl-proxy ignores Ranges.
+func (v *WSLProxyForwarder) sendOneShot(portMapping types.PortMapping) error {
+	if len(portMapping.Ranges) != 0 {
+		portMapping.Ports = portMapping.AllPorts()
+		portMapping.Ranges = nil
+	}
 	conn, err := v.dialer.DialContext(v.ctx, "unix", v.proxySocket)
 	if err != nil {
 		return err
@@ -57,3 +269,28 @@ func (v *WSLProxyForwarder) Send(portMapping types.PortMapping) error {
 
 	return nil
 }
+
+// Close closes the session.
+func (v *WSLProxyForwarder) Close() error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.disconnect()
+	return nil
+}
+
+// responseError returns the failures reported by a response.
+func responseError(resp types.WSLProxyResponse) error {
+	if resp.Error != "" {
+		return fmt.Errorf("%w: %s", ErrWSLProxyRequest, resp.Error)
+	}
+	var errs []error
+	for _, result := range resp.Results {
+		if result.Error != "" {
+			errs = append(errs, fmt.Errorf("%s %+v: %s", result.Port, result.Binding, result.Error))
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("%w: %+v", ErrWSLProxyBinding, errs)
+	}
+	return nil
+}
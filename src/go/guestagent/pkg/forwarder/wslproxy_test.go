@@ -0,0 +1,238 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwarder_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/forwarder"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
+)
+
+// failingHostPort is a host port the fake wsl-proxy fails to bind.
+const failingHostPort = "9999"
+
+// fakeWSLProxy records the requests it receives. In session mode it
+// answers them like wsl-proxy does; otherwise it behaves like the versions
+// that only know the one-shot format.
+type fakeWSLProxy struct {
+	listener net.Listener
+	session  bool
+
+	mutex    sync.Mutex
+	requests []types.WSLProxyRequest
+	// oneShot holds the port mappings sent in the one-shot format.
+	oneShot []types.PortMapping
+	conns   []net.Conn
+}
+
+func newFakeWSLProxy(t *testing.T, session bool) (*fakeWSLProxy, string) {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "wsl-proxy.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	proxy := &fakeWSLProxy{listener: listener, session: session}
+	go proxy.serve()
+	return proxy, socket
+}
+
+func (f *fakeWSLProxy) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.mutex.Lock()
+		f.conns = append(f.conns, conn)
+		f.mutex.Unlock()
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeWSLProxy) handle(conn net.Conn) {
+	defer conn.Close()
+	decoder := json.NewDecoder(conn)
+	encoder := json.NewEncoder(conn)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return
+		}
+		var req types.WSLProxyRequest
+		if err := json.Unmarshal(raw, &req); err != nil {
+			return
+		}
+		f.mutex.Lock()
+		if req.Type == "" {
+			var pm types.PortMapping
+			_ = json.Unmarshal(raw, &pm)
+			f.oneShot = append(f.oneShot, pm)
+		} else {
+			f.requests = append(f.requests, req)
+		}
+		f.mutex.Unlock()
+		if !f.session {
+			return
+		}
+
+		resp := types.WSLProxyResponse{ID: req.ID}
+		switch req.Type {
+		case types.WSLProxyHello:
+			resp.Version = types.WSLProxyProtocolVersion
+		case types.WSLProxyUpdate, types.WSLProxyResync:
+			for portProto, portBindings := range req.PortMapping.AllPorts() {
+				for _, portBinding := range portBindings {
+					result := types.BindingResult{Port: portProto, Binding: portBinding}
+					if portBinding.HostPort == failingHostPort {
+						result.Error = "address already in use"
+					}
+					resp.Results = append(resp.Results, result)
+				}
+			}
+		}
+		if err := encoder.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// received returns the requests received so far, and clears them.
+func (f *fakeWSLProxy) received() []types.WSLProxyRequest {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	requests := f.requests
+	f.requests = nil
+	return requests
+}
+
+// restart drops the connections, as if wsl-proxy restarted.
+func (f *fakeWSLProxy) restart() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	f.conns = nil
+}
+
+func requestTypes(requests []types.WSLProxyRequest) []types.WSLProxyRequestType {
+	var result []types.WSLProxyRequestType
+	for _, req := range requests {
+		result = append(result, req.Type)
+	}
+	return result
+}
+
+func portMapping(hostPort string) types.PortMapping {
+	return types.PortMapping{
+		Ports: nat.PortMap{
+			nat.Port(hostPort + "/tcp"): {{HostIP: "127.0.0.1", HostPort: hostPort}},
+		},
+	}
+}
+
+func TestWSLProxySession(t *testing.T) {
+	t.Parallel()
+
+	proxy, socket := newFakeWSLProxy(t, true)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wslProxyForwarder := forwarder.NewWSLProxyForwarder(ctx, socket)
+	defer wslProxyForwarder.Close()
+	state := portMapping("6443")
+	wslProxyForwarder.SetState(func() types.PortMapping { return state })
+
+	require.NoError(t, wslProxyForwarder.Send(portMapping("80")))
+	requests := proxy.received()
+	require.Equal(t, []types.WSLProxyRequestType{types.WSLProxyHello, types.WSLProxyResync, types.WSLProxyUpdate}, requestTypes(requests))
+	assert.Equal(t, types.WSLProxyProtocolVersion, requests[0].Version)
+	assert.Equal(t, state.Ports, requests[1].PortMapping.AllPorts())
+	assert.Equal(t, portMapping("80").Ports, requests[2].PortMapping.AllPorts())
+
+	// The session is reused, and the failed bindings are reported.
+	err := wslProxyForwarder.Send(portMapping(failingHostPort))
+	require.ErrorIs(t, err, forwarder.ErrWSLProxyBinding)
+	assert.ErrorContains(t, err, "address already in use")
+	assert.Equal(t, []types.WSLProxyRequestType{types.WSLProxyUpdate}, requestTypes(proxy.received()))
+
+	// After wsl-proxy restarts, the state is resynced on the new session.
+	proxy.restart()
+	require.NoError(t, wslProxyForwarder.Send(portMapping("443")))
+	assert.Equal(t,
+		[]types.WSLProxyRequestType{types.WSLProxyHello, types.WSLProxyResync, types.WSLProxyUpdate},
+		requestTypes(proxy.received()))
+}
+
+func TestWSLProxyOneShotFallback(t *testing.T) {
+	t.Parallel()
+
+	proxy, socket := newFakeWSLProxy(t, false)
+	wslProxyForwarder := forwarder.NewWSLProxyForwarder(context.Background(), socket)
+	defer wslProxyForwarder.Close()
+
+	require.NoError(t, wslProxyForwarder.Send(portMapping("80")))
+	require.NoError(t, wslProxyForwarder.Send(portMapping("443")))
+
+	// The hello request is all the one-shot wsl-proxy gets from the first
+	// connection, as it hangs up after the first message; the port
+	// mappings are then sent alone.
+	assert.Equal(t, []types.WSLProxyRequestType{types.WSLProxyHello}, requestTypes(proxy.received()))
+	assert.EventuallyWithT(t, func(c *assert.CollectT) {
+		proxy.mutex.Lock()
+		defer proxy.mutex.Unlock()
+		assert.ElementsMatch(c, []types.PortMapping{portMapping("80"), portMapping("443")}, proxy.oneShot)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// An older wsl-proxy ignores Ranges, so consecutive ports reach it as
+// individual bindings.
+func TestWSLProxyOneShotConsecutivePorts(t *testing.T) {
+	t.Parallel()
+
+	proxy, socket := newFakeWSLProxy(t, false)
+	wslProxyForwarder := forwarder.NewWSLProxyForwarder(context.Background(), socket)
+	defer wslProxyForwarder.Close()
+
+	consecutive := types.PortMapping{
+		Ports: nat.PortMap{
+			"5000/udp": {{HostIP: "127.0.0.1", HostPort: "5000"}},
+			"5001/udp": {{HostIP: "127.0.0.1", HostPort: "5001"}},
+			"5002/udp": {{HostIP: "127.0.0.1", HostPort: "5002"}},
+		},
+	}
+	require.Len(t, consecutive.Compact().Ranges, 1)
+
+	// The first call falls back to the one-shot format, the second one
+	// uses it from the start.
+	require.NoError(t, wslProxyForwarder.Send(consecutive))
+	require.NoError(t, wslProxyForwarder.Send(consecutive))
+
+	assert.EventuallyWithT(t, func(c *assert.CollectT) {
+		proxy.mutex.Lock()
+		defer proxy.mutex.Unlock()
+		assert.Equal(c, []types.PortMapping{consecutive, consecutive}, proxy.oneShot)
+	}, 5*time.Second, 10*time.Millisecond)
+}
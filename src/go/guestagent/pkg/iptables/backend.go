@@ -0,0 +1,42 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iptables
+
+import (
+	cnutils "github.com/containernetworking/plugins/pkg/utils"
+)
+
+// Backend is the netfilter frontend that holds the DNAT rules for the
+// published ports.
+type Backend string
+
+const (
+	// BackendIptables covers both iptables-legacy and iptables-nft.
+	BackendIptables Backend = "iptables"
+	// BackendNftables is the native nftables API, as used by the
+	// nftables backend of the CNI portmap plugin.
+	BackendNftables Backend = "nftables"
+)
+
+// DetectBackend probes the kernel and the userspace tools the same way
+// the CNI portmap plugin does when no backend is configured: iptables is
+// used whenever it works, and nftables only when iptables does not but
+// nft does. Making the same choice as the plugin keeps the scanner and
+// the loopback rules in the rule set that the plugin actually wrote.
+func DetectBackend() Backend {
+	if !cnutils.SupportsIPTables() && cnutils.SupportsNFTables() {
+		return BackendNftables
+	}
+	return BackendIptables
+}
@@ -19,12 +19,14 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Masterminds/log-go"
 	"github.com/docker/go-connections/nat"
 	limaiptables "github.com/lima-vm/lima/pkg/guestagent/iptables"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/policy"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/utils"
 )
@@ -39,18 +41,26 @@ type Iptables struct {
 	apiTracker tracker.Tracker
 	scanner    Scanner
 	listenerIP net.IP
-	// time, in seconds, to wait between updating.
-	updateInterval time.Duration
+	// time, in nanoseconds, to wait between updating; it can be
+	// changed while ForwardPorts runs.
+	updateInterval atomic.Int64
 }
 
 func New(ctx context.Context, apiTracker tracker.Tracker, iptablesScanner Scanner, listenerIP net.IP, updateInterval time.Duration) *Iptables {
-	return &Iptables{
-		context:        ctx,
-		apiTracker:     apiTracker,
-		scanner:        iptablesScanner,
-		listenerIP:     listenerIP,
-		updateInterval: updateInterval,
+	i := &Iptables{
+		context:    ctx,
+		apiTracker: apiTracker,
+		scanner:    iptablesScanner,
+		listenerIP: listenerIP,
 	}
+	i.SetInterval(updateInterval)
+	return i
+}
+
+// SetInterval changes the time to wait between updates, from the next
+// update on.
+func (i *Iptables) SetInterval(updateInterval time.Duration) {
+	i.updateInterval.Store(int64(updateInterval))
 }
 
 // ForwardPorts forwards ports found in iptables DNAT. In some environments,
@@ -61,7 +71,8 @@ func New(ctx context.Context, apiTracker tracker.Tracker, iptablesScanner Scanne
 func (i *Iptables) ForwardPorts() error {
 	var ports []limaiptables.Entry
 
-	ticker := time.NewTicker(i.updateInterval)
+	interval := time.Duration(i.updateInterval.Load())
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
@@ -70,6 +81,10 @@ func (i *Iptables) ForwardPorts() error {
 			return nil
 		case <-ticker.C:
 		}
+		if current := time.Duration(i.updateInterval.Load()); current != interval {
+			interval = current
+			ticker.Reset(interval)
+		}
 		// Detect ports for forward
 		newPorts, err := i.scanner.GetPorts()
 		if err != nil {
@@ -120,7 +135,10 @@ func (i *Iptables) ForwardPorts() error {
 				portMap[portMapKey] = []nat.PortBinding{portBinding}
 			}
 			name := entryToString(p)
-			if err := i.apiTracker.Add(utils.GenerateID(name), portMap); err != nil {
+			// The rules do not tell which pod a port belongs to, so the
+			// port forwarding policy cannot check its namespace.
+			workload := policy.Workload{Unattributed: true}
+			if err := i.apiTracker.AddWorkload(utils.GenerateID(name), workload, portMap); err != nil {
 				log.Errorf("iptables scanner failed to forward portmap for %s: %s", name, err)
 				continue
 			}
@@ -16,15 +16,22 @@ package iptables_test
 import (
 	"context"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/docker/go-connections/nat"
 	limaiptables "github.com/lima-vm/lima/pkg/guestagent/iptables"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/iptables"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/policy"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
+	guestagentTypes "github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/utils"
 )
 
@@ -56,6 +63,15 @@ func TestForwardPorts(t *testing.T) {
 				{TCP: true, IP: net.IPv4(192, 168, 21, 12), Port: 1082},
 			},
 		},
+		{
+			name:       "With entries from both nftables families",
+			listenerIP: net.IPv4(0, 0, 0, 0),
+			expectedEntries: []limaiptables.Entry{
+				{TCP: true, IP: net.IPv4(0, 0, 0, 0), Port: 1080},
+				{TCP: true, IP: net.IPv6unspecified, Port: 1081},
+				{TCP: true, IP: net.ParseIP("fd00::1"), Port: 1082},
+			},
+		},
 		{
 			name:       "With entries removed",
 			remove:     true,
@@ -233,6 +249,60 @@ func TestForwardPortsSamePortDifferentIP(t *testing.T) {
 	}
 }
 
+type nopForwarder struct{}
+
+func (nopForwarder) Send(guestagentTypes.PortMapping) error { return nil }
+
+// The hostPorts found by the scanner cannot be attributed to a namespace,
+// so they are rejected while a namespace allowlist is set.
+func TestForwardPortsNamespaceAllowlist(t *testing.T) {
+	var exposeCalls atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services/forwarder/expose", func(http.ResponseWriter, *http.Request) {
+		exposeCalls.Add(1)
+	})
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	forwardingPolicy, err := policy.New(policy.Config{AllowKubernetesNamespaces: []string{"default"}})
+	require.NoError(t, err)
+	apiTracker := tracker.NewAPITracker(ctx, nopForwarder{}, testSrv.URL, "192.168.127.2", true)
+	apiTracker.SetPolicy(forwardingPolicy)
+
+	iptablesScanner := fakeScanner{
+		expectedEntries: []limaiptables.Entry{{TCP: true, IP: net.IPv4(10, 4, 0, 7), Port: 8080}},
+	}
+	iptablesHandler := iptables.New(ctx, apiTracker.ForSource(tracker.SourceIptables), &iptablesScanner, net.IPv4zero, 100*time.Millisecond)
+	go func() {
+		_ = iptablesHandler.ForwardPorts()
+	}()
+
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		entries := apiTracker.Status()
+		if assert.Len(c, entries, 1) {
+			assert.Empty(c, entries[0].Ports)
+			if assert.Len(c, entries[0].Rejected, 1) {
+				assert.Contains(c, entries[0].Rejected[0].Reason, "allowKubernetesNamespaces")
+			}
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Zero(t, exposeCalls.Load())
+}
+
+func TestNewScanner(t *testing.T) {
+	require.IsType(t, &iptables.IptablesScanner{}, iptables.NewScanner(iptables.BackendIptables))
+	require.IsType(t, &iptables.NftablesScanner{}, iptables.NewScanner(iptables.BackendNftables))
+}
+
+func TestNewLoopbackRules(t *testing.T) {
+	for _, backend := range []iptables.Backend{iptables.BackendIptables, iptables.BackendNftables} {
+		require.Equal(t, backend, iptables.NewLoopbackRules(backend).Backend())
+	}
+}
+
 // Fake Tracker implementation for mocking behavior
 type fakeTracker struct {
 	receivedID          chan string
@@ -251,6 +321,10 @@ func (f *fakeTracker) Add(containerID string, portMapping nat.PortMap) error {
 	return f.expectedAddFuncErr
 }
 
+func (f *fakeTracker) AddWorkload(containerID string, _ policy.Workload, portMapping nat.PortMap) error {
+	return f.Add(containerID, portMapping)
+}
+
 func (f *fakeTracker) Remove(containerID string) error {
 	f.receivedRemoveID <- containerID
 	return nil
@@ -260,6 +334,10 @@ func (f *fakeTracker) RemoveAll() error {
 	return nil
 }
 
+func (f *fakeTracker) AllowsWorkload(policy.Workload) bool {
+	return true
+}
+
 // Fake Scanner to simulate iptables entries
 type fakeScanner struct {
 	expectedEntries []limaiptables.Entry
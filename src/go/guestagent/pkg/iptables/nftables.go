@@ -0,0 +1,157 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iptables
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	limaiptables "github.com/lima-vm/lima/pkg/guestagent/iptables"
+	"sigs.k8s.io/knftables"
+)
+
+const (
+	// CNIHostPortTable is the table the nftables backend of the CNI
+	// portmap plugin writes its rules to, in both the ip and ip6 families.
+	CNIHostPortTable = "cni_hostport"
+	// CNIHostPortsChain holds one DNAT rule per published port, each
+	// commented with the CNI container ID.
+	CNIHostPortsChain = "hostports"
+)
+
+// findNftablesPortRegex detects the DNAT rules that the nftables backend of
+// the CNI portmap plugin adds to the hostports chain. The following two are
+// examples of lines as printed by `nft list chain` (notice that one has the
+// destination IP and the other does not):
+//
+//	ip daddr 127.0.0.1 tcp dport 8081 dnat to 10.4.0.7:80 comment "default-2e2f8d5b91929ef9"
+//	tcp dport 8082 dnat to 10.4.0.10:80 comment "default-04579c7bb67f4c3f"
+//
+// SCTP rules are left out: an Entry is either TCP or UDP, like the rules
+// the iptables scanner reports.
+var findNftablesPortRegex = regexp.MustCompile(`^\s*(?:ip6? daddr (\S+)\s+)?(tcp|udp) dport (\d+)\s+dnat\b`)
+
+// NftablesScanner is the Scanner for the rules of the nftables backend of
+// the CNI portmap plugin. Those rules do not show up in `iptables -S`,
+// not even with iptables-nft, as they are not in the xtables tables.
+type NftablesScanner struct {
+	// listChain returns the hostports chain of the family in the text
+	// format of `nft list chain`, or "" if there is no such chain.
+	listChain func(ctx context.Context, family knftables.Family) (string, error)
+}
+
+func NewNftablesScanner() *NftablesScanner {
+	return &NftablesScanner{
+		listChain: listHostPortsChain,
+	}
+}
+
+func (n *NftablesScanner) GetPorts() ([]limaiptables.Entry, error) {
+	var entries []limaiptables.Entry
+
+	for _, family := range []knftables.Family{knftables.IPv4Family, knftables.IPv6Family} {
+		rules, err := n.listChain(context.Background(), family)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, parseNftablesPorts(rules, family)...)
+	}
+
+	return checkPortsOpen(entries), nil
+}
+
+// listHostPortsChain runs `nft list chain` for the hostports chain. As with
+// the iptables scanner, a missing nft binary means there is nothing to
+// scan; so does a missing table, which the plugin only creates for the
+// first published port.
+func listHostPortsChain(ctx context.Context, family knftables.Family) (string, error) {
+	pth, err := exec.LookPath("nft")
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, pth, "list", "chain", string(family), CNIHostPortTable, CNIHostPortsChain)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(stderr.String(), "No such file or directory") {
+			return "", nil
+		}
+		return "", fmt.Errorf("running [%s] failed: %w - %s", cmd.String(), err, stderr.String())
+	}
+
+	return stdout.String(), nil
+}
+
+func parseNftablesPorts(rules string, family knftables.Family) []limaiptables.Entry {
+	var entries []limaiptables.Entry
+
+	for _, rule := range strings.Split(rules, "\n") {
+		found := findNftablesPortRegex.FindStringSubmatch(rule)
+		if found == nil {
+			continue
+		}
+		port, err := strconv.ParseUint(found[3], 10, 16)
+		if err != nil {
+			continue
+		}
+
+		// When no IP is present the rule applies to all interfaces.
+		ip := net.ParseIP(found[1])
+		if ip == nil {
+			ip = net.IPv4zero
+			if family == knftables.IPv6Family {
+				ip = net.IPv6unspecified
+			}
+		}
+
+		entries = append(entries, limaiptables.Entry{
+			TCP:  found[2] == "tcp",
+			IP:   ip,
+			Port: int(port),
+		})
+	}
+
+	return entries
+}
+
+// checkPortsOpen drops the TCP entries that nothing answers on, the same
+// way the iptables scanner does, so that stale rules are not forwarded.
+func checkPortsOpen(entries []limaiptables.Entry) []limaiptables.Entry {
+	var open []limaiptables.Entry
+	for _, entry := range entries {
+		if entry.TCP {
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(entry.IP.String(), strconv.Itoa(entry.Port)), time.Second)
+			if err != nil {
+				continue
+			}
+			conn.Close()
+		}
+		open = append(open, entry)
+	}
+
+	return open
+}
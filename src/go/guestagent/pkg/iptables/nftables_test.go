@@ -0,0 +1,231 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iptables
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	limaiptables "github.com/lima-vm/lima/pkg/guestagent/iptables"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/knftables"
+)
+
+const hostPortsIPv4 = `table ip cni_hostport {
+	chain hostports {
+		ip daddr 127.0.0.1 tcp dport 8081 dnat to 10.4.0.7:80 comment "default-2e2f8d5b91929ef9"
+		tcp dport 8082 dnat to 10.4.0.10:80 comment "default-04579c7bb67f4c3f"
+		udp dport 5353 dnat to 10.4.0.11:53 comment "default-6a1d0e0b6b3e1f2c"
+		sctp dport 3868 dnat to 10.4.0.12:3868 comment "default-1b7e5d2c9a4f3e60"
+	}
+}
+`
+
+const hostPortsIPv6 = `table ip6 cni_hostport {
+	chain hostports {
+		tcp dport 8083 dnat to [fd00::7]:80 comment "default-9f0c4a3c2b1d0e8f"
+	}
+}
+`
+
+func TestParseNftablesPorts(t *testing.T) {
+	require.Equal(t, []limaiptables.Entry{
+		{TCP: true, IP: net.ParseIP("127.0.0.1"), Port: 8081},
+		{TCP: true, IP: net.IPv4zero, Port: 8082},
+		{TCP: false, IP: net.IPv4zero, Port: 5353},
+	}, parseNftablesPorts(hostPortsIPv4, knftables.IPv4Family))
+
+	require.Equal(t, []limaiptables.Entry{
+		{TCP: true, IP: net.IPv6unspecified, Port: 8083},
+	}, parseNftablesPorts(hostPortsIPv6, knftables.IPv6Family))
+
+	require.Empty(t, parseNftablesPorts("", knftables.IPv4Family))
+}
+
+func TestNftablesScannerGetPorts(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	openPort := listener.Addr().(*net.TCPAddr).Port
+
+	closed, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	scanner := &NftablesScanner{
+		listChain: func(_ context.Context, family knftables.Family) (string, error) {
+			if family == knftables.IPv6Family {
+				// The plugin has not created the ip6 table.
+				return "", nil
+			}
+			return fmt.Sprintf(`table ip cni_hostport {
+	chain hostports {
+		ip daddr 127.0.0.1 tcp dport %d dnat to 10.4.0.7:80 comment "default-open"
+		ip daddr 127.0.0.1 tcp dport %d dnat to 10.4.0.8:80 comment "default-closed"
+		udp dport 5353 dnat to 10.4.0.11:53 comment "default-udp"
+	}
+}
+`, openPort, closedPort), nil
+		},
+	}
+
+	entries, err := scanner.GetPorts()
+	require.NoError(t, err)
+	require.Equal(t, []limaiptables.Entry{
+		{TCP: true, IP: net.ParseIP("127.0.0.1"), Port: openPort},
+		{TCP: false, IP: net.IPv4zero, Port: 5353},
+	}, entries)
+}
+
+func TestNftablesScannerListError(t *testing.T) {
+	listErr := errors.New("synthetic nft failure")
+	scanner := &NftablesScanner{
+		listChain: func(context.Context, knftables.Family) (string, error) {
+			return "", listErr
+		},
+	}
+
+	_, err := scanner.GetPorts()
+	require.ErrorIs(t, err, listErr)
+}
+
+func newFakeHostPorts(t *testing.T) *knftables.Fake {
+	fake := knftables.NewFake(knftables.IPv4Family, CNIHostPortTable)
+	tx := fake.NewTransaction()
+	tx.Add(&knftables.Table{})
+	tx.Add(&knftables.Chain{Name: CNIHostPortsChain})
+	tx.Add(&knftables.Rule{
+		Chain:   CNIHostPortsChain,
+		Rule:    "ip daddr 127.0.0.1 tcp dport 9119 dnat to 10.4.0.22:80",
+		Comment: knftables.PtrTo("default-other"),
+	})
+	require.NoError(t, fake.Run(context.Background(), tx))
+	return fake
+}
+
+func TestNftablesRules(t *testing.T) {
+	fake := newFakeHostPorts(t)
+	rules := newNftablesRules(func(table string) (knftables.Interface, error) {
+		require.Equal(t, CNIHostPortTable, table)
+		return fake, nil
+	})
+	ctx := context.Background()
+
+	rule := LoopbackRule{
+		Table:         CNIHostPortTable,
+		Chain:         CNIHostPortsChain,
+		Protocol:      "tcp",
+		DPort:         "9119",
+		ToDestination: "10.4.0.22:80",
+		Comment:       "default-container",
+	}
+	require.NoError(t, rules.Append(ctx, rule))
+
+	udpRule := rule
+	udpRule.Protocol = "udp"
+	require.NoError(t, rules.Append(ctx, udpRule))
+
+	chain := fake.Table.Chains[CNIHostPortsChain]
+	require.Len(t, chain.Rules, 3)
+	require.Equal(t, "tcp dport 9119 dnat to 10.4.0.22:80", chain.Rules[1].Rule)
+	require.Equal(t, "default-container", *chain.Rules[1].Comment)
+	require.Equal(t, "udp dport 9119 dnat to 10.4.0.22:80", chain.Rules[2].Rule)
+
+	// Delete removes both rules of the container, and only those.
+	require.NoError(t, rules.Delete(ctx, rule))
+	chain = fake.Table.Chains[CNIHostPortsChain]
+	require.Len(t, chain.Rules, 1)
+	require.Equal(t, "default-other", *chain.Rules[0].Comment)
+
+	// Deleting again, or from a chain that is gone, is not an error.
+	require.NoError(t, rules.Delete(ctx, udpRule))
+	missing := rule
+	missing.Chain = "missing"
+	require.NoError(t, rules.Delete(ctx, missing))
+
+	missing.Comment = ""
+	require.Error(t, rules.Delete(ctx, missing))
+}
+
+func TestNftablesRulesAgentTable(t *testing.T) {
+	fake := knftables.NewFake(knftables.IPv4Family, AgentTable)
+	// A rule left by a previous run is dropped when the table is set up.
+	tx := fake.NewTransaction()
+	tx.Add(&knftables.Table{})
+	tx.Add(&knftables.Chain{Name: AgentLoopbackChain})
+	tx.Add(&knftables.Rule{
+		Chain:   AgentLoopbackChain,
+		Rule:    "tcp dport 8080 dnat to 172.17.0.9:80",
+		Comment: knftables.PtrTo("stale"),
+	})
+	require.NoError(t, fake.Run(context.Background(), tx))
+
+	rules := newNftablesRules(func(table string) (knftables.Interface, error) {
+		require.Equal(t, AgentTable, table)
+		return fake, nil
+	})
+	ctx := context.Background()
+	rule := LoopbackRule{
+		Table:         AgentTable,
+		Chain:         AgentLoopbackChain,
+		Protocol:      "tcp",
+		DPort:         "8080",
+		ToDestination: "172.17.0.2:80",
+		Comment:       "containerID",
+	}
+	require.NoError(t, rules.Append(ctx, rule))
+	udpRule := rule
+	udpRule.Protocol = "udp"
+	require.NoError(t, rules.Append(ctx, udpRule))
+
+	// The base chains are created once, at the dstnat priority.
+	for _, hook := range []knftables.BaseChainHook{knftables.PreroutingHook, knftables.OutputHook} {
+		chain := fake.Table.Chains[string(hook)]
+		require.NotNil(t, chain, hook)
+		require.Equal(t, knftables.NATType, *chain.Type)
+		require.Equal(t, hook, *chain.Hook)
+		require.Equal(t, knftables.DNATPriority, *chain.Priority)
+		require.Len(t, chain.Rules, 1)
+		require.Contains(t, chain.Rules[0].Rule, "fib daddr type local jump "+AgentLoopbackChain)
+	}
+	require.Contains(t, fake.Table.Chains[string(knftables.OutputHook)].Rules[0].Rule, "ip daddr != 127.0.0.0/8")
+
+	chain := fake.Table.Chains[AgentLoopbackChain]
+	require.Len(t, chain.Rules, 2)
+	require.Equal(t, "tcp dport 8080 dnat to 172.17.0.2:80", chain.Rules[0].Rule)
+	require.Equal(t, "udp dport 8080 dnat to 172.17.0.2:80", chain.Rules[1].Rule)
+
+	require.NoError(t, rules.Delete(ctx, rule))
+	require.Empty(t, fake.Table.Chains[AgentLoopbackChain].Rules)
+}
+
+func TestNftablesRulesMissingChain(t *testing.T) {
+	rules := newNftablesRules(func(string) (knftables.Interface, error) {
+		return newFakeHostPorts(t), nil
+	})
+
+	err := rules.Append(context.Background(), LoopbackRule{
+		Table:         NATTable,
+		Chain:         "DOCKER",
+		Protocol:      "tcp",
+		DPort:         "8080",
+		ToDestination: "172.17.0.2:80",
+		Comment:       "containerID",
+	})
+	require.ErrorContains(t, err, "nat/DOCKER")
+}
@@ -0,0 +1,243 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iptables
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+
+	"sigs.k8s.io/knftables"
+)
+
+// NATTable is the table of the iptables DNAT chains. With iptables-nft,
+// it is also the name of the nftables table that holds them.
+const NATTable = "nat"
+
+const (
+	// AgentTable is the nftables table, in the ip family, that the guest
+	// agent owns. With the nftables backend, the loopback rules of Docker
+	// containers go there rather than into the DOCKER chain, which
+	// iptables-nft owns and may rewrite at any time.
+	AgentTable = "rancher-desktop"
+	// AgentLoopbackChain holds the loopback rules in AgentTable. It is
+	// jumped to from the base chains of the prerouting and output nat
+	// hooks for traffic to a local address, like the DOCKER chain.
+	AgentLoopbackChain = "loopback"
+)
+
+// LoopbackRule is a DNAT rule that sends traffic for DPort, whatever its
+// destination, to ToDestination. The container engines only DNAT traffic
+// to 127.0.0.1 for ports published on localhost; the loopback rule makes
+// them reachable through the tap interface the vm-switch routes into.
+type LoopbackRule struct {
+	Table         string
+	Chain         string
+	Protocol      string
+	DPort         string
+	ToDestination string
+	// Comment tags the rule with its owner. It is only used by the
+	// nftables backend, which finds the rules to delete by comment.
+	Comment string
+}
+
+// LoopbackRules adds and removes LoopbackRules in the rule set of a
+// Backend. Only IPv4 rules are managed, like the container engine rules
+// they complement.
+type LoopbackRules interface {
+	Backend() Backend
+	// Append adds the rule at the end of its chain.
+	Append(ctx context.Context, rule LoopbackRule) error
+	// Delete removes a rule added by Append. With nftables, this removes
+	// every rule in the chain that carries the same comment.
+	Delete(ctx context.Context, rule LoopbackRule) error
+}
+
+// NewLoopbackRules returns the LoopbackRules for the given backend.
+func NewLoopbackRules(backend Backend) LoopbackRules {
+	if backend == BackendNftables {
+		return newNftablesRules(func(table string) (knftables.Interface, error) {
+			return knftables.New(knftables.IPv4Family, table)
+		})
+	}
+	return iptablesRules{}
+}
+
+// iptablesRules runs the iptables command, which works for both
+// iptables-legacy and iptables-nft.
+type iptablesRules struct{}
+
+func (iptablesRules) Backend() Backend {
+	return BackendIptables
+}
+
+func (iptablesRules) Append(ctx context.Context, rule LoopbackRule) error {
+	return runIptablesRule(ctx, "--append", rule)
+}
+
+func (iptablesRules) Delete(ctx context.Context, rule LoopbackRule) error {
+	return runIptablesRule(ctx, "--delete", rule)
+}
+
+func runIptablesRule(ctx context.Context, action string, rule LoopbackRule) error {
+	//nolint:gosec // no security concern with the potentially tainted command arguments
+	iptableCmd := exec.CommandContext(ctx,
+		"iptables",
+		"--table", rule.Table,
+		action, rule.Chain,
+		"--protocol", rule.Protocol,
+		"--destination", "0.0.0.0/0",
+		"--jump", "DNAT",
+		"--dport", rule.DPort,
+		"--to-destination", rule.ToDestination)
+	var stderr bytes.Buffer
+	iptableCmd.Stderr = &stderr
+	if err := iptableCmd.Run(); err != nil {
+		return fmt.Errorf("running iptables rule [%s] failed: %w - %s", iptableCmd.String(), err, stderr.String())
+	}
+	return nil
+}
+
+// nftablesRules manages the rules through the nft command, with one
+// knftables.Interface per table.
+type nftablesRules struct {
+	newTable func(table string) (knftables.Interface, error)
+	tables   map[string]knftables.Interface
+	mutex    sync.Mutex
+}
+
+func newNftablesRules(newTable func(table string) (knftables.Interface, error)) *nftablesRules {
+	return &nftablesRules{
+		newTable: newTable,
+		tables:   make(map[string]knftables.Interface),
+	}
+}
+
+func (n *nftablesRules) Backend() Backend {
+	return BackendNftables
+}
+
+func (n *nftablesRules) table(ctx context.Context, name string) (knftables.Interface, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if nft, ok := n.tables[name]; ok {
+		return nft, nil
+	}
+	nft, err := n.newTable(name)
+	if err != nil {
+		return nil, err
+	}
+	if name == AgentTable {
+		if err := setupAgentTable(ctx, nft); err != nil {
+			return nil, err
+		}
+	}
+	n.tables[name] = nft
+	return nft, nil
+}
+
+// setupAgentTable creates AgentTable and its chains, the first time it is
+// used. The loopback chain is flushed, as the rules left by a previous run
+// are created again for the running containers.
+func setupAgentTable(ctx context.Context, nft knftables.Interface) error {
+	tx := nft.NewTransaction()
+	tx.Add(&knftables.Table{
+		Comment: knftables.PtrTo("rules of the Rancher Desktop guest agent"),
+	})
+	tx.Add(&knftables.Chain{Name: AgentLoopbackChain})
+	tx.Flush(&knftables.Chain{Name: AgentLoopbackChain})
+	for _, hook := range []knftables.BaseChainHook{knftables.PreroutingHook, knftables.OutputHook} {
+		chain := &knftables.Chain{
+			Name:     string(hook),
+			Type:     knftables.PtrTo(knftables.NATType),
+			Hook:     knftables.PtrTo(hook),
+			Priority: knftables.PtrTo(knftables.DNATPriority),
+		}
+		tx.Add(chain)
+		tx.Flush(chain)
+		rule := knftables.Concat("fib daddr type local", "jump", AgentLoopbackChain)
+		if hook == knftables.OutputHook {
+			// As with Docker, local traffic to the loopback addresses is
+			// left to the rules of the container engine.
+			rule = knftables.Concat("ip daddr != 127.0.0.0/8", rule)
+		}
+		tx.Add(&knftables.Rule{Chain: chain.Name, Rule: rule})
+	}
+	if err := nft.Run(ctx, tx); err != nil {
+		return fmt.Errorf("creating nftables table %s failed: %w", AgentTable, err)
+	}
+	return nil
+}
+
+func (n *nftablesRules) Append(ctx context.Context, rule LoopbackRule) error {
+	nft, err := n.table(ctx, rule.Table)
+	if err != nil {
+		return err
+	}
+
+	nftRule := &knftables.Rule{
+		Chain: rule.Chain,
+		Rule: knftables.Concat(
+			rule.Protocol, "dport", rule.DPort,
+			"dnat to", rule.ToDestination,
+		),
+	}
+	if rule.Comment != "" {
+		nftRule.Comment = &rule.Comment
+	}
+
+	tx := nft.NewTransaction()
+	tx.Add(nftRule)
+	if err := nft.Run(ctx, tx); err != nil {
+		return fmt.Errorf("adding nftables rule [%s] to %s/%s failed: %w", nftRule.Rule, rule.Table, rule.Chain, err)
+	}
+	return nil
+}
+
+func (n *nftablesRules) Delete(ctx context.Context, rule LoopbackRule) error {
+	if rule.Comment == "" {
+		return errors.New("nftables rules can only be deleted by comment")
+	}
+	nft, err := n.table(ctx, rule.Table)
+	if err != nil {
+		return err
+	}
+
+	rules, err := nft.ListRules(ctx, rule.Chain)
+	if err != nil {
+		// The chain is already gone, and the rule with it.
+		if knftables.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("listing nftables rules in %s/%s failed: %w", rule.Table, rule.Chain, err)
+	}
+
+	tx := nft.NewTransaction()
+	for _, r := range rules {
+		if r.Comment != nil && *r.Comment == rule.Comment {
+			tx.Delete(r)
+		}
+	}
+	if tx.NumOperations() == 0 {
+		return nil
+	}
+	if err := nft.Run(ctx, tx); err != nil {
+		return fmt.Errorf("deleting nftables rules [%s] from %s/%s failed: %w", rule.Comment, rule.Table, rule.Chain, err)
+	}
+	return nil
+}
@@ -22,6 +22,15 @@ type Scanner interface {
 	GetPorts() ([]iptables.Entry, error)
 }
 
+// NewScanner returns the Scanner for the rules written by the given
+// backend.
+func NewScanner(backend Backend) Scanner {
+	if backend == BackendNftables {
+		return NewNftablesScanner()
+	}
+	return NewIptablesScanner()
+}
+
 type IptablesScanner struct{}
 
 func NewIptablesScanner() *IptablesScanner {
@@ -0,0 +1,154 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Masterminds/log-go"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
+)
+
+// loadBalancerIngressIP is the address written to the status of the
+// LoadBalancer services: whichever k8sServiceListenerAddr is used, the
+// forwarded ports are reachable on the host's localhost.
+const loadBalancerIngressIP = "127.0.0.1"
+
+// loadBalancerStatus writes the host-facing address to the status of the
+// LoadBalancer services once the tracker has forwarded their ports, and
+// clears it when they are no longer forwarded. Without it, the services
+// stay <pending> unless another load balancer controller, such as
+// servicelb, is running.
+//
+// Services that already have an ingress status set by someone else, or
+// that request another load balancer class, are left alone.
+type loadBalancerStatus struct {
+	client      kubernetes.Interface
+	portTracker tracker.Tracker
+	// pending are the forwarded LoadBalancer services whose status is
+	// not written yet, either because their ports are not exposed yet
+	// or because the update failed.
+	pending map[types.UID]types.NamespacedName
+	// published are the services whose status was written.
+	published map[types.UID]types.NamespacedName
+}
+
+func newLoadBalancerStatus(client kubernetes.Interface, portTracker tracker.Tracker) *loadBalancerStatus {
+	return &loadBalancerStatus{
+		client:      client,
+		portTracker: portTracker,
+		pending:     make(map[types.UID]types.NamespacedName),
+		published:   make(map[types.UID]types.NamespacedName),
+	}
+}
+
+// handle records the LoadBalancer service of a watcher event, after the
+// tracker has processed it, and updates its status when possible.
+func (l *loadBalancerStatus) handle(ctx context.Context, event event) {
+	if !event.loadBalancer {
+		return
+	}
+	name := types.NamespacedName{Namespace: event.namespace, Name: event.name}
+
+	if event.deleted {
+		delete(l.pending, event.UID)
+		if _, ok := l.published[event.UID]; ok {
+			delete(l.published, event.UID)
+			if err := l.clear(ctx, name, event.UID); err != nil {
+				log.Errorf("clearing load balancer status of service %s failed: %s", name, err)
+			}
+		}
+		return
+	}
+
+	if _, ok := l.published[event.UID]; !ok {
+		l.pending[event.UID] = name
+	}
+	l.sync(ctx)
+}
+
+// sync writes the status of the pending services that the tracker has
+// forwarded since.
+func (l *loadBalancerStatus) sync(ctx context.Context) {
+	for uid, name := range l.pending {
+		if l.portTracker.Get(string(uid)) == nil {
+			continue
+		}
+		if err := l.publish(ctx, name, uid); err != nil {
+			log.Errorf("updating load balancer status of service %s failed: %s", name, err)
+			continue
+		}
+		delete(l.pending, uid)
+		l.published[uid] = name
+	}
+}
+
+func (l *loadBalancerStatus) publish(ctx context.Context, name types.NamespacedName, uid types.UID) error {
+	svc, err := l.get(ctx, name, uid)
+	if svc == nil || err != nil {
+		return err
+	}
+	if svc.Spec.LoadBalancerClass != nil || len(svc.Status.LoadBalancer.Ingress) != 0 {
+		log.Debugf("kubernetes service %s: load balancer status is managed elsewhere", name)
+		return nil
+	}
+
+	svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: loadBalancerIngressIP}}
+	if _, err := l.client.CoreV1().Services(name.Namespace).UpdateStatus(ctx, svc, v1.UpdateOptions{}); err != nil {
+		return err
+	}
+	log.Debugf("kubernetes service %s: load balancer ingress set to %s", name, loadBalancerIngressIP)
+	return nil
+}
+
+func (l *loadBalancerStatus) clear(ctx context.Context, name types.NamespacedName, uid types.UID) error {
+	svc, err := l.get(ctx, name, uid)
+	if svc == nil || err != nil {
+		return err
+	}
+	ingress := svc.Status.LoadBalancer.Ingress
+	if len(ingress) != 1 || ingress[0].IP != loadBalancerIngressIP || ingress[0].Hostname != "" {
+		return nil
+	}
+
+	svc.Status.LoadBalancer.Ingress = nil
+	_, err = l.client.CoreV1().Services(name.Namespace).UpdateStatus(ctx, svc, v1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// get returns the service, or nil if it no longer exists or was
+// replaced by another service with the same name.
+func (l *loadBalancerStatus) get(ctx context.Context, name types.NamespacedName, uid types.UID) (*corev1.Service, error) {
+	svc, err := l.client.CoreV1().Services(name.Namespace).Get(ctx, name.Name, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting service: %w", err)
+	}
+	if svc.UID != uid {
+		return nil, nil
+	}
+	return svc, nil
+}
@@ -0,0 +1,160 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/policy"
+)
+
+// fakeTracker reports the IDs in forwarded as forwarded.
+type fakeTracker struct {
+	forwarded map[string]bool
+}
+
+func (t *fakeTracker) Get(id string) nat.PortMap {
+	if t.forwarded[id] {
+		return nat.PortMap{"80/tcp": []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "80"}}}
+	}
+	return nil
+}
+
+func (t *fakeTracker) Add(string, nat.PortMap) error { return nil }
+
+func (t *fakeTracker) AddWorkload(string, policy.Workload, nat.PortMap) error { return nil }
+
+func (t *fakeTracker) Remove(string) error { return nil }
+
+func (t *fakeTracker) RemoveAll() error { return nil }
+
+func (t *fakeTracker) AllowsWorkload(policy.Workload) bool { return true }
+
+func newService(name string, svcType corev1.ServiceType) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID("uid-" + name)},
+		Spec:       corev1.ServiceSpec{Type: svcType},
+	}
+}
+
+func serviceEvent(svc *corev1.Service, deleted bool) event {
+	return event{
+		UID:          svc.UID,
+		namespace:    svc.Namespace,
+		name:         svc.Name,
+		portMapping:  map[int32]corev1.Protocol{80: corev1.ProtocolTCP},
+		loadBalancer: svc.Spec.Type == corev1.ServiceTypeLoadBalancer,
+		deleted:      deleted,
+	}
+}
+
+func ingressOf(t *testing.T, client *fake.Clientset, name string) []corev1.LoadBalancerIngress {
+	t.Helper()
+	svc, err := client.CoreV1().Services("default").Get(context.Background(), name, v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting service %s failed: %s", name, err)
+	}
+	return svc.Status.LoadBalancer.Ingress
+}
+
+func TestLoadBalancerStatusPublishAndClear(t *testing.T) {
+	ctx := context.Background()
+	svc := newService("web", corev1.ServiceTypeLoadBalancer)
+	client := fake.NewClientset(svc)
+	portTracker := &fakeTracker{forwarded: map[string]bool{}}
+	lbStatus := newLoadBalancerStatus(client, portTracker)
+
+	// The ports are not exposed yet: the service stays pending.
+	lbStatus.handle(ctx, serviceEvent(svc, false))
+	if ingress := ingressOf(t, client, "web"); len(ingress) != 0 {
+		t.Fatalf("expected no ingress before the ports are forwarded, got %+v", ingress)
+	}
+
+	// Once the tracker has forwarded them, sync publishes the address.
+	portTracker.forwarded[string(svc.UID)] = true
+	lbStatus.sync(ctx)
+	ingress := ingressOf(t, client, "web")
+	if len(ingress) != 1 || ingress[0].IP != loadBalancerIngressIP {
+		t.Fatalf("expected ingress %s, got %+v", loadBalancerIngressIP, ingress)
+	}
+
+	lbStatus.handle(ctx, serviceEvent(svc, true))
+	if ingress := ingressOf(t, client, "web"); len(ingress) != 0 {
+		t.Fatalf("expected the ingress to be cleared, got %+v", ingress)
+	}
+}
+
+func TestLoadBalancerStatusLeavesOthersAlone(t *testing.T) {
+	ctx := context.Background()
+
+	managed := newService("managed", corev1.ServiceTypeLoadBalancer)
+	managed.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "192.168.1.10"}}
+	class := "example.com/lb"
+	classed := newService("classed", corev1.ServiceTypeLoadBalancer)
+	classed.Spec.LoadBalancerClass = &class
+	nodePort := newService("node-port", corev1.ServiceTypeNodePort)
+
+	client := fake.NewClientset(managed, classed, nodePort)
+	portTracker := &fakeTracker{forwarded: map[string]bool{
+		string(managed.UID):  true,
+		string(classed.UID):  true,
+		string(nodePort.UID): true,
+	}}
+	lbStatus := newLoadBalancerStatus(client, portTracker)
+
+	for _, svc := range []*corev1.Service{managed, classed, nodePort} {
+		lbStatus.handle(ctx, serviceEvent(svc, false))
+	}
+
+	if ingress := ingressOf(t, client, "managed"); len(ingress) != 1 || ingress[0].IP != "192.168.1.10" {
+		t.Fatalf("expected the ingress set by another controller to be kept, got %+v", ingress)
+	}
+	if ingress := ingressOf(t, client, "classed"); len(ingress) != 0 {
+		t.Fatalf("expected no ingress for a service of another load balancer class, got %+v", ingress)
+	}
+	if ingress := ingressOf(t, client, "node-port"); len(ingress) != 0 {
+		t.Fatalf("expected no ingress for a NodePort service, got %+v", ingress)
+	}
+
+	// Removing the managed service does not clear the status it did not set.
+	lbStatus.handle(ctx, serviceEvent(managed, true))
+	if ingress := ingressOf(t, client, "managed"); len(ingress) != 1 {
+		t.Fatalf("expected the ingress set by another controller to be kept, got %+v", ingress)
+	}
+}
+
+func TestLoadBalancerStatusDeletedService(t *testing.T) {
+	ctx := context.Background()
+	svc := newService("gone", corev1.ServiceTypeLoadBalancer)
+	client := fake.NewClientset(svc)
+	lbStatus := newLoadBalancerStatus(client, &fakeTracker{forwarded: map[string]bool{string(svc.UID): true}})
+
+	lbStatus.handle(ctx, serviceEvent(svc, false))
+	if err := client.CoreV1().Services("default").Delete(ctx, "gone", v1.DeleteOptions{}); err != nil {
+		t.Fatalf("deleting service failed: %s", err)
+	}
+
+	// Clearing the status of a service that no longer exists is a no-op.
+	lbStatus.handle(ctx, serviceEvent(svc, true))
+	if len(lbStatus.published) != 0 || len(lbStatus.pending) != 0 {
+		t.Fatalf("expected the service to be forgotten, got published %v pending %v", lbStatus.published, lbStatus.pending)
+	}
+}
@@ -0,0 +1,436 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Masterminds/log-go"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/policy"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/routes"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
+)
+
+const (
+	gatewayGroup   = "gateway.networking.k8s.io"
+	gatewayVersion = "v1"
+	// routesID prefixes the tracker IDs of the route ports, one per port.
+	routesID = "routes"
+)
+
+var (
+	httpRouteResource = schema.GroupVersionResource{Group: gatewayGroup, Version: gatewayVersion, Resource: "httproutes"}
+	gatewayResource   = schema.GroupVersionResource{Group: gatewayGroup, Version: gatewayVersion, Resource: "gateways"}
+)
+
+// httpRoute and gateway are the parts of the Gateway API objects that
+// the route table needs; the Gateway API types are not a dependency.
+type httpRoute struct {
+	v1.ObjectMeta `json:"metadata"`
+	Spec          struct {
+		ParentRefs []parentRef `json:"parentRefs"`
+		Hostnames  []string    `json:"hostnames"`
+	} `json:"spec"`
+}
+
+type parentRef struct {
+	Group       *string `json:"group"`
+	Kind        *string `json:"kind"`
+	Namespace   *string `json:"namespace"`
+	Name        string  `json:"name"`
+	SectionName *string `json:"sectionName"`
+	Port        *int32  `json:"port"`
+}
+
+type gateway struct {
+	v1.ObjectMeta `json:"metadata"`
+	Spec          struct {
+		Listeners []listener `json:"listeners"`
+	} `json:"spec"`
+}
+
+type listener struct {
+	Name     string  `json:"name"`
+	Hostname *string `json:"hostname"`
+	Port     int32   `json:"port"`
+	Protocol string  `json:"protocol"`
+}
+
+// watchRoutes informs on the Ingresses and, when the Gateway API is
+// installed, on the HTTPRoutes and Gateways. It signals the returned
+// channel whenever the routes may have changed; the returned function
+// builds the routes from the informer caches, leaving out the Ingresses
+// and HTTPRoutes that allows rejects.
+func watchRoutes(
+	ctx context.Context,
+	client kubernetes.Interface,
+	dynamicClient dynamic.Interface,
+	allows func(policy.Workload) bool,
+) (<-chan struct{}, func() []routes.Route, error) {
+	changedCh := make(chan struct{}, 1)
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify(changedCh) },
+		UpdateFunc: func(interface{}, interface{}) { notify(changedCh) },
+		DeleteFunc: func(interface{}) { notify(changedCh) },
+	}
+
+	informerFactory := informers.NewSharedInformerFactory(client, 1*time.Hour)
+	ingressLister := informerFactory.Networking().V1().Ingresses().Lister()
+	if _, err := informerFactory.Networking().V1().Ingresses().Informer().AddEventHandler(handler); err != nil {
+		return nil, nil, fmt.Errorf("error watching ingresses: %w", err)
+	}
+
+	var httpRouteLister, gatewayLister cache.GenericLister
+	dynamicFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 1*time.Hour)
+	if hasGatewayAPI(client) {
+		for _, resource := range []schema.GroupVersionResource{httpRouteResource, gatewayResource} {
+			if _, err := dynamicFactory.ForResource(resource).Informer().AddEventHandler(handler); err != nil {
+				return nil, nil, fmt.Errorf("error watching %s: %w", resource.Resource, err)
+			}
+		}
+		httpRouteLister = dynamicFactory.ForResource(httpRouteResource).Lister()
+		gatewayLister = dynamicFactory.ForResource(gatewayResource).Lister()
+	} else {
+		log.Debugf("kubernetes: the Gateway API %s/%s is not installed; only watching ingresses", gatewayGroup, gatewayVersion)
+	}
+
+	informerFactory.Start(ctx.Done())
+	dynamicFactory.Start(ctx.Done())
+	informerFactory.WaitForCacheSync(ctx.Done())
+	dynamicFactory.WaitForCacheSync(ctx.Done())
+
+	build := func() []routes.Route {
+		ingresses, err := ingressLister.List(labels.Everything())
+		if err != nil {
+			log.Errorf("listing ingresses failed: %s", err)
+		}
+		var httpRoutes []httpRoute
+		var gateways []gateway
+		if httpRouteLister != nil {
+			httpRoutes = listUnstructured[httpRoute](httpRouteLister)
+			gateways = listUnstructured[gateway](gatewayLister)
+		}
+		ingresses, httpRoutes = allowedRoutes(ingresses, httpRoutes, allows)
+		return buildRoutes(ingresses, httpRoutes, gateways)
+	}
+
+	return changedCh, build, nil
+}
+
+// allowedRoutes drops the Ingresses and HTTPRoutes whose namespace, labels
+// or annotations the port forwarding policy rejects, so that their hosts
+// are neither published nor their ports forwarded. The Gateways are kept:
+// a route may use the listeners of a Gateway in another namespace.
+func allowedRoutes(
+	ingresses []*networkingv1.Ingress,
+	httpRoutes []httpRoute,
+	allows func(policy.Workload) bool,
+) ([]*networkingv1.Ingress, []httpRoute) {
+	ingresses = slices.DeleteFunc(ingresses, func(ingress *networkingv1.Ingress) bool {
+		return !allowsObject(ingress, allows)
+	})
+	httpRoutes = slices.DeleteFunc(httpRoutes, func(route httpRoute) bool {
+		return !allowsObject(&route, allows)
+	})
+	return ingresses, httpRoutes
+}
+
+func allowsObject(obj v1.Object, allows func(policy.Workload) bool) bool {
+	if allows(policy.Workload{
+		Namespace:   obj.GetNamespace(),
+		Labels:      obj.GetLabels(),
+		Annotations: obj.GetAnnotations(),
+	}) {
+		return true
+	}
+	log.Debugf("kubernetes: the port forwarding policy rejects the routes of %s/%s", obj.GetNamespace(), obj.GetName())
+	return false
+}
+
+func notify(ch chan<- struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// hasGatewayAPI reports whether the cluster serves the Gateway API
+// resources. CRDs installed later are picked up when the watcher
+// reconnects.
+func hasGatewayAPI(client kubernetes.Interface) bool {
+	resources, err := client.Discovery().ServerResourcesForGroupVersion(gatewayGroup + "/" + gatewayVersion)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Errorf("looking up the Gateway API failed: %s", err)
+		}
+		return false
+	}
+	found := 0
+	for _, resource := range resources.APIResources {
+		if resource.Name == httpRouteResource.Resource || resource.Name == gatewayResource.Resource {
+			found++
+		}
+	}
+	return found == 2
+}
+
+func listUnstructured[T any](lister cache.GenericLister) []T {
+	objects, err := lister.List(labels.Everything())
+	if err != nil {
+		log.Errorf("listing gateway API objects failed: %s", err)
+		return nil
+	}
+	var out []T
+	for _, obj := range objects {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		var typed T
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &typed); err != nil {
+			log.Errorf("decoding %s %s/%s failed: %s", u.GetKind(), u.GetNamespace(), u.GetName(), err)
+			continue
+		}
+		out = append(out, typed)
+	}
+	return out
+}
+
+// buildRoutes returns a route per host name of the Ingress rules and the
+// HTTPRoutes. Ingress hosts are served on port 80, and on 443 when they
+// are listed for TLS; HTTPRoute hosts are served on the ports of the
+// HTTP and HTTPS listeners of their parent Gateways.
+func buildRoutes(ingresses []*networkingv1.Ingress, httpRoutes []httpRoute, gateways []gateway) []routes.Route {
+	var out []routes.Route
+
+	for _, ingress := range ingresses {
+		tlsHosts := make(map[string]bool)
+		for _, tls := range ingress.Spec.TLS {
+			for _, host := range tls.Hosts {
+				tlsHosts[host] = true
+			}
+		}
+		for _, rule := range ingress.Spec.Rules {
+			if rule.Host == "" {
+				continue
+			}
+			ports := []int32{80}
+			if tlsHosts[rule.Host] {
+				ports = append(ports, 443)
+			}
+			out = appendRoute(out, routes.Route{
+				Host:      rule.Host,
+				Ports:     ports,
+				Kind:      "Ingress",
+				Namespace: ingress.Namespace,
+				Name:      ingress.Name,
+			})
+		}
+	}
+
+	gatewaysByName := make(map[types.NamespacedName]gateway, len(gateways))
+	for _, gw := range gateways {
+		gatewaysByName[types.NamespacedName{Namespace: gw.Namespace, Name: gw.Name}] = gw
+	}
+
+	for _, route := range httpRoutes {
+		listenerHosts := make(map[string][]int32)
+		var ports []int32
+		for _, ref := range route.Spec.ParentRefs {
+			for _, l := range parentListeners(route.Namespace, ref, gatewaysByName) {
+				ports = append(ports, l.Port)
+				if l.Hostname != nil {
+					listenerHosts[*l.Hostname] = append(listenerHosts[*l.Hostname], l.Port)
+				}
+			}
+		}
+
+		if len(route.Spec.Hostnames) != 0 {
+			for _, host := range route.Spec.Hostnames {
+				out = appendRoute(out, routes.Route{
+					Host:      host,
+					Ports:     ports,
+					Kind:      "HTTPRoute",
+					Namespace: route.Namespace,
+					Name:      route.Name,
+				})
+			}
+			continue
+		}
+		// Without host names of its own, the route serves the ones of
+		// the listeners it is attached to.
+		for host, hostPorts := range listenerHosts {
+			out = appendRoute(out, routes.Route{
+				Host:      host,
+				Ports:     hostPorts,
+				Kind:      "HTTPRoute",
+				Namespace: route.Namespace,
+				Name:      route.Name,
+			})
+		}
+	}
+
+	return out
+}
+
+// parentListeners returns the HTTP and HTTPS listeners of the Gateway
+// that ref points to, restricted to its section name and port if set.
+func parentListeners(routeNamespace string, ref parentRef, gateways map[types.NamespacedName]gateway) []listener {
+	if ref.Group != nil && *ref.Group != gatewayGroup {
+		return nil
+	}
+	if ref.Kind != nil && *ref.Kind != "Gateway" {
+		return nil
+	}
+	name := types.NamespacedName{Namespace: routeNamespace, Name: ref.Name}
+	if ref.Namespace != nil {
+		name.Namespace = *ref.Namespace
+	}
+	gw, ok := gateways[name]
+	if !ok {
+		return nil
+	}
+
+	var out []listener
+	for _, l := range gw.Spec.Listeners {
+		if l.Protocol != "HTTP" && l.Protocol != "HTTPS" {
+			continue
+		}
+		if ref.SectionName != nil && *ref.SectionName != l.Name {
+			continue
+		}
+		if ref.Port != nil && *ref.Port != l.Port {
+			continue
+		}
+		out = append(out, l)
+	}
+	return out
+}
+
+func appendRoute(out []routes.Route, route routes.Route) []routes.Route {
+	route.Address = routes.Address
+	route.Ports = slices.Compact(slices.Sorted(slices.Values(route.Ports)))
+	if route.Ports == nil {
+		// A route whose Gateway is not known yet has no ports.
+		route.Ports = []int32{}
+	}
+	return append(out, route)
+}
+
+// routePorts forwards the ports of the routes through the tracker, under an
+// ID per port, so that a port appearing or going away leaves the others
+// forwarded. The ports that a forwarded service already covers, such as
+// those of the LoadBalancer service of the ingress controller, are left
+// out, as exposing them twice would fail.
+type routePorts struct {
+	portTracker  tracker.Tracker
+	listenerIP   net.IP
+	servicePorts map[types.UID]map[int32]corev1.Protocol
+	routes       []routes.Route
+	forwarded    map[int32]bool
+}
+
+func newRoutePorts(portTracker tracker.Tracker, listenerIP net.IP) *routePorts {
+	return &routePorts{
+		portTracker:  portTracker,
+		listenerIP:   listenerIP,
+		servicePorts: make(map[types.UID]map[int32]corev1.Protocol),
+		forwarded:    make(map[int32]bool),
+	}
+}
+
+// routePortID returns the tracker ID of a route port.
+func routePortID(port int32) string {
+	return routesID + "/" + strconv.Itoa(int(port))
+}
+
+// serviceEvent mirrors a service event applied to the tracker.
+func (r *routePorts) serviceEvent(event event) {
+	if event.deleted {
+		delete(r.servicePorts, event.UID)
+	} else {
+		r.servicePorts[event.UID] = event.portMapping
+	}
+	r.update()
+}
+
+func (r *routePorts) setRoutes(routes []routes.Route) {
+	r.routes = routes
+	r.update()
+}
+
+func (r *routePorts) update() {
+	covered := make(map[int32]bool)
+	for _, ports := range r.servicePorts {
+		for port, protocol := range ports {
+			if protocol == corev1.ProtocolTCP {
+				covered[port] = true
+			}
+		}
+	}
+	ports := make(map[int32]bool)
+	for _, route := range r.routes {
+		for _, port := range route.Ports {
+			if !covered[port] {
+				ports[port] = true
+			}
+		}
+	}
+
+	for _, port := range slices.Sorted(maps.Keys(r.forwarded)) {
+		if ports[port] {
+			continue
+		}
+		if err := r.portTracker.Remove(routePortID(port)); err != nil {
+			log.Errorf("failed to remove the route port %d: %s", port, err)
+		}
+		delete(r.forwarded, port)
+	}
+
+	for _, port := range slices.Sorted(maps.Keys(ports)) {
+		if r.forwarded[port] {
+			continue
+		}
+		r.forwarded[port] = true
+		portMap, err := createPortMapping(map[int32]corev1.Protocol{port: corev1.ProtocolTCP}, r.listenerIP)
+		if err != nil {
+			log.Errorf("failed to create port mapping for the route port %d: %s", port, err)
+			continue
+		}
+		if err := r.portTracker.Add(routePortID(port), portMap); err != nil {
+			log.Errorf("failed to add the route port %d: %s", port, err)
+		}
+	}
+}
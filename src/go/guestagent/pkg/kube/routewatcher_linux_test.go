@@ -0,0 +1,190 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"net"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/policy"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/routes"
+)
+
+func TestBuildRoutesIngress(t *testing.T) {
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec: networkingv1.IngressSpec{
+			TLS: []networkingv1.IngressTLS{{Hosts: []string{"secure.example.test"}}},
+			Rules: []networkingv1.IngressRule{
+				{Host: "app.localhost"},
+				{Host: "secure.example.test"},
+				{}, // no host name to resolve
+			},
+		},
+	}
+
+	actual := buildRoutes([]*networkingv1.Ingress{ingress}, nil, nil)
+	expected := []routes.Route{
+		{Host: "app.localhost", Address: routes.Address, Ports: []int32{80}, Kind: "Ingress", Namespace: "default", Name: "web"},
+		{Host: "secure.example.test", Address: routes.Address, Ports: []int32{80, 443}, Kind: "Ingress", Namespace: "default", Name: "web"},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected routes %+v, got %+v", expected, actual)
+	}
+}
+
+func TestBuildRoutesHTTPRoute(t *testing.T) {
+	section := "web"
+	wildcard := "*.apps.localhost"
+	gw := gateway{ObjectMeta: v1.ObjectMeta{Namespace: "infra", Name: "gw"}}
+	gw.Spec.Listeners = []listener{
+		{Name: "web", Port: 8080, Protocol: "HTTP"},
+		{Name: "websecure", Port: 8443, Protocol: "HTTPS"},
+		{Name: "apps", Port: 8081, Protocol: "HTTP", Hostname: &wildcard},
+		{Name: "dns", Port: 5353, Protocol: "UDP"},
+	}
+	infra := "infra"
+
+	withHostnames := httpRoute{ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "shop"}}
+	withHostnames.Spec.Hostnames = []string{"shop.localhost"}
+	withHostnames.Spec.ParentRefs = []parentRef{{Name: "gw", Namespace: &infra, SectionName: &section}}
+
+	fromListener := httpRoute{ObjectMeta: v1.ObjectMeta{Namespace: "infra", Name: "apps"}}
+	fromListener.Spec.ParentRefs = []parentRef{{Name: "gw"}}
+
+	unknownParent := httpRoute{ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "orphan"}}
+	unknownParent.Spec.Hostnames = []string{"orphan.localhost"}
+	unknownParent.Spec.ParentRefs = []parentRef{{Name: "missing"}}
+
+	actual := buildRoutes(nil, []httpRoute{withHostnames, fromListener, unknownParent}, []gateway{gw})
+	expected := []routes.Route{
+		{Host: "shop.localhost", Address: routes.Address, Ports: []int32{8080}, Kind: "HTTPRoute", Namespace: "default", Name: "shop"},
+		{Host: "*.apps.localhost", Address: routes.Address, Ports: []int32{8081}, Kind: "HTTPRoute", Namespace: "infra", Name: "apps"},
+		{Host: "orphan.localhost", Address: routes.Address, Ports: []int32{}, Kind: "HTTPRoute", Namespace: "default", Name: "orphan"},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected routes %+v, got %+v", expected, actual)
+	}
+}
+
+// recordingTracker records the IDs added and removed.
+type recordingTracker struct {
+	added   []string
+	removed []string
+}
+
+func (t *recordingTracker) Get(string) nat.PortMap { return nil }
+
+func (t *recordingTracker) Add(id string, _ nat.PortMap) error {
+	t.added = append(t.added, id)
+	return nil
+}
+
+func (t *recordingTracker) AddWorkload(id string, _ policy.Workload, portMap nat.PortMap) error {
+	return t.Add(id, portMap)
+}
+
+func (t *recordingTracker) Remove(id string) error {
+	t.removed = append(t.removed, id)
+	return nil
+}
+
+func (t *recordingTracker) RemoveAll() error { return nil }
+
+func (t *recordingTracker) AllowsWorkload(policy.Workload) bool { return true }
+
+// changes returns the IDs added and removed so far, and clears them.
+func (t *recordingTracker) changes() ([]string, []string) {
+	added, removed := t.added, t.removed
+	t.added, t.removed = nil, nil
+	return added, removed
+}
+
+func expectChanges(t *testing.T, portTracker *recordingTracker, added, removed []string) {
+	t.Helper()
+	actualAdded, actualRemoved := portTracker.changes()
+	if !reflect.DeepEqual(added, actualAdded) || !reflect.DeepEqual(removed, actualRemoved) {
+		t.Fatalf("expected added %v removed %v, got added %v removed %v", added, removed, actualAdded, actualRemoved)
+	}
+}
+
+func TestRoutePorts(t *testing.T) {
+	portTracker := &recordingTracker{}
+	forwarded := newRoutePorts(portTracker, net.IPv4zero)
+
+	appRoutes := []routes.Route{
+		{Host: "app.localhost", Ports: []int32{80, 443}},
+		{Host: "shop.localhost", Ports: []int32{80}},
+	}
+	forwarded.setRoutes(appRoutes)
+	expectChanges(t, portTracker, []string{"routes/80", "routes/443"}, nil)
+
+	// A new route port is added on its own; the others stay forwarded.
+	forwarded.setRoutes(append(slices.Clone(appRoutes), routes.Route{Host: "api.localhost", Ports: []int32{8080}}))
+	expectChanges(t, portTracker, []string{"routes/8080"}, nil)
+	forwarded.setRoutes(appRoutes)
+	expectChanges(t, portTracker, nil, []string{"routes/8080"})
+
+	// The LoadBalancer service of the ingress controller covers the ports,
+	// so the route no longer forwards them.
+	forwarded.serviceEvent(event{
+		UID:         "traefik",
+		portMapping: map[int32]corev1.Protocol{80: corev1.ProtocolTCP, 443: corev1.ProtocolTCP},
+	})
+	expectChanges(t, portTracker, nil, []string{"routes/80", "routes/443"})
+
+	// Nothing changes for an unrelated service.
+	forwarded.serviceEvent(event{UID: "dns", portMapping: map[int32]corev1.Protocol{53: corev1.ProtocolUDP}})
+	expectChanges(t, portTracker, nil, nil)
+
+	forwarded.serviceEvent(event{UID: "traefik", deleted: true})
+	expectChanges(t, portTracker, []string{"routes/80", "routes/443"}, nil)
+}
+
+func TestAllowedRoutes(t *testing.T) {
+	forwardingPolicy, err := policy.New(policy.Config{AllowKubernetesNamespaces: []string{"default"}})
+	if err != nil {
+		t.Fatalf("creating the policy failed: %s", err)
+	}
+
+	newIngress := func(namespace, name string, annotations map[string]string) *networkingv1.Ingress {
+		return &networkingv1.Ingress{
+			ObjectMeta: v1.ObjectMeta{Namespace: namespace, Name: name, Annotations: annotations},
+			Spec:       networkingv1.IngressSpec{Rules: []networkingv1.IngressRule{{Host: name + ".localhost"}}},
+		}
+	}
+	ingresses := []*networkingv1.Ingress{
+		newIngress("default", "web", nil),
+		newIngress("kube-system", "dashboard", nil),
+		newIngress("default", "private", map[string]string{policy.DefaultOptOutKey: "true"}),
+	}
+	route := httpRoute{ObjectMeta: v1.ObjectMeta{Namespace: "dev", Name: "shop"}}
+	route.Spec.Hostnames = []string{"shop.localhost"}
+
+	allowedIngresses, allowedHTTPRoutes := allowedRoutes(ingresses, []httpRoute{route}, forwardingPolicy.AllowsWorkload)
+	actual := buildRoutes(allowedIngresses, allowedHTTPRoutes, nil)
+	expected := []routes.Route{
+		{Host: "web.localhost", Address: routes.Address, Ports: []int32{80}, Kind: "Ingress", Namespace: "default", Name: "web"},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected routes %+v, got %+v", expected, actual)
+	}
+}
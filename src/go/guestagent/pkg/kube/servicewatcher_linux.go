@@ -18,11 +18,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"time"
 
 	"github.com/Masterminds/log-go"
 	"golang.org/x/sys/unix"
 	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -37,7 +39,11 @@ type event struct {
 	namespace   string
 	name        string
 	portMapping map[int32]corev1.Protocol
-	deleted     bool
+	labels      map[string]string
+	annotations map[string]string
+	// loadBalancer is set for the ports of a LoadBalancer service.
+	loadBalancer bool
+	deleted      bool
 }
 
 // watchServices monitors for NodePort and LoadBalancer services; after listing all service ports
@@ -136,6 +142,13 @@ func handleUpdate(oldObj, newObj interface{}, eventCh chan<- event) {
 	namespace := "<unknown>"
 	name := "<unknown>"
 
+	// Updates that leave the forwarded ports alone, such as the load balancer
+	// status written by this agent, would only expose the same ports again.
+	if oldSvc != nil && newSvc != nil && !forwardingChanged(oldSvc, newSvc) {
+		log.Debugf("kubernetes service update: %s/%s has no port change", newSvc.Namespace, newSvc.Name)
+		return
+	}
+
 	if oldSvc != nil {
 		namespace = oldSvc.Namespace
 		name = oldSvc.Name
@@ -184,14 +197,27 @@ func handleUpdate(oldObj, newObj interface{}, eventCh chan<- event) {
 		namespace, name, len(deleted), len(added))
 }
 
+// forwardingChanged reports whether the update changes what is forwarded for
+// the service: its type, its ports, or the labels and annotations that the
+// port forwarding policy and the PROXY protocol settings are read from.
+func forwardingChanged(oldSvc, newSvc *corev1.Service) bool {
+	return oldSvc.Spec.Type != newSvc.Spec.Type ||
+		!apiequality.Semantic.DeepEqual(oldSvc.Spec.Ports, newSvc.Spec.Ports) ||
+		!maps.Equal(oldSvc.Labels, newSvc.Labels) ||
+		!maps.Equal(oldSvc.Annotations, newSvc.Annotations)
+}
+
 func sendEvents(mapping map[int32]corev1.Protocol, svc *corev1.Service, deleted bool, eventCh chan<- event) {
 	if svc != nil {
 		eventCh <- event{
-			UID:         svc.UID,
-			namespace:   svc.Namespace,
-			name:        svc.Name,
-			portMapping: mapping,
-			deleted:     deleted,
+			UID:          svc.UID,
+			namespace:    svc.Namespace,
+			name:         svc.Name,
+			portMapping:  mapping,
+			labels:       svc.Labels,
+			annotations:  svc.Annotations,
+			loadBalancer: svc.Spec.Type == corev1.ServiceTypeLoadBalancer,
+			deleted:      deleted,
 		}
 	}
 }
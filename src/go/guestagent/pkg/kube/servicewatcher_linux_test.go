@@ -14,11 +14,24 @@ limitations under the License.
 package kube
 
 import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/policy"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
+	guestagentTypes "github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
 )
 
 func TestStatusDebugStringFormatsDebugErrorArgs(t *testing.T) {
@@ -40,3 +53,103 @@ func TestStatusDebugStringFormatsDebugErrorArgs(t *testing.T) {
 		t.Fatalf("status debug string did not include status message: %s", actual)
 	}
 }
+
+type nopForwarder struct{}
+
+func (nopForwarder) Send(guestagentTypes.PortMapping) error { return nil }
+
+// applyEvents adds the port mappings of the pending events to the tracker,
+// the way the watcher does.
+func applyEvents(t *testing.T, eventCh chan event, portTracker tracker.Tracker) {
+	t.Helper()
+	for len(eventCh) != 0 {
+		ev := <-eventCh
+		if ev.deleted {
+			continue
+		}
+		portMapping, err := createPortMapping(ev.portMapping, net.IPv4(127, 0, 0, 1))
+		if err != nil {
+			t.Fatalf("creating port mapping failed: %s", err)
+		}
+		workload := policy.Workload{Namespace: ev.namespace, Labels: ev.labels, Annotations: ev.annotations}
+		if err := portTracker.AddWorkload(string(ev.UID), workload, portMapping); err != nil {
+			t.Fatalf("adding port mapping failed: %s", err)
+		}
+	}
+}
+
+// Publishing the load balancer status updates the service, which must not
+// expose its ports again: the host-switch rejects a second expose.
+func TestLoadBalancerStatusWriteDoesNotExposeAgain(t *testing.T) {
+	var mutex sync.Mutex
+	exposed := make(map[string]bool)
+	exposeCalls := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services/forwarder/expose", func(w http.ResponseWriter, r *http.Request) {
+		var req types.ExposeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding expose request failed: %s", err)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		exposeCalls++
+		if exposed[req.Local] {
+			http.Error(w, "proxy already running", http.StatusInternalServerError)
+			return
+		}
+		exposed[req.Local] = true
+	})
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	apiTracker := tracker.NewAPITracker(ctx, nopForwarder{}, testSrv.URL, "192.168.127.2", true)
+
+	svc := newService("web", corev1.ServiceTypeLoadBalancer)
+	svc.Spec.Ports = []corev1.ServicePort{{Port: 80, Protocol: corev1.ProtocolTCP}}
+	client := fake.NewClientset(svc)
+	lbStatus := newLoadBalancerStatus(client, apiTracker)
+
+	eventCh := make(chan event, 10)
+	handleUpdate(nil, svc, eventCh)
+	ev := <-eventCh
+	eventCh <- ev
+	applyEvents(t, eventCh, apiTracker)
+	lbStatus.handle(ctx, ev)
+	lbStatus.sync(ctx)
+
+	updated, err := client.CoreV1().Services("default").Get(ctx, "web", v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting service failed: %s", err)
+	}
+	if len(updated.Status.LoadBalancer.Ingress) != 1 {
+		t.Fatalf("expected the load balancer status to be published, got %+v", updated.Status.LoadBalancer)
+	}
+
+	handleUpdate(svc, updated, eventCh)
+	if len(eventCh) != 0 {
+		t.Fatalf("expected no event for a status update, got %+v", <-eventCh)
+	}
+	applyEvents(t, eventCh, apiTracker)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if exposeCalls != 1 {
+		t.Fatalf("expected a single expose, got %d", exposeCalls)
+	}
+	for _, entry := range apiTracker.Status() {
+		if len(entry.Errors) != 0 {
+			t.Fatalf("expected no expose error, got %+v", entry.Errors)
+		}
+	}
+
+	// A change of ports is still forwarded.
+	changed := updated.DeepCopy()
+	changed.Spec.Ports = append(changed.Spec.Ports, corev1.ServicePort{Port: 443, Protocol: corev1.ProtocolTCP})
+	handleUpdate(updated, changed, eventCh)
+	if len(eventCh) != 1 {
+		t.Fatalf("expected an event for a port change, got %d", len(eventCh))
+	}
+}
@@ -34,10 +34,14 @@ import (
 	"github.com/docker/go-connections/nat"
 	"golang.org/x/sys/unix"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/metrics"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/policy"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/routes"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
 )
 
@@ -52,25 +56,71 @@ const (
 	stateWatching
 )
 
+func (s watcherState) String() string {
+	switch s {
+	case stateNoConfig:
+		return "no-config"
+	case stateDisconnected:
+		return "disconnected"
+	case stateWatching:
+		return "watching"
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+// enterState records the transition to state in the metrics and returns it.
+func enterState(state watcherState) watcherState {
+	for _, s := range []watcherState{stateNoConfig, stateDisconnected, stateWatching} {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		metrics.KubeWatcherState.Set(value, s.String())
+	}
+	metrics.KubeWatcherTransitions.Inc(state.String())
+	return state
+}
+
+// loadBalancerSyncInterval is how often the load balancer status of the
+// services whose ports are not forwarded yet is checked again.
+const loadBalancerSyncInterval = 5 * time.Second
+
 // WatchForServices watches Kubernetes for NodePort and LoadBalancer services
-// and create listeners on 0.0.0.0 matching them.
+// and create listeners on 0.0.0.0 matching them. With
+// updateLoadBalancerStatus, it also writes the host-facing address to the
+// status of the LoadBalancer services it forwards. With a routeTable, it
+// also watches Ingresses and Gateway API HTTPRoutes, keeps the table up
+// to date with their host names and forwards their ports.
 // Any connection errors are ignored and retried.
 func WatchForServices(
 	ctx context.Context,
 	configPath string,
 	k8sServiceListenerIP net.IP,
 	portTracker tracker.Tracker,
+	updateLoadBalancerStatus bool,
+	routeTable *routes.Table,
 ) error {
 	// These variables are shared across the different states
 	var (
-		state     = stateNoConfig
+		state     = enterState(stateNoConfig)
 		err       error
 		config    *restclient.Config
 		clientset *kubernetes.Clientset
 		eventCh   <-chan event
 		errorCh   <-chan error
+		lbStatus  *loadBalancerStatus
+		routesCh  <-chan struct{}
+		getRoutes func() []routes.Route
+		forwarded *routePorts
 	)
 
+	if routeTable != nil {
+		forwarded = newRoutePorts(portTracker, k8sServiceListenerIP)
+	}
+
+	syncTicker := time.NewTicker(loadBalancerSyncInterval)
+	defer syncTicker.Stop()
+
 	watchContext, watchCancel := context.WithCancel(ctx)
 
 	// Always cancel if we failed.
@@ -98,7 +148,7 @@ func WatchForServices(
 
 			log.Debugf("kubernetes: loaded kubeconfig %s", configPath)
 
-			state = stateDisconnected
+			state = enterState(stateDisconnected)
 		case stateDisconnected:
 			clientset, err = kubernetes.NewForConfig(config)
 			if err != nil {
@@ -129,7 +179,18 @@ func WatchForServices(
 
 			log.Debugf("watching kubernetes services")
 
-			state = stateWatching
+			if updateLoadBalancerStatus {
+				lbStatus = newLoadBalancerStatus(clientset, portTracker)
+			}
+
+			if routeTable != nil {
+				routesCh, getRoutes, err = startRouteWatch(watchContext, config, clientset, portTracker.AllowsWorkload)
+				if err != nil {
+					log.Errorf("kubernetes: not watching ingresses and routes: %s", err)
+				}
+			}
+
+			state = enterState(stateWatching)
 		case stateWatching:
 			select {
 			case <-ctx.Done():
@@ -144,11 +205,20 @@ func WatchForServices(
 				})
 				watchCancel()
 
-				state = stateNoConfig
+				state = enterState(stateNoConfig)
 
 				time.Sleep(time.Second)
 
 				continue
+			case <-syncTicker.C:
+				if lbStatus != nil {
+					lbStatus.sync(ctx)
+				}
+			case <-routesCh:
+				currentRoutes := getRoutes()
+				routeTable.Set(currentRoutes)
+				forwarded.setRoutes(currentRoutes)
+				log.Debugf("kubernetes: %d routes", len(currentRoutes))
 			case event := <-eventCh:
 				if event.deleted {
 					if err := portTracker.Remove(string(event.UID)); err != nil {
@@ -174,7 +244,12 @@ func WatchForServices(
 
 						continue
 					}
-					if err := portTracker.Add(string(event.UID), portMapping); err != nil {
+					workload := policy.Workload{
+						Namespace:   event.namespace,
+						Labels:      event.labels,
+						Annotations: event.annotations,
+					}
+					if err := portTracker.AddWorkload(string(event.UID), workload, portMapping); err != nil {
 						log.Errorf("failed to add port mapping: %v from tracker UID: %v namespace: %s name: %s failed: %s",
 							event.portMapping,
 							event.UID,
@@ -186,11 +261,32 @@ func WatchForServices(
 							event.namespace, event.name, event.portMapping)
 					}
 				}
+				if lbStatus != nil {
+					lbStatus.handle(ctx, event)
+				}
+				if forwarded != nil {
+					forwarded.serviceEvent(event)
+				}
 			}
 		}
 	}
 }
 
+// startRouteWatch starts watching the ingresses and routes with a dynamic
+// client for the Gateway API objects.
+func startRouteWatch(
+	ctx context.Context,
+	config *restclient.Config,
+	clientset *kubernetes.Clientset,
+	allows func(policy.Workload) bool,
+) (<-chan struct{}, func() []routes.Route, error) {
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Kubernetes dynamic client: %w", err)
+	}
+	return watchRoutes(ctx, clientset, dynamicClient, allows)
+}
+
 // getClientConfig returns a rest config.
 func getClientConfig(configPath string) (*restclient.Config, error) {
 	loadingRules := clientcmd.ClientConfigLoadingRules{
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"net"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/routes"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
 )
 
@@ -28,6 +29,8 @@ func WatchForServices(
 	configPath string,
 	k8sServiceListenerIP net.IP,
 	portTracker tracker.Tracker,
+	updateLoadBalancerStatus bool,
+	routeTable *routes.Table,
 ) error {
 	return fmt.Errorf("not implemented for non-linux")
 }
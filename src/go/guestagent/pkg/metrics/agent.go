@@ -0,0 +1,80 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"runtime"
+)
+
+// The metrics the guest agent packages update. The tracked port mappings
+// are registered by main through NewGaugeFunc, as they are read from the
+// tracker when scraped.
+var (
+	// HostSwitchRequestDuration is the latency of the host-switch
+	// forwarder API calls, by operation (expose, unexpose, all).
+	HostSwitchRequestDuration = Default.NewHistogram(
+		"guestagent_host_switch_request_duration_seconds",
+		"Latency of the host-switch forwarder API calls.",
+		DefaultBuckets, "operation")
+	// HostSwitchRequestFailures counts the failed host-switch forwarder
+	// API calls, by operation.
+	HostSwitchRequestFailures = Default.NewCounter(
+		"guestagent_host_switch_request_failures_total",
+		"Number of failed host-switch forwarder API calls.",
+		"operation")
+	// EventStreamReconnects counts the times the event stream of a
+	// container engine ended with an error, after which the agent
+	// reconnects, by monitor (docker, containerd).
+	EventStreamReconnects = Default.NewCounter(
+		"guestagent_event_stream_reconnects_total",
+		"Number of container engine event stream failures that led to a reconnect.",
+		"monitor")
+	// KubeWatcherState is 1 for the current state of the Kubernetes
+	// service watcher (no-config, disconnected, watching) and 0 for the
+	// others.
+	KubeWatcherState = Default.NewGauge(
+		"guestagent_kube_watcher_state",
+		"Current state of the Kubernetes service watcher.",
+		"state")
+	// KubeWatcherTransitions counts the state changes of the Kubernetes
+	// service watcher, by the state entered.
+	KubeWatcherTransitions = Default.NewCounter(
+		"guestagent_kube_watcher_transitions_total",
+		"Number of state changes of the Kubernetes service watcher, by state entered.",
+		"state")
+	// ListenerScanDuration is the time the procnet scanner takes to
+	// snapshot the listening sockets, through sock_diag or /proc/net.
+	ListenerScanDuration = Default.NewHistogram(
+		"guestagent_listener_scan_duration_seconds",
+		"Duration of the scans of the listening sockets.",
+		DefaultBuckets)
+	// ListenerScanFailures counts the failed scans of the listening
+	// sockets.
+	ListenerScanFailures = Default.NewCounter(
+		"guestagent_listener_scan_failures_total",
+		"Number of failed scans of the listening sockets.")
+)
+
+func init() {
+	Default.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", "",
+		func() map[string]float64 {
+			return map[string]float64{"": float64(runtime.NumGoroutine())}
+		})
+	Default.NewGaugeFunc("go_memstats_heap_alloc_bytes", "Number of heap bytes allocated and still in use.", "",
+		func() map[string]float64 {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			return map[string]float64{"": float64(stats.HeapAlloc)}
+		})
+}
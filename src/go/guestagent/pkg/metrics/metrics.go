@@ -0,0 +1,383 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics collects the guest agent metrics and serves them in the
+// Prometheus text exposition format. It only implements the counters,
+// gauges and histograms that the guest agent needs.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram buckets, in seconds, used for latencies.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the registry that the guest agent packages register their
+// metrics with, and that Serve exposes.
+var Default = NewRegistry()
+
+type collector interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry holds a set of metrics with unique names.
+type Registry struct {
+	collectors map[string]collector
+	mutex      sync.Mutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: make(map[string]collector),
+	}
+}
+
+func (r *Registry) register(c collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.collectors[c.name()]; ok {
+		panic(fmt.Sprintf("metric %s is already registered", c.name()))
+	}
+	r.collectors[c.name()] = c
+}
+
+// WriteTo writes every metric of the registry, sorted by name, in the
+// Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mutex.Lock()
+	collectors := make([]collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		collectors = append(collectors, c)
+	}
+	r.mutex.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].name() < collectors[j].name()
+	})
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP serves the metrics of the registry.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+// NewCounter registers a counter with the given label names.
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{}
+	c.init(name, help, "counter", labelNames)
+	r.register(c)
+	return c
+}
+
+// NewGauge registers a gauge with the given label names.
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Gauge {
+	g := &Gauge{}
+	g.init(name, help, "gauge", labelNames)
+	r.register(g)
+	return g
+}
+
+// NewHistogram registers a histogram with the given upper bounds, in
+// increasing order, and label names.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	h := &Histogram{buckets: slices.Clone(buckets)}
+	h.init(name, help, "histogram", labelNames)
+	r.register(h)
+	return h
+}
+
+// NewGaugeFunc registers a gauge whose values are collected by fn when the
+// metrics are written. fn returns the values keyed by the value of the
+// single label labelName; with an empty labelName, it returns a single
+// value keyed by "".
+func (r *Registry) NewGaugeFunc(name, help, labelName string, fn func() map[string]float64) {
+	var labelNames []string
+	if labelName != "" {
+		labelNames = []string{labelName}
+	}
+	g := &gaugeFunc{fn: fn}
+	g.init(name, help, "gauge", labelNames)
+	r.register(g)
+}
+
+// family is what the metric types have in common: a name, a help text, and
+// a series per combination of label values.
+type family struct {
+	metricName string
+	help       string
+	kind       string
+	labelNames []string
+	mutex      sync.Mutex
+	series     map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// bucketCounts and count are only used by histograms; value holds
+	// the sum of the observations.
+	bucketCounts []uint64
+	count        uint64
+}
+
+// init initializes the family in place, as it holds a mutex that must not
+// be copied.
+func (f *family) init(name, help, kind string, labelNames []string) {
+	f.metricName = name
+	f.help = help
+	f.kind = kind
+	f.labelNames = labelNames
+	f.series = make(map[string]*series)
+	// A metric without labels is reported from the start, even before
+	// its first update.
+	if len(labelNames) == 0 && kind != "histogram" {
+		f.get(nil)
+	}
+}
+
+func (f *family) name() string {
+	return f.metricName
+}
+
+// get returns the series for labelValues, creating it if needed; the
+// caller holds the mutex.
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s has labels %v, got values %v", f.metricName, f.labelNames, labelValues))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(labelValues)}
+		f.series[key] = s
+	}
+	return s
+}
+
+// sortedSeries returns a copy of the series, sorted by label values; the
+// caller holds the mutex.
+func (f *family) sortedSeries() []series {
+	out := make([]series, 0, len(f.series))
+	for _, s := range f.series {
+		c := *s
+		c.bucketCounts = slices.Clone(s.bucketCounts)
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return slices.Compare(out[i].labelValues, out[j].labelValues) < 0
+	})
+	return out
+}
+
+func (f *family) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.metricName, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.metricName, f.kind)
+}
+
+// writeSample writes one sample line; extraName and extraValue add a label
+// after the ones of the family, as for the "le" label of histograms.
+func (f *family) writeSample(w *bufio.Writer, suffix string, labelValues []string, extraName, extraValue string, value float64) {
+	w.WriteString(f.metricName)
+	w.WriteString(suffix)
+
+	var labels []string
+	for i, labelName := range f.labelNames {
+		labels = append(labels, labelName+`="`+labelValueEscaper.Replace(labelValues[i])+`"`)
+	}
+	if extraName != "" {
+		labels = append(labels, extraName+`="`+extraValue+`"`)
+	}
+	if len(labels) != 0 {
+		w.WriteString("{" + strings.Join(labels, ",") + "}")
+	}
+
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	family
+}
+
+// Inc adds one to the counter for the given label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter for the given
+// label values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", c.metricName))
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.get(labelValues).value += v
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mutex.Lock()
+	all := c.sortedSeries()
+	c.mutex.Unlock()
+
+	c.writeHeader(w)
+	for _, s := range all {
+		c.writeSample(w, "", s.labelValues, "", "", s.value)
+	}
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	family
+}
+
+// Set sets the gauge for the given label values.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.get(labelValues).value = v
+}
+
+// Add adds v, which may be negative, to the gauge for the given label values.
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.get(labelValues).value += v
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.mutex.Lock()
+	all := g.sortedSeries()
+	g.mutex.Unlock()
+
+	g.writeHeader(w)
+	for _, s := range all {
+		g.writeSample(w, "", s.labelValues, "", "", s.value)
+	}
+}
+
+type gaugeFunc struct {
+	family
+	fn func() map[string]float64
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	values := g.fn()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	g.writeHeader(w)
+	for _, key := range keys {
+		var labelValues []string
+		if len(g.labelNames) != 0 {
+			labelValues = []string{key}
+		}
+		g.writeSample(w, "", labelValues, "", "", values[key])
+	}
+}
+
+// Histogram counts observations, such as latencies, in buckets.
+type Histogram struct {
+	family
+	buckets []float64
+}
+
+// Observe adds an observation for the given label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	s := h.get(labelValues)
+	if s.bucketCounts == nil {
+		s.bucketCounts = make([]uint64, len(h.buckets))
+	}
+	for i, upperBound := range h.buckets {
+		if v <= upperBound {
+			s.bucketCounts[i]++
+		}
+	}
+	s.count++
+	s.value += v
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mutex.Lock()
+	all := h.sortedSeries()
+	h.mutex.Unlock()
+
+	h.writeHeader(w)
+	for _, s := range all {
+		for i, upperBound := range h.buckets {
+			h.writeSample(w, "_bucket", s.labelValues, "le", formatFloat(upperBound), float64(s.bucketCounts[i]))
+		}
+		h.writeSample(w, "_bucket", s.labelValues, "le", "+Inf", float64(s.count))
+		h.writeSample(w, "_sum", s.labelValues, "", "", s.value)
+		h.writeSample(w, "_count", s.labelValues, "", "", float64(s.count))
+	}
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,156 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/metrics"
+)
+
+func TestWriteTo(t *testing.T) {
+	t.Parallel()
+
+	registry := metrics.NewRegistry()
+	counter := registry.NewCounter("test_events_total", "Number of events.", "monitor")
+	gauge := registry.NewGauge("test_state", "Current state.\nSecond line.")
+	histogram := registry.NewHistogram("test_duration_seconds", "Duration.", []float64{0.1, 1}, "operation")
+	registry.NewGaugeFunc("test_tracked", "Tracked IDs.", "source", func() map[string]float64 {
+		return map[string]float64{"kube": 1, "docker": 2}
+	})
+
+	counter.Inc("docker")
+	counter.Add(2, `con"tainer\d`)
+	gauge.Set(3)
+	gauge.Add(-1)
+	histogram.Observe(0.05, "expose")
+	histogram.Observe(0.5, "expose")
+	histogram.Observe(5, "expose")
+
+	var out strings.Builder
+	_, err := registry.WriteTo(&out)
+	require.NoError(t, err)
+	assert.Equal(t, `# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{operation="expose",le="0.1"} 1
+test_duration_seconds_bucket{operation="expose",le="1"} 2
+test_duration_seconds_bucket{operation="expose",le="+Inf"} 3
+test_duration_seconds_sum{operation="expose"} 5.55
+test_duration_seconds_count{operation="expose"} 3
+# HELP test_events_total Number of events.
+# TYPE test_events_total counter
+test_events_total{monitor="con\"tainer\\d"} 2
+test_events_total{monitor="docker"} 1
+# HELP test_state Current state.\nSecond line.
+# TYPE test_state gauge
+test_state 2
+# HELP test_tracked Tracked IDs.
+# TYPE test_tracked gauge
+test_tracked{source="docker"} 2
+test_tracked{source="kube"} 1
+`, out.String())
+}
+
+func TestUnlabeledCounterStartsAtZero(t *testing.T) {
+	t.Parallel()
+
+	registry := metrics.NewRegistry()
+	registry.NewCounter("test_failures_total", "Number of failures.")
+
+	var out strings.Builder
+	_, err := registry.WriteTo(&out)
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "\ntest_failures_total 0\n")
+}
+
+func TestRegisterTwice(t *testing.T) {
+	t.Parallel()
+
+	registry := metrics.NewRegistry()
+	registry.NewGauge("test_state", "Current state.")
+	assert.Panics(t, func() {
+		registry.NewCounter("test_state", "Current state.")
+	})
+}
+
+func TestWrongLabelCount(t *testing.T) {
+	t.Parallel()
+
+	registry := metrics.NewRegistry()
+	counter := registry.NewCounter("test_events_total", "Number of events.", "monitor")
+	assert.Panics(t, func() {
+		counter.Inc()
+	})
+}
+
+func TestServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	registry := metrics.NewRegistry()
+	registry.NewCounter("test_failures_total", "Number of failures.")
+
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "# TYPE test_failures_total counter\n")
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "metrics.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- metrics.Serve(ctx, socketPath)
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	require.Eventually(t, func() bool {
+		res, err := client.Get("http://localhost/metrics")
+		if err != nil {
+			return false
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		return err == nil && res.StatusCode == http.StatusOK &&
+			strings.Contains(string(body), "# TYPE guestagent_event_stream_reconnects_total counter\n") &&
+			strings.Contains(string(body), "# TYPE go_goroutines gauge\n")
+	}, 5*time.Second, 50*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-errCh)
+	assert.NoFileExists(t, socketPath)
+}
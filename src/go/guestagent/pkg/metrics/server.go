@@ -0,0 +1,75 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/log-go"
+)
+
+// Serve serves the Default registry on /metrics until the context is
+// cancelled. An addr starting with "/" is the path of a Unix socket; any
+// other addr is a TCP host:port, e.g.:
+//
+//	curl --unix-socket /run/rancher-desktop-guestagent-metrics.sock http://localhost/metrics
+func Serve(ctx context.Context, addr string) error {
+	network := "tcp"
+	if strings.HasPrefix(addr, "/") {
+		network = "unix"
+		if err := os.Remove(addr); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing stale metrics socket %s: %w", addr, err)
+		}
+	}
+
+	var lc net.ListenConfig
+	listener, err := lc.Listen(ctx, network, addr)
+	if err != nil {
+		return fmt.Errorf("listening on metrics address %s: %w", addr, err)
+	}
+	if network == "unix" {
+		defer os.Remove(addr)
+		if err := os.Chmod(addr, 0o600); err != nil {
+			listener.Close()
+			return fmt.Errorf("setting permissions on metrics socket %s: %w", addr, err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", Default)
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Infof("serving guest agent metrics on %s", addr)
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("metrics server failed: %w", err)
+	}
+
+	return nil
+}
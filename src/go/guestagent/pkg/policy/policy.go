@@ -0,0 +1,249 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package policy decides which port mappings the guest agent forwards to
+// the host, based on a policy file. Without a policy file, every port
+// mapping is forwarded.
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-connections/nat"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultOptOutKey is the label or annotation that opts a container or a
+// service out of port forwarding when set to "true".
+const DefaultOptOutKey = "rancherdesktop.io/no-port-forward"
+
+var ErrInvalidPolicy = errors.New("invalid port forwarding policy")
+
+// Config is the content of the policy file, in YAML or JSON:
+//
+//	denyPorts: ["1-1023"]
+//	allowPorts: ["1024-65535"]
+//	allowKubernetesNamespaces: ["default", "dev"]
+//	optOutKey: rancherdesktop.io/no-port-forward
+type Config struct {
+	// AllowPorts, when not empty, limits forwarding to the host ports in
+	// these ranges.
+	AllowPorts []string `json:"allowPorts,omitempty"`
+	// DenyPorts are host port ranges that are never forwarded; they take
+	// precedence over AllowPorts.
+	DenyPorts []string `json:"denyPorts,omitempty"`
+	// AllowKubernetesNamespaces, when not empty, limits the forwarding of
+	// Kubernetes services to these namespaces, and stops the forwarding of
+	// the pod hostPorts, whose namespace is unknown. It does not apply to
+	// containers, whatever their containerd namespace.
+	AllowKubernetesNamespaces []string `json:"allowKubernetesNamespaces,omitempty"`
+	// OptOutKey overrides DefaultOptOutKey.
+	OptOutKey string `json:"optOutKey,omitempty"`
+}
+
+// Workload describes the owner of a port mapping, as far as the monitor
+// that reports it knows.
+type Workload struct {
+	// Namespace is the Kubernetes namespace of a service; it is empty for
+	// containers, containerd ones included, and for ports found by scanning.
+	Namespace   string
+	Labels      map[string]string
+	Annotations map[string]string
+	// Unattributed is set for the ports found by the iptables and nftables
+	// scanners, which include the hostPorts of pods whose namespace is not
+	// known. They are rejected while AllowKubernetesNamespaces is set.
+	Unattributed bool
+}
+
+// Rejection is a port binding that the policy did not allow.
+type Rejection struct {
+	Port    nat.Port        `json:"port"`
+	Binding nat.PortBinding `json:"binding"`
+	Reason  string          `json:"reason"`
+}
+
+type portRange struct {
+	first, last int
+}
+
+func (r portRange) contains(port int) bool {
+	return port >= r.first && port <= r.last
+}
+
+func (r portRange) String() string {
+	if r.first == r.last {
+		return strconv.Itoa(r.first)
+	}
+	return fmt.Sprintf("%d-%d", r.first, r.last)
+}
+
+// Policy is a parsed Config. A nil *Policy allows everything.
+type Policy struct {
+	allowPorts                []portRange
+	denyPorts                 []portRange
+	allowKubernetesNamespaces []string
+	optOutKey                 string
+}
+
+// New validates config and returns the corresponding Policy.
+func New(config Config) (*Policy, error) {
+	p := &Policy{
+		allowKubernetesNamespaces: slices.Clone(config.AllowKubernetesNamespaces),
+		optOutKey:                 config.OptOutKey,
+	}
+	if p.optOutKey == "" {
+		p.optOutKey = DefaultOptOutKey
+	}
+
+	var err error
+	if p.allowPorts, err = parsePortRanges(config.AllowPorts); err != nil {
+		return nil, fmt.Errorf("%w: allowPorts: %w", ErrInvalidPolicy, err)
+	}
+	if p.denyPorts, err = parsePortRanges(config.DenyPorts); err != nil {
+		return nil, fmt.Errorf("%w: denyPorts: %w", ErrInvalidPolicy, err)
+	}
+
+	return p, nil
+}
+
+// Load reads the policy file at path. A missing file is not an error and
+// returns a nil Policy, which allows everything.
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading port forwarding policy failed: %w", err)
+	}
+
+	var config Config
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalidPolicy, path, err)
+	}
+
+	return New(config)
+}
+
+// Evaluate splits portMap into the bindings the policy allows for the
+// workload and the ones it rejects.
+func (p *Policy) Evaluate(portMap nat.PortMap, workload Workload) (nat.PortMap, []Rejection) {
+	if p == nil {
+		return portMap, nil
+	}
+
+	allowed := make(nat.PortMap, len(portMap))
+	var rejected []Rejection
+
+	workloadReason := p.rejectWorkload(workload)
+	for portProto, portBindings := range portMap {
+		for _, portBinding := range portBindings {
+			reason := workloadReason
+			if reason == "" {
+				reason = p.rejectPort(portBinding.HostPort)
+			}
+			if reason != "" {
+				rejected = append(rejected, Rejection{Port: portProto, Binding: portBinding, Reason: reason})
+				continue
+			}
+			allowed[portProto] = append(allowed[portProto], portBinding)
+		}
+	}
+
+	return allowed, rejected
+}
+
+// AllowsWorkload reports whether the namespace and opt-out rules let the
+// ports of workload be forwarded, before looking at the ports themselves.
+func (p *Policy) AllowsWorkload(workload Workload) bool {
+	return p == nil || p.rejectWorkload(workload) == ""
+}
+
+func (p *Policy) rejectWorkload(workload Workload) string {
+	if strings.EqualFold(workload.Labels[p.optOutKey], "true") {
+		return fmt.Sprintf("opted out by label %s", p.optOutKey)
+	}
+	if strings.EqualFold(workload.Annotations[p.optOutKey], "true") {
+		return fmt.Sprintf("opted out by annotation %s", p.optOutKey)
+	}
+	if workload.Unattributed && len(p.allowKubernetesNamespaces) != 0 {
+		return "the namespace of the owner is unknown while allowKubernetesNamespaces is set"
+	}
+	if workload.Namespace != "" && len(p.allowKubernetesNamespaces) != 0 && !slices.Contains(p.allowKubernetesNamespaces, workload.Namespace) {
+		return fmt.Sprintf("namespace %s is not allowed", workload.Namespace)
+	}
+	return ""
+}
+
+func (p *Policy) rejectPort(hostPort string) string {
+	port, err := strconv.Atoi(hostPort)
+	if err != nil {
+		// Not for the policy to judge; exposing it will fail anyway.
+		return ""
+	}
+	for _, r := range p.denyPorts {
+		if r.contains(port) {
+			return fmt.Sprintf("host port %d is in denied range %s", port, r)
+		}
+	}
+	if len(p.allowPorts) == 0 {
+		return ""
+	}
+	for _, r := range p.allowPorts {
+		if r.contains(port) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("host port %d is not in an allowed range", port)
+}
+
+// parsePortRanges parses port ranges written as "N" or "N-M".
+func parsePortRanges(specs []string) ([]portRange, error) {
+	ranges := make([]portRange, 0, len(specs))
+	for _, spec := range specs {
+		first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+		if !found {
+			last = first
+		}
+		firstPort, err := parsePort(first)
+		if err != nil {
+			return nil, fmt.Errorf("port range %q: %w", spec, err)
+		}
+		lastPort, err := parsePort(last)
+		if err != nil {
+			return nil, fmt.Errorf("port range %q: %w", spec, err)
+		}
+		if firstPort > lastPort {
+			return nil, fmt.Errorf("port range %q is reversed", spec)
+		}
+		ranges = append(ranges, portRange{first: firstPort, last: lastPort})
+	}
+	return ranges, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	if port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port %d is out of range", port)
+	}
+	return port, nil
+}
@@ -0,0 +1,174 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/policy"
+)
+
+func portMap(t *testing.T, hostPorts ...string) nat.PortMap {
+	t.Helper()
+	portMap := make(nat.PortMap)
+	for _, hostPort := range hostPorts {
+		port, err := nat.NewPort("tcp", hostPort)
+		require.NoError(t, err)
+		portMap[port] = []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: hostPort}}
+	}
+	return portMap
+}
+
+func TestNilPolicyAllowsAll(t *testing.T) {
+	t.Parallel()
+
+	var p *policy.Policy
+	allowed, rejected := p.Evaluate(portMap(t, "22", "8080"), policy.Workload{Namespace: "kube-system"})
+	assert.Equal(t, portMap(t, "22", "8080"), allowed)
+	assert.Empty(t, rejected)
+}
+
+func TestPortRanges(t *testing.T) {
+	t.Parallel()
+
+	p, err := policy.New(policy.Config{
+		AllowPorts: []string{"1024-9000", "9443"},
+		DenyPorts:  []string{"1-1023", "8000-8099"},
+	})
+	require.NoError(t, err)
+
+	allowed, rejected := p.Evaluate(portMap(t, "22", "8080", "8443", "9443", "9444"), policy.Workload{})
+	assert.Equal(t, portMap(t, "8443", "9443"), allowed)
+
+	reasons := make(map[string]string, len(rejected))
+	for _, r := range rejected {
+		reasons[r.Binding.HostPort] = r.Reason
+	}
+	assert.Equal(t, map[string]string{
+		"22":   "host port 22 is in denied range 1-1023",
+		"8080": "host port 8080 is in denied range 8000-8099",
+		"9444": "host port 9444 is not in an allowed range",
+	}, reasons)
+}
+
+func TestWorkloadRules(t *testing.T) {
+	t.Parallel()
+
+	p, err := policy.New(policy.Config{AllowKubernetesNamespaces: []string{"default"}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		workload policy.Workload
+		reason   string
+	}{
+		{
+			name:     "allowed namespace",
+			workload: policy.Workload{Namespace: "default"},
+		},
+		{
+			name:     "container without namespace",
+			workload: policy.Workload{Labels: map[string]string{"app": "web"}},
+		},
+		{
+			name:     "unattributed hostPort",
+			workload: policy.Workload{Unattributed: true},
+			reason:   "the namespace of the owner is unknown while allowKubernetesNamespaces is set",
+		},
+		{
+			name:     "containerd container",
+			workload: policy.Workload{Labels: map[string]string{"nerdctl/namespace": "dev"}},
+		},
+		{
+			name:     "denied namespace",
+			workload: policy.Workload{Namespace: "kube-system"},
+			reason:   "namespace kube-system is not allowed",
+		},
+		{
+			name:     "opt-out label",
+			workload: policy.Workload{Labels: map[string]string{policy.DefaultOptOutKey: "true"}},
+			reason:   "opted out by label " + policy.DefaultOptOutKey,
+		},
+		{
+			name: "opt-out annotation",
+			workload: policy.Workload{
+				Namespace:   "default",
+				Annotations: map[string]string{policy.DefaultOptOutKey: "True"},
+			},
+			reason: "opted out by annotation " + policy.DefaultOptOutKey,
+		},
+		{
+			name:     "opt-out label set to false",
+			workload: policy.Workload{Labels: map[string]string{policy.DefaultOptOutKey: "false"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, rejected := p.Evaluate(portMap(t, "8080"), tt.workload)
+			if tt.reason == "" {
+				assert.Equal(t, portMap(t, "8080"), allowed)
+				assert.Empty(t, rejected)
+				return
+			}
+			assert.Empty(t, allowed)
+			require.Len(t, rejected, 1)
+			assert.Equal(t, tt.reason, rejected[0].Reason)
+		})
+	}
+}
+
+func TestInvalidPortRange(t *testing.T) {
+	t.Parallel()
+
+	for _, spec := range []string{"http", "0", "65536", "2000-1000", "1-"} {
+		_, err := policy.New(policy.Config{DenyPorts: []string{spec}})
+		assert.ErrorIs(t, err, policy.ErrInvalidPolicy, spec)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	p, err := policy.Load(filepath.Join(dir, "missing.yaml"))
+	require.NoError(t, err)
+	assert.Nil(t, p)
+
+	path := filepath.Join(dir, "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+denyPorts: ["1-1023"]
+allowKubernetesNamespaces: ["default"]
+optOutKey: example.com/private
+`), 0o600))
+	p, err = policy.Load(path)
+	require.NoError(t, err)
+	_, rejected := p.Evaluate(portMap(t, "8080"), policy.Workload{Labels: map[string]string{"example.com/private": "true"}})
+	require.Len(t, rejected, 1)
+	assert.Equal(t, "opted out by label example.com/private", rejected[0].Reason)
+	_, rejected = p.Evaluate(portMap(t, "8080"), policy.Workload{Namespace: "dev"})
+	require.Len(t, rejected, 1)
+	assert.Equal(t, "namespace dev is not allowed", rejected[0].Reason)
+
+	require.NoError(t, os.WriteFile(path, []byte("denyPort: [\"22\"]\n"), 0o600))
+	_, err = policy.Load(path)
+	assert.ErrorIs(t, err, policy.ErrInvalidPolicy)
+}
@@ -7,11 +7,11 @@ You may obtain a copy of the License at
 */
 
 // loopbackForwarder runs a userspace TCP/UDP proxy inside the container
-// engine's network namespace. For each 127.0.0.1 listener procnet
+// engine's network namespace. For each 127.0.0.1 or ::1 listener procnet
 // observes (--network=host containers), it opens a matching listener on
 // bindIP -- the tap-interface IP that gvisor-tap-vsock host-switch
-// already routes to -- and pipes accepted connections to
-// 127.0.0.1:<port>.
+// already routes to -- and pipes accepted connections to the loopback
+// listener's address.
 //
 // This replaces the PREROUTING DNAT rule procnet previously wrote into
 // the nat table. Both paths bridge eth0-arriving traffic to the
@@ -66,9 +66,10 @@ func key(proto string, port uint16) string {
 	return proto + "/" + strconv.Itoa(int(port))
 }
 
-// Add opens a userspace forwarder for proto/port. Repeated Adds for
-// the same key are idempotent. The caller must call Remove when the
-// upstream listener disappears.
+// Add opens a userspace forwarder for proto/port that dials
+// upstreamIP:port. Repeated Adds for the same key are idempotent, and
+// keep the upstream of the first Add. The caller must call Remove when
+// the upstream listener disappears.
 //
 // EADDRINUSE on the bind step propagates as a plain listen error.
 // The scanner's publish path rolls back the tracker entry and retries
@@ -79,8 +80,9 @@ func key(proto string, port uint16) string {
 // a wildcard entry, since the wildcard listener already accepts
 // bindIP:port directly. The remaining EADDRINUSE trigger is an
 // unrelated process inside the engine namespace holding bindIP:port.
-func (f *loopbackForwarder) Add(ctx context.Context, proto string, port uint16) error {
+func (f *loopbackForwarder) Add(ctx context.Context, proto string, port uint16, upstreamIP string) error {
 	k := key(proto, port)
+	target := net.JoinHostPort(upstreamIP, strconv.Itoa(int(port)))
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
@@ -94,7 +96,7 @@ func (f *loopbackForwarder) Add(ctx context.Context, proto string, port uint16)
 			return fmt.Errorf("listen %s: %w", k, err)
 		}
 		f.tcp[k] = lis
-		go f.acceptTCP(ctx, lis, port)
+		go f.acceptTCP(ctx, lis, port, target)
 	case protoUDP:
 		if _, ok := f.udp[k]; ok {
 			return nil
@@ -103,7 +105,6 @@ func (f *loopbackForwarder) Add(ctx context.Context, proto string, port uint16)
 		if err != nil {
 			return fmt.Errorf("listen %s: %w", k, err)
 		}
-		target := net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port)))
 		// Each flow's idle timeout is forwarder.UDPConnTrackTimeout (90s).
 		// The dial closure runs for every new client flow, including
 		// flows that arrive long after Add returns. ctx must therefore
@@ -174,7 +175,7 @@ const (
 	halfCloseDrainTimeout     = 30 * time.Second
 )
 
-func (f *loopbackForwarder) acceptTCP(ctx context.Context, lis net.Listener, port uint16) {
+func (f *loopbackForwarder) acceptTCP(ctx context.Context, lis net.Listener, port uint16, target string) {
 	backoff := acceptRetryInitialBackoff
 	// loggedAcceptError throttles per-listener Accept-error logs the
 	// same way logAddFailure throttles publish-failure logs in the
@@ -210,14 +211,13 @@ func (f *loopbackForwarder) acceptTCP(ctx context.Context, lis net.Listener, por
 		}
 		backoff = acceptRetryInitialBackoff
 		loggedAcceptError = false
-		go f.pipeTCP(ctx, conn, port)
+		go f.pipeTCP(ctx, conn, port, target)
 	}
 }
 
-func (f *loopbackForwarder) pipeTCP(ctx context.Context, in net.Conn, port uint16) {
+func (f *loopbackForwarder) pipeTCP(ctx context.Context, in net.Conn, port uint16, target string) {
 	defer in.Close()
-	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port)))
-	out, err := f.dialer.DialContext(ctx, protoTCP, addr)
+	out, err := f.dialer.DialContext(ctx, protoTCP, target)
 	if err != nil {
 		log.Debugf("loopback forwarder dial tcp/%d: %s", port, err)
 		return
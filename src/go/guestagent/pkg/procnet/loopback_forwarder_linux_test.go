@@ -61,7 +61,7 @@ func TestForwarderTCPEndToEnd(t *testing.T) {
 	bindIP := net.ParseIP("127.0.0.99")
 	fwd := newLoopbackForwarder(bindIP)
 	defer fwd.Close()
-	if err := fwd.Add(context.Background(), "tcp", port); err != nil {
+	if err := fwd.Add(context.Background(), "tcp", port, "127.0.0.1"); err != nil {
 		t.Fatalf("forwarder.Add: %v", err)
 	}
 
@@ -89,7 +89,7 @@ func TestForwarderRemoveStopsListening(t *testing.T) {
 	fwd := newLoopbackForwarder(bindIP)
 	defer fwd.Close()
 
-	if err := fwd.Add(context.Background(), "tcp", port); err != nil {
+	if err := fwd.Add(context.Background(), "tcp", port, "127.0.0.1"); err != nil {
 		t.Fatalf("forwarder.Add: %v", err)
 	}
 	// Sanity: forwarder is listening.
@@ -122,7 +122,7 @@ func TestForwarderAddIsIdempotent(t *testing.T) {
 	defer fwd.Close()
 
 	for i := 0; i < 3; i++ {
-		if err := fwd.Add(context.Background(), "tcp", port); err != nil {
+		if err := fwd.Add(context.Background(), "tcp", port, "127.0.0.1"); err != nil {
 			t.Fatalf("forwarder.Add iteration %d: %v", i, err)
 		}
 	}
@@ -163,7 +163,7 @@ func TestForwarderAddFailsWhenPortInUse(t *testing.T) {
 			fwd := newLoopbackForwarder(bindIP)
 			defer fwd.Close()
 
-			if err := fwd.Add(context.Background(), tc.proto, port); err == nil {
+			if err := fwd.Add(context.Background(), tc.proto, port, "127.0.0.1"); err == nil {
 				t.Fatalf("forwarder.Add succeeded on busy port; expected EADDRINUSE")
 			}
 		})
@@ -206,7 +206,7 @@ func TestForwarderUDPEndToEnd(t *testing.T) {
 	bindIP := net.ParseIP("127.0.0.99")
 	fwd := newLoopbackForwarder(bindIP)
 	defer fwd.Close()
-	if err := fwd.Add(context.Background(), "udp", port); err != nil {
+	if err := fwd.Add(context.Background(), "udp", port, "127.0.0.1"); err != nil {
 		t.Fatalf("forwarder.Add: %v", err)
 	}
 
@@ -238,7 +238,7 @@ func TestForwarderUDPConcurrentFlows(t *testing.T) {
 	bindIP := net.ParseIP("127.0.0.99")
 	fwd := newLoopbackForwarder(bindIP)
 	defer fwd.Close()
-	if err := fwd.Add(context.Background(), "udp", port); err != nil {
+	if err := fwd.Add(context.Background(), "udp", port, "127.0.0.1"); err != nil {
 		t.Fatalf("forwarder.Add: %v", err)
 	}
 
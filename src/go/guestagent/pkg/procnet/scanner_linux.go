@@ -12,69 +12,83 @@ limitations under the License.
 */
 
 /*
-Package procnet scans /proc/net for IPv4 TCP and UDP listeners the
+Package procnet scans /proc/net for TCP and UDP listeners the
 container-engine events handler does not publish -- mainly
---network=host containers binding 127.0.0.1 -- and exposes them to
-host-switch via the API tracker. For loopback listeners it also opens
-a userspace forwarder on the namespace's tap IP so traffic arriving
-from host-switch reaches the in-namespace 127.0.0.1 listener. A
+--network=host containers binding 127.0.0.1 or ::1 -- and exposes
+them to host-switch via the API tracker. For loopback listeners it also
+opens a userspace forwarder on the namespace's tap IP so traffic
+arriving from host-switch reaches the in-namespace loopback listener. A
 two-scan stability gate filters out the transient reservation socket
 nerdctl's OCI createRuntime hook opens before CNI installs its
 iptables rules.
 
-IPv6 limitation: procnettcp.ParseFiles returns entries from
-/proc/net/{tcp,tcp6,udp,udp6}, but addValidProtoEntryToPortMap
-dispatches only on the TCP and UDP Kinds and silently drops the
-TCP6 and UDP6 entries. A --network=host container that listens
-exclusively on [::1]:port is invisible to the scanner and is not
-reachable from Windows. Containers that bind dual-stack
-(e.g. [::]:port with IPV6_V6ONLY=0, the Go and Python default) are
-also invisible because the socket appears only in /proc/net/tcp6.
-Only listeners that bind 127.0.0.1, 0.0.0.0, or both v4 and v6
-separately are reachable from Windows.
+IPv6: entries from /proc/net/{tcp6,udp6} are published alongside the
+IPv4 ones, so a listener on [::1]:port or a dual-stack [::]:port is
+reachable from Windows. host-switch only connects to the tap interface
+over IPv4; a [::] listener is treated like 0.0.0.0 (dual-stack sockets,
+the Go and Python default, accept IPv4 as well) and a [::1]-only
+listener is reached through the loopback forwarder, which dials ::1
+when no 127.0.0.1 listener shares the port. A listener that sets
+IPV6_V6ONLY on [::] is published but cannot be reached through the
+IPv4 tap address.
+
+Snapshots come from a listenerSource: NewProcNetScanner parses the
+/proc/net tables, while NewSockDiagScanner dumps the sockets over
+netlink sock_diag, which is cheap enough to poll at a shorter interval,
+and falls back to /proc/net for a scan where the dump fails. Both feed
+the same Tick reconciliation.
 */
 package procnet
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Masterminds/log-go"
 	"github.com/docker/go-connections/nat"
 	"github.com/lima-vm/lima/pkg/guestagent/procnettcp"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/audit"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/metrics"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/utils"
 )
 
 const (
-	loopbackIP = "127.0.0.1"
-	wildcardIP = "0.0.0.0"
+	loopbackIP   = "127.0.0.1"
+	loopbackIPv6 = "::1"
+	wildcardIP   = "0.0.0.0"
+	wildcardIPv6 = "::"
 )
 
 // loopbackController is what the scanner calls to manage userspace
-// listeners for 127.0.0.1 ports. The real implementation opens listeners
-// on bindIP; unit tests substitute a recording fake.
+// listeners for loopback ports. The real implementation opens listeners
+// on bindIP and dials upstreamIP; unit tests substitute a recording fake.
 type loopbackController interface {
-	Add(ctx context.Context, proto string, port uint16) error
+	Add(ctx context.Context, proto string, port uint16, upstreamIP string) error
 	Remove(proto string, port uint16) error
 	Close() error
 }
 
-// ProcNetScanner polls /proc/net for IPv4 TCP and UDP listeners
-// and reconciles the observed set against the API tracker and a
-// userspace loopback forwarder. See the package comment for the
-// design, including the IPv6 limitation.
+// ProcNetScanner polls /proc/net for TCP and UDP listeners and
+// reconciles the observed set against the API tracker and a userspace
+// loopback forwarder. See the package comment for the design,
+// including how IPv6 listeners are reached.
 type ProcNetScanner struct {
-	ctx          context.Context
-	tracker      tracker.Tracker
-	forwarder    loopbackController
-	bindIP       net.IP
-	scanInterval time.Duration
+	ctx       context.Context
+	tracker   tracker.Tracker
+	forwarder loopbackController
+	source    listenerSource
+	bindIP    net.IP
+	// scanInterval is the poll cadence, in nanoseconds; it can be
+	// changed while ForwardPorts runs.
+	scanInterval atomic.Int64
 
 	published nat.PortMap
 	pending   map[nat.Port]struct{}
@@ -86,14 +100,18 @@ type ProcNetScanner struct {
 	// Error lines per stuck port when wsl-proxy or host-switch is
 	// down, drowning the log.
 	addErrorLogged map[nat.Port]bool
+
+	// auditLog records the publish and unpublish decisions; nil
+	// discards them.
+	auditLog *audit.Log
 }
 
 // NewProcNetScanner constructs a /proc/net scanner that publishes
 // the observed listeners through tracker t. For each loopback
-// (127.0.0.1) binding it also opens a userspace forwarder on
+// (127.0.0.1 or ::1) binding it also opens a userspace forwarder on
 // bindIP — the namespace's tap interface IP — that pipes traffic
-// into 127.0.0.1. Wildcard (0.0.0.0) bindings rely on the
-// engine-namespace listener to accept bindIP:port directly and
+// into the loopback listener. Wildcard (0.0.0.0 or ::) bindings rely
+// on the engine-namespace listener to accept bindIP:port directly and
 // skip the forwarder. scanInterval controls the poll cadence; the
 // two-scan stability gate adds one additional cadence of delay
 // before a new port is published.
@@ -102,22 +120,36 @@ func NewProcNetScanner(ctx context.Context, t tracker.Tracker, bindIP net.IP, sc
 }
 
 func newScanner(ctx context.Context, t tracker.Tracker, f loopbackController, bindIP net.IP, scanInterval time.Duration) *ProcNetScanner {
-	return &ProcNetScanner{
+	p := &ProcNetScanner{
 		ctx:            ctx,
 		tracker:        t,
 		forwarder:      f,
+		source:         procNetSource{},
 		bindIP:         bindIP,
-		scanInterval:   scanInterval,
 		published:      make(nat.PortMap),
 		pending:        make(map[nat.Port]struct{}),
 		addErrorLogged: make(map[nat.Port]bool),
 	}
+	p.SetInterval(scanInterval)
+	return p
+}
+
+// SetAuditLog sets the log that records the publish and unpublish
+// decisions. It must be called before ForwardPorts.
+func (p *ProcNetScanner) SetAuditLog(l *audit.Log) {
+	p.auditLog = l
+}
+
+// SetInterval changes the poll cadence, from the next scan on.
+func (p *ProcNetScanner) SetInterval(scanInterval time.Duration) {
+	p.scanInterval.Store(int64(scanInterval))
 }
 
 // ForwardPorts polls /proc/net every scanInterval and drives Tick with
 // each snapshot.
 func (p *ProcNetScanner) ForwardPorts() error {
-	ticker := time.NewTicker(p.scanInterval)
+	interval := time.Duration(p.scanInterval.Load())
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 	defer p.forwarder.Close()
 
@@ -126,6 +158,10 @@ func (p *ProcNetScanner) ForwardPorts() error {
 		case <-p.ctx.Done():
 			return fmt.Errorf("/proc/net scanner context cancelled: %w", p.ctx.Err())
 		case <-ticker.C:
+			if current := time.Duration(p.scanInterval.Load()); current != interval {
+				interval = current
+				ticker.Reset(interval)
+			}
 			scanned, err := p.scanListeners()
 			if err != nil {
 				log.Errorf("failed to scan /proc/net: %s", err)
@@ -166,11 +202,15 @@ func (p *ProcNetScanner) Tick(scanned nat.PortMap) {
 		p.published[port] = bindings
 	}
 
+	pending := p.pending
 	p.pending = make(map[nat.Port]struct{})
-	for port := range scanned {
+	for port, bindings := range scanned {
 		if _, ok := p.published[port]; ok {
 			continue
 		}
+		if _, ok := pending[port]; !ok {
+			p.audit(audit.ActionExpose, port, bindings, audit.ResultDeferred, errDeferred)
+		}
 		p.pending[port] = struct{}{}
 	}
 
@@ -193,15 +233,25 @@ func (p *ProcNetScanner) Tick(scanned nat.PortMap) {
 // userspace forwarder for each loopback binding. It returns an error
 // if either step fails after rolling back the tracker entry, so the
 // caller can leave the port in pending for next-tick retry instead
-// of recording it as published.
-func (p *ProcNetScanner) publish(port nat.Port, bindings []nat.PortBinding) error {
+// of recording it as published. A tracker failure that the tracker
+// queued for retry (tracker.ErrRetryScheduled) is not rolled back.
+func (p *ProcNetScanner) publish(port nat.Port, bindings []nat.PortBinding) (err error) {
+	defer func() {
+		p.audit(audit.ActionExpose, port, bindings, "", err)
+	}()
+
 	id := utils.GenerateID(fmt.Sprintf("%s/%s", port.Proto(), port.Port()))
 	if err := p.tracker.Add(id, nat.PortMap{port: bindings}); err != nil {
 		p.logAddFailure(port, fmt.Sprintf("failed to add: %s", err))
-		if removeErr := p.tracker.Remove(id); removeErr != nil {
-			p.logAddFailure(port, fmt.Sprintf("rollback after tracker.Add failure: %s", removeErr))
+		// The tracker keeps retrying the bindings it queued until they
+		// are exposed or the entry is removed, so keep the entry and go
+		// on with the forwarder rather than re-adding on every tick.
+		if !errors.Is(err, tracker.ErrRetryScheduled) {
+			if removeErr := p.tracker.Remove(id); removeErr != nil {
+				p.logAddFailure(port, fmt.Sprintf("rollback after tracker.Add failure: %s", removeErr))
+			}
+			return err
 		}
-		return err
 	}
 
 	// A wildcard binding on the same port accepts traffic to bindIP:port
@@ -210,12 +260,10 @@ func (p *ProcNetScanner) publish(port nat.Port, bindings []nat.PortBinding) erro
 	// Windows.
 	if !hasWildcardBinding(bindings) {
 		// Every binding under a given nat.Port carries the same HostPort
-		// (addEntryToPortMap derives both from entry.Port), so forwarder.Add
-		// sees one key and rollback unwinds at most one listener.
-		for _, b := range bindings {
-			if b.HostIP != loopbackIP {
-				continue
-			}
+		// (addEntryToPortMap derives both from entry.Port), so one
+		// forwarder covers both loopback families and rollback unwinds
+		// at most one listener.
+		if b, ok := loopbackBinding(bindings); ok {
 			portNum, err := strconv.ParseUint(b.HostPort, 10, 16)
 			if err != nil {
 				// b.HostPort is strconv.Itoa of a uint16 (see
@@ -229,7 +277,7 @@ func (p *ProcNetScanner) publish(port nat.Port, bindings []nat.PortBinding) erro
 				}
 				return fmt.Errorf("/proc/net scanner: bad port %q: %w", b.HostPort, err)
 			}
-			if err := p.forwarder.Add(p.ctx, port.Proto(), uint16(portNum)); err != nil {
+			if err := p.forwarder.Add(p.ctx, port.Proto(), uint16(portNum), b.HostIP); err != nil {
 				p.logAddFailure(port, fmt.Sprintf("loopback forwarder %s/%s: %s", port.Proto(), b.HostPort, err))
 				if removeErr := p.tracker.Remove(id); removeErr != nil {
 					p.logAddFailure(port, fmt.Sprintf("rollback after forwarder.Add failure: %s", removeErr))
@@ -264,7 +312,9 @@ func (p *ProcNetScanner) logAddFailure(port nat.Port, msg string) {
 
 func (p *ProcNetScanner) unpublish(port nat.Port, bindings []nat.PortBinding) {
 	id := utils.GenerateID(fmt.Sprintf("%s/%s", port.Proto(), port.Port()))
-	if err := p.tracker.Remove(id); err != nil {
+	err := p.tracker.Remove(id)
+	p.audit(audit.ActionUnexpose, port, bindings, "", err)
+	if err != nil {
 		log.Errorf("/proc/net scanner failed to remove %s: %s", port, err)
 	} else {
 		log.Infof("/proc/net scanner removed port: %s -> %+v", port, bindings)
@@ -277,27 +327,62 @@ func (p *ProcNetScanner) unpublish(port nat.Port, bindings []nat.PortBinding) {
 		return
 	}
 
-	for _, b := range bindings {
-		if b.HostIP != loopbackIP {
-			continue
-		}
-		portNum, err := strconv.ParseUint(b.HostPort, 10, 16)
+	b, ok := loopbackBinding(bindings)
+	if !ok {
+		return
+	}
+	portNum, err := strconv.ParseUint(b.HostPort, 10, 16)
+	if err != nil {
+		return
+	}
+	if err := p.forwarder.Remove(port.Proto(), uint16(portNum)); err != nil {
+		log.Errorf("/proc/net scanner: loopback forwarder remove %s/%s: %s", port.Proto(), b.HostPort, err)
+	}
+}
+
+// errDeferred is the reason recorded for a new listener held back by the
+// two-scan stability gate.
+var errDeferred = errors.New("waiting for the listener to be seen by a second scan")
+
+// audit records a decision for each of the bindings of port.
+func (p *ProcNetScanner) audit(action audit.Action, port nat.Port, bindings []nat.PortBinding, result audit.Result, err error) {
+	if p.auditLog == nil {
+		return
+	}
+	if result == "" {
+		result = audit.ResultOK
 		if err != nil {
-			continue
+			result = audit.ResultFailed
 		}
-		if err := p.forwarder.Remove(port.Proto(), uint16(portNum)); err != nil {
-			log.Errorf("/proc/net scanner: loopback forwarder remove %s/%s: %s", port.Proto(), b.HostPort, err)
+	}
+	for _, binding := range bindings {
+		record := audit.Record{
+			Component: "procnet",
+			Event:     "scan",
+			Source:    "procnet",
+			ID:        utils.GenerateID(fmt.Sprintf("%s/%s", port.Proto(), port.Port())),
+			Action:    action,
+			Port:      string(port),
+			HostIP:    binding.HostIP,
+			HostPort:  binding.HostPort,
+			Result:    result,
+		}
+		if err != nil {
+			record.Error = err.Error()
 		}
+		p.auditLog.Write(record)
 	}
 }
 
-// scanListeners parses /proc/net/{tcp,tcp6,udp,udp6} via
-// procnettcp.ParseFiles. See addValidProtoEntryToPortMap for the
-// IPv6 drop and entriesToPortMap for the filter that drops the
-// forwarder's own sockets.
+// scanListeners takes a snapshot from the scanner's listenerSource.
+// See entriesToPortMap for the filter that drops the forwarder's own
+// sockets.
 func (p *ProcNetScanner) scanListeners() (nat.PortMap, error) {
-	entries, err := procnettcp.ParseFiles()
+	start := time.Now()
+	entries, err := p.source.Entries()
+	metrics.ListenerScanDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
+		metrics.ListenerScanFailures.Inc()
 		return nil, err
 	}
 	return p.entriesToPortMap(entries), nil
@@ -332,11 +417,11 @@ func (p *ProcNetScanner) entriesToPortMap(entries []procnettcp.Entry) nat.PortMa
 
 func addValidProtoEntryToPortMap(entry procnettcp.Entry, portMap nat.PortMap) error {
 	switch entry.Kind {
-	case procnettcp.TCP:
+	case procnettcp.TCP, procnettcp.TCP6:
 		if entry.State == procnettcp.TCPListen {
 			return addEntryToPortMap(entry, portMap)
 		}
-	case procnettcp.UDP:
+	case procnettcp.UDP, procnettcp.UDP6:
 		if entry.State == procnettcp.UDPEstablished {
 			return addEntryToPortMap(entry, portMap)
 		}
@@ -346,22 +431,25 @@ func addValidProtoEntryToPortMap(entry procnettcp.Entry, portMap nat.PortMap) er
 
 func addEntryToPortMap(entry procnettcp.Entry, portMap nat.PortMap) error {
 	port := strconv.Itoa(int(entry.Port))
-	portMapKey, err := nat.NewPort(strings.ToLower(entry.Kind), port)
+	proto := strings.TrimSuffix(strings.ToLower(entry.Kind), "6")
+	portMapKey, err := nat.NewPort(proto, port)
 	if err != nil {
 		return fmt.Errorf("generating portMapKey protocol: %s, port: %d failed: %w",
 			entry.Kind, entry.Port, err)
 	}
 
 	// Listeners on non-loopback, non-wildcard addresses (e.g. 192.168.x.y)
-	// are not reachable from the Windows host as-is. Coerce to 0.0.0.0 so
-	// the tracker can decide between 0.0.0.0 and 127.0.0.1 based on the
-	// admin-install flag.
+	// are not reachable from the Windows host as-is. Coerce to the
+	// wildcard of the same family so the tracker can decide between the
+	// wildcard and loopback address based on the admin-install flag.
 	var hostIP net.IP
-	inAddrAny := net.IPv4(0, 0, 0, 0)
-	if entry.IP.IsLoopback() || entry.IP.Equal(inAddrAny) {
+	switch {
+	case entry.IP.IsLoopback() || entry.IP.IsUnspecified():
 		hostIP = entry.IP
-	} else {
-		hostIP = inAddrAny
+	case entry.IP.To4() != nil:
+		hostIP = net.IPv4zero
+	default:
+		hostIP = net.IPv6unspecified
 	}
 	portMap[portMapKey] = append(portMap[portMapKey], nat.PortBinding{
 		HostIP:   hostIP.String(),
@@ -370,20 +458,37 @@ func addEntryToPortMap(entry procnettcp.Entry, portMap nat.PortMap) error {
 	return nil
 }
 
-// hasWildcardBinding reports whether bindings holds a 0.0.0.0 entry.
-// A wildcard listener inside the engine namespace already accepts
-// traffic on every IP in that namespace, including bindIP, so opening
-// a forwarder on bindIP:port would just duplicate the wildcard's
-// claim and fail with EADDRINUSE.
+// hasWildcardBinding reports whether bindings holds a 0.0.0.0 or ::
+// entry. A wildcard listener inside the engine namespace already
+// accepts traffic on every IP in that namespace, including bindIP, so
+// opening a forwarder on bindIP:port would just duplicate the
+// wildcard's claim and fail with EADDRINUSE.
 func hasWildcardBinding(bindings []nat.PortBinding) bool {
 	for _, b := range bindings {
-		if b.HostIP == wildcardIP {
+		if b.HostIP == wildcardIP || b.HostIP == wildcardIPv6 {
 			return true
 		}
 	}
 	return false
 }
 
+// loopbackBinding returns the binding the loopback forwarder should
+// dial for bindings, preferring 127.0.0.1 over ::1 when a listener
+// holds both.
+func loopbackBinding(bindings []nat.PortBinding) (nat.PortBinding, bool) {
+	var found nat.PortBinding
+	ok := false
+	for _, b := range bindings {
+		switch b.HostIP {
+		case loopbackIP:
+			return b, true
+		case loopbackIPv6:
+			found, ok = b, true
+		}
+	}
+	return found, ok
+}
+
 // bindingsEqual reports whether two binding lists hold the same set
 // of (HostIP, HostPort) pairs. Order does not matter, but duplicates
 // must match in multiplicity so a list with two identical entries
@@ -20,6 +20,9 @@ import (
 
 	"github.com/docker/go-connections/nat"
 	"github.com/lima-vm/lima/pkg/guestagent/procnettcp"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/policy"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
 )
 
 // fakeTracker records Add/Remove calls keyed by the containerID the
@@ -38,6 +41,10 @@ func (t *fakeTracker) Add(id string, _ nat.PortMap) error {
 	return t.addErr
 }
 
+func (t *fakeTracker) AddWorkload(id string, _ policy.Workload, portMap nat.PortMap) error {
+	return t.Add(id, portMap)
+}
+
 func (t *fakeTracker) Remove(id string) error {
 	t.removed = append(t.removed, id)
 	return t.removeErr
@@ -45,17 +52,22 @@ func (t *fakeTracker) Remove(id string) error {
 func (t *fakeTracker) Get(string) nat.PortMap { return nil }
 func (t *fakeTracker) RemoveAll() error       { return nil }
 
+func (t *fakeTracker) AllowsWorkload(policy.Workload) bool { return true }
+
 // fakeForwarder records the proto/port pairs the scanner asks to bind
-// or release. addErr, when non-nil, is returned from Add so tests can
-// drive the forwarder-failure rollback path.
+// or release, and the upstream address each Add dials. addErr, when
+// non-nil, is returned from Add so tests can drive the
+// forwarder-failure rollback path.
 type fakeForwarder struct {
-	added   []string
-	removed []string
-	addErr  error
+	added     []string
+	upstreams []string
+	removed   []string
+	addErr    error
 }
 
-func (f *fakeForwarder) Add(_ context.Context, proto string, port uint16) error {
+func (f *fakeForwarder) Add(_ context.Context, proto string, port uint16, upstreamIP string) error {
 	f.added = append(f.added, fmt.Sprintf("%s/%d", proto, port))
+	f.upstreams = append(f.upstreams, upstreamIP)
 	return f.addErr
 }
 
@@ -311,6 +323,35 @@ func TestTCPAndUDPSamePort(t *testing.T) {
 	}
 }
 
+// TestPublishKeepsRetryScheduledEntry checks that a tracker.Add failure
+// the tracker queued for retry neither rolls the entry back nor keeps
+// the port pending: the tracker owns the retry from then on.
+func TestPublishKeepsRetryScheduledEntry(t *testing.T) {
+	tr := &fakeTracker{addErr: fmt.Errorf("host-switch down: %w", tracker.ErrRetryScheduled)}
+	fwd := &fakeForwarder{}
+	s := newScanner(context.Background(), tr, fwd, nil, time.Second)
+	port := mustPort(t, "tcp", 8009)
+	scan := loopbackPortMap(t, 8009)
+
+	s.Tick(scan)
+	s.Tick(scan)
+
+	if len(tr.removed) != 0 {
+		t.Fatalf("tracker.Remove called %v; want no rollback", tr.removed)
+	}
+	if _, ok := s.published[port]; !ok {
+		t.Fatalf("port %s not published after retry-scheduled failure", port)
+	}
+	if len(fwd.added) != 1 {
+		t.Fatalf("forwarder.Add called %d times; want 1", len(fwd.added))
+	}
+
+	s.Tick(scan)
+	if len(tr.added) != 1 {
+		t.Fatalf("tracker.Add called %d times; want 1", len(tr.added))
+	}
+}
+
 // TestPublishLogOnceFlagState pins the per-port log-throttle flag the
 // scanner uses to suppress error-log floods on persistent tracker.Add
 // failures. The first failure for a port sets the flag; recovery
@@ -554,6 +595,98 @@ func TestEntriesToPortMapSkipsForwarderBindIP(t *testing.T) {
 	}
 }
 
+func TestEntriesToPortMapIncludesIPv6(t *testing.T) {
+	s := newScanner(context.Background(), &fakeTracker{}, &fakeForwarder{}, net.ParseIP("192.168.127.2"), time.Second)
+
+	entries := []procnettcp.Entry{
+		{Kind: procnettcp.TCP6, IP: net.IPv6loopback, Port: 8009, State: procnettcp.TCPListen},
+		{Kind: procnettcp.TCP6, IP: net.IPv6unspecified, Port: 8010, State: procnettcp.TCPListen},
+		{Kind: procnettcp.TCP6, IP: net.ParseIP("fd00::5"), Port: 8011, State: procnettcp.TCPListen},
+		{Kind: procnettcp.UDP6, IP: net.IPv6loopback, Port: 5353, State: procnettcp.UDPEstablished},
+	}
+	out := s.entriesToPortMap(entries)
+
+	want := map[nat.Port]string{
+		mustPort(t, "tcp", 8009): "::1",
+		mustPort(t, "tcp", 8010): "::",
+		mustPort(t, "tcp", 8011): "::",
+		mustPort(t, "udp", 5353): "::1",
+	}
+	if len(out) != len(want) {
+		t.Fatalf("entriesToPortMap returned %v, want keys %v", out, want)
+	}
+	for port, hostIP := range want {
+		bindings := out[port]
+		if len(bindings) != 1 || bindings[0].HostIP != hostIP {
+			t.Fatalf("bindings for %s = %+v, want one entry with HostIP=%s", port, bindings, hostIP)
+		}
+	}
+}
+
+func TestIPv6LoopbackForwarderDialsIPv6(t *testing.T) {
+	tr := &fakeTracker{}
+	fwd := &fakeForwarder{}
+	s := newScanner(context.Background(), tr, fwd, nil, time.Second)
+
+	port := mustPort(t, "tcp", 8009)
+	scan := nat.PortMap{port: []nat.PortBinding{{HostIP: "::1", HostPort: "8009"}}}
+	s.Tick(scan)
+	s.Tick(scan)
+
+	if got, want := fwd.added, []string{"tcp/8009"}; !equalStringSlices(got, want) {
+		t.Fatalf("forwarder.Add = %v, want %v", got, want)
+	}
+	if got, want := fwd.upstreams, []string{"::1"}; !equalStringSlices(got, want) {
+		t.Fatalf("forwarder upstreams = %v, want %v", got, want)
+	}
+
+	s.Tick(nat.PortMap{})
+	if got, want := fwd.removed, []string{"tcp/8009"}; !equalStringSlices(got, want) {
+		t.Fatalf("forwarder.Remove = %v, want %v", got, want)
+	}
+}
+
+// TestDualFamilyLoopbackPrefersIPv4 pins that a listener holding both
+// 127.0.0.1 and ::1 gets a single forwarder dialing 127.0.0.1.
+func TestDualFamilyLoopbackPrefersIPv4(t *testing.T) {
+	tr := &fakeTracker{}
+	fwd := &fakeForwarder{}
+	s := newScanner(context.Background(), tr, fwd, nil, time.Second)
+
+	port := mustPort(t, "tcp", 8009)
+	scan := nat.PortMap{port: []nat.PortBinding{
+		{HostIP: "::1", HostPort: "8009"},
+		{HostIP: "127.0.0.1", HostPort: "8009"},
+	}}
+	s.Tick(scan)
+	s.Tick(scan)
+
+	if got, want := fwd.upstreams, []string{"127.0.0.1"}; !equalStringSlices(got, want) {
+		t.Fatalf("forwarder upstreams = %v, want %v", got, want)
+	}
+}
+
+func TestIPv6WildcardBindingSkipsForwarder(t *testing.T) {
+	tr := &fakeTracker{}
+	fwd := &fakeForwarder{}
+	s := newScanner(context.Background(), tr, fwd, nil, time.Second)
+
+	port := mustPort(t, "tcp", 8009)
+	scan := nat.PortMap{port: []nat.PortBinding{
+		{HostIP: "::1", HostPort: "8009"},
+		{HostIP: "::", HostPort: "8009"},
+	}}
+	s.Tick(scan)
+	s.Tick(scan)
+
+	if len(tr.added) != 1 {
+		t.Fatalf("tracker.Add = %v, want one call", tr.added)
+	}
+	if len(fwd.added) != 0 {
+		t.Fatalf("forwarder.Add fired for IPv6 wildcard binding: %v", fwd.added)
+	}
+}
+
 // TestEndToEndForwardingThroughTick wires a real loopbackForwarder
 // behind newScanner and verifies a client dial through the bindIP
 // alias reaches a real upstream listener on 127.0.0.1. The other
@@ -21,6 +21,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/audit"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
 )
 
@@ -30,6 +31,18 @@ func NewProcNetScanner(context.Context, tracker.Tracker, net.IP, time.Duration)
 	panic("only implemented for Linux")
 }
 
+func NewSockDiagScanner(context.Context, tracker.Tracker, net.IP, time.Duration) (*ProcNetScanner, error) {
+	return nil, fmt.Errorf("only implemented for Linux")
+}
+
+func (p *ProcNetScanner) SetAuditLog(*audit.Log) {
+	panic("only implemented for Linux")
+}
+
+func (p *ProcNetScanner) SetInterval(time.Duration) {
+	panic("only implemented for Linux")
+}
+
 func (p *ProcNetScanner) ForwardPorts() error {
 	return fmt.Errorf("only implemented for Linux")
 }
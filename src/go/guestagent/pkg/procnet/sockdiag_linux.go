@@ -0,0 +1,238 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package procnet
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/Masterminds/log-go"
+	"github.com/lima-vm/lima/pkg/guestagent/procnettcp"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
+)
+
+// listenerSource takes a snapshot of the sockets in the namespace. The
+// entries are filtered down to listeners by entriesToPortMap, so a
+// source may return sockets in any state.
+type listenerSource interface {
+	Entries() ([]procnettcp.Entry, error)
+}
+
+// procNetSource parses /proc/net/{tcp,tcp6,udp,udp6}.
+type procNetSource struct{}
+
+func (procNetSource) Entries() ([]procnettcp.Entry, error) {
+	return procnettcp.ParseFiles()
+}
+
+// sockDiagSource dumps the listening TCP sockets and the unconnected UDP
+// sockets of both families over a NETLINK_SOCK_DIAG socket. The kernel
+// filters the sockets by state and fills in binary socket records
+// directly, so unlike the /proc/net text tables, the cost of a scan does
+// not grow with the established and TIME_WAIT connections of a busy host.
+//
+// This is still polling: sock_diag only multicasts socket destruction,
+// not creation, so new listeners are found on the next scan; the lower
+// cost is what allows a shorter scan interval.
+type sockDiagSource struct {
+	dumpTCP func(family uint8) ([]*netlink.Socket, error)
+	dumpUDP func(family uint8) ([]*netlink.Socket, error)
+}
+
+func newSockDiagSource() *sockDiagSource {
+	return &sockDiagSource{
+		dumpTCP: dumpSockets(unix.IPPROTO_TCP, 1<<procnettcp.TCPListen),
+		// UDP sockets that are not connected to a peer are reported in
+		// the TCP_CLOSE state, which procnettcp names UDPEstablished.
+		dumpUDP: dumpSockets(unix.IPPROTO_UDP, 1<<procnettcp.UDPEstablished),
+	}
+}
+
+const (
+	// sizeofInetDiagRequest is the size of struct inet_diag_req_v2.
+	sizeofInetDiagRequest = 56
+	// sizeofInetDiagMsg is the size of struct inet_diag_msg.
+	sizeofInetDiagMsg = 72
+)
+
+// inetDiagRequest is a struct inet_diag_req_v2 dumping the sockets of a
+// family and protocol in the given states, a bit mask of the kernel TCP
+// states. netlink.SocketDiagTCP and SocketDiagUDP always dump every state.
+type inetDiagRequest struct {
+	family   uint8
+	protocol uint8
+	states   uint32
+}
+
+func (r *inetDiagRequest) Len() int {
+	return sizeofInetDiagRequest
+}
+
+func (r *inetDiagRequest) Serialize() []byte {
+	b := make([]byte, sizeofInetDiagRequest)
+	b[0] = r.family
+	b[1] = r.protocol
+	binary.NativeEndian.PutUint32(b[4:8], r.states)
+	return b
+}
+
+// dumpSockets returns a dump of the sockets of the protocol in the given
+// states. Only the fields used by socketsToEntries are filled in.
+func dumpSockets(protocol uint8, states uint32) func(family uint8) ([]*netlink.Socket, error) {
+	return func(family uint8) ([]*netlink.Socket, error) {
+		req := nl.NewNetlinkRequest(nl.SOCK_DIAG_BY_FAMILY, unix.NLM_F_DUMP)
+		req.AddData(&inetDiagRequest{family: family, protocol: protocol, states: states})
+
+		var sockets []*netlink.Socket
+		var parseErr error
+		err := req.ExecuteIter(unix.NETLINK_INET_DIAG, nl.SOCK_DIAG_BY_FAMILY, func(msg []byte) bool {
+			socket, err := parseInetDiagMsg(msg)
+			if err != nil {
+				parseErr = err
+				return false
+			}
+			sockets = append(sockets, socket)
+			return true
+		})
+		if err == nil {
+			err = parseErr
+		}
+		return sockets, err
+	}
+}
+
+// parseInetDiagMsg parses the family, state, source address and port of
+// a struct inet_diag_msg.
+func parseInetDiagMsg(msg []byte) (*netlink.Socket, error) {
+	if len(msg) < sizeofInetDiagMsg {
+		return nil, fmt.Errorf("sock_diag message is too short: %d bytes", len(msg))
+	}
+	socket := &netlink.Socket{
+		Family: msg[0],
+		State:  msg[1],
+	}
+	socket.ID.SourcePort = binary.BigEndian.Uint16(msg[4:6])
+	if socket.Family == unix.AF_INET6 {
+		socket.ID.Source = net.IP(slices.Clone(msg[8:24]))
+	} else {
+		socket.ID.Source = net.IPv4(msg[8], msg[9], msg[10], msg[11])
+	}
+	return socket, nil
+}
+
+func (s *sockDiagSource) Entries() ([]procnettcp.Entry, error) {
+	var entries []procnettcp.Entry
+
+	dumps := []struct {
+		dump   func(family uint8) ([]*netlink.Socket, error)
+		family uint8
+		kind   procnettcp.Kind
+	}{
+		{s.dumpTCP, unix.AF_INET, procnettcp.TCP},
+		{s.dumpTCP, unix.AF_INET6, procnettcp.TCP6},
+		{s.dumpUDP, unix.AF_INET, procnettcp.UDP},
+		{s.dumpUDP, unix.AF_INET6, procnettcp.UDP6},
+	}
+
+	for _, d := range dumps {
+		sockets, err := d.dump(d.family)
+		// An interrupted dump (netlink.ErrDumpInterrupted) may be
+		// missing sockets, and Tick unpublishes a missing listener
+		// right away, so treat it as a failed dump.
+		if err != nil {
+			return nil, fmt.Errorf("sock_diag dump of %s sockets failed: %w", d.kind, err)
+		}
+		entries = append(entries, socketsToEntries(sockets, d.kind)...)
+	}
+
+	return entries, nil
+}
+
+// socketsToEntries converts sock_diag records into the procnettcp
+// representation shared with the /proc/net parser. The socket states
+// use the same kernel values in both.
+func socketsToEntries(sockets []*netlink.Socket, kind procnettcp.Kind) []procnettcp.Entry {
+	entries := make([]procnettcp.Entry, 0, len(sockets))
+	for _, socket := range sockets {
+		ip := slices.Clone(socket.ID.Source)
+		if v4 := ip.To4(); v4 != nil && (kind == procnettcp.TCP || kind == procnettcp.UDP) {
+			ip = v4
+		}
+		entries = append(entries, procnettcp.Entry{
+			Kind:  kind,
+			IP:    ip,
+			Port:  socket.ID.SourcePort,
+			State: procnettcp.State(socket.State),
+		})
+	}
+	return entries
+}
+
+// fallbackSource reads from primary and, if that fails, from fallback
+// for the same scan, so a transient netlink error does not skip a tick.
+type fallbackSource struct {
+	primary  listenerSource
+	fallback listenerSource
+	// failing throttles the fallback log to the first failure of a run.
+	failing bool
+}
+
+func (f *fallbackSource) Entries() ([]procnettcp.Entry, error) {
+	entries, err := f.primary.Entries()
+	if err == nil {
+		if f.failing {
+			log.Info("/proc/net scanner: sock_diag recovered")
+			f.failing = false
+		}
+		return entries, nil
+	}
+
+	if !f.failing {
+		log.Errorf("/proc/net scanner: %s; falling back to /proc/net", err)
+		f.failing = true
+	} else {
+		log.Debugf("/proc/net scanner: %s; falling back to /proc/net", err)
+	}
+
+	fallbackEntries, fallbackErr := f.fallback.Entries()
+	if fallbackErr != nil {
+		return nil, errors.Join(err, fallbackErr)
+	}
+	return fallbackEntries, nil
+}
+
+// NewSockDiagScanner is like NewProcNetScanner, but takes its snapshots
+// through netlink sock_diag, falling back to /proc/net for any scan where
+// the dump fails. It returns an error if sock_diag is not usable at all,
+// e.g. on a kernel built without CONFIG_INET_DIAG, in which case the
+// caller should use NewProcNetScanner instead.
+func NewSockDiagScanner(ctx context.Context, t tracker.Tracker, bindIP net.IP, scanInterval time.Duration) (*ProcNetScanner, error) {
+	source := newSockDiagSource()
+	if _, err := source.dumpTCP(unix.AF_INET); err != nil {
+		return nil, fmt.Errorf("netlink sock_diag is not available: %w", err)
+	}
+
+	p := newScanner(ctx, t, newLoopbackForwarder(bindIP), bindIP, scanInterval)
+	p.source = &fallbackSource{primary: source, fallback: procNetSource{}}
+	return p, nil
+}
@@ -0,0 +1,186 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+*/
+
+package procnet
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/lima-vm/lima/pkg/guestagent/procnettcp"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestSocketsToEntries(t *testing.T) {
+	sockets := []*netlink.Socket{
+		{
+			Family: unix.AF_INET,
+			State:  uint8(procnettcp.TCPListen),
+			ID:     netlink.SocketID{Source: net.IPv4(127, 0, 0, 1), SourcePort: 8080},
+		},
+		{
+			Family: unix.AF_INET6,
+			State:  uint8(procnettcp.TCPListen),
+			ID:     netlink.SocketID{Source: net.IPv6loopback, SourcePort: 8081},
+		},
+	}
+
+	v4 := socketsToEntries(sockets[:1], procnettcp.TCP)
+	if len(v4) != 1 || v4[0].Kind != procnettcp.TCP || !v4[0].IP.Equal(net.IPv4(127, 0, 0, 1)) ||
+		v4[0].Port != 8080 || v4[0].State != procnettcp.TCPListen {
+		t.Fatalf("socketsToEntries(AF_INET) = %+v", v4)
+	}
+	if len(v4[0].IP) != net.IPv4len {
+		t.Fatalf("IPv4 entry IP has length %d; want %d", len(v4[0].IP), net.IPv4len)
+	}
+
+	v6 := socketsToEntries(sockets[1:], procnettcp.TCP6)
+	if len(v6) != 1 || v6[0].Kind != procnettcp.TCP6 || !v6[0].IP.Equal(net.IPv6loopback) || v6[0].Port != 8081 {
+		t.Fatalf("socketsToEntries(AF_INET6) = %+v", v6)
+	}
+
+	// Both feed the same port map as the /proc/net parser would.
+	s := newScanner(t.Context(), &fakeTracker{}, &fakeForwarder{}, nil, 0)
+	portMap := s.entriesToPortMap(append(v4, v6...))
+	if len(portMap) != 2 {
+		t.Fatalf("entriesToPortMap = %+v; want two ports", portMap)
+	}
+}
+
+func TestSockDiagSourceFailsOnInterruptedDump(t *testing.T) {
+	source := &sockDiagSource{
+		dumpTCP: func(uint8) ([]*netlink.Socket, error) { return nil, nil },
+		dumpUDP: func(uint8) ([]*netlink.Socket, error) {
+			return []*netlink.Socket{{}}, netlink.ErrDumpInterrupted
+		},
+	}
+
+	if _, err := source.Entries(); !errors.Is(err, netlink.ErrDumpInterrupted) {
+		t.Fatalf("Entries() error = %v; want ErrDumpInterrupted", err)
+	}
+}
+
+type staticSource struct {
+	entries []procnettcp.Entry
+	err     error
+}
+
+func (s staticSource) Entries() ([]procnettcp.Entry, error) {
+	return s.entries, s.err
+}
+
+func TestFallbackSource(t *testing.T) {
+	procEntry := procnettcp.Entry{Kind: procnettcp.TCP, IP: net.IPv4(127, 0, 0, 1), Port: 80, State: procnettcp.TCPListen}
+	diagEntry := procnettcp.Entry{Kind: procnettcp.TCP, IP: net.IPv4(127, 0, 0, 1), Port: 81, State: procnettcp.TCPListen}
+
+	primary := &staticSource{err: errors.New("synthetic netlink failure")}
+	f := &fallbackSource{primary: primary, fallback: staticSource{entries: []procnettcp.Entry{procEntry}}}
+
+	entries, err := f.Entries()
+	if err != nil || len(entries) != 1 || entries[0].Port != 80 {
+		t.Fatalf("Entries() with failing primary = %+v, %v; want the fallback entry", entries, err)
+	}
+	if !f.failing {
+		t.Fatalf("failing = false after primary failure")
+	}
+
+	primary.err = nil
+	primary.entries = []procnettcp.Entry{diagEntry}
+	entries, err = f.Entries()
+	if err != nil || len(entries) != 1 || entries[0].Port != 81 {
+		t.Fatalf("Entries() after recovery = %+v, %v; want the primary entry", entries, err)
+	}
+	if f.failing {
+		t.Fatalf("failing = true after recovery")
+	}
+}
+
+// TestSockDiagFindsListener dumps the sockets of the test's own network
+// namespace and checks that a fresh listener shows up.
+func TestSockDiagFindsListener(t *testing.T) {
+	source := newSockDiagSource()
+	if _, err := source.dumpTCP(unix.AF_INET); err != nil {
+		t.Skipf("netlink sock_diag is not available: %v", err)
+	}
+
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	port := uint16(l.Addr().(*net.TCPAddr).Port)
+
+	entries, err := source.Entries()
+	if err != nil {
+		t.Fatalf("Entries(): %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Kind == procnettcp.TCP && entry.Port == port && entry.State == procnettcp.TCPListen {
+			return
+		}
+	}
+	t.Fatalf("listener on port %d not found in sock_diag dump", port)
+}
+
+// TestSockDiagOnlyDumpsListeners checks that the kernel filters out the
+// connected sockets, so that they do not add to the cost of a scan.
+func TestSockDiagOnlyDumpsListeners(t *testing.T) {
+	source := newSockDiagSource()
+	if _, err := source.dumpTCP(unix.AF_INET); err != nil {
+		t.Skipf("netlink sock_diag is not available: %v", err)
+	}
+
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	conn, err := net.Dial("tcp4", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	tcpClientPort := uint16(conn.LocalAddr().(*net.TCPAddr).Port)
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen udp: %v", err)
+	}
+	defer udpListener.Close()
+	udpPort := uint16(udpListener.LocalAddr().(*net.UDPAddr).Port)
+	udpConn, err := net.Dial("udp4", udpListener.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("dial udp: %v", err)
+	}
+	defer udpConn.Close()
+	udpClientPort := uint16(udpConn.LocalAddr().(*net.UDPAddr).Port)
+
+	entries, err := source.Entries()
+	if err != nil {
+		t.Fatalf("Entries(): %v", err)
+	}
+	foundUDP := false
+	for _, entry := range entries {
+		switch entry.Kind {
+		case procnettcp.TCP, procnettcp.TCP6:
+			if entry.State != procnettcp.TCPListen || entry.Port == tcpClientPort {
+				t.Fatalf("sock_diag dump has a TCP socket that is not listening: %+v", entry)
+			}
+		case procnettcp.UDP, procnettcp.UDP6:
+			if entry.State != procnettcp.UDPEstablished || entry.Port == udpClientPort {
+				t.Fatalf("sock_diag dump has a connected UDP socket: %+v", entry)
+			}
+			foundUDP = foundUDP || entry.Port == udpPort
+		}
+	}
+	if !foundUDP {
+		t.Fatalf("UDP socket on port %d not found in sock_diag dump", udpPort)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rotate implements an append-only file that is rotated by size.
+package rotate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// File is an append-only file that keeps count of its size. Rotate renames
+// it with a ".1" suffix, shifts the previous backups, and drops the oldest
+// one. File is not safe for concurrent use.
+type File struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	// file is nil after a failed rotation, in which case it is opened
+	// again on the next write.
+	file *os.File
+	size int64
+}
+
+// Open opens the file at path, appending to it if it exists. The file is
+// full once it reaches maxSize bytes, and at most maxBackups rotated files
+// are kept.
+func Open(path string, maxSize int64, maxBackups int) (*File, error) {
+	f := &File{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write appends b to the file, opening it again if the last rotation
+// failed to.
+func (f *File) Write(b []byte) (int, error) {
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+	return n, err
+}
+
+// Size returns the size of the current file.
+func (f *File) Size() int64 {
+	return f.size
+}
+
+// Full reports whether writing n more bytes would take the file over its
+// maximum size. An empty file is never full, so that a write larger than
+// the maximum size still goes somewhere; neither is a file without a
+// maximum size.
+func (f *File) Full(n int64) bool {
+	return f.maxSize > 0 && f.size > 0 && f.size+n > f.maxSize
+}
+
+// Rotate moves the current file to the first backup and opens a new one.
+// The current file is opened again when it cannot be moved.
+func (f *File) Rotate() error {
+	if f.file != nil {
+		if err := f.file.Close(); err != nil {
+			return err
+		}
+		f.file = nil
+	}
+	err := f.shift()
+	return errors.Join(err, f.open())
+}
+
+func (f *File) shift() error {
+	if f.maxBackups == 0 {
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(f.path, f.path+".1")
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,93 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rotate_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/rotate"
+)
+
+func TestRotate(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(path, []byte("1"), 0o600))
+
+	f, err := rotate.Open(path, 2, 2)
+	require.NoError(t, err)
+	defer f.Close()
+	assert.Equal(t, int64(1), f.Size())
+
+	for _, data := range []string{"2", "3", "4"} {
+		if f.Full(int64(len(data))) {
+			require.NoError(t, f.Rotate())
+		}
+		_, err := f.Write([]byte(data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+
+	for name, expected := range map[string]string{path: "34", path + ".1": "12"} {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(data), name)
+	}
+	assert.NoFileExists(t, path+".2")
+}
+
+func TestRotateDropsOldest(t *testing.T) {
+	t.Parallel()
+
+	for _, maxBackups := range []int{0, 1} {
+		path := filepath.Join(t.TempDir(), "file")
+		f, err := rotate.Open(path, 1, maxBackups)
+		require.NoError(t, err)
+		for _, data := range []string{"1", "2", "3"} {
+			require.NoError(t, f.Rotate())
+			_, err := f.Write([]byte(data))
+			require.NoError(t, err)
+		}
+		require.NoError(t, f.Close())
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "3", string(data))
+		if maxBackups == 0 {
+			assert.NoFileExists(t, path+".1")
+			continue
+		}
+		data, err = os.ReadFile(path + ".1")
+		require.NoError(t, err)
+		assert.Equal(t, "2", string(data))
+		assert.NoFileExists(t, path+".2")
+	}
+}
+
+func TestFullWithoutMaxSize(t *testing.T) {
+	t.Parallel()
+
+	f, err := rotate.Open(filepath.Join(t.TempDir(), "file"), 0, 1)
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = f.Write([]byte("data"))
+	require.NoError(t, err)
+	assert.False(t, f.Full(1<<20))
+}
@@ -0,0 +1,96 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package routes holds the route table of the guest agent: the host names
+// that Kubernetes Ingress and Gateway API HTTPRoute objects serve, and the
+// ports they are served on, so that the host can resolve them to the
+// forwarded ports.
+package routes
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Address is the address the host reaches the routes on, as their ports
+// are forwarded to the host's localhost.
+const Address = "127.0.0.1"
+
+// Route is a host name served by a Kubernetes object.
+type Route struct {
+	Host    string `json:"host"`
+	Address string `json:"address"`
+	// Ports are the ports the host name is served on, sorted.
+	Ports     []int32 `json:"ports"`
+	Kind      string  `json:"kind"`
+	Namespace string  `json:"namespace"`
+	Name      string  `json:"name"`
+}
+
+// IsWildcard reports whether the route is for all the subdomains of a
+// domain, e.g. "*.example.localhost".
+func (r Route) IsWildcard() bool {
+	return strings.HasPrefix(r.Host, "*.")
+}
+
+// Table is the route table that the Kubernetes watcher maintains.
+type Table struct {
+	routes []Route
+	mutex  sync.Mutex
+}
+
+func NewTable() *Table {
+	return &Table{}
+}
+
+// Set replaces the routes of the table.
+func (t *Table) Set(routes []Route) {
+	routes = slices.Clone(routes)
+	sort.Slice(routes, func(i, j int) bool {
+		a, b := routes[i], routes[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		return fmt.Sprintf("%s/%s/%s", a.Kind, a.Namespace, a.Name) < fmt.Sprintf("%s/%s/%s", b.Kind, b.Namespace, b.Name)
+	})
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.routes = routes
+}
+
+// Routes returns the routes of the table, sorted by host name.
+func (t *Table) Routes() []Route {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return slices.Clone(t.routes)
+}
+
+// Hosts returns the routes in the hosts file format, one line per host
+// name. Wildcard host names cannot be written to a hosts file and are
+// left out.
+func Hosts(routes []Route) string {
+	var b strings.Builder
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		if route.IsWildcard() || seen[route.Host] {
+			continue
+		}
+		seen[route.Host] = true
+		fmt.Fprintf(&b, "%s\t%s\n", route.Address, route.Host)
+	}
+	return b.String()
+}
@@ -0,0 +1,56 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package routes_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/routes"
+)
+
+func TestTable(t *testing.T) {
+	t.Parallel()
+
+	table := routes.NewTable()
+	assert.Empty(t, table.Routes())
+
+	table.Set([]routes.Route{
+		{Host: "shop.localhost", Address: routes.Address, Kind: "Ingress", Namespace: "default", Name: "shop"},
+		{Host: "app.localhost", Address: routes.Address, Kind: "Ingress", Namespace: "default", Name: "web"},
+		{Host: "app.localhost", Address: routes.Address, Kind: "HTTPRoute", Namespace: "default", Name: "web"},
+	})
+
+	current := table.Routes()
+	assert.Equal(t, []string{"app.localhost", "app.localhost", "shop.localhost"},
+		[]string{current[0].Host, current[1].Host, current[2].Host})
+	assert.Equal(t, "HTTPRoute", current[0].Kind)
+
+	// Changing the returned slice does not change the table.
+	current[0].Host = "changed"
+	assert.Equal(t, "app.localhost", table.Routes()[0].Host)
+}
+
+func TestHosts(t *testing.T) {
+	t.Parallel()
+
+	hosts := routes.Hosts([]routes.Route{
+		{Host: "*.apps.localhost", Address: routes.Address},
+		{Host: "app.localhost", Address: routes.Address, Kind: "HTTPRoute"},
+		{Host: "app.localhost", Address: routes.Address, Kind: "Ingress"},
+		{Host: "shop.localhost", Address: routes.Address},
+	})
+	assert.Equal(t, "127.0.0.1\tapp.localhost\n127.0.0.1\tshop.localhost\n", hosts)
+}
@@ -0,0 +1,199 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package status serves a local HTTP/JSON API over a Unix socket that
+// reports the port mappings tracked by the guest agent. It is meant for
+// debugging port forwarding, e.g.:
+//
+//	curl --unix-socket /run/rancher-desktop-guestagent.sock http://localhost/ports
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Masterminds/log-go"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/routes"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
+)
+
+// Provider returns a snapshot of the tracked port mappings.
+type Provider interface {
+	Status() []tracker.Entry
+}
+
+// RouteProvider returns the route table of the Kubernetes watcher.
+type RouteProvider interface {
+	Routes() []routes.Route
+}
+
+// Server serves the status API on a Unix socket.
+type Server struct {
+	context    context.Context
+	socketPath string
+	provider   Provider
+	routes     RouteProvider
+	mux        *http.ServeMux
+}
+
+// New creates a status Server listening on socketPath that reports the
+// port mappings returned by provider.
+func New(ctx context.Context, socketPath string, provider Provider) *Server {
+	s := &Server{
+		context:    ctx,
+		socketPath: socketPath,
+		provider:   provider,
+		mux:        http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /ports", s.listPorts)
+	s.mux.HandleFunc("GET /ports/{id...}", s.getPort)
+	s.mux.HandleFunc("GET /remaps", s.listRemaps)
+	s.mux.HandleFunc("GET /routes", s.listRoutes)
+	s.mux.HandleFunc("GET /routes/hosts", s.listRouteHosts)
+	return s
+}
+
+// SetRoutes makes the server report the route table of provider; without
+// it, the route table is empty.
+func (s *Server) SetRoutes(provider RouteProvider) {
+	s.routes = provider
+}
+
+// Serve listens on the Unix socket and serves requests until the
+// context is cancelled. A stale socket file left by a previous run
+// is removed first.
+func (s *Server) Serve() error {
+	if err := os.Remove(s.socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing stale status socket %s: %w", s.socketPath, err)
+	}
+
+	var lc net.ListenConfig
+	listener, err := lc.Listen(s.context, "unix", s.socketPath)
+	if err != nil {
+		return fmt.Errorf("listening on status socket %s: %w", s.socketPath, err)
+	}
+	defer os.Remove(s.socketPath)
+
+	if err := os.Chmod(s.socketPath, 0o600); err != nil {
+		listener.Close()
+		return fmt.Errorf("setting permissions on status socket %s: %w", s.socketPath, err)
+	}
+
+	server := &http.Server{
+		Handler:           s.mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-s.context.Done()
+		server.Close()
+	}()
+
+	log.Infof("serving guest agent status on %s", s.socketPath)
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("status server failed: %w", err)
+	}
+
+	return nil
+}
+
+// ServeHTTP allows the status API to be exercised without a socket.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) listPorts(w http.ResponseWriter, r *http.Request) {
+	entries := s.provider.Status()
+
+	if source := r.URL.Query().Get("source"); source != "" {
+		filtered := entries[:0]
+		for _, entry := range entries {
+			if entry.Source == tracker.Source(source) {
+				filtered = append(filtered, entry)
+			}
+		}
+		entries = filtered
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (s *Server) getPort(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	for _, entry := range s.provider.Status() {
+		if entry.ID == id {
+			writeJSON(w, http.StatusOK, entry)
+			return
+		}
+	}
+
+	http.Error(w, fmt.Sprintf("%s is not tracked", id), http.StatusNotFound)
+}
+
+// RemapStatus is a port binding that is exposed on another host port than
+// the one it requested, as listed by GET /remaps.
+type RemapStatus struct {
+	ID     string         `json:"id"`
+	Source tracker.Source `json:"source"`
+	tracker.Remap
+}
+
+func (s *Server) listRemaps(w http.ResponseWriter, _ *http.Request) {
+	remaps := []RemapStatus{}
+	for _, entry := range s.provider.Status() {
+		for _, remap := range entry.Remapped {
+			remaps = append(remaps, RemapStatus{ID: entry.ID, Source: entry.Source, Remap: remap})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, remaps)
+}
+
+func (s *Server) currentRoutes() []routes.Route {
+	if s.routes == nil {
+		return nil
+	}
+	return s.routes.Routes()
+}
+
+func (s *Server) listRoutes(w http.ResponseWriter, _ *http.Request) {
+	current := s.currentRoutes()
+	if current == nil {
+		current = []routes.Route{}
+	}
+	writeJSON(w, http.StatusOK, current)
+}
+
+// listRouteHosts serves the route table in the hosts file format.
+func (s *Server) listRouteHosts(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, routes.Hosts(s.currentRoutes()))
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("encoding status response failed: %s", err)
+	}
+}
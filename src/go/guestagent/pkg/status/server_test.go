@@ -0,0 +1,216 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package status_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/routes"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/status"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
+)
+
+type fakeProvider []tracker.Entry
+
+func (f fakeProvider) Status() []tracker.Entry {
+	return append([]tracker.Entry(nil), f...)
+}
+
+var testEntries = fakeProvider{
+	{
+		ID:     "containerID_1",
+		Source: tracker.SourceDocker,
+		Ports: nat.PortMap{
+			"80/tcp": []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "8080"}},
+		},
+		Errors: []tracker.BindingError{
+			{
+				Port:    "443/tcp",
+				Binding: nat.PortBinding{HostIP: "0.0.0.0", HostPort: "8443"},
+				Error:   "proxy already running",
+			},
+		},
+		Remapped: []tracker.Remap{
+			{
+				Port:     "80/tcp",
+				Binding:  nat.PortBinding{HostIP: "0.0.0.0", HostPort: "8080"},
+				HostPort: "8081",
+			},
+		},
+	},
+	{
+		ID:     "default/nginx",
+		Source: tracker.SourceKube,
+		Ports: nat.PortMap{
+			"30080/tcp": []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: "30080"}},
+		},
+	},
+}
+
+func TestListPorts(t *testing.T) {
+	t.Parallel()
+
+	srv := status.New(context.Background(), "", testEntries)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ports", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var entries []tracker.Entry
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&entries))
+	assert.Equal(t, []tracker.Entry(testEntries), entries)
+}
+
+func TestListPortsBySource(t *testing.T) {
+	t.Parallel()
+
+	srv := status.New(context.Background(), "", testEntries)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ports?source=kube", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var entries []tracker.Entry
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&entries))
+	require.Len(t, entries, 1)
+	assert.Equal(t, "default/nginx", entries[0].ID)
+}
+
+func TestGetPort(t *testing.T) {
+	t.Parallel()
+
+	srv := status.New(context.Background(), "", testEntries)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ports/default/nginx", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var entry tracker.Entry
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&entry))
+	assert.Equal(t, testEntries[1], entry)
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ports/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestListRemaps(t *testing.T) {
+	t.Parallel()
+
+	srv := status.New(context.Background(), "", testEntries)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/remaps", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var remaps []status.RemapStatus
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&remaps))
+	assert.Equal(t, []status.RemapStatus{
+		{
+			ID:     "containerID_1",
+			Source: tracker.SourceDocker,
+			Remap:  testEntries[0].Remapped[0],
+		},
+	}, remaps)
+
+	srv = status.New(context.Background(), "", fakeProvider{testEntries[1]})
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/remaps", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, "[]", rec.Body.String())
+}
+
+type fakeRoutes []routes.Route
+
+func (f fakeRoutes) Routes() []routes.Route {
+	return append([]routes.Route(nil), f...)
+}
+
+func TestListRoutes(t *testing.T) {
+	t.Parallel()
+
+	srv := status.New(context.Background(), "", testEntries)
+
+	// Without a route table, the routes are empty.
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/routes", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, "[]", rec.Body.String())
+
+	table := fakeRoutes{
+		{Host: "*.apps.localhost", Address: routes.Address, Ports: []int32{8081}, Kind: "HTTPRoute", Namespace: "infra", Name: "apps"},
+		{Host: "app.example.test", Address: routes.Address, Ports: []int32{80, 443}, Kind: "Ingress", Namespace: "default", Name: "web"},
+	}
+	srv.SetRoutes(table)
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/routes", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var listed []routes.Route
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&listed))
+	assert.Equal(t, []routes.Route(table), listed)
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/routes/hosts", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "127.0.0.1\tapp.example.test\n", rec.Body.String())
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	socketPath := filepath.Join(t.TempDir(), "status.sock")
+	srv := status.New(ctx, socketPath, testEntries)
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Serve() }()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		var err error
+		resp, err = client.Get("http://localhost/ports")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	defer resp.Body.Close()
+
+	var entries []tracker.Entry
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&entries))
+	assert.Len(t, entries, 2)
+
+	cancel()
+	require.NoError(t, <-errCh)
+	assert.NoFileExists(t, socketPath)
+}
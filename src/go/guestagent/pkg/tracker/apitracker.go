@@ -18,13 +18,19 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Masterminds/log-go"
 	"github.com/containers/gvisor-tap-vsock/pkg/types"
 	"github.com/docker/go-connections/nat"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/audit"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/forwarder"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/policy"
 	guestagentTypes "github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
 )
 
@@ -35,11 +41,19 @@ const (
 )
 
 var (
-	ErrAPI         = errors.New("error from API")
-	ErrInvalidIPv4 = errors.New("not an IPv4 address")
-	ErrWSLProxy    = errors.New("error from Rancher Desktop WSL Proxy")
+	ErrAPI       = errors.New("error from API")
+	ErrInvalidIP = errors.New("not an IP address")
+	ErrWSLProxy  = errors.New("error from Rancher Desktop WSL Proxy")
+	ErrReconcile = errors.New("reconciling with host-switch failed")
+	// ErrRetryScheduled is matched by the errors returned from Add when the
+	// bindings that failed are queued to be retried in the background, so
+	// callers can keep the port mapping instead of rolling it back.
+	ErrRetryScheduled = errors.New("retry scheduled")
 )
 
+// retryPollInterval is how often ProcessRetries checks for due retries.
+const retryPollInterval = 250 * time.Millisecond
+
 // APITracker keeps track of the port mappings and calls the
 // corresponding API endpoints that is responsible for exposing
 // and unexposing the ports on the host. This should only be used when
@@ -52,6 +66,21 @@ type APITracker struct {
 	tapInterfaceIP    string
 	portStorage       *portStorage
 	apiForwarder      *forwarder.APIForwarder
+	retries           *retryQueue
+	// reserved holds the host-switch ports that are exposed by
+	// something other than the tracker, keyed by protocol/local.
+	reserved map[string]bool
+	// policy decides which bindings are forwarded; nil allows all.
+	policy *policy.Policy
+	// remapRange, when set, is where a binding whose host port is
+	// taken is exposed instead.
+	remapRange *RemapRange
+	// auditLog records every expose and unexpose decision; nil
+	// discards them.
+	auditLog *audit.Log
+	// mutex serializes the changes to the exposed ports so that
+	// Reconcile sees a consistent view of the host-switch.
+	mutex sync.Mutex
 }
 
 // NewAPITracker creates a new instance of APITracker with the specified configuration.
@@ -75,44 +104,182 @@ func NewAPITracker(ctx context.Context, wslProxyForwarder forwarder.Forwarder, b
 		tapInterfaceIP:    tapIfaceIP,
 		portStorage:       newPortStorage(),
 		apiForwarder:      forwarder.NewAPIForwarder(baseURL),
+		retries:           newRetryQueue(),
+		reserved:          make(map[string]bool),
 	}
 }
 
 // Add a container ID and port mapping to the tracker and calls the
 // /services/forwarder/expose endpoint to forward the port mappings.
 func (a *APITracker) Add(containerID string, portMap nat.PortMap) error {
+	return a.add("", containerID, policy.Workload{}, portMap)
+}
+
+// AddWorkload is like Add, for a port mapping owned by workload.
+func (a *APITracker) AddWorkload(containerID string, workload policy.Workload, portMap nat.PortMap) error {
+	return a.add("", containerID, workload, portMap)
+}
+
+// SetPolicy sets the port forwarding policy that Add enforces from now on;
+// a nil policy allows everything. The port mappings that are already
+// forwarded are not re-evaluated.
+func (a *APITracker) SetPolicy(p *policy.Policy) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.policy = p
+}
+
+// AllowsWorkload reports whether the port forwarding policy allows the
+// workload, see Tracker.
+func (a *APITracker) AllowsWorkload(workload policy.Workload) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.policy.AllowsWorkload(workload)
+}
+
+// ForSource returns a Tracker that records source as the origin of
+// every port mapping it adds, as reported by Status.
+func (a *APITracker) ForSource(source Source) Tracker {
+	return &sourceTracker{APITracker: a, source: source}
+}
+
+// SetRemapRange enables exposing a binding on another host port, picked
+// from r, when the host port it requests is already taken on the host.
+// A nil r disables remapping.
+func (a *APITracker) SetRemapRange(r *RemapRange) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.remapRange = r
+}
+
+// SetAuditLog sets the log that records every expose and unexpose
+// decision from now on; a nil log disables it.
+func (a *APITracker) SetAuditLog(l *audit.Log) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.auditLog = l
+}
+
+// Status returns a snapshot of all the tracked IDs along with
+// the bindings that failed to be exposed.
+func (a *APITracker) Status() []Entry {
+	return a.portStorage.status()
+}
+
+// WSLProxyState returns every forwarded port binding, along with the PROXY
+// protocol versions, which is the state wsl-proxy gets resynced with. It
+// does not wait for the changes in progress, as it is called while they
+// are sent to wsl-proxy.
+func (a *APITracker) WSLProxyState() guestagentTypes.PortMapping {
+	state := guestagentTypes.PortMapping{Ports: make(nat.PortMap)}
+	for _, entry := range a.portStorage.status() {
+		for portProto, portBindings := range entry.Ports {
+			for _, portBinding := range portBindings {
+				if !slices.Contains(state.Ports[portProto], portBinding) {
+					state.Ports[portProto] = append(state.Ports[portProto], portBinding)
+				}
+			}
+		}
+		for hostPort, version := range entry.ProxyProtocol {
+			if state.ProxyProtocol == nil {
+				state.ProxyProtocol = make(map[string]guestagentTypes.ProxyProtocolVersion)
+			}
+			state.ProxyProtocol[hostPort] = version
+		}
+	}
+	return state
+}
+
+func (a *APITracker) add(source Source, containerID string, workload policy.Workload, portMap nat.PortMap) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	portMap, rejected := a.policy.Evaluate(portMap, workload)
+	for _, rejection := range rejected {
+		log.Warnf("port forwarding policy rejected %s %+v for %s: %s",
+			rejection.Port, rejection.Binding, containerID, rejection.Reason)
+		a.audit(audit.Record{Event: "add", Source: string(source), ID: containerID, Action: audit.ActionExpose, Result: audit.ResultRejected},
+			rejection.Port, rejection.Binding, "", errors.New(rejection.Reason))
+	}
+
 	var errs []error
+	var bindingErrs []BindingError
+	var remaps []Remap
 
 	successfullyForwarded := make(nat.PortMap)
 
-	for portProto, portBindings := range portMap {
-		var tmpPortBinding []nat.PortBinding
+	// exposed maps the bindings to the host port they are exposed on.
+	exposed := make(map[nat.Port]map[nat.PortBinding]string, len(portMap))
+	failed := make(map[nat.Port]map[nat.PortBinding]error, len(portMap))
+	var pending []pendingBinding
 
+	for portProto, portBindings := range portMap {
 		log.Debugf("called add with portProto: %+v, portBindings: %+v\n", portProto, portBindings)
 
+		exposed[portProto] = make(map[nat.PortBinding]string, len(portBindings))
+		failed[portProto] = make(map[nat.PortBinding]error)
+
 		for _, portBinding := range portBindings {
-			// The expose API only supports IPv4
-			ipv4, err := isIPv4(portBinding.HostIP)
-			if !ipv4 || err != nil {
-				log.Errorf("did not receive IPv4 for HostIP: %s", portBinding.HostIP)
+			if _, err := parseHostIP(portBinding.HostIP); err != nil {
+				log.Errorf("did not receive a valid HostIP: %s", err)
+				failed[portProto][portBinding] = err
+				continue
+			}
+
+			if _, ok := a.dualStackBinding(portBinding, portBindings); ok {
+				continue
+			}
+
+			if !hostSwitchProtocol(portProto) {
+				log.Debugf("forwarding %s %+v to wsl-proxy only, the host-switch does not support %s",
+					portProto, portBinding, portProto.Proto())
+				exposed[portProto][portBinding] = portBinding.HostPort
 				continue
 			}
 
 			log.Debugf("exposing the following port binding: %+v", portBinding)
+			pending = append(pending, pendingBinding{port: portProto, binding: portBinding})
+		}
+	}
 
-			err = a.apiForwarder.Expose(
-				&types.ExposeRequest{
-					Local:    ipPortBuilder(a.determineHostIP(portBinding.HostIP), portBinding.HostPort),
-					Remote:   ipPortBuilder(a.tapInterfaceIP, portBinding.HostPort),
-					Protocol: types.TransportProtocol(strings.ToLower(portProto.Proto())),
-				})
-			if err != nil {
-				errs = append(errs, fmt.Errorf("exposing %+v failed: %w", portBinding, err))
+	hostPorts, exposeErrs := a.exposeAll(containerID, pending)
+	for i, p := range pending {
+		if err := exposeErrs[i]; err != nil {
+			errs = append(errs, fmt.Errorf("exposing %+v failed: %w", p.binding, err))
+			failed[p.port][p.binding] = err
+			continue
+		}
+		exposed[p.port][p.binding] = hostPorts[i]
+	}
+
+	for portProto, portBindings := range portMap {
+		var tmpPortBinding []nat.PortBinding
 
+		// Keep the caller's ordering; an IPv4 wildcard binding folded into
+		// a dual-stack listener is forwarded only if that listener is.
+		for _, portBinding := range portBindings {
+			covering := portBinding
+			if dualStack, ok := a.dualStackBinding(portBinding, portBindings); ok {
+				covering = dualStack
+			}
+			record := audit.Record{Event: "add", Source: string(source), ID: containerID, Action: audit.ActionExpose}
+			if exposedHostPort, ok := exposed[portProto][covering]; ok {
+				tmpPortBinding = append(tmpPortBinding, portBinding)
+				if exposedHostPort != portBinding.HostPort {
+					remaps = append(remaps, Remap{Port: portProto, Binding: portBinding, HostPort: exposedHostPort})
+				}
+				a.audit(record, portProto, portBinding, exposedHostPort, nil)
 				continue
 			}
-
-			tmpPortBinding = append(tmpPortBinding, portBinding)
+			if err, ok := failed[portProto][covering]; ok {
+				a.audit(record, portProto, portBinding, "", err)
+				bindingErrs = append(bindingErrs, BindingError{
+					Port:    portProto,
+					Binding: portBinding,
+					Error:   err.Error(),
+					Time:    time.Now(),
+				})
+			}
 		}
 
 		if len(tmpPortBinding) != 0 {
@@ -120,23 +287,35 @@ func (a *APITracker) Add(containerID string, portMap nat.PortMap) error {
 		}
 	}
 
+	proxyProtocol := proxyProtocols(containerID, workload, portMap)
+	a.portStorage.add(containerID, source, successfullyForwarded, remaps, bindingErrs, rejected, proxyProtocol)
+
 	if len(successfullyForwarded) != 0 {
-		a.portStorage.add(containerID, successfullyForwarded)
 		portMapping := guestagentTypes.PortMapping{
-			Remove: false,
-			Ports:  successfullyForwarded,
+			Remove:        false,
+			Ports:         successfullyForwarded,
+			ProxyProtocol: proxyProtocol,
 		}
 		log.Debugf("forwarding to wsl-proxy to add port mapping: %+v", portMapping)
 		err := a.wslProxyForwarder.Send(portMapping)
 		if err != nil {
-			return fmt.Errorf("sending port mappings to wsl proxy error: %w", err)
+			a.retries.schedule(containerID, true)
+			return retryError{fmt.Errorf("sending port mappings to wsl proxy error: %w", err)}
 		}
 	}
 
 	if len(errs) != 0 {
-		return fmt.Errorf("%w: %+v", forwarder.ErrExposeAPI, errs)
+		err := fmt.Errorf("%w: %+v", forwarder.ErrExposeAPI, errs)
+		if retryable(bindingErrs) {
+			a.retries.schedule(containerID, false)
+			return retryError{err}
+		}
+		a.retries.remove(containerID)
+		return err
 	}
 
+	a.retries.remove(containerID)
+
 	return nil
 }
 
@@ -148,33 +327,23 @@ func (a *APITracker) Get(containerID string) nat.PortMap {
 // Remove a single entry from the port storage and calls the
 // /services/forwarder/unexpose endpoint to remove the forwarded port mappings.
 func (a *APITracker) Remove(containerID string) error {
-	portMap := a.portStorage.get(containerID)
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	entry, _ := a.portStorage.lookup(containerID)
+	portMap := entry.Ports
 	defer a.portStorage.remove(containerID)
+	a.retries.remove(containerID)
 
 	var errs []error
 
-	for portProto, portBindings := range portMap {
-		for _, portBinding := range portBindings {
-			// The unexpose API only supports IPv4
-			ipv4, err := isIPv4(portBinding.HostIP)
-			if !ipv4 || err != nil {
-				log.Errorf("did not receive IPv4 for HostIP: %s", portBinding.HostIP)
-				continue
-			}
-
-			log.Debugf("unexposing the following port binding: %+v", portBinding)
-
-			err = a.apiForwarder.Unexpose(
-				&types.UnexposeRequest{
-					Local:    ipPortBuilder(a.determineHostIP(portBinding.HostIP), portBinding.HostPort),
-					Protocol: types.TransportProtocol(strings.ToLower(portProto.Proto())),
-				})
-			if err != nil {
-				errs = append(errs,
-					fmt.Errorf("unexposing %+v failed: %w", portBinding, err))
-
-				continue
-			}
+	pending := a.unexposable(entry)
+	for i, err := range a.unexposeAll(entry, pending) {
+		a.audit(audit.Record{Event: "remove", Source: string(entry.Source), ID: containerID, Action: audit.ActionUnexpose},
+			pending[i].port, pending[i].binding, entry.HostPort(pending[i].port, pending[i].binding), err)
+		if err != nil {
+			errs = append(errs,
+				fmt.Errorf("unexposing %+v failed: %w", pending[i].binding, err))
 		}
 	}
 
@@ -200,35 +369,54 @@ func (a *APITracker) Remove(containerID string) error {
 // RemoveAll calls the /services/forwarder/unexpose
 // and removes all the port bindings from the tracker.
 func (a *APITracker) RemoveAll() error {
-	var apiErrs, wslProxyErrs []error
+	return a.removeAll(func(Entry) bool { return true })
+}
 
-	for _, portMapping := range a.portStorage.getAll() {
-		for _, portBindings := range portMapping {
-			for _, portBinding := range portBindings {
-				// The unexpose API only supports IPv4
-				ipv4, err := isIPv4(portBinding.HostIP)
-				if !ipv4 || err != nil {
-					continue
-				}
+// removeAll unexposes and removes the tracked entries that match.
+func (a *APITracker) removeAll(match func(Entry) bool) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
 
-				log.Debugf("unexposing the following port binding: %+v", portBinding)
+	var apiErrs, wslProxyErrs []error
 
-				err = a.apiForwarder.Unexpose(
-					&types.UnexposeRequest{
-						Local: ipPortBuilder(a.determineHostIP(portBinding.HostIP), portBinding.HostPort),
-					})
-				if err != nil {
-					apiErrs = append(apiErrs,
-						fmt.Errorf("RemoveAll unexposing %+v failed: %w", portBinding, err))
+	// Unexpose the bindings of all the entries in a single batch.
+	var entries []Entry
+	var pending []pendingBinding
+	var owners []int
+	for _, entry := range a.portStorage.status() {
+		if !match(entry) {
+			continue
+		}
+		a.portStorage.remove(entry.ID)
+		a.retries.remove(entry.ID)
+		if len(entry.Ports) == 0 {
+			continue
+		}
+		for _, p := range a.unexposable(entry) {
+			pending = append(pending, p)
+			owners = append(owners, len(entries))
+		}
+		entries = append(entries, entry)
+	}
 
-					continue
-				}
-			}
+	reqs := make([]*types.UnexposeRequest, len(pending))
+	for i, p := range pending {
+		reqs[i] = a.unexposeRequest(entries[owners[i]], p)
+	}
+	for i, err := range a.apiForwarder.UnexposeBatch(reqs) {
+		entry := entries[owners[i]]
+		a.audit(audit.Record{Event: "removeAll", Source: string(entry.Source), ID: entry.ID, Action: audit.ActionUnexpose},
+			pending[i].port, pending[i].binding, entry.HostPort(pending[i].port, pending[i].binding), err)
+		if err != nil {
+			apiErrs = append(apiErrs,
+				fmt.Errorf("RemoveAll unexposing %+v failed: %w", pending[i].binding, err))
 		}
+	}
 
+	for _, entry := range entries {
 		portMapping := guestagentTypes.PortMapping{
 			Remove: true,
-			Ports:  portMapping,
+			Ports:  entry.Ports,
 		}
 
 		log.Debugf("forwarding to wsl-proxy to remove port mapping: %+v", portMapping)
@@ -239,8 +427,6 @@ func (a *APITracker) RemoveAll() error {
 		}
 	}
 
-	a.portStorage.removeAll()
-
 	if len(apiErrs) != 0 {
 		return fmt.Errorf("%w: %+v", forwarder.ErrUnexposeAPI, apiErrs)
 	}
@@ -252,30 +438,598 @@ func (a *APITracker) RemoveAll() error {
 	return nil
 }
 
+// Reserve marks a port exposed on the host-switch by other means, such as
+// the static --port-forward for the Kubernetes API, so that Reconcile
+// does not treat it as an orphan.
+func (a *APITracker) Reserve(protocol types.TransportProtocol, local string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.reserved[forwarderKey(protocol, local)] = true
+}
+
+// Reconcile compares the ports exposed by the host-switch, as reported by
+// the /services/forwarder/all endpoint, with the tracked port mappings.
+// This recovers from a guest agent restart, where the host-switch still
+// holds the ports exposed by the previous run:
+//   - a binding that failed to be exposed because the host-switch already
+//     exposes it is adopted as forwarded.
+//   - a tracked binding missing from the host-switch is exposed again.
+//   - a port exposed by the host-switch that is neither tracked nor
+//     reserved is an orphan and is unexposed.
+func (a *APITracker) Reconcile() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	exposed, err := a.apiForwarder.All()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrReconcile, err)
+	}
+
+	orphans := make(map[string]types.ExposeRequest, len(exposed))
+	for _, req := range exposed {
+		orphans[forwarderKey(req.Protocol, req.Local)] = req
+	}
+
+	// ensureExposed claims the host-switch entry for a binding,
+	// exposing it first if the host-switch does not have it.
+	ensureExposed := func(entry Entry, portProto nat.Port, portBinding nat.PortBinding, hostPort string) error {
+		if !hostSwitchProtocol(portProto) {
+			return nil
+		}
+		req := a.exposeRequest(portProto, portBinding, hostPort)
+		key := forwarderKey(req.Protocol, req.Local)
+		if _, ok := orphans[key]; ok {
+			delete(orphans, key)
+			return nil
+		}
+		log.Debugf("reconcile: exposing missing port binding: %+v", portBinding)
+		err := a.apiForwarder.Expose(req)
+		a.audit(audit.Record{Event: "reconcile", Source: string(entry.Source), ID: entry.ID, Action: audit.ActionExpose},
+			portProto, portBinding, hostPort, err)
+		return err
+	}
+
+	var errs []error
+
+	for _, entry := range a.portStorage.status() {
+		for portProto, portBindings := range entry.Ports {
+			for _, portBinding := range portBindings {
+				if _, ok := a.dualStackBinding(portBinding, portBindings); ok {
+					continue
+				}
+				if err := ensureExposed(entry, portProto, portBinding, entry.HostPort(portProto, portBinding)); err != nil {
+					errs = append(errs, fmt.Errorf("exposing %+v failed: %w", portBinding, err))
+				}
+			}
+		}
+
+		if len(entry.Errors) == 0 {
+			continue
+		}
+
+		adopted, bindingErrs := a.exposeFailed(entry.Errors, func(portProto nat.Port, portBinding nat.PortBinding) error {
+			return ensureExposed(entry, portProto, portBinding, portBinding.HostPort)
+		})
+		a.portStorage.adopt(entry.ID, adopted, nil, bindingErrs)
+		a.auditAdopted(audit.Record{Event: "reconcile", Source: string(entry.Source), ID: entry.ID}, adopted, nil, bindingErrs)
+
+		if len(adopted) != 0 {
+			portMapping := guestagentTypes.PortMapping{
+				Remove:        false,
+				Ports:         adopted,
+				ProxyProtocol: entry.ProxyProtocol,
+			}
+			log.Debugf("forwarding to wsl-proxy to add reconciled port mapping: %+v", portMapping)
+			if err := a.wslProxyForwarder.Send(portMapping); err != nil {
+				a.retries.schedule(entry.ID, true)
+				errs = append(errs, fmt.Errorf("sending port mappings to wsl proxy error: %w", err))
+			}
+		}
+	}
+
+	for key, req := range orphans {
+		if a.reserved[key] {
+			continue
+		}
+		log.Infof("reconcile: unexposing orphaned port: %+v", req)
+		err := a.apiForwarder.Unexpose(
+			&types.UnexposeRequest{
+				Local:    req.Local,
+				Protocol: req.Protocol,
+			})
+		a.auditOrphan(req, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unexposing %+v failed: %w", req, err))
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("%w: %+v", ErrReconcile, errs)
+	}
+
+	return nil
+}
+
+// ProcessRetries retries the failed exposes queued by Add, with
+// exponential backoff, until the context is cancelled.
+func (a *APITracker) ProcessRetries() {
+	ticker := time.NewTicker(retryPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.context.Done():
+			return
+		case now := <-ticker.C:
+			for _, containerID := range a.retries.due(now) {
+				a.retry(containerID)
+			}
+		}
+	}
+}
+
+// retry exposes the failed bindings of containerID again, and resends
+// its port mapping to wsl-proxy if that failed before.
+func (a *APITracker) retry(containerID string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	item, ok := a.retries.get(containerID)
+	if !ok {
+		return
+	}
+	entry, ok := a.portStorage.lookup(containerID)
+	if !ok {
+		a.retries.remove(containerID)
+		return
+	}
+
+	log.Debugf("retrying to expose port bindings for %s, attempt %d", containerID, item.attempt+1)
+
+	var remaps []Remap
+	adopted, bindingErrs := a.exposeFailed(entry.Errors, func(portProto nat.Port, portBinding nat.PortBinding) error {
+		exposedHostPort, err := a.expose(containerID, portProto, portBinding)
+		if err == nil && exposedHostPort != portBinding.HostPort {
+			remaps = append(remaps, Remap{Port: portProto, Binding: portBinding, HostPort: exposedHostPort})
+		}
+		return err
+	})
+	a.portStorage.adopt(containerID, adopted, remaps, bindingErrs)
+	a.auditAdopted(audit.Record{Event: "retry", Source: string(entry.Source), ID: containerID}, adopted, remaps, bindingErrs)
+
+	// wsl-proxy never received the earlier bindings either.
+	toSend := adopted
+	if item.resendWSLProxy {
+		toSend = a.portStorage.get(containerID)
+	}
+
+	resendWSLProxy := false
+	if len(toSend) != 0 {
+		portMapping := guestagentTypes.PortMapping{
+			Remove:        false,
+			Ports:         toSend,
+			ProxyProtocol: entry.ProxyProtocol,
+		}
+		log.Debugf("forwarding to wsl-proxy to add retried port mapping: %+v", portMapping)
+		if err := a.wslProxyForwarder.Send(portMapping); err != nil {
+			log.Debugf("sending port mappings to wsl proxy error: %s", err)
+			resendWSLProxy = true
+		}
+	}
+
+	if resendWSLProxy || retryable(bindingErrs) {
+		a.retries.backoff(containerID, resendWSLProxy)
+		return
+	}
+
+	a.retries.remove(containerID)
+	log.Infof("port bindings for %s are exposed after retrying", containerID)
+}
+
+// exposeFailed calls expose for each failed binding, folding dual-stack
+// bindings the same way Add does, and returns the bindings that are now
+// forwarded along with the ones that still fail.
+func (a *APITracker) exposeFailed(
+	failedBindings []BindingError,
+	expose func(nat.Port, nat.PortBinding) error,
+) (nat.PortMap, []BindingError) {
+	failed := make(nat.PortMap)
+	for _, bindingErr := range failedBindings {
+		failed[bindingErr.Port] = append(failed[bindingErr.Port], bindingErr.Binding)
+	}
+
+	adopted := make(nat.PortMap)
+	var bindingErrs []BindingError
+
+	for portProto, portBindings := range failed {
+		outcome := make(map[nat.PortBinding]error, len(portBindings))
+		for _, portBinding := range portBindings {
+			if _, ok := a.dualStackBinding(portBinding, portBindings); ok {
+				continue
+			}
+			if _, err := parseHostIP(portBinding.HostIP); err != nil {
+				outcome[portBinding] = err
+				continue
+			}
+			outcome[portBinding] = expose(portProto, portBinding)
+		}
+		for _, portBinding := range portBindings {
+			covering := portBinding
+			if dualStack, ok := a.dualStackBinding(portBinding, portBindings); ok {
+				covering = dualStack
+			}
+			if err := outcome[covering]; err != nil {
+				bindingErrs = append(bindingErrs, BindingError{
+					Port:    portProto,
+					Binding: portBinding,
+					Error:   err.Error(),
+					Time:    time.Now(),
+				})
+				continue
+			}
+			adopted[portProto] = append(adopted[portProto], portBinding)
+		}
+	}
+
+	return adopted, bindingErrs
+}
+
+// pendingBinding is a binding to expose along with its port in the VM.
+type pendingBinding struct {
+	port    nat.Port
+	binding nat.PortBinding
+}
+
+// exposeAll exposes the bindings on the host-switch in batches, like
+// expose, and returns the host port each is exposed on along with its
+// error.
+func (a *APITracker) exposeAll(containerID string, bindings []pendingBinding) ([]string, []error) {
+	reqs := make([]*types.ExposeRequest, len(bindings))
+	for i, b := range bindings {
+		reqs[i] = a.exposeRequest(b.port, b.binding, b.binding.HostPort)
+	}
+	errs := a.apiForwarder.ExposeBatch(reqs)
+
+	hostPorts := make([]string, len(bindings))
+	for i, b := range bindings {
+		hostPorts[i], errs[i] = a.remap(containerID, b.port, b.binding, errs[i])
+	}
+	return hostPorts, errs
+}
+
+// unexposable returns the bindings of entry that are exposed on the
+// host-switch on their own.
+func (a *APITracker) unexposable(entry Entry) []pendingBinding {
+	var bindings []pendingBinding
+	for portProto, portBindings := range entry.Ports {
+		for _, portBinding := range portBindings {
+			if _, err := parseHostIP(portBinding.HostIP); err != nil {
+				log.Errorf("did not receive a valid HostIP: %s", err)
+				continue
+			}
+
+			// Folded into a dual-stack listener that is unexposed on its own.
+			if _, ok := a.dualStackBinding(portBinding, portBindings); ok {
+				continue
+			}
+
+			if !hostSwitchProtocol(portProto) {
+				continue
+			}
+
+			log.Debugf("unexposing the following port binding: %+v", portBinding)
+			bindings = append(bindings, pendingBinding{port: portProto, binding: portBinding})
+		}
+	}
+	return bindings
+}
+
+// unexposeAll unexposes the bindings of entry in batches and returns their
+// errors.
+func (a *APITracker) unexposeAll(entry Entry, bindings []pendingBinding) []error {
+	reqs := make([]*types.UnexposeRequest, len(bindings))
+	for i, b := range bindings {
+		reqs[i] = a.unexposeRequest(entry, b)
+	}
+	return a.apiForwarder.UnexposeBatch(reqs)
+}
+
+// unexposeRequest builds the request to unexpose a binding of entry, from
+// the host port it is exposed on.
+func (a *APITracker) unexposeRequest(entry Entry, b pendingBinding) *types.UnexposeRequest {
+	return &types.UnexposeRequest{
+		Local:    ipPortBuilder(a.determineHostIP(b.binding.HostIP), entry.HostPort(b.port, b.binding)),
+		Protocol: types.TransportProtocol(strings.ToLower(b.port.Proto())),
+	}
+}
+
+// expose exposes portBinding on the host-switch and returns the host port
+// it is exposed on. If remapping is enabled and the requested host port
+// is taken, the binding is exposed on the first free port of the remap
+// range instead, still forwarding to the requested port in the VM.
+func (a *APITracker) expose(containerID string, portProto nat.Port, portBinding nat.PortBinding) (string, error) {
+	err := a.apiForwarder.Expose(a.exposeRequest(portProto, portBinding, portBinding.HostPort))
+	return a.remap(containerID, portProto, portBinding, err)
+}
+
+// remap handles err, the outcome of exposing portBinding on the host port
+// it requested: if the port is taken and remapping is enabled, the binding
+// is exposed on another host port.
+func (a *APITracker) remap(containerID string, portProto nat.Port, portBinding nat.PortBinding, err error) (string, error) {
+	if err == nil || a.remapRange == nil || !a.hostPortTaken(containerID, portProto, portBinding, err) {
+		return portBinding.HostPort, err
+	}
+
+	requested, convErr := strconv.Atoi(portBinding.HostPort)
+	if convErr != nil {
+		return "", err
+	}
+	for _, candidate := range a.remapRange.candidates(requested) {
+		hostPort := strconv.Itoa(candidate)
+		remapErr := a.apiForwarder.Expose(a.exposeRequest(portProto, portBinding, hostPort))
+		if remapErr == nil {
+			log.Infof("host port %s is taken; exposed %s %+v for %s on host port %s instead",
+				portBinding.HostPort, portProto, portBinding, containerID, hostPort)
+			return hostPort, nil
+		}
+		remapBinding := nat.PortBinding{HostIP: portBinding.HostIP, HostPort: hostPort}
+		if !a.hostPortTaken(containerID, portProto, remapBinding, remapErr) {
+			return "", remapErr
+		}
+		log.Debugf("remapping %+v to host port %s failed: %s", portBinding, hostPort, remapErr)
+	}
+
+	return "", fmt.Errorf("no free host port to remap to: %w", err)
+}
+
+// hostPortTaken reports whether err, returned when exposing portBinding,
+// is because the host port is in use: either by another process on the
+// host, or by a binding of another tracked ID. A host-switch entry that
+// nothing tracks is not counted, as it is left over from a previous run
+// and gets adopted or cleaned up by Reconcile.
+func (a *APITracker) hostPortTaken(containerID string, portProto nat.Port, portBinding nat.PortBinding, err error) bool {
+	if isHostPortTaken(err) {
+		return true
+	}
+	req := a.exposeRequest(portProto, portBinding, portBinding.HostPort)
+	key := forwarderKey(req.Protocol, req.Local)
+	for _, entry := range a.portStorage.status() {
+		if entry.ID == containerID {
+			continue
+		}
+		for trackedProto, trackedBindings := range entry.Ports {
+			for _, trackedBinding := range trackedBindings {
+				tracked := a.exposeRequest(trackedProto, trackedBinding, entry.HostPort(trackedProto, trackedBinding))
+				if forwarderKey(tracked.Protocol, tracked.Local) == key {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// exposeRequest builds the request to expose portBinding on the given
+// host port; the host-switch forwards it to the requested port in the VM.
+func (a *APITracker) exposeRequest(portProto nat.Port, portBinding nat.PortBinding, hostPort string) *types.ExposeRequest {
+	return &types.ExposeRequest{
+		Local:    ipPortBuilder(a.determineHostIP(portBinding.HostIP), hostPort),
+		Remote:   ipPortBuilder(a.tapInterfaceIP, portBinding.HostPort),
+		Protocol: types.TransportProtocol(strings.ToLower(portProto.Proto())),
+	}
+}
+
 func (a *APITracker) determineHostIP(hostIP string) string {
 	// If Rancher Desktop is installed as non-admin, we use the
 	// localhost IP address since binding to a port on 127.0.0.1
 	// does not require administrative privileges on Windows.
 	if !a.isAdmin {
+		if ip, err := parseHostIP(hostIP); err == nil && ip.To4() == nil {
+			return net.IPv6loopback.String()
+		}
 		return "127.0.0.1"
 	}
 
 	return hostIP
 }
 
+// dualStackBinding reports whether portBinding is an IPv4 wildcard binding
+// that is served by an IPv6 wildcard binding for the same host port, and
+// returns that binding. host-switch listens on [::] as a dual-stack socket,
+// which accepts IPv4 connections as well; a separate 0.0.0.0 listener on
+// the same port would fail with "address already in use". Non-admin
+// installs bind both families to their loopback addresses instead, which
+// do not collide.
+func (a *APITracker) dualStackBinding(portBinding nat.PortBinding, portBindings []nat.PortBinding) (nat.PortBinding, bool) {
+	if !a.isAdmin {
+		return nat.PortBinding{}, false
+	}
+
+	ip, err := parseHostIP(portBinding.HostIP)
+	if err != nil || !ip.Equal(net.IPv4zero) {
+		return nat.PortBinding{}, false
+	}
+
+	for _, candidate := range portBindings {
+		if candidate.HostPort != portBinding.HostPort {
+			continue
+		}
+		if candidateIP, err := parseHostIP(candidate.HostIP); err == nil && candidateIP.Equal(net.IPv6unspecified) {
+			return candidate, true
+		}
+	}
+
+	return nat.PortBinding{}, false
+}
+
+// audit records the outcome of exposing or unexposing portBinding on
+// hostPort; an empty hostPort is the requested one.
+func (a *APITracker) audit(record audit.Record, portProto nat.Port, portBinding nat.PortBinding, hostPort string, err error) {
+	if a.auditLog == nil {
+		return
+	}
+	record.Component = "tracker"
+	record.Port = string(portProto)
+	record.HostIP = portBinding.HostIP
+	record.HostPort = portBinding.HostPort
+	if _, ipErr := parseHostIP(portBinding.HostIP); ipErr == nil {
+		record.ResolvedHostIP = a.determineHostIP(portBinding.HostIP)
+	}
+	if hostPort != portBinding.HostPort {
+		record.ExposedHostPort = hostPort
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	switch {
+	case record.Result != "":
+	case err != nil:
+		record.Result = audit.ResultFailed
+	default:
+		record.Result = audit.ResultOK
+	}
+	a.auditLog.Write(record)
+}
+
+// auditAdopted records the outcome of exposing the failed bindings of an
+// entry again.
+func (a *APITracker) auditAdopted(record audit.Record, adopted nat.PortMap, remaps []Remap, bindingErrs []BindingError) {
+	if a.auditLog == nil {
+		return
+	}
+	record.Action = audit.ActionExpose
+	for portProto, portBindings := range adopted {
+		for _, portBinding := range portBindings {
+			hostPort := ""
+			for _, remap := range remaps {
+				if remap.Port == portProto && remap.Binding == portBinding {
+					hostPort = remap.HostPort
+				}
+			}
+			a.audit(record, portProto, portBinding, hostPort, nil)
+		}
+	}
+	for _, bindingErr := range bindingErrs {
+		a.audit(record, bindingErr.Port, bindingErr.Binding, "", errors.New(bindingErr.Error))
+	}
+}
+
+// auditOrphan records the outcome of unexposing a host-switch port that
+// no monitor reports.
+func (a *APITracker) auditOrphan(req types.ExposeRequest, err error) {
+	if a.auditLog == nil {
+		return
+	}
+	record := audit.Record{
+		Component: "tracker",
+		Event:     "reconcile",
+		Action:    audit.ActionUnexpose,
+		Result:    audit.ResultOK,
+	}
+	if host, port, splitErr := net.SplitHostPort(req.Local); splitErr == nil {
+		record.ResolvedHostIP, record.HostPort = host, port
+	}
+	if _, port, splitErr := net.SplitHostPort(req.Remote); splitErr == nil {
+		record.Port = port + "/" + string(req.Protocol)
+	}
+	if err != nil {
+		record.Result = audit.ResultFailed
+		record.Error = err.Error()
+	}
+	a.auditLog.Write(record)
+}
+
+// sourceTracker is a Tracker that tags the port mappings it adds
+// with the component that produced them.
+type sourceTracker struct {
+	*APITracker
+	source Source
+}
+
+// key namespaces containerID by the source, so that the IDs reported by
+// different sources cannot collide, e.g. when Docker and containerd both
+// run containers.
+func (s *sourceTracker) key(containerID string) string {
+	return string(s.source) + "/" + containerID
+}
+
+func (s *sourceTracker) Get(containerID string) nat.PortMap {
+	return s.APITracker.Get(s.key(containerID))
+}
+
+func (s *sourceTracker) Add(containerID string, portMap nat.PortMap) error {
+	return s.add(s.source, s.key(containerID), policy.Workload{}, portMap)
+}
+
+func (s *sourceTracker) AddWorkload(containerID string, workload policy.Workload, portMap nat.PortMap) error {
+	return s.add(s.source, s.key(containerID), workload, portMap)
+}
+
+func (s *sourceTracker) Remove(containerID string) error {
+	return s.APITracker.Remove(s.key(containerID))
+}
+
+// RemoveAll only removes the port mappings of the source, so that a
+// monitor shutting down leaves the ones of the other monitors alone.
+func (s *sourceTracker) RemoveAll() error {
+	return s.removeAll(func(entry Entry) bool { return entry.Source == s.source })
+}
+
+// retryable reports whether any of the failed bindings may succeed when
+// exposed again; a binding without a valid HostIP never will.
+func retryable(bindingErrs []BindingError) bool {
+	for _, bindingErr := range bindingErrs {
+		if _, err := parseHostIP(bindingErr.Binding.HostIP); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// retryError marks an error returned from Add whose failed bindings
+// were queued for retry. It keeps the message of the wrapped error.
+type retryError struct {
+	error
+}
+
+func (e retryError) Is(target error) bool {
+	return target == ErrRetryScheduled
+}
+
+func (e retryError) Unwrap() error {
+	return e.error
+}
+
+// hostSwitchProtocol reports whether the host-switch can expose portProto.
+// Its network stack only has TCP and UDP; the other protocols, such as
+// SCTP, are only forwarded to the WSL distributions by wsl-proxy.
+func hostSwitchProtocol(portProto nat.Port) bool {
+	switch types.TransportProtocol(strings.ToLower(portProto.Proto())) {
+	case types.TCP, types.UDP:
+		return true
+	default:
+		return false
+	}
+}
+
+// forwarderKey mirrors how the host-switch keys its exposed ports;
+// an empty protocol defaults to TCP.
+func forwarderKey(protocol types.TransportProtocol, local string) string {
+	if protocol == "" {
+		protocol = types.TCP
+	}
+	return string(protocol) + "/" + local
+}
+
 func ipPortBuilder(ip, port string) string {
-	return ip + ":" + port
+	return net.JoinHostPort(ip, port)
 }
 
-func isIPv4(addr string) (bool, error) {
+func parseHostIP(addr string) (net.IP, error) {
 	ip := net.ParseIP(addr)
 	if ip == nil {
-		return false, fmt.Errorf("%w: %s", ErrInvalidIPv4, addr)
-	}
-
-	if ip.To4() != nil {
-		return true, nil
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIP, addr)
 	}
 
-	return false, nil
+	return ip, nil
 }
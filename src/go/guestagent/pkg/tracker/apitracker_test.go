@@ -16,17 +16,27 @@ package tracker_test
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/containers/gvisor-tap-vsock/pkg/types"
 	"github.com/docker/go-connections/nat"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/audit"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/forwarder"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/policy"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
 	guestagentType "github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
 )
@@ -602,8 +612,8 @@ func TestRemoveAllWithError(t *testing.T) {
 	require.EqualError(t, err, expectedErr.Error())
 
 	assert.ElementsMatch(t, expectedUnexposeReq, []*types.UnexposeRequest{
-		{Local: ipPortBuilder(hostIP, hostPort)},
-		{Local: ipPortBuilder(hostIP3, hostPort2)},
+		{Local: ipPortBuilder(hostIP, hostPort), Protocol: protocolTCP},
+		{Local: ipPortBuilder(hostIP3, hostPort2), Protocol: protocolTCP},
 	})
 
 	expectedPortMapping1 := apiTracker.Get(containerID)
@@ -683,10 +693,986 @@ func TestNonAdminInstall(t *testing.T) {
 	assert.Nil(t, portMapping)
 }
 
+func TestAddIPv6(t *testing.T) {
+	t.Parallel()
+
+	var expectedExposeReq []*types.ExposeRequest
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/services/forwarder/expose", func(_ http.ResponseWriter, r *http.Request) {
+		var tmpReq *types.ExposeRequest
+		err := json.NewDecoder(r.Body).Decode(&tmpReq)
+		require.NoError(t, err)
+		expectedExposeReq = append(expectedExposeReq, tmpReq)
+	})
+
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	wslProxy := &testForwarder{}
+	apiTracker := tracker.NewAPITracker(context.Background(), wslProxy, testSrv.URL, hostSwitchIP, true)
+
+	protoPort, err := nat.NewPort(protocolTCP, hostPort)
+	require.NoError(t, err)
+
+	portMapping := nat.PortMap{
+		protoPort: []nat.PortBinding{
+			{
+				HostIP:   "::1",
+				HostPort: hostPort,
+			},
+		},
+	}
+	err = apiTracker.Add(containerID, portMapping)
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, expectedExposeReq,
+		[]*types.ExposeRequest{
+			{
+				Local:    net.JoinHostPort("::1", hostPort),
+				Remote:   ipPortBuilder(hostSwitchIP, hostPort),
+				Protocol: types.TransportProtocol(protocolTCP),
+			},
+		})
+	assert.Equal(t, portMapping, apiTracker.Get(containerID))
+	require.Len(t, wslProxy.receivedPortMappings, 1)
+	assert.Equal(t, portMapping, wslProxy.receivedPortMappings[0].Ports)
+}
+
+func TestAddDualStack(t *testing.T) {
+	t.Parallel()
+
+	var expectedExposeReq []*types.ExposeRequest
+	var expectedUnexposeReq []*types.UnexposeRequest
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/services/forwarder/expose", func(_ http.ResponseWriter, r *http.Request) {
+		var tmpReq *types.ExposeRequest
+		err := json.NewDecoder(r.Body).Decode(&tmpReq)
+		require.NoError(t, err)
+		expectedExposeReq = append(expectedExposeReq, tmpReq)
+	})
+
+	mux.HandleFunc("/services/forwarder/unexpose", func(_ http.ResponseWriter, r *http.Request) {
+		var tmpReq *types.UnexposeRequest
+		err := json.NewDecoder(r.Body).Decode(&tmpReq)
+		require.NoError(t, err)
+		expectedUnexposeReq = append(expectedUnexposeReq, tmpReq)
+	})
+
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	apiTracker := tracker.NewAPITracker(context.Background(), &testForwarder{}, testSrv.URL, hostSwitchIP, true)
+
+	protoPort, err := nat.NewPort(protocolTCP, hostPort)
+	require.NoError(t, err)
+
+	portMapping := nat.PortMap{
+		protoPort: []nat.PortBinding{
+			{
+				HostIP:   "0.0.0.0",
+				HostPort: hostPort,
+			},
+			{
+				HostIP:   "::",
+				HostPort: hostPort,
+			},
+		},
+	}
+	err = apiTracker.Add(containerID, portMapping)
+	require.NoError(t, err)
+
+	// The IPv4 wildcard is served by the dual-stack [::] listener.
+	assert.ElementsMatch(t, expectedExposeReq,
+		[]*types.ExposeRequest{
+			{
+				Local:    net.JoinHostPort("::", hostPort),
+				Remote:   ipPortBuilder(hostSwitchIP, hostPort),
+				Protocol: types.TransportProtocol(protocolTCP),
+			},
+		})
+	assert.Equal(t, portMapping, apiTracker.Get(containerID))
+
+	err = apiTracker.Remove(containerID)
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, expectedUnexposeReq,
+		[]*types.UnexposeRequest{
+			{
+				Local:    net.JoinHostPort("::", hostPort),
+				Protocol: types.TransportProtocol(protocolTCP),
+			},
+		})
+}
+
+func TestDualStackExposeFailure(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/services/forwarder/expose", func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "address already in use", http.StatusInternalServerError)
+	})
+
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	apiTracker := tracker.NewAPITracker(context.Background(), &testForwarder{}, testSrv.URL, hostSwitchIP, true)
+
+	protoPort, err := nat.NewPort(protocolTCP, hostPort)
+	require.NoError(t, err)
+
+	portMapping := nat.PortMap{
+		protoPort: []nat.PortBinding{
+			{
+				HostIP:   "0.0.0.0",
+				HostPort: hostPort,
+			},
+			{
+				HostIP:   "::",
+				HostPort: hostPort,
+			},
+		},
+	}
+	err = apiTracker.Add(containerID, portMapping)
+	require.ErrorIs(t, err, forwarder.ErrExposeAPI)
+
+	// Neither binding is forwarded when the shared listener fails.
+	assert.Nil(t, apiTracker.Get(containerID))
+}
+
+func TestNonAdminInstallIPv6(t *testing.T) {
+	t.Parallel()
+
+	var expectedExposeReq []*types.ExposeRequest
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/services/forwarder/expose", func(_ http.ResponseWriter, r *http.Request) {
+		var tmpReq *types.ExposeRequest
+		err := json.NewDecoder(r.Body).Decode(&tmpReq)
+		require.NoError(t, err)
+		expectedExposeReq = append(expectedExposeReq, tmpReq)
+	})
+
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	apiTracker := tracker.NewAPITracker(context.Background(), &testForwarder{}, testSrv.URL, hostSwitchIP, false)
+
+	protoPort, err := nat.NewPort(protocolTCP, hostPort)
+	require.NoError(t, err)
+
+	portMapping := nat.PortMap{
+		protoPort: []nat.PortBinding{
+			{
+				HostIP:   "0.0.0.0",
+				HostPort: hostPort,
+			},
+			{
+				HostIP:   "::",
+				HostPort: hostPort,
+			},
+		},
+	}
+	err = apiTracker.Add(containerID, portMapping)
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, expectedExposeReq,
+		[]*types.ExposeRequest{
+			{
+				Local:    ipPortBuilder("127.0.0.1", hostPort),
+				Remote:   ipPortBuilder(hostSwitchIP, hostPort),
+				Protocol: types.TransportProtocol(protocolTCP),
+			},
+			{
+				Local:    net.JoinHostPort("::1", hostPort),
+				Remote:   ipPortBuilder(hostSwitchIP, hostPort),
+				Protocol: types.TransportProtocol(protocolTCP),
+			},
+		})
+}
+
+func TestStatus(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/services/forwarder/expose", func(w http.ResponseWriter, r *http.Request) {
+		var tmpReq *types.ExposeRequest
+		err := json.NewDecoder(r.Body).Decode(&tmpReq)
+		require.NoError(t, err)
+		if tmpReq.Local == ipPortBuilder(hostIP2, hostPort) {
+			http.Error(w, "proxy already running", http.StatusInternalServerError)
+		}
+	})
+	mux.HandleFunc("/services/forwarder/unexpose", func(_ http.ResponseWriter, _ *http.Request) {})
+
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	apiTracker := tracker.NewAPITracker(context.Background(), &testForwarder{}, testSrv.URL, hostSwitchIP, true)
+
+	protoPort, err := nat.NewPort(protocolTCP, hostPort)
+	require.NoError(t, err)
+
+	okBinding := nat.PortBinding{HostIP: hostIP, HostPort: hostPort}
+	failedBinding := nat.PortBinding{HostIP: hostIP2, HostPort: hostPort}
+
+	dockerTracker := apiTracker.ForSource(tracker.SourceDocker)
+	kubeTracker := apiTracker.ForSource(tracker.SourceKube)
+
+	err = dockerTracker.Add(containerID, nat.PortMap{
+		protoPort: []nat.PortBinding{okBinding, failedBinding},
+	})
+	require.ErrorIs(t, err, forwarder.ErrExposeAPI)
+
+	// A mapping where every binding fails is still reported.
+	err = kubeTracker.Add(containerID2, nat.PortMap{
+		protoPort: []nat.PortBinding{failedBinding},
+	})
+	require.ErrorIs(t, err, forwarder.ErrExposeAPI)
+	assert.Nil(t, kubeTracker.Get(containerID2))
+
+	entries := apiTracker.Status()
+	require.Len(t, entries, 2)
+
+	assert.Equal(t, "docker/"+containerID, entries[0].ID)
+	assert.Equal(t, tracker.SourceDocker, entries[0].Source)
+	assert.Equal(t, nat.PortMap{protoPort: []nat.PortBinding{okBinding}}, entries[0].Ports)
+	require.Len(t, entries[0].Errors, 1)
+	assert.Equal(t, protoPort, entries[0].Errors[0].Port)
+	assert.Equal(t, failedBinding, entries[0].Errors[0].Binding)
+	assert.Contains(t, entries[0].Errors[0].Error, "proxy already running")
+
+	assert.Equal(t, "kube/"+containerID2, entries[1].ID)
+	assert.Equal(t, tracker.SourceKube, entries[1].Source)
+	assert.Empty(t, entries[1].Ports)
+	require.Len(t, entries[1].Errors, 1)
+
+	require.NoError(t, dockerTracker.Remove(containerID))
+	require.NoError(t, kubeTracker.Remove(containerID2))
+	assert.Empty(t, apiTracker.Status())
+}
+
+func TestPolicy(t *testing.T) {
+	t.Parallel()
+
+	var exposed []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services/forwarder/expose", func(_ http.ResponseWriter, r *http.Request) {
+		var tmpReq *types.ExposeRequest
+		err := json.NewDecoder(r.Body).Decode(&tmpReq)
+		require.NoError(t, err)
+		exposed = append(exposed, tmpReq.Local)
+	})
+
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	forwardingPolicy, err := policy.New(policy.Config{
+		DenyPorts:                 []string{"1-1023"},
+		AllowKubernetesNamespaces: []string{"default"},
+	})
+	require.NoError(t, err)
+
+	wslProxy := &testForwarder{}
+	apiTracker := tracker.NewAPITracker(context.Background(), wslProxy, testSrv.URL, hostSwitchIP, true)
+	apiTracker.SetPolicy(forwardingPolicy)
+
+	lowPort, err := nat.NewPort(protocolTCP, hostPort)
+	require.NoError(t, err)
+	highPort, err := nat.NewPort(protocolTCP, additionalPort)
+	require.NoError(t, err)
+	lowBinding := nat.PortBinding{HostIP: hostIP, HostPort: hostPort}
+	highBinding := nat.PortBinding{HostIP: hostIP, HostPort: additionalPort}
+
+	dockerTracker := apiTracker.ForSource(tracker.SourceDocker)
+	kubeTracker := apiTracker.ForSource(tracker.SourceKube)
+
+	// The denied port range rejects a binding, the rest is forwarded.
+	err = dockerTracker.Add(containerID, nat.PortMap{
+		lowPort:  []nat.PortBinding{lowBinding},
+		highPort: []nat.PortBinding{highBinding},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{ipPortBuilder(hostIP, additionalPort)}, exposed)
+	assert.Equal(t, nat.PortMap{highPort: []nat.PortBinding{highBinding}}, dockerTracker.Get(containerID))
+	require.Len(t, wslProxy.receivedPortMappings, 1)
+	assert.Equal(t, nat.PortMap{highPort: []nat.PortBinding{highBinding}}, wslProxy.receivedPortMappings[0].Ports)
+
+	// A service outside the allowed namespaces is rejected as a whole.
+	err = kubeTracker.AddWorkload(containerID2,
+		policy.Workload{Namespace: "kube-system"},
+		nat.PortMap{highPort: []nat.PortBinding{highBinding}})
+	require.NoError(t, err)
+	assert.Len(t, exposed, 1)
+	assert.Nil(t, kubeTracker.Get(containerID2))
+
+	entries := apiTracker.Status()
+	require.Len(t, entries, 2)
+	assert.Equal(t, []policy.Rejection{
+		{Port: lowPort, Binding: lowBinding, Reason: "host port 80 is in denied range 1-1023"},
+	}, entries[0].Rejected)
+	assert.Empty(t, entries[0].Errors)
+	assert.Equal(t, []policy.Rejection{
+		{Port: highPort, Binding: highBinding, Reason: "namespace kube-system is not allowed"},
+	}, entries[1].Rejected)
+
+	require.NoError(t, kubeTracker.Remove(containerID2))
+	assert.Len(t, apiTracker.Status(), 1)
+}
+
+func TestSourcesShareTracker(t *testing.T) {
+	t.Parallel()
+
+	var unexposed []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services/forwarder/expose", func(_ http.ResponseWriter, _ *http.Request) {})
+	mux.HandleFunc("/services/forwarder/unexpose", func(_ http.ResponseWriter, r *http.Request) {
+		var tmpReq *types.UnexposeRequest
+		err := json.NewDecoder(r.Body).Decode(&tmpReq)
+		require.NoError(t, err)
+		unexposed = append(unexposed, tmpReq.Local)
+	})
+
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	apiTracker := tracker.NewAPITracker(context.Background(), &testForwarder{}, testSrv.URL, hostSwitchIP, true)
+	dockerTracker := apiTracker.ForSource(tracker.SourceDocker)
+	containerdTracker := apiTracker.ForSource(tracker.SourceContainerd)
+
+	dockerPort, err := nat.NewPort(protocolTCP, hostPort)
+	require.NoError(t, err)
+	containerdPort, err := nat.NewPort(protocolTCP, additionalPort)
+	require.NoError(t, err)
+	dockerPortMap := nat.PortMap{dockerPort: []nat.PortBinding{{HostIP: hostIP, HostPort: hostPort}}}
+	containerdPortMap := nat.PortMap{containerdPort: []nat.PortBinding{{HostIP: hostIP, HostPort: additionalPort}}}
+
+	// The same ID reported by both monitors does not collide.
+	require.NoError(t, dockerTracker.Add(containerID, dockerPortMap))
+	require.NoError(t, containerdTracker.Add(containerID, containerdPortMap))
+	assert.Equal(t, dockerPortMap, dockerTracker.Get(containerID))
+	assert.Equal(t, containerdPortMap, containerdTracker.Get(containerID))
+	assert.Equal(t, dockerPortMap, apiTracker.Get("docker/"+containerID))
+	assert.Nil(t, apiTracker.Get(containerID))
+
+	// A monitor shutting down only removes its own port mappings.
+	require.NoError(t, containerdTracker.RemoveAll())
+	assert.Equal(t, []string{ipPortBuilder(hostIP, additionalPort)}, unexposed)
+	assert.Nil(t, containerdTracker.Get(containerID))
+	assert.Equal(t, dockerPortMap, dockerTracker.Get(containerID))
+
+	require.NoError(t, apiTracker.RemoveAll())
+	assert.Empty(t, apiTracker.Status())
+}
+
+func TestSCTPForwardedToWSLProxyOnly(t *testing.T) {
+	t.Parallel()
+
+	var exposed, unexposed []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services/forwarder/expose", func(_ http.ResponseWriter, r *http.Request) {
+		var req *types.ExposeRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		exposed = append(exposed, string(req.Protocol)+"/"+req.Local)
+	})
+	mux.HandleFunc("/services/forwarder/unexpose", func(_ http.ResponseWriter, r *http.Request) {
+		var req *types.UnexposeRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		unexposed = append(unexposed, string(req.Protocol)+"/"+req.Local)
+	})
+
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	wslProxyForwarder := &testForwarder{}
+	apiTracker := tracker.NewAPITracker(context.Background(), wslProxyForwarder, testSrv.URL, hostSwitchIP, true)
+
+	tcpPort, err := nat.NewPort(protocolTCP, hostPort)
+	require.NoError(t, err)
+	sctpPort, err := nat.NewPort("sctp", "36412")
+	require.NoError(t, err)
+	portMapping := nat.PortMap{
+		tcpPort:  []nat.PortBinding{{HostIP: hostIP, HostPort: hostPort}},
+		sctpPort: []nat.PortBinding{{HostIP: hostIP, HostPort: "36412"}},
+	}
+
+	require.NoError(t, apiTracker.Add(containerID, portMapping))
+	// The host-switch has no SCTP; wsl-proxy forwards it on its own.
+	assert.Equal(t, []string{"tcp/" + ipPortBuilder(hostIP, hostPort)}, exposed)
+	require.Len(t, wslProxyForwarder.receivedPortMappings, 1)
+	assert.Equal(t, portMapping, wslProxyForwarder.receivedPortMappings[0].Ports)
+	assert.Equal(t, portMapping, apiTracker.Get(containerID))
+
+	require.NoError(t, apiTracker.Remove(containerID))
+	assert.Equal(t, []string{"tcp/" + ipPortBuilder(hostIP, hostPort)}, unexposed)
+	require.Len(t, wslProxyForwarder.receivedPortMappings, 2)
+	assert.True(t, wslProxyForwarder.receivedPortMappings[1].Remove)
+	assert.Equal(t, portMapping, wslProxyForwarder.receivedPortMappings[1].Ports)
+}
+
+func TestAuditLog(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services/forwarder/expose", func(w http.ResponseWriter, r *http.Request) {
+		var tmpReq *types.ExposeRequest
+		err := json.NewDecoder(r.Body).Decode(&tmpReq)
+		require.NoError(t, err)
+		if tmpReq.Local == ipPortBuilder(hostIP, hostPort2) {
+			http.Error(w, "proxy already running", http.StatusInternalServerError)
+		}
+	})
+	mux.HandleFunc("/services/forwarder/unexpose", func(_ http.ResponseWriter, _ *http.Request) {})
+
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	forwardingPolicy, err := policy.New(policy.Config{DenyPorts: []string{hostPort}})
+	require.NoError(t, err)
+
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.Open(auditPath, 1<<20, 1)
+	require.NoError(t, err)
+	defer auditLog.Close()
+
+	// Non-admin installs expose the wildcard bindings on localhost.
+	apiTracker := tracker.NewAPITracker(context.Background(), &testForwarder{}, testSrv.URL, hostSwitchIP, false)
+	apiTracker.SetPolicy(forwardingPolicy)
+	apiTracker.SetAuditLog(auditLog)
+	dockerTracker := apiTracker.ForSource(tracker.SourceDocker)
+
+	rejectedPort, err := nat.NewPort(protocolTCP, hostPort)
+	require.NoError(t, err)
+	failedPort, err := nat.NewPort(protocolTCP, hostPort2)
+	require.NoError(t, err)
+	exposedPort, err := nat.NewPort(protocolTCP, additionalPort)
+	require.NoError(t, err)
+
+	err = dockerTracker.Add(containerID, nat.PortMap{
+		rejectedPort: []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: hostPort}},
+		failedPort:   []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: hostPort2}},
+		exposedPort:  []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: additionalPort}},
+	})
+	require.ErrorIs(t, err, tracker.ErrRetryScheduled)
+	require.NoError(t, dockerTracker.Remove(containerID))
+
+	data, err := os.ReadFile(auditPath)
+	require.NoError(t, err)
+	byResult := make(map[audit.Action]map[audit.Result]audit.Record)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var record audit.Record
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		assert.Equal(t, "tracker", record.Component)
+		assert.Equal(t, "docker", record.Source)
+		assert.Equal(t, "docker/"+containerID, record.ID)
+		if byResult[record.Action] == nil {
+			byResult[record.Action] = make(map[audit.Result]audit.Record)
+		}
+		byResult[record.Action][record.Result] = record
+	}
+
+	rejected := byResult[audit.ActionExpose][audit.ResultRejected]
+	assert.Equal(t, string(rejectedPort), rejected.Port)
+	assert.Contains(t, rejected.Error, "denied range")
+
+	failed := byResult[audit.ActionExpose][audit.ResultFailed]
+	assert.Equal(t, string(failedPort), failed.Port)
+	assert.Equal(t, "0.0.0.0", failed.HostIP)
+	assert.Equal(t, hostIP, failed.ResolvedHostIP)
+	assert.Contains(t, failed.Error, "proxy already running")
+
+	exposed := byResult[audit.ActionExpose][audit.ResultOK]
+	assert.Equal(t, "add", exposed.Event)
+	assert.Equal(t, string(exposedPort), exposed.Port)
+	assert.Equal(t, additionalPort, exposed.HostPort)
+	assert.Equal(t, hostIP, exposed.ResolvedHostIP)
+	assert.Empty(t, exposed.ExposedHostPort)
+
+	unexposed := byResult[audit.ActionUnexpose][audit.ResultOK]
+	assert.Equal(t, "remove", unexposed.Event)
+	assert.Equal(t, string(exposedPort), unexposed.Port)
+}
+
+func TestRemapTakenHostPort(t *testing.T) {
+	t.Parallel()
+
+	var exposed, unexposed []*types.ExposeRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services/forwarder/expose", func(w http.ResponseWriter, r *http.Request) {
+		var tmpReq *types.ExposeRequest
+		err := json.NewDecoder(r.Body).Decode(&tmpReq)
+		require.NoError(t, err)
+		if tmpReq.Local == ipPortBuilder(hostIP, hostPort) {
+			http.Error(w, "listen tcp 127.0.0.1:80: bind: address already in use", http.StatusInternalServerError)
+			return
+		}
+		for _, req := range exposed {
+			if req.Local == tmpReq.Local {
+				http.Error(w, "proxy already running", http.StatusInternalServerError)
+				return
+			}
+		}
+		exposed = append(exposed, tmpReq)
+	})
+	mux.HandleFunc("/services/forwarder/unexpose", func(_ http.ResponseWriter, r *http.Request) {
+		var tmpReq *types.ExposeRequest
+		err := json.NewDecoder(r.Body).Decode(&tmpReq)
+		require.NoError(t, err)
+		unexposed = append(unexposed, tmpReq)
+	})
+
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	wslProxy := &testForwarder{}
+	apiTracker := tracker.NewAPITracker(context.Background(), wslProxy, testSrv.URL, hostSwitchIP, true)
+	apiTracker.SetRemapRange(&tracker.RemapRange{})
+
+	portProto, err := nat.NewPort(protocolTCP, hostPort)
+	require.NoError(t, err)
+	portBinding := nat.PortBinding{HostIP: hostIP, HostPort: hostPort}
+	portMap := nat.PortMap{portProto: []nat.PortBinding{portBinding}}
+
+	err = apiTracker.Add(containerID, portMap)
+	require.NoError(t, err)
+
+	// The binding is exposed on the next port, and still reaches the
+	// requested one in the VM.
+	require.Len(t, exposed, 1)
+	assert.Equal(t, ipPortBuilder(hostIP, "81"), exposed[0].Local)
+	assert.Equal(t, ipPortBuilder(hostSwitchIP, hostPort), exposed[0].Remote)
+	assert.Equal(t, portMap, apiTracker.Get(containerID))
+	require.Len(t, wslProxy.receivedPortMappings, 1)
+	assert.Equal(t, portMap, wslProxy.receivedPortMappings[0].Ports)
+
+	entries := apiTracker.Status()
+	require.Len(t, entries, 1)
+	assert.Empty(t, entries[0].Errors)
+	assert.Equal(t, []tracker.Remap{
+		{Port: portProto, Binding: portBinding, HostPort: "81"},
+	}, entries[0].Remapped)
+
+	// A second container asking for the same host port gets the one after,
+	// as the next port is tracked for the first container.
+	err = apiTracker.Add(containerID2, portMap)
+	require.NoError(t, err)
+	require.Len(t, exposed, 2)
+	assert.Equal(t, ipPortBuilder(hostIP, "82"), exposed[1].Local)
+
+	require.NoError(t, apiTracker.Remove(containerID))
+	require.Len(t, unexposed, 1)
+	assert.Equal(t, ipPortBuilder(hostIP, "81"), unexposed[0].Local)
+}
+
+func TestRemapDisabled(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services/forwarder/expose", func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "address already in use", http.StatusInternalServerError)
+	})
+
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	apiTracker := tracker.NewAPITracker(context.Background(), &testForwarder{}, testSrv.URL, hostSwitchIP, true)
+
+	portProto, err := nat.NewPort(protocolTCP, hostPort)
+	require.NoError(t, err)
+	err = apiTracker.Add(containerID, nat.PortMap{
+		portProto: []nat.PortBinding{{HostIP: hostIP, HostPort: hostPort}},
+	})
+	require.ErrorIs(t, err, forwarder.ErrExposeAPI)
+
+	entries := apiTracker.Status()
+	require.Len(t, entries, 1)
+	assert.Empty(t, entries[0].Remapped)
+	assert.Len(t, entries[0].Errors, 1)
+}
+
+func TestParseRemapRange(t *testing.T) {
+	t.Parallel()
+
+	remapRange, err := tracker.ParseRemapRange("")
+	require.NoError(t, err)
+	assert.Nil(t, remapRange)
+
+	remapRange, err = tracker.ParseRemapRange("next")
+	require.NoError(t, err)
+	assert.Equal(t, &tracker.RemapRange{}, remapRange)
+
+	remapRange, err = tracker.ParseRemapRange("20000-20100")
+	require.NoError(t, err)
+	assert.Equal(t, &tracker.RemapRange{First: 20000, Last: 20100}, remapRange)
+
+	for _, spec := range []string{"20000", "a-b", "0-10", "20100-20000", "1-65536"} {
+		_, err = tracker.ParseRemapRange(spec)
+		require.ErrorIs(t, err, tracker.ErrInvalidRemapRange, spec)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	t.Parallel()
+
+	staticLocal := ipPortBuilder(hostIP, "6443")
+	orphanLocal := ipPortBuilder(hostIP, additionalPort)
+	// left behind by a previous run of the guest agent
+	staleLocal := ipPortBuilder(hostIP, hostPort)
+
+	hostState := map[string]types.ExposeRequest{
+		staticLocal: {Local: staticLocal, Remote: ipPortBuilder(hostSwitchIP, "6443"), Protocol: types.TCP},
+		orphanLocal: {Local: orphanLocal, Remote: ipPortBuilder(hostSwitchIP, additionalPort), Protocol: types.TCP},
+		staleLocal:  {Local: staleLocal, Remote: ipPortBuilder(hostSwitchIP, hostPort), Protocol: types.TCP},
+	}
+	var exposeReqs []*types.ExposeRequest
+	var unexposeReqs []*types.UnexposeRequest
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/services/forwarder/all", func(w http.ResponseWriter, _ *http.Request) {
+		all := make([]types.ExposeRequest, 0, len(hostState))
+		for _, req := range hostState {
+			all = append(all, req)
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(all))
+	})
+	mux.HandleFunc("/services/forwarder/expose", func(w http.ResponseWriter, r *http.Request) {
+		var tmpReq *types.ExposeRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&tmpReq))
+		if _, ok := hostState[tmpReq.Local]; ok {
+			http.Error(w, "proxy already running", http.StatusInternalServerError)
+			return
+		}
+		exposeReqs = append(exposeReqs, tmpReq)
+		hostState[tmpReq.Local] = *tmpReq
+	})
+	mux.HandleFunc("/services/forwarder/unexpose", func(_ http.ResponseWriter, r *http.Request) {
+		var tmpReq *types.UnexposeRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&tmpReq))
+		unexposeReqs = append(unexposeReqs, tmpReq)
+		delete(hostState, tmpReq.Local)
+	})
+
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	wslProxy := &testForwarder{}
+	apiTracker := tracker.NewAPITracker(context.Background(), wslProxy, testSrv.URL, hostSwitchIP, true)
+	apiTracker.Reserve(types.TCP, staticLocal)
+
+	protoPort, err := nat.NewPort(protocolTCP, hostPort)
+	require.NoError(t, err)
+	protoPort2, err := nat.NewPort(protocolTCP, hostPort2)
+	require.NoError(t, err)
+
+	staleBinding := nat.PortBinding{HostIP: hostIP, HostPort: hostPort}
+	missingBinding := nat.PortBinding{HostIP: hostIP, HostPort: hostPort2}
+
+	err = apiTracker.Add(containerID, nat.PortMap{
+		protoPort:  []nat.PortBinding{staleBinding},
+		protoPort2: []nat.PortBinding{missingBinding},
+	})
+	require.ErrorIs(t, err, forwarder.ErrExposeAPI)
+	assert.Equal(t, nat.PortMap{protoPort2: []nat.PortBinding{missingBinding}}, apiTracker.Get(containerID))
+
+	// The host-switch lost a port that the tracker forwarded.
+	delete(hostState, ipPortBuilder(hostIP, hostPort2))
+	exposeReqs = nil
+	wslProxy.receivedPortMappings = nil
+
+	require.NoError(t, apiTracker.Reconcile())
+
+	assert.ElementsMatch(t, exposeReqs, []*types.ExposeRequest{
+		{
+			Local:    ipPortBuilder(hostIP, hostPort2),
+			Remote:   ipPortBuilder(hostSwitchIP, hostPort2),
+			Protocol: types.TCP,
+		},
+	})
+	assert.ElementsMatch(t, unexposeReqs, []*types.UnexposeRequest{
+		{
+			Local:    orphanLocal,
+			Protocol: types.TCP,
+		},
+	})
+	assert.Contains(t, hostState, staticLocal)
+
+	assert.Equal(t, nat.PortMap{
+		protoPort:  []nat.PortBinding{staleBinding},
+		protoPort2: []nat.PortBinding{missingBinding},
+	}, apiTracker.Get(containerID))
+	entries := apiTracker.Status()
+	require.Len(t, entries, 1)
+	assert.Empty(t, entries[0].Errors)
+
+	require.Len(t, wslProxy.receivedPortMappings, 1)
+	assert.Equal(t, nat.PortMap{protoPort: []nat.PortBinding{staleBinding}}, wslProxy.receivedPortMappings[0].Ports)
+}
+
+func TestReconcileAllAPIError(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/services/forwarder/all", func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "Bad API error", http.StatusInternalServerError)
+	})
+
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	apiTracker := tracker.NewAPITracker(context.Background(), &testForwarder{}, testSrv.URL, hostSwitchIP, true)
+
+	err := apiTracker.Reconcile()
+	require.ErrorIs(t, err, tracker.ErrReconcile)
+	require.ErrorIs(t, err, forwarder.ErrAPI)
+}
+
+func TestRetryFailedExpose(t *testing.T) {
+	t.Parallel()
+
+	var mutex sync.Mutex
+	exposeAttempts := 0
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/services/forwarder/expose", func(w http.ResponseWriter, _ *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		exposeAttempts++
+		if exposeAttempts <= 2 {
+			http.Error(w, "host-switch is not ready", http.StatusServiceUnavailable)
+		}
+	})
+
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wslProxy := &lockedForwarder{}
+	apiTracker := tracker.NewAPITracker(ctx, wslProxy, testSrv.URL, hostSwitchIP, true)
+	go apiTracker.ProcessRetries()
+
+	protoPort, err := nat.NewPort(protocolTCP, hostPort)
+	require.NoError(t, err)
+
+	portMapping := nat.PortMap{
+		protoPort: []nat.PortBinding{
+			{
+				HostIP:   hostIP,
+				HostPort: hostPort,
+			},
+		},
+	}
+	err = apiTracker.Add(containerID, portMapping)
+	require.ErrorIs(t, err, forwarder.ErrExposeAPI)
+	require.ErrorIs(t, err, tracker.ErrRetryScheduled)
+	assert.Nil(t, apiTracker.Get(containerID))
+
+	require.Eventually(t, func() bool {
+		return apiTracker.Get(containerID) != nil
+	}, 10*time.Second, 50*time.Millisecond)
+
+	assert.Equal(t, portMapping, apiTracker.Get(containerID))
+	entries := apiTracker.Status()
+	require.Len(t, entries, 1)
+	assert.Empty(t, entries[0].Errors)
+
+	mutex.Lock()
+	assert.Equal(t, 3, exposeAttempts)
+	mutex.Unlock()
+
+	received := wslProxy.received()
+	require.Len(t, received, 1)
+	assert.Equal(t, portMapping, received[0].Ports)
+}
+
+func TestRetryResendsToWSLProxy(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services/forwarder/expose", func(_ http.ResponseWriter, _ *http.Request) {})
+
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wslProxy := &lockedForwarder{failures: 1}
+	apiTracker := tracker.NewAPITracker(ctx, wslProxy, testSrv.URL, hostSwitchIP, true)
+	go apiTracker.ProcessRetries()
+
+	protoPort, err := nat.NewPort(protocolTCP, hostPort)
+	require.NoError(t, err)
+
+	portMapping := nat.PortMap{
+		protoPort: []nat.PortBinding{
+			{
+				HostIP:   hostIP,
+				HostPort: hostPort,
+			},
+		},
+	}
+	err = apiTracker.Add(containerID, portMapping)
+	require.ErrorIs(t, err, tracker.ErrRetryScheduled)
+
+	require.Eventually(t, func() bool {
+		return len(wslProxy.received()) == 1
+	}, 10*time.Second, 50*time.Millisecond)
+	assert.Equal(t, portMapping, wslProxy.received()[0].Ports)
+}
+
+func TestRetryStopsOnRemove(t *testing.T) {
+	t.Parallel()
+
+	var mutex sync.Mutex
+	exposeAttempts := 0
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/services/forwarder/expose", func(w http.ResponseWriter, _ *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		exposeAttempts++
+		http.Error(w, "host-switch is not ready", http.StatusServiceUnavailable)
+	})
+
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	apiTracker := tracker.NewAPITracker(ctx, &lockedForwarder{}, testSrv.URL, hostSwitchIP, true)
+	go apiTracker.ProcessRetries()
+
+	protoPort, err := nat.NewPort(protocolTCP, hostPort)
+	require.NoError(t, err)
+
+	err = apiTracker.Add(containerID, nat.PortMap{
+		protoPort: []nat.PortBinding{
+			{
+				HostIP:   hostIP,
+				HostPort: hostPort,
+			},
+		},
+	})
+	require.ErrorIs(t, err, tracker.ErrRetryScheduled)
+
+	require.Eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return exposeAttempts >= 2
+	}, 10*time.Second, 50*time.Millisecond)
+
+	require.NoError(t, apiTracker.Remove(containerID))
+
+	mutex.Lock()
+	attempts := exposeAttempts
+	mutex.Unlock()
+
+	time.Sleep(2 * time.Second)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, attempts, exposeAttempts)
+}
+
+func TestProxyProtocolLabel(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services/forwarder/expose", func(http.ResponseWriter, *http.Request) {})
+	mux.HandleFunc("/services/forwarder/unexpose", func(http.ResponseWriter, *http.Request) {})
+	testSrv := httptest.NewServer(mux)
+	defer testSrv.Close()
+
+	wslProxyForwarder := &testForwarder{}
+	apiTracker := tracker.NewAPITracker(context.Background(), wslProxyForwarder, testSrv.URL, hostSwitchIP, true)
+
+	portMapping := nat.PortMap{
+		"80/tcp":  []nat.PortBinding{{HostIP: hostIP, HostPort: "8080"}},
+		"443/tcp": []nat.PortBinding{{HostIP: hostIP, HostPort: "8443"}},
+		"53/udp":  []nat.PortBinding{{HostIP: hostIP, HostPort: "5353"}},
+	}
+	tests := map[string]struct {
+		value string
+		want  map[string]guestagentType.ProxyProtocolVersion
+	}{
+		"all ports": {
+			value: "v2",
+			want:  map[string]guestagentType.ProxyProtocolVersion{"8080": "v2", "8443": "v2"},
+		},
+		"per port": {
+			value: "443=v1, 80=v2",
+			want:  map[string]guestagentType.ProxyProtocolVersion{"8080": "v2", "8443": "v1"},
+		},
+		"port overrides all": {
+			value: "v1,443=v2",
+			want:  map[string]guestagentType.ProxyProtocolVersion{"8080": "v1", "8443": "v2"},
+		},
+		"invalid version": {
+			value: "80=v3,443=v1",
+			want:  map[string]guestagentType.ProxyProtocolVersion{"8443": "v1"},
+		},
+	}
+	for name, tt := range tests {
+		workload := policy.Workload{Labels: map[string]string{tracker.ProxyProtocolKey: tt.value}}
+		require.NoError(t, apiTracker.AddWorkload(name, workload, portMapping), name)
+		sent := wslProxyForwarder.receivedPortMappings[len(wslProxyForwarder.receivedPortMappings)-1]
+		assert.Equal(t, tt.want, sent.ProxyProtocol, name)
+		require.NoError(t, apiTracker.Remove(name))
+	}
+
+	// The versions are kept for the wsl-proxy resync.
+	workload := policy.Workload{Annotations: map[string]string{tracker.ProxyProtocolKey: "v1"}}
+	require.NoError(t, apiTracker.AddWorkload(containerID, workload, portMapping))
+	state := apiTracker.WSLProxyState()
+	assert.Equal(t, portMapping, state.Ports)
+	assert.Equal(t, map[string]guestagentType.ProxyProtocolVersion{"8080": "v1", "8443": "v1"}, state.ProxyProtocol)
+}
+
 func ipPortBuilder(ip, port string) string {
 	return ip + ":" + port
 }
 
+// lockedForwarder is a testForwarder safe for use from the retry
+// goroutine; the first `failures` calls to Send fail.
+type lockedForwarder struct {
+	mutex                sync.Mutex
+	failures             int
+	receivedPortMappings []guestagentType.PortMapping
+}
+
+func (v *lockedForwarder) Send(portMapping guestagentType.PortMapping) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.failures > 0 {
+		v.failures--
+		return errors.New("wsl-proxy socket is missing")
+	}
+
+	v.receivedPortMappings = append(v.receivedPortMappings, portMapping)
+
+	return nil
+}
+
+func (v *lockedForwarder) received() []guestagentType.PortMapping {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return slices.Clone(v.receivedPortMappings)
+}
+
 type testForwarder struct {
 	receivedPortMappings []guestagentType.PortMapping
 	sendErr              error
@@ -15,71 +15,181 @@ package tracker
 
 import (
 	"maps"
+	"slices"
+	"strings"
 	"sync"
 
 	"github.com/Masterminds/log-go"
 	"github.com/docker/go-connections/nat"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/policy"
+	guestagentTypes "github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
 )
 
+// portEntry is what portStorage keeps for a single tracked ID.
+type portEntry struct {
+	source Source
+	// portMap holds the successfully forwarded port bindings.
+	portMap nat.PortMap
+	// errors holds the bindings that failed during the last Add.
+	errors []BindingError
+	// rejected holds the bindings the policy rejected during the last Add.
+	rejected []policy.Rejection
+	// remaps holds the bindings of portMap exposed on another host port.
+	remaps []Remap
+	// proxyProtocol holds the PROXY protocol versions by TCP host port.
+	proxyProtocol map[string]guestagentTypes.ProxyProtocolVersion
+}
+
 // portStorage is responsible for storing all the port mappings.
 type portStorage struct {
 	// container ID is the key for both docker and containerd
-	portmap map[string]nat.PortMap
+	entries map[string]*portEntry
 	mutex   sync.Mutex
 }
 
 func newPortStorage() *portStorage {
 	return &portStorage{
-		portmap: make(map[string]nat.PortMap),
+		entries: make(map[string]*portEntry),
 	}
 }
 
-func (p *portStorage) add(containerID string, portMap nat.PortMap) {
+// add records the forwarded port bindings, along with their remapped host
+// ports, the failed ones, the rejected ones and the PROXY protocol
+// versions for containerID. When
+// nothing was forwarded, a previously stored portMap is kept so that a
+// later remove can still unexpose it.
+func (p *portStorage) add(
+	containerID string,
+	source Source,
+	portMap nat.PortMap,
+	remaps []Remap,
+	errs []BindingError,
+	rejected []policy.Rejection,
+	proxyProtocol map[string]guestagentTypes.ProxyProtocolVersion,
+) {
 	p.mutex.Lock()
-	p.portmap[containerID] = portMap
-	p.mutex.Unlock()
-	log.Debugf("portStorage add status: %+v", p.portmap)
+	defer p.mutex.Unlock()
+
+	entry, ok := p.entries[containerID]
+	switch {
+	case len(portMap) != 0:
+		p.entries[containerID] = &portEntry{
+			source:        source,
+			portMap:       portMap,
+			remaps:        remaps,
+			errors:        errs,
+			rejected:      rejected,
+			proxyProtocol: proxyProtocol,
+		}
+	case ok:
+		entry.source = source
+		entry.errors = errs
+		entry.rejected = rejected
+		entry.proxyProtocol = proxyProtocol
+	case len(errs) != 0 || len(rejected) != 0:
+		p.entries[containerID] = &portEntry{source: source, errors: errs, rejected: rejected, proxyProtocol: proxyProtocol}
+	}
+	log.Debugf("portStorage add status: %+v", p.entries[containerID])
 }
 
 func (p *portStorage) get(containerID string) nat.PortMap {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	log.Debugf("portStorage get status: %+v", p.portmap)
-
-	if portMap, ok := p.portmap[containerID]; ok {
-		return portMap
+	if entry, ok := p.entries[containerID]; ok && len(entry.portMap) != 0 {
+		log.Debugf("portStorage get status: %+v", entry.portMap)
+		return entry.portMap
 	}
 
 	return nil
 }
 
-func (p *portStorage) removeAll() {
+// status returns a snapshot of every tracked ID, sorted by ID.
+func (p *portStorage) status() []Entry {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	for containerID, portMap := range p.portmap {
-		log.Debugf("removing the following container [%s] port binding: %+v", containerID, portMap)
-		delete(p.portmap, containerID)
+	entries := make([]Entry, 0, len(p.entries))
+
+	for k, v := range p.entries {
+		entries = append(entries, Entry{
+			ID:            k,
+			Source:        v.source,
+			Ports:         maps.Clone(v.portMap),
+			Errors:        slices.Clone(v.errors),
+			Rejected:      slices.Clone(v.rejected),
+			Remapped:      slices.Clone(v.remaps),
+			ProxyProtocol: maps.Clone(v.proxyProtocol),
+		})
 	}
+
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	return entries
 }
 
-func (p *portStorage) getAll() map[string]nat.PortMap {
+// lookup returns a snapshot of the entry for containerID.
+func (p *portStorage) lookup(containerID string) (Entry, bool) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	portMappings := make(map[string]nat.PortMap, len(p.portmap))
-
-	for k, v := range p.portmap {
-		portMappings[k] = maps.Clone(v)
+	entry, ok := p.entries[containerID]
+	if !ok {
+		return Entry{}, false
 	}
 
-	return portMappings
+	return Entry{
+		ID:            containerID,
+		Source:        entry.source,
+		Ports:         maps.Clone(entry.portMap),
+		Errors:        slices.Clone(entry.errors),
+		Rejected:      slices.Clone(entry.rejected),
+		Remapped:      slices.Clone(entry.remaps),
+		ProxyProtocol: maps.Clone(entry.proxyProtocol),
+	}, true
+}
+
+// adopt appends the bindings that turned out to be forwarded, and their
+// remapped host ports, to the entry for containerID and replaces its
+// failed bindings with errs. Bindings the entry already holds are not
+// added twice, so that they are only unexposed once.
+func (p *portStorage) adopt(containerID string, adopted nat.PortMap, remaps []Remap, errs []BindingError) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	entry, ok := p.entries[containerID]
+	if !ok {
+		return
+	}
+	if entry.portMap == nil {
+		entry.portMap = make(nat.PortMap, len(adopted))
+	} else {
+		entry.portMap = maps.Clone(entry.portMap)
+	}
+	for portProto, portBindings := range adopted {
+		bindings := slices.Clone(entry.portMap[portProto])
+		for _, portBinding := range portBindings {
+			if !slices.Contains(bindings, portBinding) {
+				bindings = append(bindings, portBinding)
+			}
+		}
+		entry.portMap[portProto] = bindings
+	}
+	entry.remaps = slices.Clone(entry.remaps)
+	for _, remap := range remaps {
+		if !slices.Contains(entry.remaps, remap) {
+			entry.remaps = append(entry.remaps, remap)
+		}
+	}
+	entry.errors = errs
 }
 
 func (p *portStorage) remove(containerID string) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
-	delete(p.portmap, containerID)
-	log.Debugf("portStorage remove status: %+v", p.portmap)
+	delete(p.entries, containerID)
+	log.Debugf("portStorage remove status: %d entries left", len(p.entries))
 }
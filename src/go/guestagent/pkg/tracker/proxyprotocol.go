@@ -0,0 +1,86 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracker
+
+import (
+	"strings"
+
+	"github.com/Masterminds/log-go"
+	"github.com/docker/go-connections/nat"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/policy"
+	guestagentTypes "github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
+)
+
+// ProxyProtocolKey is the label or annotation that makes wsl-proxy start
+// the connections to the TCP ports of a container or a service with a
+// PROXY protocol header. Its value is either the version of the header,
+// "v1" or "v2", for all the ports, or a comma-separated list of
+// <port>=<version>, e.g. "80=v1,443=v2", where port is the container port.
+const ProxyProtocolKey = "rancherdesktop.io/proxy-protocol"
+
+// proxyProtocols returns the version of the PROXY protocol header of the
+// TCP bindings of portMap, by host port, as set by the ProxyProtocolKey
+// label or annotation of workload; the label takes precedence. The
+// invalid parts of the value are logged and ignored.
+func proxyProtocols(containerID string, workload policy.Workload, portMap nat.PortMap) map[string]guestagentTypes.ProxyProtocolVersion {
+	value, ok := workload.Labels[ProxyProtocolKey]
+	if !ok {
+		value, ok = workload.Annotations[ProxyProtocolKey]
+	}
+	if !ok {
+		return nil
+	}
+
+	var all guestagentTypes.ProxyProtocolVersion
+	byPort := make(map[string]guestagentTypes.ProxyProtocolVersion)
+	for _, item := range strings.Split(value, ",") {
+		port, version, hasPort := strings.Cut(strings.TrimSpace(item), "=")
+		if !hasPort {
+			port, version = "", port
+		}
+		switch guestagentTypes.ProxyProtocolVersion(version) {
+		case guestagentTypes.ProxyProtocolV1, guestagentTypes.ProxyProtocolV2:
+		default:
+			log.Warnf("ignoring invalid %s value %q for %s: unsupported version %q", ProxyProtocolKey, value, containerID, version)
+			continue
+		}
+		if !hasPort {
+			all = guestagentTypes.ProxyProtocolVersion(version)
+			continue
+		}
+		byPort[port] = guestagentTypes.ProxyProtocolVersion(version)
+	}
+
+	versions := make(map[string]guestagentTypes.ProxyProtocolVersion)
+	for portProto, portBindings := range portMap {
+		if portProto.Proto() != "tcp" {
+			continue
+		}
+		version, ok := byPort[portProto.Port()]
+		if !ok {
+			version = all
+		}
+		if version == "" {
+			continue
+		}
+		for _, portBinding := range portBindings {
+			versions[portBinding.HostPort] = version
+		}
+	}
+	if len(versions) == 0 {
+		return nil
+	}
+	return versions
+}
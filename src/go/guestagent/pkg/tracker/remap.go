@@ -0,0 +1,112 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracker
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// remapMaxAttempts bounds the number of host ports tried for a single
+// binding, as each attempt is a round trip to the host-switch.
+const remapMaxAttempts = 64
+
+var ErrInvalidRemapRange = errors.New("invalid port remapping range")
+
+// Remap records that a binding was exposed on the host on HostPort
+// instead of the host port it requested, which was taken.
+type Remap struct {
+	Port     nat.Port        `json:"port"`
+	Binding  nat.PortBinding `json:"binding"`
+	HostPort string          `json:"hostPort"`
+}
+
+// RemapRange is where the tracker looks for a free host port when the
+// requested one is taken. The zero RemapRange tries the ports following
+// the requested one.
+type RemapRange struct {
+	First int
+	Last  int
+}
+
+// ParseRemapRange parses the -remapPorts flag: "" disables remapping,
+// "next" picks the next free port, and "N-M" picks a free port in that
+// range.
+func ParseRemapRange(spec string) (*RemapRange, error) {
+	switch spec {
+	case "":
+		return nil, nil
+	case "next":
+		return &RemapRange{}, nil
+	}
+
+	first, last, found := strings.Cut(spec, "-")
+	if !found {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidRemapRange, spec)
+	}
+	firstPort, err := strconv.Atoi(first)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %w", ErrInvalidRemapRange, spec, err)
+	}
+	lastPort, err := strconv.Atoi(last)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %w", ErrInvalidRemapRange, spec, err)
+	}
+	if firstPort < 1 || lastPort > 65535 || firstPort > lastPort {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidRemapRange, spec)
+	}
+
+	return &RemapRange{First: firstPort, Last: lastPort}, nil
+}
+
+// candidates returns the host ports to try, in order, for a binding that
+// requested the given host port.
+func (r RemapRange) candidates(requested int) []int {
+	first, last := requested+1, 65535
+	if r.First != 0 {
+		first, last = r.First, r.Last
+	}
+
+	var ports []int
+	for port := first; port <= last && len(ports) < remapMaxAttempts; port++ {
+		if port != requested {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// HostPort returns the host port that portBinding is exposed on, which
+// differs from the requested one if it was remapped.
+func (e Entry) HostPort(portProto nat.Port, portBinding nat.PortBinding) string {
+	for _, remap := range e.Remapped {
+		if remap.Port == portProto && remap.Binding == portBinding {
+			return remap.HostPort
+		}
+	}
+	return portBinding.HostPort
+}
+
+// isHostPortTaken reports whether an expose error means that something on
+// the host already listens on the port; the messages are those of the
+// Linux and Windows bind errors relayed by the host-switch.
+func isHostPortTaken(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "address already in use") ||
+		strings.Contains(msg, "only one usage of each socket address")
+}
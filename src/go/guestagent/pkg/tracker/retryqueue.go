@@ -0,0 +1,120 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracker
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 2 * time.Minute
+)
+
+// retryItem is the retry state of a single tracked ID.
+type retryItem struct {
+	attempt int
+	next    time.Time
+	// resendWSLProxy is set when the port mapping could not be
+	// delivered to wsl-proxy and must be sent again.
+	resendWSLProxy bool
+}
+
+// retryQueue schedules the tracked IDs whose port mappings were not fully
+// exposed. Each failed attempt doubles the delay before the next one, up
+// to retryMaxDelay, with jitter so that many IDs failing at once (e.g.
+// host-switch not being up yet) do not retry in lockstep. The queue is
+// only kept in memory; after a restart, it is filled again as the
+// monitors add the port mappings they find.
+type retryQueue struct {
+	items map[string]*retryItem
+	mutex sync.Mutex
+}
+
+func newRetryQueue() *retryQueue {
+	return &retryQueue{
+		items: make(map[string]*retryItem),
+	}
+}
+
+// schedule (re)starts the backoff for containerID, for a port mapping
+// that was just added.
+func (q *retryQueue) schedule(containerID string, resendWSLProxy bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.items[containerID] = &retryItem{
+		next:           time.Now().Add(backoff(0)),
+		resendWSLProxy: resendWSLProxy,
+	}
+}
+
+// backoff records another failed attempt for containerID and pushes
+// its next attempt further out.
+func (q *retryQueue) backoff(containerID string, resendWSLProxy bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	item, ok := q.items[containerID]
+	if !ok {
+		item = &retryItem{}
+		q.items[containerID] = item
+	}
+	item.attempt++
+	item.next = time.Now().Add(backoff(item.attempt))
+	item.resendWSLProxy = resendWSLProxy
+}
+
+func (q *retryQueue) remove(containerID string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	delete(q.items, containerID)
+}
+
+// due returns the IDs whose next attempt is at or before now.
+func (q *retryQueue) due(now time.Time) []string {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var ids []string
+	for id, item := range q.items {
+		if !item.next.After(now) {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// get returns a copy of the retry state for containerID.
+func (q *retryQueue) get(containerID string) (retryItem, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	item, ok := q.items[containerID]
+	if !ok {
+		return retryItem{}, false
+	}
+	return *item, true
+}
+
+// backoff returns the delay before the given attempt: exponential in
+// attempt and capped at retryMaxDelay, with the upper half jittered.
+func backoff(attempt int) time.Duration {
+	delay := retryMaxDelay
+	if attempt < 16 {
+		delay = min(retryBaseDelay<<attempt, retryMaxDelay)
+	}
+	half := delay / 2
+	return half + rand.N(half+1)
+}
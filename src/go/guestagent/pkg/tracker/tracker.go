@@ -15,7 +15,50 @@ limitations under the License.
 // of the ports during various container event types e.g start, stop
 package tracker
 
-import "github.com/docker/go-connections/nat"
+import (
+	"time"
+
+	"github.com/docker/go-connections/nat"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/policy"
+	guestagentTypes "github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
+)
+
+// Source identifies the component that produced a port mapping.
+type Source string
+
+const (
+	SourceDocker     Source = "docker"
+	SourceContainerd Source = "containerd"
+	SourceKube       Source = "kube"
+	SourceIptables   Source = "iptables"
+	SourceProcNet    Source = "procnet"
+)
+
+// Entry is a snapshot of a tracked ID used for introspection.
+type Entry struct {
+	ID     string         `json:"id"`
+	Source Source         `json:"source,omitempty"`
+	Ports  nat.PortMap    `json:"ports"`
+	Errors []BindingError `json:"errors,omitempty"`
+	// Rejected holds the bindings that the port forwarding policy
+	// did not allow; they are neither exposed nor retried.
+	Rejected []policy.Rejection `json:"rejected,omitempty"`
+	// Remapped lists the bindings in Ports that are exposed on the host
+	// on another host port than the one they requested.
+	Remapped []Remap `json:"remapped,omitempty"`
+	// ProxyProtocol maps the TCP host ports whose connections start with
+	// a PROXY protocol header to the version of the header.
+	ProxyProtocol map[string]guestagentTypes.ProxyProtocolVersion `json:"proxyProtocol,omitempty"`
+}
+
+// BindingError is the last error seen while exposing a port binding.
+type BindingError struct {
+	Port    nat.Port        `json:"port"`
+	Binding nat.PortBinding `json:"binding"`
+	Error   string          `json:"error"`
+	Time    time.Time       `json:"time"`
+}
 
 // Tracker is the interface that includes all the functions that
 // are used to keep track of the port mappings plus NetTracker methods
@@ -29,6 +72,16 @@ type Tracker interface {
 	// so the caller is responsible for calling Remove first if necessary.
 	Add(containerID string, portMapping nat.PortMap) error
 
+	// AddWorkload is like Add, for a port mapping that belongs to the given
+	// workload, which the port forwarding policy may reject based on its
+	// namespace, labels or annotations.
+	AddWorkload(containerID string, workload policy.Workload, portMapping nat.PortMap) error
+
+	// AllowsWorkload reports whether the port forwarding policy lets the
+	// ports of the workload be forwarded, for the sources that forward
+	// ports on behalf of other objects, such as the routes of an Ingress.
+	AllowsWorkload(workload policy.Workload) bool
+
 	// Remove removes a portMap using the containerID as a key.
 	Remove(containerID string) error
 
@@ -0,0 +1,29 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+// The batch endpoints of the host-switch take a JSON array of the requests
+// of /services/forwarder/expose or /services/forwarder/unexpose, and reply
+// with a JSON array of BatchResult, one per request, in the same order.
+const (
+	ExposeBatchAPI   = "/services/forwarder/expose-batch"
+	UnexposeBatchAPI = "/services/forwarder/unexpose-batch"
+)
+
+// BatchResult is the outcome of a single request of a batch.
+type BatchResult struct {
+	// Error is the response of the single endpoint when it failed, and
+	// empty when it succeeded.
+	Error string `json:"error,omitempty"`
+}
@@ -15,7 +15,17 @@ limitations under the License.
 // different packages.
 package types
 
-import "github.com/docker/go-connections/nat"
+import (
+	"cmp"
+	"slices"
+	"strconv"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// MinPortRangeLength is the number of consecutive ports from which
+// Compact folds them into a PortRange.
+const MinPortRangeLength = 2
 
 // PortMapping represents the mapping of ports and addresses to be communicated
 // over the network. It includes a flag (remove) on whether to add or remove port mappings
@@ -30,6 +40,128 @@ type PortMapping struct {
 	// in terms of the network namespace the container engine is running in (i.e. the
 	// "Rancher Desktop" network namespace).
 	ConnectAddrs []ConnectAddrs `json:"connectAddrs"`
+	// Ranges holds runs of consecutive bindings, such as the ones published
+	// with "-p 5000-5999:5000-5999/udp", in addition to Ports, so that large
+	// ranges do not need a binding each on the wire.
+	Ranges []PortRange `json:"ranges,omitempty"`
+	// ProxyProtocol maps the TCP host ports whose upstream connections
+	// start with a PROXY protocol header, so that the container sees the
+	// address of the client rather than the one of the bridge, to the
+	// version of the header.
+	ProxyProtocol map[string]ProxyProtocolVersion `json:"proxyProtocol,omitempty"`
+}
+
+// ProxyProtocolVersion is a version of the PROXY protocol header.
+type ProxyProtocolVersion string
+
+const (
+	// ProxyProtocolV1 is the human-readable header.
+	ProxyProtocolV1 ProxyProtocolVersion = "v1"
+	// ProxyProtocolV2 is the binary header.
+	ProxyProtocolV2 ProxyProtocolVersion = "v2"
+)
+
+// PortRange is a run of bindings of the same protocol and host IP, where
+// both the port and the host port increase by one from a binding to the
+// next: port Port+i is bound to host port HostPort+i for i in [0, Count).
+type PortRange struct {
+	Protocol string `json:"protocol"`
+	HostIP   string `json:"hostIP"`
+	Port     uint16 `json:"port"`
+	HostPort uint16 `json:"hostPort"`
+	Count    uint16 `json:"count"`
+}
+
+// Compact returns a copy of the port mapping where the runs of at least
+// MinPortRangeLength consecutive bindings are moved from Ports to Ranges.
+// It only applies to the messages sent to wsl-proxy; the host-switch is
+// asked to expose each binding on its own.
+func (p PortMapping) Compact() PortMapping {
+	type group struct {
+		proto  string
+		hostIP string
+	}
+	type binding struct {
+		port, hostPort uint16
+	}
+	groups := make(map[group][]binding)
+	ports := make(nat.PortMap, len(p.Ports))
+	for portProto, portBindings := range p.Ports {
+		for _, portBinding := range portBindings {
+			port, portErr := strconv.ParseUint(portProto.Port(), 10, 16)
+			hostPort, hostPortErr := strconv.ParseUint(portBinding.HostPort, 10, 16)
+			if portErr != nil || hostPortErr != nil {
+				// Not a single port; keep it as is.
+				ports[portProto] = append(ports[portProto], portBinding)
+				continue
+			}
+			g := group{proto: portProto.Proto(), hostIP: portBinding.HostIP}
+			groups[g] = append(groups[g], binding{port: uint16(port), hostPort: uint16(hostPort)})
+		}
+	}
+
+	compacted := p
+	compacted.Ranges = slices.Clone(p.Ranges)
+	for g, bindings := range groups {
+		slices.SortFunc(bindings, func(a, b binding) int {
+			return cmp.Compare(a.hostPort, b.hostPort)
+		})
+		for start := 0; start < len(bindings); {
+			end := start + 1
+			for end < len(bindings) &&
+				bindings[end].hostPort == bindings[end-1].hostPort+1 &&
+				bindings[end].port == bindings[end-1].port+1 {
+				end++
+			}
+			if end-start >= MinPortRangeLength {
+				compacted.Ranges = append(compacted.Ranges, PortRange{
+					Protocol: g.proto,
+					HostIP:   g.hostIP,
+					Port:     bindings[start].port,
+					HostPort: bindings[start].hostPort,
+					Count:    uint16(end - start),
+				})
+			} else {
+				for _, b := range bindings[start:end] {
+					portProto := nat.Port(strconv.Itoa(int(b.port)) + "/" + g.proto)
+					ports[portProto] = append(ports[portProto], nat.PortBinding{
+						HostIP:   g.hostIP,
+						HostPort: strconv.Itoa(int(b.hostPort)),
+					})
+				}
+			}
+			start = end
+		}
+	}
+	slices.SortFunc(compacted.Ranges, func(a, b PortRange) int {
+		return cmp.Or(
+			cmp.Compare(a.Protocol, b.Protocol),
+			cmp.Compare(a.HostIP, b.HostIP),
+			cmp.Compare(a.HostPort, b.HostPort))
+	})
+	compacted.Ports = ports
+	return compacted
+}
+
+// AllPorts returns Ports along with the bindings of Ranges.
+func (p PortMapping) AllPorts() nat.PortMap {
+	if len(p.Ranges) == 0 {
+		return p.Ports
+	}
+	ports := make(nat.PortMap, len(p.Ports))
+	for portProto, portBindings := range p.Ports {
+		ports[portProto] = slices.Clone(portBindings)
+	}
+	for _, r := range p.Ranges {
+		for i := range int(r.Count) {
+			portProto := nat.Port(strconv.Itoa(int(r.Port)+i) + "/" + r.Protocol)
+			ports[portProto] = append(ports[portProto], nat.PortBinding{
+				HostIP:   r.HostIP,
+				HostPort: strconv.Itoa(int(r.HostPort) + i),
+			})
+		}
+	}
+	return ports
 }
 
 // ConnectAddrs defines a network address used for the WSL interface inside
@@ -0,0 +1,66 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
+)
+
+func TestCompact(t *testing.T) {
+	t.Parallel()
+
+	ports := nat.PortMap{
+		// A single port stays as is.
+		"80/tcp": {{HostIP: "0.0.0.0", HostPort: "8080"}},
+		// Remapped ports are not consecutive in the VM.
+		"443/tcp": {{HostIP: "0.0.0.0", HostPort: "8081"}},
+		"36412/sctp": {
+			{HostIP: "0.0.0.0", HostPort: "36412"},
+			{HostIP: "::", HostPort: "36412"},
+		},
+		"36413/sctp": {
+			{HostIP: "0.0.0.0", HostPort: "36413"},
+			{HostIP: "::", HostPort: "36413"},
+		},
+	}
+	for port := 5000; port < 5200; port++ {
+		ports[nat.Port(fmt.Sprintf("%d/udp", port+1000))] = []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: fmt.Sprint(port)}}
+	}
+	portMapping := types.PortMapping{Ports: ports}
+
+	compacted := portMapping.Compact()
+	assert.Equal(t, nat.PortMap{
+		"80/tcp":  {{HostIP: "0.0.0.0", HostPort: "8080"}},
+		"443/tcp": {{HostIP: "0.0.0.0", HostPort: "8081"}},
+	}, compacted.Ports)
+	assert.Equal(t, []types.PortRange{
+		{Protocol: "sctp", HostIP: "0.0.0.0", Port: 36412, HostPort: 36412, Count: 2},
+		{Protocol: "sctp", HostIP: "::", Port: 36412, HostPort: 36412, Count: 2},
+		{Protocol: "udp", HostIP: "127.0.0.1", Port: 6000, HostPort: 5000, Count: 200},
+	}, compacted.Ranges)
+	assert.Equal(t, ports, compacted.AllPorts())
+}
+
+func TestAllPortsWithoutRanges(t *testing.T) {
+	t.Parallel()
+
+	ports := nat.PortMap{"80/tcp": {{HostIP: "0.0.0.0", HostPort: "8080"}}}
+	assert.Equal(t, ports, types.PortMapping{Ports: ports}.AllPorts())
+}
@@ -0,0 +1,73 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import "github.com/docker/go-connections/nat"
+
+// WSLProxyProtocolVersion is the version of the session protocol spoken
+// between the guest agent and wsl-proxy.
+//
+// A session is a long-lived connection carrying a JSON WSLProxyRequest
+// and a JSON WSLProxyResponse at a time, starting with a hello request
+// that negotiates the version. A connection whose first message is a
+// PortMapping, without a type, is the original one-shot format: wsl-proxy
+// applies it and hangs up without a response.
+const WSLProxyProtocolVersion = 1
+
+// WSLProxyRequestType is the kind of a WSLProxyRequest.
+type WSLProxyRequestType string
+
+const (
+	// WSLProxyHello opens a session; Version is the highest version the
+	// guest agent supports.
+	WSLProxyHello WSLProxyRequestType = "hello"
+	// WSLProxyUpdate adds or removes the bindings of PortMapping.
+	WSLProxyUpdate WSLProxyRequestType = "update"
+	// WSLProxyResync replaces every binding of wsl-proxy with the ones of
+	// PortMapping; it is sent after connecting, as wsl-proxy may have
+	// missed updates, or kept the bindings of a previous guest agent.
+	WSLProxyResync WSLProxyRequestType = "resync"
+	// WSLProxyPing checks that the session is still up.
+	WSLProxyPing WSLProxyRequestType = "ping"
+)
+
+// WSLProxyRequest is a message from the guest agent to wsl-proxy.
+type WSLProxyRequest struct {
+	Type WSLProxyRequestType `json:"type"`
+	// ID is echoed in the response.
+	ID uint64 `json:"id"`
+	// Version is set for WSLProxyHello.
+	Version int `json:"version,omitempty"`
+	// PortMapping is set for WSLProxyUpdate and WSLProxyResync.
+	PortMapping *PortMapping `json:"portMapping,omitempty"`
+}
+
+// WSLProxyResponse is the reply of wsl-proxy to a WSLProxyRequest.
+type WSLProxyResponse struct {
+	ID uint64 `json:"id"`
+	// Version is the negotiated version, in the reply to WSLProxyHello.
+	Version int `json:"version,omitempty"`
+	// Error is set when the request as a whole failed.
+	Error string `json:"error,omitempty"`
+	// Results holds the outcome of each binding of the request.
+	Results []BindingResult `json:"results,omitempty"`
+}
+
+// BindingResult is the outcome of adding or removing a single binding.
+type BindingResult struct {
+	Port    nat.Port        `json:"port"`
+	Binding nat.PortBinding `json:"binding"`
+	// Error is empty when the binding succeeded.
+	Error string `json:"error,omitempty"`
+}
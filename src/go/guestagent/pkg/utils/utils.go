@@ -17,6 +17,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"net"
 )
 
 var (
@@ -25,12 +26,19 @@ var (
 )
 
 // NormalizeHostIP checks if the provided IP address is valid.
-// The valid options are "127.0.0.1" and "0.0.0.0". If the input is "127.0.0.1",
-// it returns "127.0.0.1". Any other address will be mapped to "0.0.0.0".
+// The valid options are "127.0.0.1", "0.0.0.0", "::1" and "::". Loopback
+// addresses are returned as-is; any other IPv6 address is mapped to "::"
+// and everything else to "0.0.0.0".
 func NormalizeHostIP(ip string) string {
 	if ip == "127.0.0.1" || ip == "localhost" {
 		return ip
 	}
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		if parsed.IsLoopback() {
+			return net.IPv6loopback.String()
+		}
+		return net.IPv6unspecified.String()
+	}
 	return "0.0.0.0"
 }
 
@@ -0,0 +1,105 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Masterminds/log-go"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/config"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/iptables"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/procnet"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
+)
+
+// reloader applies a reloaded configuration to the running agent. The
+// port forwarding policy, the port remapping, the log level and the
+// intervals are applied in place, so the existing port forwards are kept;
+// the other settings only take effect when the agent restarts.
+type reloader struct {
+	// running is the configuration the agent was started with.
+	running    *config.Config
+	logger     *levelLogger
+	apiTracker *tracker.APITracker
+	// reconcile is the reconciliation interval, in nanoseconds.
+	reconcile atomic.Int64
+
+	procScanner *procnet.ProcNetScanner
+	// procScannerInterval returns the interval of procScanner, which
+	// depends on whether it scans through sock_diag or /proc/net.
+	procScannerInterval func(*config.Config) config.Duration
+	// iptablesHandler is nil when Kubernetes is disabled.
+	iptablesHandler *iptables.Iptables
+}
+
+func newReloader(cfg *config.Config, logger *levelLogger, apiTracker *tracker.APITracker) *reloader {
+	r := &reloader{
+		running:    cfg,
+		logger:     logger,
+		apiTracker: apiTracker,
+	}
+	r.reconcile.Store(int64(cfg.Intervals.Reconcile))
+	return r
+}
+
+func (r *reloader) reconcileInterval() time.Duration {
+	return time.Duration(r.reconcile.Load())
+}
+
+// apply applies cfg; nothing is changed if it fails.
+func (r *reloader) apply(cfg *config.Config) error {
+	forwardingPolicy, err := cfg.LoadPolicy()
+	if err != nil {
+		return err
+	}
+	remapRange, err := tracker.ParseRemapRange(cfg.RemapPorts)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Debug {
+		r.logger.SetLevel(log.DebugLevel)
+	} else {
+		r.logger.SetLevel(log.InfoLevel)
+	}
+	r.apiTracker.SetPolicy(forwardingPolicy)
+	r.apiTracker.SetRemapRange(remapRange)
+	r.reconcile.Store(int64(cfg.Intervals.Reconcile))
+	if r.procScanner != nil {
+		r.procScanner.SetInterval(time.Duration(r.procScannerInterval(cfg)))
+	}
+	if r.iptablesHandler != nil {
+		r.iptablesHandler.SetInterval(time.Duration(cfg.Intervals.IptablesScan))
+	}
+
+	if keys := r.running.RestartRequired(cfg); len(keys) != 0 {
+		log.Warnf("the changes to %s take effect when the agent restarts", strings.Join(keys, ", "))
+	}
+	if forwardingPolicy != nil {
+		log.Infof("enforcing the port forwarding policy from %s", policySource(cfg))
+	}
+	log.Info("reloaded the configuration")
+	return nil
+}
+
+// policySource describes where the port forwarding policy comes from.
+func policySource(cfg *config.Config) string {
+	if cfg.Policy != nil {
+		return "the configuration file"
+	}
+	return cfg.PortPolicy
+}
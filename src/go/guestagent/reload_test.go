@@ -0,0 +1,84 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io"
+	stdlog "log"
+	"sync"
+	"testing"
+
+	"github.com/Masterminds/log-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/config"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/tracker"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
+)
+
+type nopForwarder struct{}
+
+func (nopForwarder) Send(types.PortMapping) error { return nil }
+
+// The log level is changed on reload while the other goroutines log; run
+// with -race.
+func TestReloadWhileLogging(t *testing.T) {
+	output := stdlog.Writer()
+	stdlog.SetOutput(io.Discard)
+	t.Cleanup(func() { stdlog.SetOutput(output) })
+
+	logger := newLevelLogger(log.InfoLevel)
+	previous := log.Current
+	log.Current = logger
+	t.Cleanup(func() { log.Current = previous })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.Default()
+	apiTracker := tracker.NewAPITracker(ctx, nopForwarder{}, "http://127.0.0.1:0", "192.168.127.2", true)
+	r := newReloader(&cfg, logger, apiTracker)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					log.Debugf("debug message")
+					log.Info("info message")
+				}
+			}
+		}()
+	}
+
+	for i := range 100 {
+		reloaded := config.Default()
+		reloaded.Debug = i%2 == 0
+		require.NoError(t, r.apply(&reloaded))
+	}
+	close(done)
+	wg.Wait()
+
+	// The last reload disabled the debug logging.
+	assert.False(t, logger.enabled(log.DebugLevel))
+	assert.True(t, logger.enabled(log.InfoLevel))
+}
@@ -22,10 +22,8 @@ import (
 )
 
 const (
-	captureFile    = "capture.pcap"
-	localHost      = "127.0.0.1"
-	defaultMTU     = 1500
-	gatewayMacAddr = "5a:94:ef:e4:0c:dd"
+	captureFile = "capture.pcap"
+	localHost   = "127.0.0.1"
 )
 
 type arrayFlags []string
@@ -39,13 +37,13 @@ func (i *arrayFlags) Set(value string) error {
 	return nil
 }
 
-func newConfig(subnet config.Subnet, staticPortForwarding map[string]string, debug bool) types.Configuration {
+func newConfig(subnet config.Subnet, staticPortForwarding map[string]string, mtu int, debug bool) types.Configuration {
 	c := types.Configuration{
 		Debug:             debug,
-		MTU:               defaultMTU,
+		MTU:               mtu,
 		Subnet:            subnet.SubnetCIDR,
 		GatewayIP:         subnet.GatewayIP,
-		GatewayMacAddress: gatewayMacAddr,
+		GatewayMacAddress: config.GatewayMacAddr,
 		DHCPStaticLeases:  subnet.StaticDHCPLease,
 		DNS: []types.Zone{
 			{
@@ -32,6 +32,8 @@ import (
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/batch"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/config"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/vsock"
 )
@@ -39,6 +41,7 @@ import (
 var (
 	debug             bool
 	virtualSubnet     string
+	mtu               int
 	staticPortForward arrayFlags
 )
 
@@ -53,6 +56,8 @@ func main() {
 	flag.BoolVar(&debug, "debug", false, "enable additional debugging")
 	flag.StringVar(&virtualSubnet, "subnet", config.DefaultSubnet,
 		fmt.Sprintf("Subnet range with CIDR suffix for virtual network, e,g: %s", config.DefaultSubnet))
+	flag.IntVar(&mtu, "mtu", config.DefaultMTU,
+		"MTU of the virtual network; it must match the MTU of the vm-switch tap interface")
 	flag.Var(&staticPortForward, "port-forward",
 		"List of ports that needs to be pre forwarded to the WSL VM in Host:Port=Guest:Port format e.g: 127.0.0.1:2222=192.168.127.2:22")
 	flag.Parse()
@@ -68,6 +73,10 @@ func main() {
 
 	logrus.Debugf("attempting to start with the following subnet: %+v", subnet)
 
+	if err := config.ValidateMTU(mtu); err != nil {
+		logrus.Fatal(err)
+	}
+
 	portForwarding, err := config.ParsePortForwarding(staticPortForward)
 	if err != nil {
 		logrus.Fatal(err)
@@ -88,7 +97,7 @@ func runSwitch(subnet config.Subnet, portForwarding map[string]string) error {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 
-	cfg := newConfig(subnet, portForwarding, debug)
+	cfg := newConfig(subnet, portForwarding, mtu, debug)
 
 	logrus.Debugf("attempting to start a virtual network with the following config: %+v", cfg)
 	vn, err := virtualnetwork.New(&cfg)
@@ -105,6 +114,8 @@ func runSwitch(subnet config.Subnet, portForwarding map[string]string) error {
 	mux.Handle("/services/forwarder/all", vn.Mux())
 	mux.Handle("/services/forwarder/expose", vn.Mux())
 	mux.Handle("/services/forwarder/unexpose", vn.Mux())
+	mux.Handle(types.ExposeBatchAPI, batch.NewHandler(vn.Mux(), "/services/forwarder/expose"))
+	mux.Handle(types.UnexposeBatchAPI, batch.NewHandler(vn.Mux(), "/services/forwarder/unexpose"))
 	httpServe(ctx, groupErrs, vnLn, mux)
 	logrus.Infof("port forwarding API server is running on: %s", apiServer)
 
@@ -37,6 +37,7 @@ import (
 	"golang.org/x/sys/unix"
 
 	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/config"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/control"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/log"
 	rdvsock "github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/vsock"
 )
@@ -44,6 +45,12 @@ import (
 var options struct {
 	debug            bool
 	tracePackets     bool
+	capture          bool
+	captureSocket    string
+	captureFile      string
+	captureFilter    string
+	controlSocket    string
+	impair           []string
 	vmSwitchPath     string
 	unshareArg       string
 	vmSwitchLogFile  string
@@ -53,6 +60,7 @@ var options struct {
 	tapIface         string
 	subnet           string
 	tapDeviceMacAddr string
+	mtu              int
 }
 
 const (
@@ -70,6 +78,7 @@ const (
 	cidrOnes                = 24
 	cidrBits                = 32
 	stdout                  = "/dev/stdout"
+	defaultCaptureSocket    = "/run/vm-switch-capture.sock"
 )
 
 func run() error {
@@ -83,6 +92,10 @@ func run() error {
 		return fmt.Errorf("path to the vm-switch process must be provided")
 	}
 
+	if err := config.ValidateMTU(options.mtu); err != nil {
+		return err
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), unix.SIGTERM, unix.SIGHUP, unix.SIGQUIT)
 	defer cancel()
 
@@ -152,7 +165,8 @@ func run() error {
 		originNS,
 		peerNS,
 		WSLVeth,
-		namespaceVeth)
+		namespaceVeth,
+		options.mtu)
 	if err != nil {
 		return fmt.Errorf("failed to create veth pair: %w", err)
 	}
@@ -206,10 +220,20 @@ func main() {
 func initializeFlags() {
 	flag.BoolVar(&options.debug, "debug", false, "enable additional debugging")
 	flag.BoolVar(&options.tracePackets, "trace-packets", false, "forward per-packet tracing to the vm-switch process")
+	flag.BoolVar(&options.capture, "capture", false, "start the vm-switch packet capture right away")
+	flag.StringVar(&options.captureSocket, "capture-socket", defaultCaptureSocket, "path to the Unix socket streaming the vm-switch packet capture")
+	flag.StringVar(&options.captureFile, "capture-file", "", "path to the pcapng file of the vm-switch packet capture")
+	flag.StringVar(&options.captureFilter, "capture-filter", "", "filter of the vm-switch packet capture")
+	flag.Func("impair", "impairment rule of the vm-switch frames; can be repeated", func(value string) error {
+		options.impair = append(options.impair, value)
+		return nil
+	})
+	flag.StringVar(&options.controlSocket, "control-socket", control.DefaultSocket, "path to the Unix socket of the vm-switch control API")
 	flag.StringVar(&options.namespaceService, "namespace-service", defaultNamespaceService, "systemd service which creates the network namespace")
 	flag.StringVar(&options.tapIface, "tap-interface", defaultTapDevice, "tap interface name, eg. eth0, eth1")
 	flag.StringVar(&options.subnet, "subnet", config.DefaultSubnet,
 		fmt.Sprintf("Subnet range with CIDR suffix that is associated to the tap interface, e,g: %s", config.DefaultSubnet))
+	flag.IntVar(&options.mtu, "mtu", config.DefaultMTU, "MTU of the vm-switch tap interface and of the network namespace veth pair")
 	flag.StringVar(&options.tapDeviceMacAddr, "tap-mac-address", config.TapDeviceMacAddr,
 		"MAC address that is associated to the tap interface")
 	flag.StringVar(&options.dhcpScript, "dhcp-script", "", "script to run on DHCP events")
@@ -269,6 +293,23 @@ func configureVMSwitch(
 	if options.tracePackets {
 		args = append(args, "-trace-packets")
 	}
+	if options.capture {
+		args = append(args, "-capture")
+	}
+	if options.captureSocket != "" {
+		args = append(args, "-capture-socket", options.captureSocket)
+	}
+	if options.captureFile != "" {
+		args = append(args, "-capture-file", options.captureFile)
+	}
+	if options.captureFilter != "" {
+		args = append(args, "-capture-filter", options.captureFilter)
+	}
+	args = append(args, "-control-socket", options.controlSocket)
+	args = append(args, "-mtu", strconv.Itoa(options.mtu))
+	for _, rule := range options.impair {
+		args = append(args, "-impair", rule)
+	}
 
 	//nolint:gosec // Arguments are ultimately controlled by our configs.
 	vmSwitchCmd := exec.CommandContext(ctx, args[0], args[1:]...)
@@ -280,11 +321,14 @@ func configureVMSwitch(
 	return vmSwitchCmd
 }
 
-func createVethPair(defaultNS, peerNS netns.NsHandle, defaultNSVeth, rancherDesktopNSVeth string) error {
+// Create the veth pair between the given namespaces; the peer takes the MTU of
+// the default namespace end.
+func createVethPair(defaultNS, peerNS netns.NsHandle, defaultNSVeth, rancherDesktopNSVeth string, mtu int) error {
 	veth := &netlink.Veth{
 		LinkAttrs: netlink.LinkAttrs{
 			Name:      defaultNSVeth,
 			Namespace: netlink.NsFd(defaultNS),
+			MTU:       mtu,
 		},
 		PeerName:      rancherDesktopNSVeth,
 		PeerNamespace: netlink.NsFd(peerNS),
@@ -292,7 +336,7 @@ func createVethPair(defaultNS, peerNS netns.NsHandle, defaultNSVeth, rancherDesk
 	if err := netlink.LinkAdd(veth); err != nil {
 		return fmt.Errorf("failed to add veth link %+v: %w", veth, err)
 	}
-	logrus.Infof("created veth pair %s and %s", defaultNSVeth, rancherDesktopNSVeth)
+	logrus.Infof("created veth pair %s and %s with MTU %d", defaultNSVeth, rancherDesktopNSVeth, mtu)
 	return nil
 }
 
@@ -34,6 +34,7 @@ var (
 	socketFile   string
 	upstreamAddr string
 	udpBuffer    int
+	acceptProxy  bool
 )
 
 const (
@@ -50,6 +51,7 @@ func main() {
 	flag.StringVar(&socketFile, "socketFile", defaultSocket, "path to the .sock file for UNIX socket")
 	flag.StringVar(&upstreamAddr, "upstreamAddress", bridgeIPAddr, "IP address of the upstream server to forward to")
 	flag.IntVar(&udpBuffer, "udpBuffer", defaultUDPBufferSize, "max buffer size in bytes for UDP socket I/O")
+	flag.BoolVar(&acceptProxy, "acceptProxyProtocol", false, "require a PROXY protocol header on inbound TCP connections")
 	flag.Parse()
 
 	setupLogging(logFile)
@@ -64,8 +66,9 @@ func main() {
 		return
 	}
 	proxyConfig := &portproxy.ProxyConfig{
-		UpstreamAddress: upstreamAddr,
-		UDPBufferSize:   udpBuffer,
+		UpstreamAddress:     upstreamAddr,
+		UDPBufferSize:       udpBuffer,
+		AcceptProxyProtocol: acceptProxy,
 	}
 	proxy := portproxy.NewPortProxy(ctx, socket, proxyConfig)
 
@@ -0,0 +1,119 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/control"
+)
+
+const ctlUsage = `usage: vm-switch ctl [-socket path] <command>
+
+Commands:
+  stats                 print the counters of the running vm-switch
+  settings              print its runtime settings
+  set <key>=<value>...  change its runtime settings; the keys are
+                        trace-packets, capture, capture-filter, log-level
+                        and impair, which replaces the impairment rules
+                        with the ones given, or removes them when empty
+`
+
+var errCtlUsage = errors.New("invalid vm-switch ctl usage")
+
+// ctl is the client of the control socket of a running vm-switch; it is
+// run as `vm-switch ctl`, e.g. through `rdctl shell`.
+func ctl(args []string) error {
+	flags := flag.NewFlagSet("vm-switch ctl", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(flags.Output(), ctlUsage) }
+	socketPath := flags.String("socket", control.DefaultSocket, "path to the vm-switch control socket")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errCtlUsage
+	}
+
+	client := control.NewClient(*socketPath)
+	ctx := context.Background()
+	var result any
+	var err error
+	switch command := flags.Arg(0); {
+	case command == "stats" && flags.NArg() == 1:
+		result, err = client.Stats(ctx)
+	case command == "settings" && flags.NArg() == 1:
+		result, err = client.Settings(ctx)
+	case command == "set" && flags.NArg() > 1:
+		var patch control.Settings
+		if patch, err = parseSettings(flags.Args()[1:]); err != nil {
+			return err
+		}
+		result, err = client.Apply(ctx, patch)
+	default:
+		flags.Usage()
+		return errCtlUsage
+	}
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+// parseSettings parses the key=value arguments of `vm-switch ctl set`.
+func parseSettings(args []string) (control.Settings, error) {
+	var patch control.Settings
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return patch, fmt.Errorf("%w: %q is not a key=value pair", errCtlUsage, arg)
+		}
+		switch key {
+		case "trace-packets", "capture":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return patch, fmt.Errorf("%w: invalid value for %s: %q", errCtlUsage, key, value)
+			}
+			if key == "capture" {
+				patch.Capture = &enabled
+			} else {
+				patch.TracePackets = &enabled
+			}
+		case "capture-filter":
+			patch.CaptureFilter = &value
+		case "log-level":
+			patch.LogLevel = &value
+		case "impair":
+			if patch.Impairments == nil {
+				patch.Impairments = &[]string{}
+			}
+			if value != "" {
+				*patch.Impairments = append(*patch.Impairments, value)
+			}
+		default:
+			return patch, fmt.Errorf("%w: unknown setting %q", errCtlUsage, key)
+		}
+	}
+	return patch, nil
+}
@@ -16,6 +16,7 @@ package main
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -34,10 +35,16 @@ import (
 	"github.com/songgao/packets/ethernet"
 	"github.com/songgao/water"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/capture"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/config"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/control"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/impair"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/ipv6"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/log"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/mss"
 )
 
 var (
@@ -47,13 +54,17 @@ var (
 	tapIface         string
 	logFile          string
 	subnet           string
+	subnet6          string
 	tapDeviceMacAddr string
+	mtu              int
 )
 
 const (
 	defaultTapDevice = "eth0"
 	defaultVsockFD   = 3
-	maxMTU           = 4000
+
+	defaultCaptureMaxSize  = 100 * 1024 * 1024
+	defaultCaptureMaxFiles = 5
 )
 
 var (
@@ -62,9 +73,44 @@ var (
 	// tracePackets gates per-packet logging. It is initialized from traceFlag
 	// and can be toggled at runtime by sending SIGUSR1 to this process.
 	tracePackets atomic.Bool
+
+	// captureConfig holds the -capture-* flags.
+	captureConfig capture.Config
+	// captureFlag is the startup value of the -capture flag.
+	captureFlag bool
+	// packetCapture writes pcapng captures of the frames; it is nil when
+	// neither -capture-file nor -capture-socket is set.
+	packetCapture *capture.Capture
+
+	// controlSocket is the path of the control socket; empty disables it.
+	controlSocket string
+	// counters are reported by the control API.
+	counters control.Counters
+
+	// tapIP6 is the IPv6 address of the tap device when -subnet6 is set.
+	tapIP6 string
+	// impairFlags holds the -impair rules.
+	impairFlags []string
+	// impairment emulates an impaired network on the frames; it has no
+	// rules unless -impair is set, or rules are set through the control API.
+	impairment *impair.Impairment
+
+	// ipv6Responder answers the IPv6 configuration requests on behalf of
+	// the gateway; it is nil when -subnet6 is not set.
+	ipv6Responder *ipv6.Responder
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		if err := ctl(os.Args[2:]); err != nil {
+			if !errors.Is(err, flag.ErrHelp) {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
 	flag.BoolVar(&debug, "debug", false, "enable debug flag")
 	flag.BoolVar(&traceFlag, "trace-packets", false, "log a decode of every packet (very verbose); can also be toggled at runtime via SIGUSR1")
 	flag.StringVar(&tapIface, "tap-interface", defaultTapDevice, "tap interface name, eg. eth0, eth1")
@@ -74,13 +120,49 @@ func main() {
 		"MAC address that is associated to the tap interface")
 	flag.StringVar(&subnet, "subnet", config.DefaultSubnet,
 		fmt.Sprintf("Subnet range with CIDR suffix that is associated to the tap interface, e,g: %s", config.DefaultSubnet))
+	flag.StringVar(&subnet6, "subnet6", "", "IPv6 unique local prefix that is associated to the tap interface alongside the subnet, e.g: fd00:5241:4e43::/64")
+	flag.IntVar(&mtu, "mtu", config.DefaultMTU, "MTU of the tap interface; the TCP connections through it are clamped to fit")
 	flag.StringVar(&logFile, "logfile", "/var/log/vm-switch.log", "path to vm-switch process logfile")
+	flag.BoolVar(&captureFlag, "capture", false, "capture packets from startup; can also be toggled at runtime via SIGUSR2")
+	flag.StringVar(&captureConfig.File, "capture-file", "", "path to a pcapng file to write the captured packets to")
+	flag.Int64Var(&captureConfig.MaxSize, "capture-max-size", defaultCaptureMaxSize, "size in bytes from which the capture file is rotated")
+	flag.IntVar(&captureConfig.MaxFiles, "capture-max-files", defaultCaptureMaxFiles, "number of rotated capture files to keep")
+	flag.StringVar(&captureConfig.Socket, "capture-socket", "", "path to a Unix socket streaming the captured packets in pcapng format, e.g. to Wireshark")
+	flag.StringVar(&captureConfig.Filter, "capture-filter", "", `filter of the captured packets, in a subset of the tcpdump syntax, e.g. "udp port 53 or icmp"`)
+	flag.IntVar(&captureConfig.SnapLength, "capture-snaplen", 0, "maximum number of bytes captured per packet; 0 captures them whole")
+	flag.Func("impair", "impair the frames matching a rule, e.g. dst=10.0.0.0/8,port=443,latency=100ms,jitter=20ms,loss=1%; can be repeated", func(value string) error {
+		impairFlags = append(impairFlags, value)
+		return nil
+	})
+	flag.StringVar(&controlSocket, "control-socket", control.DefaultSocket, "path to the Unix socket of the control API; empty disables it")
 	flag.Parse()
 
 	if err := log.SetOutputFile(logFile, logrus.StandardLogger()); err != nil {
 		logrus.Fatalf("setting logger's output file failed: %v", err)
 	}
 
+	if err := config.ValidateMTU(mtu); err != nil {
+		logrus.Fatal(err)
+	}
+
+	impairRules, err := impair.ParseRules(impairFlags)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	impairment = impair.New(impairRules)
+
+	if subnet6 != "" {
+		dualStack, err := config.ValidateDualStackSubnet(subnet, subnet6)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if ipv6Responder, err = ipv6.NewResponder(dualStack); err != nil {
+			logrus.Fatalf("setting up IPv6 failed: %s", err)
+		}
+		_, prefix, _ := net.ParseCIDR(dualStack.SubnetCIDR6)
+		tapIP6 = config.TapDeviceIP6(prefix.IP)
+	}
+
 	if debug {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
@@ -88,13 +170,15 @@ func main() {
 	// Per-packet tracing is intentionally separate from -debug (it is extremely
 	// verbose and can fill the disk). It starts in the state requested by
 	// -trace-packets and can be flipped on/off at runtime, without restarting
-	// the network stack, by sending SIGUSR1.
+	// the network stack, through the control API (`vm-switch ctl`) or by
+	// sending SIGUSR1.
 	//
 	// vm-switch runs in the top-level WSL (init) PID namespace, so the signal
 	// must be sent from there, e.g. from the Windows host:
 	//   wsl -d rancher-desktop --exec sh -c 'kill -USR1 $(pgrep vm-switch)'
 	// It is NOT reachable via `rdctl shell`, which enters the Rancher Desktop
-	// network namespace where vm-switch is not visible (pgrep finds nothing).
+	// network namespace where vm-switch is not visible (pgrep finds nothing);
+	// the control socket in /run is reachable from both.
 	tracePackets.Store(traceFlag)
 	traceSigCh := make(chan os.Signal, 1)
 	signal.Notify(traceSigCh, syscall.SIGUSR1)
@@ -106,6 +190,36 @@ func main() {
 		}
 	}()
 
+	if captureConfig.File != "" || captureConfig.Socket != "" {
+		captureConfig.Interface = tapIface
+		var err error
+		packetCapture, err = capture.New(captureConfig)
+		if err != nil {
+			logrus.Fatalf("setting up packet capture failed: %s", err)
+		}
+		if err := packetCapture.SetEnabled(captureFlag); err != nil {
+			logrus.Fatalf("starting packet capture failed: %s", err)
+		}
+	} else if captureFlag {
+		logrus.Fatal("-capture requires -capture-file or -capture-socket")
+	}
+	// Like tracing, the capture can be flipped on/off at runtime through the
+	// control API or by sending SIGUSR2; the capture file is closed while it
+	// is off.
+	captureSigCh := make(chan os.Signal, 1)
+	signal.Notify(captureSigCh, syscall.SIGUSR2)
+	go func() {
+		for range captureSigCh {
+			if packetCapture == nil {
+				logrus.Warn("SIGUSR2 received: packet capture is not configured")
+				continue
+			}
+			if err := packetCapture.SetEnabled(!packetCapture.Enabled()); err != nil {
+				logrus.Errorf("SIGUSR2 received: toggling packet capture failed: %s", err)
+			}
+		}
+	}()
+
 	// the FD is passed-in as an extra arg from exec.Command
 	// of the parent process. This is for the AF_VSOCK connection that
 	// is handed over from the default namespace to Rancher Desktop's
@@ -121,19 +235,36 @@ func main() {
 		logrus.Fatal(err)
 	}
 
+	controlCtx, stopControl := context.WithCancel(context.Background())
+	if controlSocket != "" {
+		server := control.New(controlCtx, controlSocket, &counters, &tracePackets, packetCapture, impairment, logrus.StandardLogger())
+		go func() {
+			if err := server.Serve(); err != nil {
+				logrus.Errorf("control API failed: %s", err)
+			}
+		}()
+	}
+
 	// catch user issued signals
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 
-	for {
+	for attempt := 0; ; attempt++ {
 		ctx, cancel := context.WithCancel(context.Background())
 		select {
 		case s := <-sigChan:
 			logrus.Errorf("signal caught: %v", s)
 			cancel()
+			stopControl()
 			connFile.Close()
+			if err := packetCapture.Close(); err != nil {
+				logrus.Errorf("closing packet capture failed: %s", err)
+			}
 			os.Exit(1)
 		default:
+			if attempt > 0 {
+				counters.Reconnects.Add(1)
+			}
 			if err := run(ctx, cancel, connFile); err != nil {
 				logrus.Error(err)
 			}
@@ -161,18 +292,31 @@ func run(ctx context.Context, cancel context.CancelFunc, connFile io.ReadWriteCl
 		logrus.Debugf("closed tap device: %s", tapIface)
 	}()
 
-	if err := linkUp(tapIface, tapDeviceMacAddr); err != nil {
-		logrus.Fatalf("setting mac address [%s] for %s tap device failed: %s", tapDeviceMacAddr, tapIface, err)
+	if err := linkUp(tapIface, tapDeviceMacAddr, mtu); err != nil {
+		logrus.Fatalf("setting mac address [%s] and MTU %d for %s tap device failed: %s", tapDeviceMacAddr, mtu, tapIface, err)
 	}
 	if err := loopbackUp(); err != nil {
 		logrus.Fatalf("enabling loop back device failed: %s", err)
 	}
 
+	if tapIP6 != "" {
+		if err := addressUp6(tapIface, tapIP6); err != nil {
+			logrus.Fatalf("assigning IPv6 address %s to %s tap device failed: %s", tapIP6, tapIface, err)
+		}
+	}
+
 	logrus.Debugf("setup complete for tap interface %s(%s) + loopback", tapIface, tapDeviceMacAddr)
 
 	errCh := make(chan error, 1)
-	go tx(ctx, connFile, tap, errCh, maxMTU)
-	go rx(ctx, connFile, tap, errCh, maxMTU)
+	// The frames go through the impairment links, which deliver them right
+	// away unless an -impair rule delays them.
+	toHost := impairment.NewLink(ctx, impair.VMToHost, writeToHost(connFile))
+	toVM := impairment.NewLink(ctx, impair.HostToVM, writeToVM(tap))
+	go tx(ctx, connFile, toVM, errCh, mtu)
+	go rx(ctx, toHost, tap, errCh, mtu)
+	if ipv6Responder != nil {
+		go advertise(ctx, tap)
+	}
 	go func() {
 		if err := dhcp(ctx, tapIface); err != nil {
 			errCh <- fmt.Errorf("dhcp error: %w", err)
@@ -192,11 +336,14 @@ func loopbackUp() error {
 	return netlink.LinkSetUp(lo)
 }
 
-func linkUp(iface, mac string) error {
+func linkUp(iface, mac string, mtu int) error {
 	link, err := netlink.LinkByName(iface)
 	if err != nil {
 		return err
 	}
+	if err := netlink.LinkSetMTU(link, mtu); err != nil {
+		return err
+	}
 	if mac == "" {
 		return netlink.LinkSetUp(link)
 	}
@@ -212,6 +359,40 @@ func linkUp(iface, mac string) error {
 	return netlink.LinkSetUp(link)
 }
 
+// addressUp6 assigns the static IPv6 address of the tap device; it is the
+// one the DHCPv6 lease holds, assigned directly as udhcpc is IPv4-only.
+func addressUp6(iface, ip string) error {
+	link, err := netlink.LinkByName(iface)
+	if err != nil {
+		return err
+	}
+	return netlink.AddrAdd(link, &netlink.Addr{
+		IPNet: &net.IPNet{IP: net.ParseIP(ip), Mask: net.CIDRMask(64, 128)},
+		// The address is leased to the tap device only.
+		Flags: unix.IFA_F_NODAD,
+	})
+}
+
+// advertise sends the IPv6 router advertisements of the gateway to the
+// tap device until ctx is done; the solicited ones are sent by rx.
+func advertise(ctx context.Context, tap io.Writer) {
+	ticker := time.NewTicker(ipv6.AdvertisementInterval)
+	defer ticker.Stop()
+	for {
+		frame, err := ipv6Responder.Advertisement()
+		if err != nil {
+			logrus.Errorf("building IPv6 router advertisement failed: %s", err)
+		} else if _, err := tap.Write(frame); err != nil {
+			logrus.Errorf("sending IPv6 router advertisement failed: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func dhcp(ctx context.Context, iface string) error {
 	args := []string{"-f", "-i", iface}
 	if dhcpScript != "" {
@@ -223,12 +404,9 @@ func dhcp(ctx context.Context, iface string) error {
 	return cmd.Run()
 }
 
-func rx(ctx context.Context, conn io.Writer, tap *water.Interface, errCh chan error, mtu int) {
+// rx reads the frames from tap and sends them to the host through link.
+func rx(ctx context.Context, link *impair.Link, tap *water.Interface, errCh chan error, mtu int) {
 	logrus.Info("waiting for packets...")
-	if mtu > math.MaxUint16 {
-		errCh <- fmt.Errorf("invalid MTU %d", mtu)
-		return
-	}
 	var frame ethernet.Frame
 	for {
 		select {
@@ -236,35 +414,71 @@ func rx(ctx context.Context, conn io.Writer, tap *water.Interface, errCh chan er
 			logrus.Info("exiting rx goroutine")
 			return
 		default:
-			frame.Resize(mtu)
+			// The buffer holds the largest frame the host connection
+			// can carry, so that the frames exceeding the MTU are
+			// counted rather than truncated.
+			frame.Resize(math.MaxUint16)
 			n, err := tap.Read([]byte(frame))
 			if err != nil {
 				errCh <- fmt.Errorf("reading packet from tap failed: %w", err)
 				return
 			}
 			frame = frame[:n]
+			if n > mtu+header.EthernetMinimumSize {
+				counters.MTUExceeded.Add(1)
+			}
 
-			size := make([]byte, 2)
-			binary.LittleEndian.PutUint16(size, uint16(n))
-
-			if _, err := conn.Write(size); err != nil {
-				errCh <- fmt.Errorf("writing size to the socket failed: %w", err)
-				return
+			// The IPv6 configuration requests are answered here, as the
+			// host-switch does not handle IPv6.
+			if reply, handled := ipv6Responder.Reply(frame); handled {
+				if reply != nil {
+					if _, err := tap.Write(reply); err != nil {
+						errCh <- fmt.Errorf("writing IPv6 reply to tap failed: %w", err)
+						return
+					}
+				}
+				continue
 			}
-			if _, err := conn.Write(frame); err != nil {
-				errCh <- fmt.Errorf("writing packet to the socket failed: %w", err)
+
+			mss.Clamp(frame, mtu)
+			if err := link.Send(frame); err != nil {
+				errCh <- err
 				return
 			}
+		}
+	}
+}
 
-			if tracePackets.Load() {
-				packet := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.Default)
-				logrus.Infof("wrote packet (vm -> host %d): %s", n, packet.String())
-			}
+// writeToHost returns the delivery function of the frames from the VM to
+// the host, over conn.
+func writeToHost(conn io.Writer) func([]byte) error {
+	size := make([]byte, 2)
+	return func(frame []byte) error {
+		n := len(frame)
+		binary.LittleEndian.PutUint16(size, uint16(n))
+
+		if _, err := conn.Write(size); err != nil {
+			counters.VMToHost.Drop()
+			return fmt.Errorf("writing size to the socket failed: %w", err)
+		}
+		if _, err := conn.Write(frame); err != nil {
+			counters.VMToHost.Drop()
+			return fmt.Errorf("writing packet to the socket failed: %w", err)
 		}
+		counters.VMToHost.Add(n)
+
+		packetCapture.Packet(capture.VMToHost, frame)
+		if tracePackets.Load() {
+			packet := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.Default)
+			logrus.Infof("wrote packet (vm -> host %d): %s", n, packet.String())
+		}
+		return nil
 	}
 }
 
-func tx(ctx context.Context, conn io.Reader, tap *water.Interface, errCh chan error, mtu int) {
+// tx reads the frames from the host on conn and sends them to the VM
+// through link.
+func tx(ctx context.Context, conn io.Reader, link *impair.Link, errCh chan error, mtu int) {
 	sizeBuf := make([]byte, 2)
 	buf := make([]byte, mtu+header.EthernetMinimumSize)
 
@@ -284,6 +498,9 @@ func tx(ctx context.Context, conn io.Reader, tap *water.Interface, errCh chan er
 				return
 			}
 			size := int(binary.LittleEndian.Uint16(sizeBuf[0:2]))
+			if size > mtu+header.EthernetMinimumSize {
+				counters.MTUExceeded.Add(1)
+			}
 
 			if cap(buf) < size {
 				buf = make([]byte, size)
@@ -299,16 +516,32 @@ func tx(ctx context.Context, conn io.Reader, tap *water.Interface, errCh chan er
 				return
 			}
 
-			if _, err := tap.Write(buf[:size]); err != nil {
-				errCh <- fmt.Errorf("writing packet to tap failed: %w", err)
+			mss.Clamp(buf[:size], mtu)
+			if err := link.Send(buf[:size]); err != nil {
+				errCh <- err
 				return
 			}
+		}
+	}
+}
 
-			if tracePackets.Load() {
-				packet := gopacket.NewPacket(buf[:size], layers.LayerTypeEthernet, gopacket.Default)
-				logrus.Infof("read packet (host -> vm %d): %s", size, packet.String())
-			}
+// writeToVM returns the delivery function of the frames from the host to
+// the VM, through tap.
+func writeToVM(tap io.Writer) func([]byte) error {
+	return func(frame []byte) error {
+		size := len(frame)
+		if _, err := tap.Write(frame); err != nil {
+			counters.HostToVM.Drop()
+			return fmt.Errorf("writing packet to tap failed: %w", err)
+		}
+		counters.HostToVM.Add(size)
+
+		packetCapture.Packet(capture.HostToVM, frame)
+		if tracePackets.Load() {
+			packet := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.Default)
+			logrus.Infof("read packet (host -> vm %d): %s", size, packet.String())
 		}
+		return nil
 	}
 }
 
@@ -0,0 +1,94 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package batch serves the batch endpoints of the port forwarding API,
+// which apply many expose or unexpose requests in a single call.
+package batch
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
+)
+
+// maxRequests bounds the number of requests of a single batch.
+const maxRequests = 1024
+
+// NewHandler returns a handler that takes a JSON array of requests, passes
+// each of them to single as a POST to path, and replies with a JSON array
+// of types.BatchResult in the same order. The requests are applied one
+// after the other, as the port forwarder serializes them anyway.
+func NewHandler(single http.Handler, path string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "post only", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var reqs []json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(reqs) > maxRequests {
+			http.Error(w, "too many requests in batch", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		results := make([]types.BatchResult, len(reqs))
+		for i, req := range reqs {
+			singleReq, err := http.NewRequestWithContext(r.Context(), http.MethodPost, path, bytes.NewReader(req))
+			if err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+			recorder := &responseRecorder{header: make(http.Header), status: http.StatusOK}
+			single.ServeHTTP(recorder, singleReq)
+			if recorder.status != http.StatusOK {
+				results[i].Error = strings.TrimSpace(recorder.body.String())
+				logrus.Debugf("batch %s request %s failed: %s", path, req, results[i].Error)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(results); err != nil {
+			logrus.Errorf("writing batch %s response failed: %s", path, err)
+		}
+	})
+}
+
+// responseRecorder keeps the response of a single request of a batch.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+}
@@ -0,0 +1,73 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package batch_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/batch"
+)
+
+func TestHandler(t *testing.T) {
+	var paths []string
+	single := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		var req map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req["local"] == "127.0.0.1:80" {
+			http.Error(w, "address already in use", http.StatusInternalServerError)
+		}
+	})
+	handler := batch.NewHandler(single, "/services/forwarder/expose")
+
+	body := `[{"local": "127.0.0.1:8080"}, {"local": "127.0.0.1:80"}, {"local": "127.0.0.1:8081"}]`
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, types.ExposeBatchAPI, strings.NewReader(body)))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var results []types.BatchResult
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&results))
+	require.Equal(t, []types.BatchResult{{}, {Error: "address already in use"}, {}}, results)
+	require.Equal(t, []string{
+		"/services/forwarder/expose",
+		"/services/forwarder/expose",
+		"/services/forwarder/expose",
+	}, paths)
+}
+
+func TestHandlerInvalid(t *testing.T) {
+	single := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		t.Error("unexpected single request")
+	})
+	handler := batch.NewHandler(single, "/services/forwarder/expose")
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, types.ExposeBatchAPI, nil))
+	require.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, types.ExposeBatchAPI, strings.NewReader(`{"local": "127.0.0.1:80"}`)))
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+}
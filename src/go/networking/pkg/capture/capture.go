@@ -0,0 +1,445 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package capture writes pcapng captures of the Ethernet frames that
+// cross the vm-switch, to a rotated file and to the clients of a Unix
+// socket, such as a live Wireshark session.
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/rotate"
+)
+
+// Direction is the way a frame crosses the vm-switch. Each direction is a
+// separate interface of the capture, so that Wireshark can tell them apart
+// through frame.interface_id.
+type Direction int
+
+const (
+	// VMToHost is a frame read from the tap device and sent to the host.
+	VMToHost Direction = iota
+	// HostToVM is a frame received from the host and written to the tap
+	// device.
+	HostToVM
+)
+
+const (
+	// queueLength bounds the frames waiting to be written; the frames
+	// captured while the queue is full are dropped rather than slowing
+	// the network down.
+	queueLength = 1024
+	// clientWriteTimeout bounds writing to a socket client, which is
+	// disconnected when it does not keep up.
+	clientWriteTimeout = time.Second
+)
+
+// Config configures a Capture.
+type Config struct {
+	// Interface is the name of the tap device, used to name the
+	// interfaces of the capture.
+	Interface string
+	// File is the path of the capture file; empty disables it.
+	File string
+	// MaxSize is the size in bytes from which the capture file is
+	// rotated.
+	MaxSize int64
+	// MaxFiles is the number of rotated capture files to keep.
+	MaxFiles int
+	// Socket is the path of a Unix socket that streams the capture to
+	// each client that connects; empty disables it.
+	Socket string
+	// Filter is the capture filter expression, see ParseFilter.
+	Filter string
+	// SnapLength truncates the captured frames; 0 captures them whole.
+	SnapLength int
+}
+
+type frame struct {
+	direction Direction
+	timestamp time.Time
+	length    int
+	data      []byte
+}
+
+// Capture writes the frames passed to Packet while it is enabled. A nil
+// Capture discards them.
+type Capture struct {
+	config   Config
+	enabled  atomic.Bool
+	filter   atomic.Pointer[Filter]
+	queue    chan frame
+	dropped  atomic.Uint64
+	listener net.Listener
+	// stop ends the goroutine writing the frames, which closes done.
+	stop chan struct{}
+	done chan struct{}
+
+	mutex sync.Mutex
+	// file is the capture file while the capture is enabled.
+	file *captureFile
+	// clients are the connections to the socket.
+	clients map[net.Conn]*pcapgo.NgWriter
+	closed  bool
+}
+
+var ErrNoOutput = errors.New("a capture needs a file or a socket")
+
+// New creates a capture that is disabled until SetEnabled is called. It
+// listens on the socket of config right away.
+func New(config Config) (*Capture, error) {
+	if config.File == "" && config.Socket == "" {
+		return nil, ErrNoOutput
+	}
+	filter, err := ParseFilter(config.Filter)
+	if err != nil {
+		return nil, err
+	}
+	c := &Capture{
+		config:  config,
+		queue:   make(chan frame, queueLength),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		clients: make(map[net.Conn]*pcapgo.NgWriter),
+	}
+	c.filter.Store(filter)
+
+	if config.Socket != "" {
+		if err := os.Remove(config.Socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("removing capture socket failed: %w", err)
+		}
+		c.listener, err = net.Listen("unix", config.Socket)
+		if err != nil {
+			return nil, fmt.Errorf("listening on capture socket failed: %w", err)
+		}
+		go c.accept()
+	}
+	go c.write()
+	return c, nil
+}
+
+// Enabled reports whether the frames are captured.
+func (c *Capture) Enabled() bool {
+	return c != nil && c.enabled.Load()
+}
+
+// SetEnabled starts or stops capturing. Starting opens the capture file,
+// appending a new pcapng section if it exists; stopping closes it.
+func (c *Capture) SetEnabled(enabled bool) error {
+	if c == nil {
+		return ErrNoOutput
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	if enabled && c.file == nil && c.config.File != "" {
+		file, err := openCaptureFile(c.config, c.interfaces())
+		if err != nil {
+			return err
+		}
+		c.file = file
+	}
+	if !enabled && c.file != nil {
+		if err := c.file.Close(); err != nil {
+			logrus.Errorf("closing capture file failed: %s", err)
+		}
+		c.file = nil
+	}
+	c.enabled.Store(enabled)
+	logrus.Infof("packet capture is now %t", enabled)
+	return nil
+}
+
+// Filter returns the current capture filter.
+func (c *Capture) Filter() *Filter {
+	if c == nil {
+		return nil
+	}
+	return c.filter.Load()
+}
+
+// SetFilter replaces the capture filter with expr; the filter is kept if
+// expr is invalid.
+func (c *Capture) SetFilter(expr string) error {
+	if c == nil {
+		return ErrNoOutput
+	}
+	filter, err := ParseFilter(expr)
+	if err != nil {
+		return err
+	}
+	c.filter.Store(filter)
+	return nil
+}
+
+// Dropped returns the number of frames dropped because the capture did
+// not keep up.
+func (c *Capture) Dropped() uint64 {
+	if c == nil {
+		return 0
+	}
+	return c.dropped.Load()
+}
+
+// Packet captures a copy of data, if the capture is enabled and the
+// frame matches the filter. It never blocks. The filter sees the whole
+// frame, before it is cut to the snap length, so that it can still match
+// on the ports and the inner addresses.
+func (c *Capture) Packet(direction Direction, data []byte) {
+	if !c.Enabled() || !c.Filter().Match(data) {
+		return
+	}
+	length := len(data)
+	if c.config.SnapLength > 0 && len(data) > c.config.SnapLength {
+		data = data[:c.config.SnapLength]
+	}
+	f := frame{
+		direction: direction,
+		timestamp: time.Now(),
+		length:    length,
+		data:      append([]byte(nil), data...),
+	}
+	select {
+	case c.queue <- f:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// Close stops the capture and closes the file, the socket and its
+// clients.
+func (c *Capture) Close() error {
+	if c == nil {
+		return nil
+	}
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.enabled.Store(false)
+	c.mutex.Unlock()
+
+	var errs []error
+	if c.listener != nil {
+		errs = append(errs, c.listener.Close())
+	}
+	close(c.stop)
+	<-c.done
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.file != nil {
+		errs = append(errs, c.file.Close())
+		c.file = nil
+	}
+	for conn := range c.clients {
+		_ = conn.Close()
+		delete(c.clients, conn)
+	}
+	return errors.Join(errs...)
+}
+
+// interfaces returns the pcapng interfaces of the directions, in the order
+// of their values.
+func (c *Capture) interfaces() []pcapgo.NgInterface {
+	names := map[Direction]string{
+		VMToHost: "vm -> host",
+		HostToVM: "host -> vm",
+	}
+	interfaces := make([]pcapgo.NgInterface, len(names))
+	for direction, description := range names {
+		intf := pcapgo.DefaultNgInterface
+		intf.Name = fmt.Sprintf("%s-%d", c.config.Interface, direction)
+		intf.Description = fmt.Sprintf("%s (%s)", c.config.Interface, description)
+		intf.Filter = c.Filter().String()
+		intf.LinkType = layers.LinkTypeEthernet
+		intf.SnapLength = uint32(max(c.config.SnapLength, 0))
+		interfaces[direction] = intf
+	}
+	return interfaces
+}
+
+// newWriter writes the section header and the interfaces of a capture to w.
+func newWriter(w io.Writer, interfaces []pcapgo.NgInterface) (*pcapgo.NgWriter, error) {
+	options := pcapgo.DefaultNgWriterOptions
+	options.SectionInfo.Application = "rancher-desktop vm-switch"
+	writer, err := pcapgo.NewNgWriterInterface(w, interfaces[0], options)
+	if err != nil {
+		return nil, err
+	}
+	for _, intf := range interfaces[1:] {
+		if _, err := writer.AddInterface(intf); err != nil {
+			return nil, err
+		}
+	}
+	return writer, writer.Flush()
+}
+
+func (c *Capture) accept() {
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logrus.Errorf("accepting capture client failed: %s", err)
+			}
+			return
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+		writer, err := newWriter(conn, c.interfaces())
+		if err != nil {
+			logrus.Errorf("writing capture header to client failed: %s", err)
+			conn.Close()
+			continue
+		}
+		c.mutex.Lock()
+		if c.closed {
+			c.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		c.clients[conn] = writer
+		c.mutex.Unlock()
+		logrus.Info("capture client connected")
+	}
+}
+
+// write writes the queued frames until the capture is closed, and then
+// the frames still queued.
+func (c *Capture) write() {
+	defer close(c.done)
+	for {
+		select {
+		case f := <-c.queue:
+			c.writeFrame(f)
+		case <-c.stop:
+			for {
+				select {
+				case f := <-c.queue:
+					c.writeFrame(f)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *Capture) writeFrame(f frame) {
+	ci := gopacket.CaptureInfo{
+		Timestamp:      f.timestamp,
+		CaptureLength:  len(f.data),
+		Length:         f.length,
+		InterfaceIndex: int(f.direction),
+	}
+
+	c.mutex.Lock()
+	if c.file != nil {
+		if err := c.file.WritePacket(ci, f.data); err != nil {
+			logrus.Errorf("writing capture file failed: %s", err)
+		}
+	}
+	for conn, writer := range c.clients {
+		_ = conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+		err := writer.WritePacket(ci, f.data)
+		if err == nil {
+			err = writer.Flush()
+		}
+		if err != nil {
+			logrus.Infof("capture client disconnected: %s", err)
+			conn.Close()
+			delete(c.clients, conn)
+		}
+	}
+	c.mutex.Unlock()
+}
+
+// captureFile is a capture file rotated once it reaches its maximum size.
+// Each file starts with a new pcapng section.
+type captureFile struct {
+	config     Config
+	interfaces []pcapgo.NgInterface
+	file       *rotate.File
+	writer     *pcapgo.NgWriter
+	// packets is the number of packets in the current file.
+	packets int
+}
+
+func openCaptureFile(config Config, interfaces []pcapgo.NgInterface) (*captureFile, error) {
+	file, err := rotate.Open(config.File, config.MaxSize, config.MaxFiles)
+	if err != nil {
+		return nil, fmt.Errorf("opening capture file failed: %w", err)
+	}
+	f := &captureFile{config: config, interfaces: interfaces, file: file}
+	if err := f.newSection(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// newSection writes the section header and the interfaces to the file.
+func (f *captureFile) newSection() error {
+	f.packets = 0
+	writer, err := newWriter(f.file, f.interfaces)
+	if err != nil {
+		return fmt.Errorf("writing capture file header failed: %w", err)
+	}
+	f.writer = writer
+	return nil
+}
+
+func (f *captureFile) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
+	if f.config.MaxSize > 0 && f.file.Size() >= f.config.MaxSize && f.packets > 0 {
+		if err := f.rotate(); err != nil {
+			return fmt.Errorf("rotating capture file failed: %w", err)
+		}
+	}
+	if err := f.writer.WritePacket(ci, data); err != nil {
+		return err
+	}
+	f.packets++
+	return f.writer.Flush()
+}
+
+// rotate moves the current file to the first backup and starts a new one.
+func (f *captureFile) rotate() error {
+	if err := f.writer.Flush(); err != nil {
+		return err
+	}
+	err := f.file.Rotate()
+	return errors.Join(err, f.newSection())
+}
+
+func (f *captureFile) Close() error {
+	flushErr := f.writer.Flush()
+	return errors.Join(flushErr, f.file.Close())
+}
@@ -0,0 +1,314 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package capture_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/capture"
+)
+
+func udpFrame(t *testing.T, srcIP, dstIP string, srcPort, dstPort uint16) []byte {
+	t.Helper()
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x5a, 0x94, 0xef, 0xe4, 0x0c, 0xee},
+		DstMAC:       net.HardwareAddr{0x5a, 0x94, 0xef, 0xe4, 0x0c, 0xdd},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.ParseIP(srcIP).To4(),
+		DstIP:    net.ParseIP(dstIP).To4(),
+	}
+	udp := &layers.UDP{SrcPort: layers.UDPPort(srcPort), DstPort: layers.UDPPort(dstPort)}
+	require.NoError(t, udp.SetNetworkLayerForChecksum(ip))
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload("payload")))
+	return buf.Bytes()
+}
+
+func arpFrame(t *testing.T, srcIP, dstIP string) []byte {
+	t.Helper()
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x5a, 0x94, 0xef, 0xe4, 0x0c, 0xee},
+		DstMAC:       layers.EthernetBroadcast,
+		EthernetType: layers.EthernetTypeARP,
+	}
+	arp := &layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   eth.SrcMAC,
+		SourceProtAddress: net.ParseIP(srcIP).To4(),
+		DstHwAddress:      make([]byte, 6),
+		DstProtAddress:    net.ParseIP(dstIP).To4(),
+	}
+	buf := gopacket.NewSerializeBuffer()
+	require.NoError(t, gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, eth, arp))
+	return buf.Bytes()
+}
+
+func TestFilter(t *testing.T) {
+	dns := udpFrame(t, "192.168.127.2", "192.168.127.1", 40000, 53)
+	other := udpFrame(t, "10.0.0.5", "192.168.127.2", 8080, 40001)
+	arp := arpFrame(t, "192.168.127.2", "192.168.127.1")
+
+	tests := map[string][3]bool{
+		"":                                  {true, true, true},
+		"udp":                               {true, true, false},
+		"arp":                               {false, false, true},
+		"udp port 53":                       {true, false, false},
+		"dst port 53":                       {true, false, false},
+		"src port 53":                       {false, false, false},
+		"portrange 8000-8999":               {false, true, false},
+		"host 192.168.127.1":                {true, false, true},
+		"src host 192.168.127.1":            {false, false, false},
+		"net 10.0.0.0/8":                    {false, true, false},
+		"not arp and not port 53":           {false, true, false},
+		"arp or (udp and dst port 53)":      {true, false, true},
+		"! ( udp || arp )":                  {false, false, false},
+		"dst net 192.168.0.0/16 && tcp":     {false, false, false},
+		"ip and src net 192.168.127.0/24":   {true, false, false},
+		"ip6 or icmp or icmp6 or sctp":      {false, false, false},
+		"udp and (port 53 or port 40001)":   {true, true, false},
+		"not (src host 10.0.0.5)":           {true, false, true},
+		"dst host 192.168.127.1 and not ip": {false, false, true},
+	}
+	for expr, want := range tests {
+		filter, err := capture.ParseFilter(expr)
+		require.NoError(t, err, expr)
+		require.Equal(t, expr, filter.String())
+		require.Equal(t, want, [3]bool{filter.Match(dns), filter.Match(other), filter.Match(arp)}, expr)
+	}
+
+	for _, expr := range []string{
+		"tcp and",
+		"(udp",
+		"udp)",
+		"port http",
+		"port 65536",
+		"portrange 10-5",
+		"host example.com",
+		"net 10.0.0.0",
+		"src tcp",
+		"vlan 10",
+	} {
+		_, err := capture.ParseFilter(expr)
+		require.ErrorIs(t, err, capture.ErrInvalidFilter, expr)
+	}
+}
+
+// readCapture reads the packets of a pcapng stream.
+func readCapture(t *testing.T, r io.Reader, count int) ([]gopacket.CaptureInfo, [][]byte) {
+	t.Helper()
+	reader, err := pcapgo.NewNgReader(r, pcapgo.DefaultNgReaderOptions)
+	require.NoError(t, err)
+	var infos []gopacket.CaptureInfo
+	var packets [][]byte
+	for len(packets) < count {
+		data, ci, err := reader.ReadPacketData()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		infos = append(infos, ci)
+		packets = append(packets, data)
+	}
+	return infos, packets
+}
+
+func TestCaptureFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "capture.pcapng")
+	c, err := capture.New(capture.Config{
+		Interface: "eth0",
+		File:      path,
+		MaxSize:   1 << 20,
+		MaxFiles:  1,
+		Filter:    "udp",
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	dns := udpFrame(t, "192.168.127.2", "192.168.127.1", 40000, 53)
+	reply := udpFrame(t, "192.168.127.1", "192.168.127.2", 53, 40000)
+
+	// Nothing is captured until the capture is enabled.
+	c.Packet(capture.VMToHost, dns)
+	require.NoFileExists(t, path)
+
+	require.NoError(t, c.SetEnabled(true))
+	c.Packet(capture.VMToHost, dns)
+	c.Packet(capture.HostToVM, arpFrame(t, "192.168.127.1", "192.168.127.2"))
+	c.Packet(capture.HostToVM, reply)
+	require.NoError(t, c.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	infos, packets := readCapture(t, file, 3)
+	require.Equal(t, [][]byte{dns, reply}, packets)
+	require.Equal(t, int(capture.VMToHost), infos[0].InterfaceIndex)
+	require.Equal(t, int(capture.HostToVM), infos[1].InterfaceIndex)
+	require.Zero(t, c.Dropped())
+}
+
+// The filter matches the whole frame, even when the snap length cuts it
+// before its ports.
+func TestCaptureFilterBeforeSnapLength(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "capture.pcapng")
+	c, err := capture.New(capture.Config{
+		Interface:  "eth0",
+		File:       path,
+		MaxSize:    1 << 20,
+		MaxFiles:   1,
+		Filter:     "udp port 53",
+		SnapLength: 20,
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	dns := udpFrame(t, "192.168.127.2", "192.168.127.1", 40000, 53)
+	require.NoError(t, c.SetEnabled(true))
+	c.Packet(capture.VMToHost, dns)
+	c.Packet(capture.VMToHost, udpFrame(t, "192.168.127.2", "192.168.127.1", 40000, 123))
+	require.NoError(t, c.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	infos, packets := readCapture(t, file, 2)
+	require.Equal(t, [][]byte{dns[:20]}, packets)
+	require.Equal(t, len(dns), infos[0].Length)
+}
+
+// The interfaces record the filter set through SetFilter rather than the
+// one the capture started with.
+func TestCaptureFileRecordsFilter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "capture.pcapng")
+	c, err := capture.New(capture.Config{
+		Interface: "eth0",
+		File:      path,
+		MaxSize:   1 << 20,
+		MaxFiles:  1,
+		Filter:    "udp",
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.NoError(t, c.SetFilter("udp port 53"))
+	require.NoError(t, c.SetEnabled(true))
+	c.Packet(capture.VMToHost, udpFrame(t, "192.168.127.2", "192.168.127.1", 40000, 53))
+	require.NoError(t, c.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	reader, err := pcapgo.NewNgReader(file, pcapgo.DefaultNgReaderOptions)
+	require.NoError(t, err)
+	_, _, err = reader.ReadPacketData()
+	require.NoError(t, err)
+	require.Equal(t, 2, reader.NInterfaces())
+	for i := range reader.NInterfaces() {
+		intf, err := reader.Interface(i)
+		require.NoError(t, err)
+		require.Equal(t, "udp port 53", intf.Filter)
+	}
+}
+
+func TestCaptureRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "capture.pcapng")
+	frame := udpFrame(t, "192.168.127.2", "192.168.127.1", 40000, 53)
+	c, err := capture.New(capture.Config{
+		Interface:  "eth0",
+		File:       path,
+		MaxSize:    1,
+		MaxFiles:   2,
+		SnapLength: 20,
+	})
+	require.NoError(t, err)
+	require.NoError(t, c.SetEnabled(true))
+	for range 4 {
+		c.Packet(capture.VMToHost, frame)
+	}
+	require.NoError(t, c.Close())
+
+	// Each file holds a single packet, the oldest one is dropped.
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		file, err := os.Open(name)
+		require.NoError(t, err)
+		infos, packets := readCapture(t, file, 2)
+		file.Close()
+		require.Len(t, packets, 1, name)
+		require.Equal(t, frame[:20], packets[0])
+		require.Equal(t, len(frame), infos[0].Length)
+	}
+	require.NoFileExists(t, path+".3")
+}
+
+func TestCaptureSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "capture.sock")
+	c, err := capture.New(capture.Config{Interface: "eth0", Socket: socket})
+	require.NoError(t, err)
+	defer c.Close()
+	require.NoError(t, c.SetEnabled(true))
+
+	conn, err := net.Dial("unix", socket)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	frame := udpFrame(t, "192.168.127.2", "192.168.127.1", 40000, 53)
+	reader, err := pcapgo.NewNgReader(conn, pcapgo.DefaultNgReaderOptions)
+	require.NoError(t, err)
+	// The client is registered once its header is written.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+				c.Packet(capture.HostToVM, frame)
+			}
+		}
+	}()
+	data, ci, err := reader.ReadPacketData()
+	done <- struct{}{}
+	<-done
+	require.NoError(t, err)
+	require.Equal(t, frame, data)
+	require.Equal(t, int(capture.HostToVM), ci.InterfaceIndex)
+}
@@ -0,0 +1,332 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var ErrInvalidFilter = errors.New("invalid capture filter")
+
+// Filter is a compiled capture filter expression. A nil Filter matches
+// every packet.
+type Filter struct {
+	expr  string
+	match func(gopacket.Packet) bool
+}
+
+// String returns the expression the filter was compiled from.
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.expr
+}
+
+// Match reports whether the Ethernet frame matches the filter.
+func (f *Filter) Match(frame []byte) bool {
+	if f == nil {
+		return true
+	}
+	return f.match(gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.DecodeOptions{Lazy: true, NoCopy: true}))
+}
+
+// ParseFilter compiles a filter expression in a subset of the tcpdump
+// syntax:
+//
+//	arp, ip, ip6, tcp, udp, sctp, icmp, icmp6
+//	[src|dst] host <address>
+//	[src|dst] net <cidr>
+//	[src|dst] port <port>
+//	[src|dst] portrange <first>-<last>
+//
+// combined with "and" (or "&&"), "or" (or "||"), "not" (or "!") and
+// parentheses; adjacent primitives, as in "udp port 53", are and-ed. An
+// empty expression returns a nil Filter.
+func ParseFilter(expr string) (*Filter, error) {
+	tokens := tokenize(expr)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	p := &parser{tokens: tokens}
+	match, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrInvalidFilter, expr, err)
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("%w %q: unexpected %q", ErrInvalidFilter, expr, p.peek())
+	}
+	return &Filter{expr: expr, match: match}, nil
+}
+
+func tokenize(expr string) []string {
+	for _, symbol := range []string{"(", ")", "!"} {
+		expr = strings.ReplaceAll(expr, symbol, " "+symbol+" ")
+	}
+	return strings.Fields(expr)
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *parser) done() bool {
+	return p.pos == len(p.tokens)
+}
+
+func (p *parser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() (string, error) {
+	if p.done() {
+		return "", errors.New("unexpected end of expression")
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	return token, nil
+}
+
+func (p *parser) parseOr() (func(gopacket.Packet) bool, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" || p.peek() == "||" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = or(left, right)
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (func(gopacket.Packet) bool, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for !p.done() && p.peek() != "or" && p.peek() != "||" && p.peek() != ")" {
+		if p.peek() == "and" || p.peek() == "&&" {
+			p.pos++
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = and(left, right)
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (func(gopacket.Packet) bool, error) {
+	switch p.peek() {
+	case "not", "!":
+		p.pos++
+		match, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(packet gopacket.Packet) bool { return !match(packet) }, nil
+	case "(":
+		p.pos++
+		match, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if token, err := p.next(); err != nil || token != ")" {
+			return nil, errors.New("missing closing parenthesis")
+		}
+		return match, nil
+	}
+	return p.parsePrimitive()
+}
+
+// direction qualifies an address or a port.
+type direction int
+
+const (
+	srcOrDst direction = iota
+	src
+	dst
+)
+
+func (p *parser) parsePrimitive() (func(gopacket.Packet) bool, error) {
+	token, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if layerType, ok := protocols[token]; ok {
+		return func(packet gopacket.Packet) bool { return packet.Layer(layerType) != nil }, nil
+	}
+
+	dir := srcOrDst
+	switch token {
+	case "src":
+		dir = src
+	case "dst":
+		dir = dst
+	}
+	if dir != srcOrDst {
+		if token, err = p.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	switch token {
+	case "host":
+		value, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid host %q", value)
+		}
+		return matchIP(dir, func(candidate net.IP) bool { return ip.Equal(candidate) }), nil
+	case "net":
+		value, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid net %q", value)
+		}
+		return matchIP(dir, ipNet.Contains), nil
+	case "port":
+		value, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		port, err := parsePort(value)
+		if err != nil {
+			return nil, err
+		}
+		return matchPort(dir, port, port), nil
+	case "portrange":
+		value, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		firstValue, lastValue, ok := strings.Cut(value, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid port range %q", value)
+		}
+		first, err := parsePort(firstValue)
+		if err != nil {
+			return nil, err
+		}
+		last, err := parsePort(lastValue)
+		if err != nil {
+			return nil, err
+		}
+		if first > last {
+			return nil, fmt.Errorf("invalid port range %q", value)
+		}
+		return matchPort(dir, first, last), nil
+	}
+	return nil, fmt.Errorf("unexpected %q", token)
+}
+
+var protocols = map[string]gopacket.LayerType{
+	"arp":   layers.LayerTypeARP,
+	"ip":    layers.LayerTypeIPv4,
+	"ip6":   layers.LayerTypeIPv6,
+	"tcp":   layers.LayerTypeTCP,
+	"udp":   layers.LayerTypeUDP,
+	"sctp":  layers.LayerTypeSCTP,
+	"icmp":  layers.LayerTypeICMPv4,
+	"icmp6": layers.LayerTypeICMPv6,
+}
+
+func parsePort(value string) (uint16, error) {
+	port, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port %q", value)
+	}
+	return uint16(port), nil
+}
+
+func and(left, right func(gopacket.Packet) bool) func(gopacket.Packet) bool {
+	return func(packet gopacket.Packet) bool { return left(packet) && right(packet) }
+}
+
+func or(left, right func(gopacket.Packet) bool) func(gopacket.Packet) bool {
+	return func(packet gopacket.Packet) bool { return left(packet) || right(packet) }
+}
+
+// addresses returns the source and destination addresses of the IPv4,
+// IPv6 or ARP packet.
+func addresses(packet gopacket.Packet) (net.IP, net.IP, bool) {
+	switch network := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		return network.SrcIP, network.DstIP, true
+	case *layers.IPv6:
+		return network.SrcIP, network.DstIP, true
+	}
+	if arp, ok := packet.Layer(layers.LayerTypeARP).(*layers.ARP); ok {
+		return net.IP(arp.SourceProtAddress), net.IP(arp.DstProtAddress), true
+	}
+	return nil, nil, false
+}
+
+func matchIP(dir direction, match func(net.IP) bool) func(gopacket.Packet) bool {
+	return func(packet gopacket.Packet) bool {
+		srcIP, dstIP, ok := addresses(packet)
+		if !ok {
+			return false
+		}
+		return (dir != dst && match(srcIP)) || (dir != src && match(dstIP))
+	}
+}
+
+// ports returns the source and destination ports of the TCP, UDP or SCTP
+// packet.
+func ports(packet gopacket.Packet) (uint16, uint16, bool) {
+	switch transport := packet.TransportLayer().(type) {
+	case *layers.TCP:
+		return uint16(transport.SrcPort), uint16(transport.DstPort), true
+	case *layers.UDP:
+		return uint16(transport.SrcPort), uint16(transport.DstPort), true
+	case *layers.SCTP:
+		return uint16(transport.SrcPort), uint16(transport.DstPort), true
+	}
+	return 0, 0, false
+}
+
+func matchPort(dir direction, first, last uint16) func(gopacket.Packet) bool {
+	inRange := func(port uint16) bool { return port >= first && port <= last }
+	return func(packet gopacket.Packet) bool {
+		srcPort, dstPort, ok := ports(packet)
+		if !ok {
+			return false
+		}
+		return (dir != dst && inRange(srcPort)) || (dir != src && inRange(dstPort))
+	}
+}
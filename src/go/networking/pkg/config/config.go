@@ -16,6 +16,7 @@ package config
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -33,10 +34,43 @@ const (
 	// Reserved Mac Address for the tap device eth0 that
 	// is used by vm switch during the tap device
 	// creation.
-	TapDeviceMacAddr   = "5a:94:ef:e4:0c:ee"
+	TapDeviceMacAddr = "5a:94:ef:e4:0c:ee"
+	// Reserved Mac Address for the gateway of the
+	// virtual network.
+	GatewayMacAddr     = "5a:94:ef:e4:0c:dd"
 	gatewayLastByte    = 1
 	staticDHCPLastByte = 2
 	staticHostLastByte = 254
+	// The IPv6 addresses are derived the same way as the
+	// IPv4 ones, from the interface ID of the prefix.
+	gatewayInterfaceID    = 0x1
+	staticDHCPInterfaceID = 0x2
+	staticHostInterfaceID = 0xfe
+	// The subnet must be large enough for the addresses
+	// above.
+	maxIPv4PrefixLength = 24
+	// Router advertisements and stateless address
+	// autoconfiguration require a /64.
+	maxIPv6PrefixLength = 64
+	// Subnet of the veth pair that network-setup creates
+	// between the default and the Rancher Desktop network
+	// namespaces.
+	namespaceVethSubnet = "192.168.143.0/24"
+	// Unique local addresses, RFC 4193.
+	uniqueLocalPrefix = "fc00::/7"
+	// MTU of the virtual network that is used by default
+	// if one is not provided through the arguments.
+	DefaultMTU = 1500
+	// IPv6 requires links with an MTU of at least 1280,
+	// RFC 8200.
+	minMTU = 1280
+	// The largest jumbo frame the virtual network carries.
+	maxMTU = 9000
+)
+
+var (
+	ErrInvalidSubnet = errors.New("invalid subnet")
+	ErrInvalidMTU    = errors.New("invalid MTU")
 )
 
 // Subnet represents all the network properties
@@ -46,25 +80,101 @@ type Subnet struct {
 	StaticDHCPLease map[string]string
 	StaticDNSHost   string
 	SubnetCIDR      string
+	// The IPv6 properties are only set when an IPv6
+	// prefix is configured alongside the IPv4 subnet.
+	GatewayIP6       string
+	StaticDHCP6Lease map[string]string
+	StaticDNSHost6   string
+	SubnetCIDR6      string
 }
 
 // ValidateSubnet validates a given IP CIDR format and
 // creates all the network addresses that are consumable
 // by the host switch process.
 func ValidateSubnet(subnet string) (*Subnet, error) {
-	ip, _, err := net.ParseCIDR(subnet)
+	return ValidateDualStackSubnet(subnet, "")
+}
+
+// ValidateDualStackSubnet validates an IPv4 subnet and an
+// optional IPv6 unique local prefix, and creates the
+// network addresses of both; the IPv6 ones are left
+// empty when subnet6 is empty.
+func ValidateDualStackSubnet(subnet, subnet6 string) (*Subnet, error) {
+	network, err := parsePrefix(subnet, 4, maxIPv4PrefixLength)
 	if err != nil {
-		return nil, fmt.Errorf("validating subnet: %w", err)
+		return nil, err
+	}
+	_, vethNetwork, _ := net.ParseCIDR(namespaceVethSubnet)
+	if overlaps(network, vethNetwork) {
+		return nil, fmt.Errorf("%w: %s overlaps the %s subnet of the network namespace veth pair",
+			ErrInvalidSubnet, subnet, namespaceVethSubnet)
 	}
-	ipv4 := ip.To4()
-	return &Subnet{
+	ipv4 := network.IP.To4()
+	s := &Subnet{
 		GatewayIP: gatewayIP(ipv4),
 		StaticDHCPLease: map[string]string{
 			TapDeviceIP(ipv4): TapDeviceMacAddr,
 		},
 		StaticDNSHost: staticDNSHost(ipv4),
 		SubnetCIDR:    subnet,
-	}, nil
+	}
+	if subnet6 == "" {
+		return s, nil
+	}
+
+	network6, err := parsePrefix(subnet6, 6, maxIPv6PrefixLength)
+	if err != nil {
+		return nil, err
+	}
+	_, uniqueLocal, _ := net.ParseCIDR(uniqueLocalPrefix)
+	ones, _ := network6.Mask.Size()
+	if !uniqueLocal.Contains(network6.IP) || ones < 7 {
+		return nil, fmt.Errorf("%w: %s is not a unique local IPv6 prefix within %s",
+			ErrInvalidSubnet, subnet6, uniqueLocalPrefix)
+	}
+	s.GatewayIP6 = gatewayIP6(network6.IP)
+	s.StaticDHCP6Lease = map[string]string{
+		TapDeviceIP6(network6.IP): TapDeviceMacAddr,
+	}
+	s.StaticDNSHost6 = staticDNSHost6(network6.IP)
+	s.SubnetCIDR6 = subnet6
+	return s, nil
+}
+
+// parsePrefix parses a CIDR of the given IP version, with
+// a prefix length of at most maxPrefixLength.
+func parsePrefix(cidr string, version, maxPrefixLength int) (*net.IPNet, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSubnet, err)
+	}
+	bits := net.IPv4len * 8
+	if version == 6 {
+		bits = net.IPv6len * 8
+	}
+	ones, networkBits := network.Mask.Size()
+	if networkBits != bits {
+		return nil, fmt.Errorf("%w: %s is not an IPv%d subnet", ErrInvalidSubnet, cidr, version)
+	}
+	if ones > maxPrefixLength {
+		return nil, fmt.Errorf("%w: %s is too small, the prefix length must be at most /%d",
+			ErrInvalidSubnet, cidr, maxPrefixLength)
+	}
+	return network, nil
+}
+
+func overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// ValidateMTU validates the MTU of the virtual network that
+// is shared by the host switch, the vm switch and the
+// network namespace veth pair.
+func ValidateMTU(mtu int) error {
+	if mtu < minMTU || mtu > maxMTU {
+		return fmt.Errorf("%w: %d is not between %d and %d", ErrInvalidMTU, mtu, minMTU, maxMTU)
+	}
+	return nil
 }
 
 // SearchDomains reads the content of the /etc/resolv.conf when
@@ -119,6 +229,13 @@ func TapDeviceIP(ip net.IP) string {
 	return net.IPv4(ip[0], ip[1], ip[2], staticDHCPLastByte).String()
 }
 
+// TapDeviceIP6 returns the allocated IPv6 address for
+// the Tap Device.
+func TapDeviceIP6(prefix net.IP) string {
+	// Tap device IP is always prefix::2
+	return interfaceIP6(prefix, staticDHCPInterfaceID).String()
+}
+
 func gatewayIP(ip net.IP) string {
 	// Gateway is always x.x.x.1
 	return net.IPv4(ip[0], ip[1], ip[2], gatewayLastByte).String()
@@ -129,6 +246,25 @@ func staticDNSHost(ip net.IP) string {
 	return net.IPv4(ip[0], ip[1], ip[2], staticHostLastByte).String()
 }
 
+func gatewayIP6(prefix net.IP) string {
+	// Gateway is always prefix::1
+	return interfaceIP6(prefix, gatewayInterfaceID).String()
+}
+
+func staticDNSHost6(prefix net.IP) string {
+	// Static DNS Host is always prefix::fe
+	return interfaceIP6(prefix, staticHostInterfaceID).String()
+}
+
+// interfaceIP6 returns the address with the given interface
+// ID in the first /64 of the prefix.
+func interfaceIP6(prefix net.IP, interfaceID byte) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix.To16()[:8])
+	ip[net.IPv6len-1] = interfaceID
+	return ip
+}
+
 func validateIPPort(ipPorts []string) error {
 	for _, ipPort := range ipPorts {
 		ip, port, err := net.SplitHostPort(ipPort)
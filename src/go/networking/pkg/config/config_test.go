@@ -0,0 +1,73 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/config"
+)
+
+func TestValidateSubnet(t *testing.T) {
+	subnet, err := config.ValidateSubnet("192.168.127.5/24")
+	require.NoError(t, err)
+	require.Equal(t, &config.Subnet{
+		GatewayIP:       "192.168.127.1",
+		StaticDHCPLease: map[string]string{"192.168.127.2": config.TapDeviceMacAddr},
+		StaticDNSHost:   "192.168.127.254",
+		SubnetCIDR:      "192.168.127.5/24",
+	}, subnet)
+}
+
+func TestValidateDualStackSubnet(t *testing.T) {
+	subnet, err := config.ValidateDualStackSubnet(config.DefaultSubnet, "fd7a:6b8e:10ad::/48")
+	require.NoError(t, err)
+	require.Equal(t, "192.168.127.1", subnet.GatewayIP)
+	require.Equal(t, "fd7a:6b8e:10ad::1", subnet.GatewayIP6)
+	require.Equal(t, map[string]string{"fd7a:6b8e:10ad::2": config.TapDeviceMacAddr}, subnet.StaticDHCP6Lease)
+	require.Equal(t, "fd7a:6b8e:10ad::fe", subnet.StaticDNSHost6)
+	require.Equal(t, "fd7a:6b8e:10ad::/48", subnet.SubnetCIDR6)
+}
+
+func TestValidateSubnetInvalid(t *testing.T) {
+	tests := map[string]struct{ subnet, subnet6 string }{
+		"malformed":           {subnet: "192.168.127.0"},
+		"IPv6 subnet":         {subnet: "fd00::/64"},
+		"too small":           {subnet: "192.168.127.0/25"},
+		"overlapping veth":    {subnet: "192.168.0.0/16"},
+		"malformed prefix":    {subnet: config.DefaultSubnet, subnet6: "fd00::"},
+		"IPv4 prefix":         {subnet: config.DefaultSubnet, subnet6: "10.0.0.0/8"},
+		"too small prefix":    {subnet: config.DefaultSubnet, subnet6: "fd00::/80"},
+		"global prefix":       {subnet: config.DefaultSubnet, subnet6: "2001:db8::/64"},
+		"overlapping non-ULA": {subnet: config.DefaultSubnet, subnet6: "fc00::/6"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := config.ValidateDualStackSubnet(tt.subnet, tt.subnet6)
+			require.ErrorIs(t, err, config.ErrInvalidSubnet)
+		})
+	}
+}
+
+func TestValidateMTU(t *testing.T) {
+	for _, mtu := range []int{1280, config.DefaultMTU, 1400, 9000} {
+		require.NoError(t, config.ValidateMTU(mtu))
+	}
+	for _, mtu := range []int{0, -1, 576, 1279, 9001, 65536} {
+		require.ErrorIs(t, config.ValidateMTU(mtu), config.ErrInvalidMTU)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const clientTimeout = 10 * time.Second
+
+var ErrRequestFailed = errors.New("control request failed")
+
+// Client talks to the control API of a vm-switch.
+type Client struct {
+	client *http.Client
+}
+
+// NewClient creates a Client for the control socket at socketPath.
+func NewClient(socketPath string) *Client {
+	return &Client{
+		client: &http.Client{
+			Timeout: clientTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// Stats returns the counters of the vm-switch.
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	var stats Stats
+	if err := c.do(ctx, http.MethodGet, "/stats", nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Settings returns the current settings of the vm-switch.
+func (c *Client) Settings(ctx context.Context) (*Settings, error) {
+	var settings Settings
+	if err := c.do(ctx, http.MethodGet, "/settings", nil, &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// Apply changes the settings that are set in patch, and returns the
+// resulting settings.
+func (c *Client) Apply(ctx context.Context, patch Settings) (*Settings, error) {
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	var settings Settings
+	if err := c.do(ctx, http.MethodPatch, "/settings", body, &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body []byte, result any) error {
+	// The host is ignored, as the transport always dials the socket.
+	req, err := http.NewRequestWithContext(ctx, method, "http://vm-switch"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%w: %s %s: %s: %s", ErrRequestFailed, method, path, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
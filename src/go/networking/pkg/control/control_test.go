@@ -0,0 +1,167 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package control_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/capture"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/control"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/impair"
+)
+
+func serve(t *testing.T, counters *control.Counters, trace *atomic.Bool, packetCapture *capture.Capture, impairment *impair.Impairment, logger *logrus.Logger) *control.Client {
+	t.Helper()
+	// Unix socket paths are limited in length, so avoid the long test
+	// directory names.
+	dir, err := os.MkdirTemp("", "control")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socketPath := filepath.Join(dir, "vm-switch.sock")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- control.New(ctx, socketPath, counters, trace, packetCapture, impairment, logger).Serve()
+	}()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-errCh)
+	})
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(socketPath)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	return control.NewClient(socketPath)
+}
+
+func TestStats(t *testing.T) {
+	var counters control.Counters
+	counters.VMToHost.Add(100)
+	counters.VMToHost.Add(60)
+	counters.HostToVM.Add(1500)
+	counters.HostToVM.Drop()
+	counters.MTUExceeded.Add(1)
+	counters.Reconnects.Add(2)
+	client := serve(t, &counters, &atomic.Bool{}, nil, impair.New(nil), logrus.New())
+
+	stats, err := client.Stats(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, control.DirectionStats{Frames: 2, Bytes: 160}, stats.VMToHost)
+	require.Equal(t, control.DirectionStats{Frames: 1, Bytes: 1500, Dropped: 1}, stats.HostToVM)
+	require.Equal(t, uint64(1), stats.MTUExceeded)
+	require.Equal(t, uint64(2), stats.Reconnects)
+	require.Zero(t, stats.CaptureDropped)
+	require.False(t, stats.StartTime.IsZero())
+}
+
+func TestSettings(t *testing.T) {
+	dir := t.TempDir()
+	packetCapture, err := capture.New(capture.Config{
+		Interface: "eth0",
+		File:      filepath.Join(dir, "vm-switch.pcapng"),
+		MaxSize:   1 << 20,
+		MaxFiles:  1,
+	})
+	require.NoError(t, err)
+	defer packetCapture.Close()
+	var trace atomic.Bool
+	impairment := impair.New(nil)
+	logger := logrus.New()
+	client := serve(t, &control.Counters{}, &trace, packetCapture, impairment, logger)
+	ctx := context.Background()
+
+	settings, err := client.Settings(ctx)
+	require.NoError(t, err)
+	require.False(t, *settings.TracePackets)
+	require.False(t, *settings.Capture)
+	require.Empty(t, *settings.CaptureFilter)
+	require.Equal(t, "info", *settings.LogLevel)
+	require.Empty(t, *settings.Impairments)
+
+	enabled := true
+	filter := "udp port 53"
+	level := "debug"
+	impairments := []string{"dst=10.0.0.0/8,latency=100ms", "loss=1%"}
+	settings, err = client.Apply(ctx, control.Settings{
+		TracePackets:  &enabled,
+		Capture:       &enabled,
+		CaptureFilter: &filter,
+		LogLevel:      &level,
+		Impairments:   &impairments,
+	})
+	require.NoError(t, err)
+	require.True(t, *settings.TracePackets)
+	require.True(t, *settings.Capture)
+	require.Equal(t, filter, *settings.CaptureFilter)
+	require.Equal(t, "debug", *settings.LogLevel)
+	require.Equal(t, impairments, *settings.Impairments)
+	require.Len(t, impairment.Rules(), 2)
+	require.True(t, trace.Load())
+	require.True(t, packetCapture.Enabled())
+	require.Equal(t, logrus.DebugLevel, logger.GetLevel())
+	require.FileExists(t, filepath.Join(dir, "vm-switch.pcapng"))
+
+	// An invalid setting leaves all of them unchanged.
+	disabled := false
+	invalid := "warning!"
+	_, err = client.Apply(ctx, control.Settings{TracePackets: &disabled, LogLevel: &invalid})
+	require.ErrorIs(t, err, control.ErrRequestFailed)
+	require.ErrorContains(t, err, "400")
+	invalidImpairments := []string{"latency=soon"}
+	_, err = client.Apply(ctx, control.Settings{TracePackets: &disabled, Impairments: &invalidImpairments})
+	require.ErrorIs(t, err, control.ErrRequestFailed)
+	require.Len(t, impairment.Rules(), 2)
+	require.True(t, trace.Load())
+	require.Equal(t, logrus.DebugLevel, logger.GetLevel())
+}
+
+func TestSettingsWithoutCapture(t *testing.T) {
+	client := serve(t, &control.Counters{}, &atomic.Bool{}, nil, impair.New(nil), logrus.New())
+	ctx := context.Background()
+
+	settings, err := client.Settings(ctx)
+	require.NoError(t, err)
+	require.Nil(t, settings.Capture)
+	require.Nil(t, settings.CaptureFilter)
+
+	enabled := true
+	_, err = client.Apply(ctx, control.Settings{Capture: &enabled})
+	require.ErrorIs(t, err, control.ErrRequestFailed)
+	require.ErrorContains(t, err, control.ErrCaptureNotConfigured.Error())
+}
+
+func TestInvalidRequests(t *testing.T) {
+	server := control.New(context.Background(), "", &control.Counters{}, &atomic.Bool{}, nil, impair.New(nil), logrus.New())
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/stats", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodPatch, "/settings", strings.NewReader("[]")))
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+}
@@ -0,0 +1,301 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package control serves the control API of vm-switch, a local HTTP/JSON
+// API over a Unix socket that reports its counters and changes its
+// settings at runtime, e.g.:
+//
+//	curl --unix-socket /run/vm-switch.sock http://localhost/stats
+//	curl --unix-socket /run/vm-switch.sock -X PATCH -d '{"tracePackets": true}' http://localhost/settings
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/capture"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/impair"
+)
+
+// DefaultSocket is the path of the control socket; it is in the WSL /run
+// directory, which is shared by the Rancher Desktop namespaces.
+const DefaultSocket = "/run/vm-switch.sock"
+
+// Counter counts the frames going one way through the vm-switch.
+type Counter struct {
+	frames  atomic.Uint64
+	bytes   atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// Add counts a frame of n bytes that went through.
+func (c *Counter) Add(n int) {
+	c.frames.Add(1)
+	c.bytes.Add(uint64(n))
+}
+
+// Drop counts a frame that could not be delivered.
+func (c *Counter) Drop() {
+	c.dropped.Add(1)
+}
+
+// DirectionStats is a snapshot of a Counter.
+type DirectionStats struct {
+	Frames  uint64 `json:"frames"`
+	Bytes   uint64 `json:"bytes"`
+	Dropped uint64 `json:"dropped"`
+}
+
+func (c *Counter) snapshot() DirectionStats {
+	return DirectionStats{
+		Frames:  c.frames.Load(),
+		Bytes:   c.bytes.Load(),
+		Dropped: c.dropped.Load(),
+	}
+}
+
+// Counters are the counters of the vm-switch.
+type Counters struct {
+	VMToHost Counter
+	HostToVM Counter
+	// MTUExceeded counts the frames larger than the MTU.
+	MTUExceeded atomic.Uint64
+	// Reconnects counts the times the connection to the host was set up
+	// again after it failed.
+	Reconnects atomic.Uint64
+}
+
+// Stats is the response of GET /stats.
+type Stats struct {
+	StartTime   time.Time      `json:"startTime"`
+	VMToHost    DirectionStats `json:"vmToHost"`
+	HostToVM    DirectionStats `json:"hostToVM"`
+	MTUExceeded uint64         `json:"mtuExceeded"`
+	Reconnects  uint64         `json:"reconnects"`
+	// CaptureDropped counts the frames the packet capture did not keep
+	// up with.
+	CaptureDropped uint64 `json:"captureDropped"`
+	// ImpairmentDropped counts the frames dropped by the impairment rules.
+	ImpairmentDropped uint64 `json:"impairmentDropped"`
+}
+
+// Settings are the settings that can be changed at runtime, as returned by
+// GET /settings. PATCH /settings changes the ones that are set.
+type Settings struct {
+	TracePackets *bool `json:"tracePackets,omitempty"`
+	// Capture and CaptureFilter are not set when the packet capture is
+	// not configured.
+	Capture       *bool   `json:"capture,omitempty"`
+	CaptureFilter *string `json:"captureFilter,omitempty"`
+	LogLevel      *string `json:"logLevel,omitempty"`
+	// Impairments are the specifications of the impairment rules, in
+	// order; an empty list removes them.
+	Impairments *[]string `json:"impairments,omitempty"`
+}
+
+var ErrCaptureNotConfigured = errors.New("packet capture is not configured")
+
+// Server serves the control API on a Unix socket.
+type Server struct {
+	context    context.Context
+	socketPath string
+	startTime  time.Time
+	counters   *Counters
+	trace      *atomic.Bool
+	capture    *capture.Capture
+	impairment *impair.Impairment
+	logger     *logrus.Logger
+	mux        *http.ServeMux
+}
+
+// New creates a control Server listening on socketPath that reports
+// counters, and toggles the per-packet tracing through trace, the packet
+// capture, which may be nil, the impairment rules and the level of logger.
+func New(ctx context.Context, socketPath string, counters *Counters, trace *atomic.Bool, packetCapture *capture.Capture, impairment *impair.Impairment, logger *logrus.Logger) *Server {
+	s := &Server{
+		context:    ctx,
+		socketPath: socketPath,
+		startTime:  time.Now(),
+		counters:   counters,
+		trace:      trace,
+		capture:    packetCapture,
+		impairment: impairment,
+		logger:     logger,
+		mux:        http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /stats", s.getStats)
+	s.mux.HandleFunc("GET /settings", s.getSettings)
+	s.mux.HandleFunc("PATCH /settings", s.patchSettings)
+	return s
+}
+
+// Serve listens on the Unix socket and serves requests until the
+// context is cancelled. A stale socket file left by a previous run
+// is removed first.
+func (s *Server) Serve() error {
+	if err := os.Remove(s.socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing stale control socket %s: %w", s.socketPath, err)
+	}
+
+	var lc net.ListenConfig
+	listener, err := lc.Listen(s.context, "unix", s.socketPath)
+	if err != nil {
+		return fmt.Errorf("listening on control socket %s: %w", s.socketPath, err)
+	}
+	defer os.Remove(s.socketPath)
+
+	if err := os.Chmod(s.socketPath, 0o600); err != nil {
+		listener.Close()
+		return fmt.Errorf("setting permissions on control socket %s: %w", s.socketPath, err)
+	}
+
+	server := &http.Server{
+		Handler:           s.mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-s.context.Done()
+		server.Close()
+	}()
+
+	logrus.Infof("serving vm-switch control API on %s", s.socketPath)
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("control server failed: %w", err)
+	}
+
+	return nil
+}
+
+// ServeHTTP allows the control API to be exercised without a socket.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) getStats(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, Stats{
+		StartTime:         s.startTime,
+		VMToHost:          s.counters.VMToHost.snapshot(),
+		HostToVM:          s.counters.HostToVM.snapshot(),
+		MTUExceeded:       s.counters.MTUExceeded.Load(),
+		Reconnects:        s.counters.Reconnects.Load(),
+		CaptureDropped:    s.capture.Dropped(),
+		ImpairmentDropped: s.impairment.Dropped(),
+	})
+}
+
+func (s *Server) settings() Settings {
+	trace := s.trace.Load()
+	level := s.logger.GetLevel().String()
+	impairments := []string{}
+	for _, rule := range s.impairment.Rules() {
+		impairments = append(impairments, rule.String())
+	}
+	settings := Settings{TracePackets: &trace, LogLevel: &level, Impairments: &impairments}
+	if s.capture != nil {
+		enabled := s.capture.Enabled()
+		filter := s.capture.Filter().String()
+		settings.Capture = &enabled
+		settings.CaptureFilter = &filter
+	}
+	return settings
+}
+
+func (s *Server) getSettings(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.settings())
+}
+
+// patchSettings applies the settings of the request; nothing is changed
+// if one of them is invalid.
+func (s *Server) patchSettings(w http.ResponseWriter, r *http.Request) {
+	var patch Settings
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var level logrus.Level
+	if patch.LogLevel != nil {
+		var err error
+		if level, err = logrus.ParseLevel(*patch.LogLevel); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if (patch.Capture != nil || patch.CaptureFilter != nil) && s.capture == nil {
+		http.Error(w, ErrCaptureNotConfigured.Error(), http.StatusConflict)
+		return
+	}
+	if patch.CaptureFilter != nil {
+		if _, err := capture.ParseFilter(*patch.CaptureFilter); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	var impairRules []impair.Rule
+	if patch.Impairments != nil {
+		var err error
+		if impairRules, err = impair.ParseRules(*patch.Impairments); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if patch.CaptureFilter != nil {
+		if err := s.capture.SetFilter(*patch.CaptureFilter); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logrus.Infof("control: packet capture filter is now %q", *patch.CaptureFilter)
+	}
+	if patch.Capture != nil {
+		if err := s.capture.SetEnabled(*patch.Capture); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if patch.TracePackets != nil {
+		s.trace.Store(*patch.TracePackets)
+		logrus.Infof("control: per-packet tracing is now %t", *patch.TracePackets)
+	}
+	if patch.Impairments != nil {
+		s.impairment.SetRules(impairRules)
+		logrus.Infof("control: impairment rules are now %q", *patch.Impairments)
+	}
+	if patch.LogLevel != nil {
+		s.logger.SetLevel(level)
+		logrus.Infof("control: log level is now %s", level)
+	}
+
+	writeJSON(w, http.StatusOK, s.settings())
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Errorf("encoding control response failed: %s", err)
+	}
+}
@@ -0,0 +1,283 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package impair emulates an impaired network on the frames that cross the
+// vm-switch, like netem: it delays, drops, reorders and rate limits the
+// frames matching its rules.
+package impair
+
+import (
+	"container/heap"
+	"context"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Direction is the direction of the frames a rule applies to.
+type Direction string
+
+const (
+	VMToHost Direction = "vm-to-host"
+	HostToVM Direction = "host-to-vm"
+)
+
+// queueLimit is the maximum number of delayed frames of a link; the
+// frames over it are dropped.
+const queueLimit = 1000
+
+// Impairment holds the rules shared by the links of both directions. The
+// rules can be changed at runtime.
+type Impairment struct {
+	mutex sync.RWMutex
+	rules []*rule
+
+	dropped atomic.Uint64
+}
+
+// rule is a Rule along with the state of its rate limit in each direction.
+type rule struct {
+	Rule
+	// departures is when the rate limit lets the next frame go, by
+	// direction; it is guarded by the mutex of the Impairment.
+	departures map[Direction]time.Time
+}
+
+// New creates an Impairment applying rules; the first rule matching a frame
+// applies to it.
+func New(rules []Rule) *Impairment {
+	i := &Impairment{}
+	i.SetRules(rules)
+	return i
+}
+
+// Rules returns the rules.
+func (i *Impairment) Rules() []Rule {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	rules := make([]Rule, 0, len(i.rules))
+	for _, r := range i.rules {
+		rules = append(rules, r.Rule)
+	}
+	return rules
+}
+
+// SetRules replaces the rules; the frames already delayed keep their delay.
+func (i *Impairment) SetRules(rules []Rule) {
+	compiled := make([]*rule, 0, len(rules))
+	for _, r := range rules {
+		compiled = append(compiled, &rule{Rule: r, departures: make(map[Direction]time.Time)})
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.rules = compiled
+	if len(rules) > 0 {
+		logrus.Infof("impairing the network with %d rules", len(rules))
+	}
+}
+
+// Dropped returns the number of frames dropped by the rules or because
+// too many were delayed.
+func (i *Impairment) Dropped() uint64 {
+	return i.dropped.Load()
+}
+
+// delay returns how long to delay a frame of the direction, and whether to
+// drop it.
+func (i *Impairment) delay(direction Direction, frame []byte, now time.Time) (time.Duration, bool) {
+	i.mutex.RLock()
+	rules := i.rules
+	i.mutex.RUnlock()
+
+	for _, r := range rules {
+		if r.Direction != "" && r.Direction != direction {
+			continue
+		}
+		if r.Filter != nil && !r.Filter.Match(frame) {
+			continue
+		}
+		if r.Loss > 0 && rand.Float64() < r.Loss {
+			return 0, true
+		}
+		var delay time.Duration
+		if r.Rate > 0 {
+			// The frame goes once the ones before it are through.
+			transmission := time.Duration(uint64(len(frame)) * 8 * uint64(time.Second) / r.Rate)
+			i.mutex.Lock()
+			departure := r.departures[direction]
+			if departure.Before(now) {
+				departure = now
+			}
+			departure = departure.Add(transmission)
+			r.departures[direction] = departure
+			i.mutex.Unlock()
+			delay = departure.Sub(now)
+		}
+		if r.Reorder > 0 && rand.Float64() < r.Reorder {
+			return delay, false
+		}
+		delay += r.Latency
+		if r.Jitter > 0 {
+			delay += time.Duration(rand.Int64N(int64(2*r.Jitter+1))) - r.Jitter
+		}
+		return max(delay, 0), false
+	}
+	return 0, false
+}
+
+// Link impairs the frames of a direction before delivering them.
+type Link struct {
+	impairment *Impairment
+	direction  Direction
+	deliver    func([]byte) error
+
+	// deliverMutex serializes the calls to deliver.
+	deliverMutex sync.Mutex
+
+	mutex    sync.Mutex
+	queue    frameQueue
+	sequence uint64
+	// wake is signalled when a frame is queued.
+	wake chan struct{}
+}
+
+// NewLink creates a Link delivering the frames of direction with deliver,
+// which is never called concurrently, until ctx is done; the frames still
+// delayed then are dropped.
+func (i *Impairment) NewLink(ctx context.Context, direction Direction, deliver func([]byte) error) *Link {
+	l := &Link{
+		impairment: i,
+		direction:  direction,
+		deliver:    deliver,
+		wake:       make(chan struct{}, 1),
+	}
+	go l.run(ctx)
+	return l
+}
+
+// Send delivers frame, or queues a copy of it if it is delayed. The error
+// is the one of deliver, when frame is delivered right away.
+func (l *Link) Send(frame []byte) error {
+	now := time.Now()
+	delay, drop := l.impairment.delay(l.direction, frame, now)
+	if drop {
+		l.impairment.dropped.Add(1)
+		return nil
+	}
+	if delay == 0 {
+		l.mutex.Lock()
+		pending := len(l.queue) > 0 && !l.queue[0].due.After(now)
+		l.mutex.Unlock()
+		// The frames that are not delayed go right away, unless delayed
+		// frames that are due are still queued ahead of them.
+		if !pending {
+			l.deliverMutex.Lock()
+			defer l.deliverMutex.Unlock()
+			return l.deliver(frame)
+		}
+	}
+
+	l.mutex.Lock()
+	if len(l.queue) >= queueLimit {
+		l.mutex.Unlock()
+		l.impairment.dropped.Add(1)
+		return nil
+	}
+	l.sequence++
+	heap.Push(&l.queue, &delayedFrame{
+		due:      now.Add(delay),
+		sequence: l.sequence,
+		data:     append([]byte(nil), frame...),
+	})
+	l.mutex.Unlock()
+
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// run delivers the delayed frames when they are due.
+func (l *Link) run(ctx context.Context) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		l.mutex.Lock()
+		var next *delayedFrame
+		var wait time.Duration
+		if len(l.queue) > 0 {
+			wait = time.Until(l.queue[0].due)
+			if wait <= 0 {
+				next = heap.Pop(&l.queue).(*delayedFrame)
+			}
+		}
+		l.mutex.Unlock()
+
+		if next != nil {
+			l.deliverMutex.Lock()
+			err := l.deliver(next.data)
+			l.deliverMutex.Unlock()
+			if err != nil {
+				logrus.Debugf("delivering a delayed %s frame failed: %s", l.direction, err)
+			}
+			continue
+		}
+
+		timer.Stop()
+		if wait > 0 {
+			timer.Reset(wait)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-l.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+type delayedFrame struct {
+	due      time.Time
+	sequence uint64
+	data     []byte
+}
+
+// frameQueue is a heap of the delayed frames, by due time and then in the
+// order they were sent.
+type frameQueue []*delayedFrame
+
+func (q frameQueue) Len() int { return len(q) }
+
+func (q frameQueue) Less(i, j int) bool {
+	if q[i].due.Equal(q[j].due) {
+		return q[i].sequence < q[j].sequence
+	}
+	return q[i].due.Before(q[j].due)
+}
+
+func (q frameQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *frameQueue) Push(x any) { *q = append(*q, x.(*delayedFrame)) }
+
+func (q *frameQueue) Pop() any {
+	old := *q
+	frame := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return frame
+}
@@ -0,0 +1,174 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package impair_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/impair"
+)
+
+func udpFrame(t *testing.T, dstIP string, dstPort uint16, payload string) []byte {
+	t.Helper()
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x5a, 0x94, 0xef, 0xe4, 0x0c, 0xee},
+		DstMAC:       net.HardwareAddr{0x5a, 0x94, 0xef, 0xe4, 0x0c, 0xdd},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.ParseIP("192.168.127.2").To4(),
+		DstIP:    net.ParseIP(dstIP).To4(),
+	}
+	udp := &layers.UDP{SrcPort: 40000, DstPort: layers.UDPPort(dstPort)}
+	require.NoError(t, udp.SetNetworkLayerForChecksum(ip))
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload(payload)))
+	return buf.Bytes()
+}
+
+// recorder records the payloads of the delivered frames.
+type recorder struct {
+	mutex    sync.Mutex
+	payloads []string
+}
+
+func (r *recorder) deliver(frame []byte) error {
+	packet := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.Default)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.payloads = append(r.payloads, string(packet.Layer(layers.LayerTypeUDP).LayerPayload()))
+	return nil
+}
+
+func (r *recorder) delivered() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.payloads...)
+}
+
+func newLink(t *testing.T, direction impair.Direction, specs ...string) (*impair.Impairment, *impair.Link, *recorder) {
+	t.Helper()
+	rules, err := impair.ParseRules(specs)
+	require.NoError(t, err)
+	impairment := impair.New(rules)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	var r recorder
+	return impairment, impairment.NewLink(ctx, direction, r.deliver), &r
+}
+
+func TestParseRule(t *testing.T) {
+	rule, err := impair.ParseRule("dst=10.0.0.0/8,port=443,direction=vm-to-host,latency=100ms,jitter=20ms,loss=1.5%,reorder=5%,rate=2.5mbit")
+	require.NoError(t, err)
+	require.Equal(t, impair.VMToHost, rule.Direction)
+	require.Equal(t, 100*time.Millisecond, rule.Latency)
+	require.Equal(t, 20*time.Millisecond, rule.Jitter)
+	require.InDelta(t, 0.015, rule.Loss, 1e-9)
+	require.InDelta(t, 0.05, rule.Reorder, 1e-9)
+	require.Equal(t, uint64(2_500_000), rule.Rate)
+	require.Equal(t, "dst net 10.0.0.0/8 and dst port 443", rule.Filter.String())
+
+	rule, err = impair.ParseRule("match=udp or icmp,port=5000-5010,loss=0%")
+	require.NoError(t, err)
+	require.Equal(t, "(udp or icmp) and dst portrange 5000-5010", rule.Filter.String())
+
+	for _, spec := range []string{
+		"",
+		"latency",
+		"latency=fast",
+		"latency=-1s",
+		"loss=5",
+		"loss=101%",
+		"rate=10",
+		"rate=0mbit",
+		"reorder=5%",
+		"dst=10.0.0.1",
+		"port=http",
+		"match=port",
+		"direction=up",
+		"colour=blue",
+	} {
+		_, err := impair.ParseRule(spec)
+		require.ErrorIs(t, err, impair.ErrInvalidRule, spec)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	_, link, r := newLink(t, impair.VMToHost, "dst=10.0.0.0/8,latency=100ms")
+
+	start := time.Now()
+	require.NoError(t, link.Send(udpFrame(t, "10.0.0.1", 80, "delayed 1")))
+	require.NoError(t, link.Send(udpFrame(t, "10.0.0.1", 80, "delayed 2")))
+	// The frames the rule does not match go right away.
+	require.NoError(t, link.Send(udpFrame(t, "192.168.1.1", 80, "direct")))
+	require.Equal(t, []string{"direct"}, r.delivered())
+
+	require.Eventually(t, func() bool { return len(r.delivered()) == 3 }, 5*time.Second, 5*time.Millisecond)
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	require.Equal(t, []string{"direct", "delayed 1", "delayed 2"}, r.delivered())
+}
+
+func TestLoss(t *testing.T) {
+	impairment, link, r := newLink(t, impair.VMToHost, "port=53,loss=100%")
+
+	for range 10 {
+		require.NoError(t, link.Send(udpFrame(t, "10.0.0.1", 53, "lost")))
+	}
+	require.NoError(t, link.Send(udpFrame(t, "10.0.0.1", 80, "kept")))
+	require.Equal(t, []string{"kept"}, r.delivered())
+	require.Equal(t, uint64(10), impairment.Dropped())
+
+	// The rules can be replaced at runtime.
+	impairment.SetRules(nil)
+	require.NoError(t, link.Send(udpFrame(t, "10.0.0.1", 53, "no longer lost")))
+	require.Equal(t, []string{"kept", "no longer lost"}, r.delivered())
+}
+
+func TestDirection(t *testing.T) {
+	_, link, r := newLink(t, impair.VMToHost, "direction=host-to-vm,loss=100%")
+	require.NoError(t, link.Send(udpFrame(t, "10.0.0.1", 80, "other direction")))
+	require.Equal(t, []string{"other direction"}, r.delivered())
+}
+
+func TestReorder(t *testing.T) {
+	_, link, r := newLink(t, impair.HostToVM, "latency=1h,reorder=100%")
+	require.NoError(t, link.Send(udpFrame(t, "10.0.0.1", 80, "ahead")))
+	require.Equal(t, []string{"ahead"}, r.delivered())
+}
+
+func TestRate(t *testing.T) {
+	_, link, r := newLink(t, impair.HostToVM, "rate=80kbit")
+	frame := udpFrame(t, "10.0.0.1", 80, string(make([]byte, 958)))
+	require.Len(t, frame, 1000) // 100ms at 80kbit/s.
+
+	start := time.Now()
+	for range 3 {
+		require.NoError(t, link.Send(frame))
+	}
+	require.Eventually(t, func() bool { return len(r.delivered()) == 3 }, 5*time.Second, 5*time.Millisecond)
+	require.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+}
@@ -0,0 +1,189 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package impair
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/capture"
+)
+
+var ErrInvalidRule = errors.New("invalid impairment rule")
+
+// Rule describes the impairments of the frames it matches.
+type Rule struct {
+	// Direction is the direction the rule applies to; empty applies it to
+	// both.
+	Direction Direction
+	// Filter selects the frames of the rule; nil matches every frame.
+	Filter *capture.Filter
+	// Latency delays each frame, give or take up to Jitter.
+	Latency time.Duration
+	Jitter  time.Duration
+	// Loss is the probability, from 0 to 1, that a frame is dropped.
+	Loss float64
+	// Reorder is the probability, from 0 to 1, that a frame is sent
+	// without its delay, ahead of the delayed ones.
+	Reorder float64
+	// Rate caps the bandwidth, in bits per second; 0 leaves it uncapped.
+	Rate uint64
+
+	spec string
+}
+
+// String returns the specification the rule was parsed from.
+func (r *Rule) String() string {
+	return r.spec
+}
+
+// ParseRule parses a rule specification: comma-separated key=value pairs,
+// of which
+//
+//	dst=<cidr>             matches the frames to the addresses of the CIDR
+//	port=<port>[-<port>]   matches the frames to the port or port range
+//	match=<filter>         matches the frames of a capture filter expression
+//	direction=<direction>  applies the rule to vm-to-host or host-to-vm only
+//	latency=<duration>     delays the frames, e.g. 100ms
+//	jitter=<duration>      varies the delay by up to the duration
+//	loss=<percent>%        drops the frames with the probability
+//	reorder=<percent>%     sends the frames ahead of the delayed ones
+//	rate=<rate>            caps the bandwidth, in bit, kbit, mbit or gbit
+//
+// e.g. "dst=10.0.0.0/8,port=443,latency=100ms,jitter=20ms,loss=1%". The
+// matching keys are and-ed; a rule without any matches every frame.
+func ParseRule(spec string) (Rule, error) {
+	rule := Rule{spec: spec}
+	var matches []string
+	for _, field := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return Rule{}, fmt.Errorf("%w: %q is not a key=value pair", ErrInvalidRule, field)
+		}
+		var err error
+		switch key {
+		case "dst":
+			if _, _, err = net.ParseCIDR(value); err == nil {
+				matches = append(matches, "dst net "+value)
+			}
+		case "port":
+			if strings.Contains(value, "-") {
+				matches = append(matches, "dst portrange "+value)
+			} else {
+				matches = append(matches, "dst port "+value)
+			}
+		case "match":
+			matches = append(matches, "("+value+")")
+		case "direction":
+			switch Direction(value) {
+			case VMToHost, HostToVM:
+				rule.Direction = Direction(value)
+			default:
+				err = fmt.Errorf("unknown direction %q", value)
+			}
+		case "latency":
+			rule.Latency, err = parseDuration(value)
+		case "jitter":
+			rule.Jitter, err = parseDuration(value)
+		case "loss":
+			rule.Loss, err = parseProbability(value)
+		case "reorder":
+			rule.Reorder, err = parseProbability(value)
+		case "rate":
+			rule.Rate, err = parseRate(value)
+		default:
+			err = fmt.Errorf("unknown key %q", key)
+		}
+		if err != nil {
+			return Rule{}, fmt.Errorf("%w: %q: %w", ErrInvalidRule, spec, err)
+		}
+	}
+	if rule.Reorder > 0 && rule.Latency == 0 {
+		return Rule{}, fmt.Errorf("%w: %q: reorder requires a latency", ErrInvalidRule, spec)
+	}
+	if len(matches) > 0 {
+		filter, err := capture.ParseFilter(strings.Join(matches, " and "))
+		if err != nil {
+			return Rule{}, fmt.Errorf("%w: %q: %w", ErrInvalidRule, spec, err)
+		}
+		rule.Filter = filter
+	}
+	return rule, nil
+}
+
+// ParseRules parses the rule specifications, in order.
+func ParseRules(specs []string) ([]Rule, error) {
+	rules := make([]Rule, 0, len(specs))
+	for _, spec := range specs {
+		rule, err := ParseRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("negative duration %q", value)
+	}
+	return duration, nil
+}
+
+// parseProbability parses a percentage, such as "5%", into a probability.
+func parseProbability(value string) (float64, error) {
+	percent, ok := strings.CutSuffix(value, "%")
+	if !ok {
+		return 0, fmt.Errorf("%q is not a percentage", value)
+	}
+	probability, err := strconv.ParseFloat(percent, 64)
+	if err != nil || probability < 0 || probability > 100 {
+		return 0, fmt.Errorf("invalid percentage %q", value)
+	}
+	return probability / 100, nil
+}
+
+var rateUnits = []struct {
+	suffix     string
+	multiplier uint64
+}{
+	// The longest suffixes go first, as they end with the shorter ones.
+	{"gbit", 1_000_000_000},
+	{"mbit", 1_000_000},
+	{"kbit", 1_000},
+	{"bit", 1},
+}
+
+func parseRate(value string) (uint64, error) {
+	for _, unit := range rateUnits {
+		if number, ok := strings.CutSuffix(strings.ToLower(value), unit.suffix); ok {
+			rate, err := strconv.ParseFloat(number, 64)
+			if err != nil || rate <= 0 {
+				return 0, fmt.Errorf("invalid rate %q", value)
+			}
+			return uint64(rate * float64(unit.multiplier)), nil
+		}
+	}
+	return 0, fmt.Errorf("rate %q has no bit, kbit, mbit or gbit unit", value)
+}
@@ -0,0 +1,341 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ipv6 answers, on behalf of the gateway, the router solicitations,
+// neighbor solicitations and DHCPv6 requests of the Rancher Desktop network
+// namespace for its IPv6 unique local prefix. The virtual network of the
+// host-switch is IPv4-only, so the vm-switch answers them on the tap device
+// instead.
+package ipv6
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/config"
+)
+
+// AdvertisementInterval is the interval of the unsolicited router
+// advertisements.
+const AdvertisementInterval = 3 * time.Minute
+
+const (
+	prefixLength      = 64
+	validLifetime     = 24 * time.Hour
+	preferredLifetime = 4 * time.Hour
+	// The hop limit of the neighbor discovery messages, RFC 4861.
+	ndpHopLimit = 255
+
+	raFlagManaged        = 0x80
+	raFlagOther          = 0x40
+	prefixFlagOnLink     = 0x80
+	naFlagRouter         = 0x80
+	naFlagSolicited      = 0x40
+	naFlagOverride       = 0x20
+	dhcp6ClientPort      = 546
+	dhcp6ServerPort      = 547
+	dhcp6StatusSuccess   = 0
+	dhcp6StatusNoAddrs   = 2
+	dhcp6StatusNotOnLink = 4
+)
+
+var (
+	ErrNoPrefix = errors.New("no IPv6 prefix is configured")
+
+	allNodes    = net.ParseIP("ff02::1")
+	allNodesMAC = net.HardwareAddr{0x33, 0x33, 0x00, 0x00, 0x00, 0x01}
+)
+
+// Responder builds the replies of the gateway to the IPv6 configuration
+// requests of the network namespace. A nil Responder answers nothing.
+type Responder struct {
+	routerMAC net.HardwareAddr
+	// linkLocal is the link-local address of the gateway, the source of
+	// its messages.
+	linkLocal net.IP
+	gateway   net.IP
+	prefix    net.IP
+	// leases are the static DHCPv6 leases by MAC address.
+	leases   map[string]net.IP
+	serverID []byte
+}
+
+// NewResponder creates a Responder for the IPv6 prefix of subnet.
+func NewResponder(subnet *config.Subnet) (*Responder, error) {
+	if subnet.SubnetCIDR6 == "" {
+		return nil, ErrNoPrefix
+	}
+	_, network, err := net.ParseCIDR(subnet.SubnetCIDR6)
+	if err != nil {
+		return nil, err
+	}
+	routerMAC, err := net.ParseMAC(config.GatewayMacAddr)
+	if err != nil {
+		return nil, err
+	}
+	leases := make(map[string]net.IP, len(subnet.StaticDHCP6Lease))
+	for ip, mac := range subnet.StaticDHCP6Lease {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DHCPv6 lease for %s: %w", ip, err)
+		}
+		leases[hw.String()] = net.ParseIP(ip)
+	}
+	serverID := (&layers.DHCPv6DUID{
+		Type:             layers.DHCPv6DUIDTypeLL,
+		HardwareType:     []byte{0, 1}, // Ethernet
+		LinkLayerAddress: routerMAC,
+	}).Encode()
+	return &Responder{
+		routerMAC: routerMAC,
+		linkLocal: linkLocalIP(routerMAC),
+		gateway:   net.ParseIP(subnet.GatewayIP6),
+		prefix:    network.IP.Mask(net.CIDRMask(prefixLength, net.IPv6len*8)),
+		leases:    leases,
+		serverID:  serverID,
+	}, nil
+}
+
+// linkLocalIP returns the modified EUI-64 link-local address of mac.
+func linkLocalIP(mac net.HardwareAddr) net.IP {
+	return net.IP{
+		0xfe, 0x80, 0, 0, 0, 0, 0, 0,
+		mac[0] ^ 0x02, mac[1], mac[2], 0xff, 0xfe, mac[3], mac[4], mac[5],
+	}
+}
+
+// Reply returns the reply to frame, when frame is a request the gateway
+// answers. handled tells whether frame is such a request, in which case it
+// must not be forwarded to the host; the reply is nil when there is nothing
+// to answer.
+func (r *Responder) Reply(frame []byte) (reply []byte, handled bool) {
+	if r == nil || len(frame) < 14 || binary.BigEndian.Uint16(frame[12:14]) != uint16(layers.EthernetTypeIPv6) {
+		return nil, false
+	}
+	packet := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.NoCopy)
+	eth, _ := packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	ip6, _ := packet.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+	if eth == nil || ip6 == nil {
+		return nil, false
+	}
+
+	var err error
+	switch {
+	case packet.Layer(layers.LayerTypeICMPv6RouterSolicitation) != nil:
+		reply, err = r.Advertisement()
+	case packet.Layer(layers.LayerTypeICMPv6NeighborSolicitation) != nil:
+		ns := packet.Layer(layers.LayerTypeICMPv6NeighborSolicitation).(*layers.ICMPv6NeighborSolicitation)
+		if !ns.TargetAddress.Equal(r.linkLocal) && !ns.TargetAddress.Equal(r.gateway) {
+			return nil, false
+		}
+		reply, err = r.neighborAdvertisement(eth, ip6, ns.TargetAddress)
+	default:
+		udp, _ := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+		if udp == nil || udp.DstPort != dhcp6ServerPort {
+			return nil, false
+		}
+		var request layers.DHCPv6
+		if err := request.DecodeFromBytes(udp.Payload, gopacket.NilDecodeFeedback); err != nil {
+			return nil, true
+		}
+		reply, err = r.dhcp6Reply(eth, ip6, &request)
+	}
+	if err != nil {
+		return nil, true
+	}
+	return reply, true
+}
+
+// Advertisement returns a router advertisement frame, sent to all the
+// nodes.
+func (r *Responder) Advertisement() ([]byte, error) {
+	prefixInfo := make([]byte, 30)
+	prefixInfo[0] = prefixLength
+	prefixInfo[1] = prefixFlagOnLink
+	binary.BigEndian.PutUint32(prefixInfo[2:], uint32(validLifetime/time.Second))
+	binary.BigEndian.PutUint32(prefixInfo[6:], uint32(preferredLifetime/time.Second))
+	copy(prefixInfo[14:], r.prefix)
+
+	return r.serializeICMPv6(allNodesMAC, allNodes, layers.ICMPv6TypeRouterAdvertisement,
+		&layers.ICMPv6RouterAdvertisement{
+			// The addresses are leased through DHCPv6. The router lifetime
+			// is zero, as the host-switch does not route IPv6: the
+			// gateway is not a default router.
+			Flags: raFlagManaged | raFlagOther,
+			Options: layers.ICMPv6Options{
+				{Type: layers.ICMPv6OptSourceAddress, Data: r.routerMAC},
+				{Type: layers.ICMPv6OptPrefixInfo, Data: prefixInfo},
+			},
+		})
+}
+
+func (r *Responder) neighborAdvertisement(eth *layers.Ethernet, ip6 *layers.IPv6, target net.IP) ([]byte, error) {
+	dstMAC, dstIP := eth.SrcMAC, ip6.SrcIP
+	flags := uint8(naFlagRouter | naFlagSolicited | naFlagOverride)
+	// A solicitation from the unspecified address, e.g. for duplicate
+	// address detection, is answered to all the nodes.
+	if dstIP.IsUnspecified() {
+		dstMAC, dstIP = allNodesMAC, allNodes
+		flags &^= naFlagSolicited
+	}
+	return r.serializeICMPv6(dstMAC, dstIP, layers.ICMPv6TypeNeighborAdvertisement,
+		&layers.ICMPv6NeighborAdvertisement{
+			Flags:         flags,
+			TargetAddress: target,
+			Options: layers.ICMPv6Options{
+				{Type: layers.ICMPv6OptTargetAddress, Data: r.routerMAC},
+			},
+		})
+}
+
+func (r *Responder) serializeICMPv6(dstMAC net.HardwareAddr, dstIP net.IP, icmpType uint8, message gopacket.SerializableLayer) ([]byte, error) {
+	eth := &layers.Ethernet{
+		SrcMAC:       r.routerMAC,
+		DstMAC:       dstMAC,
+		EthernetType: layers.EthernetTypeIPv6,
+	}
+	ip6 := &layers.IPv6{
+		Version:    6,
+		NextHeader: layers.IPProtocolICMPv6,
+		HopLimit:   ndpHopLimit,
+		SrcIP:      r.linkLocal,
+		DstIP:      dstIP,
+	}
+	icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(icmpType, 0)}
+	if err := icmp.SetNetworkLayerForChecksum(ip6); err != nil {
+		return nil, err
+	}
+	return serialize(eth, ip6, icmp, message)
+}
+
+// dhcp6Reply answers a DHCPv6 request with the static lease of the MAC
+// address of the client, RFC 8415.
+func (r *Responder) dhcp6Reply(eth *layers.Ethernet, ip6 *layers.IPv6, request *layers.DHCPv6) ([]byte, error) {
+	msgType := layers.DHCPv6MsgTypeReply
+	var options layers.DHCPv6Options
+	lease := r.leases[eth.SrcMAC.String()]
+
+	switch request.MsgType {
+	case layers.DHCPv6MsgTypeSolicit:
+		msgType = layers.DHCPv6MsgTypeAdverstise
+		if findOption(request.Options, layers.DHCPv6OptRapidCommit) != nil {
+			msgType = layers.DHCPv6MsgTypeReply
+			options = append(options, layers.NewDHCPv6Option(layers.DHCPv6OptRapidCommit, nil))
+		}
+		options = append(options, r.leaseOptions(request, lease)...)
+	case layers.DHCPv6MsgTypeRequest, layers.DHCPv6MsgTypeRenew, layers.DHCPv6MsgTypeRebind:
+		options = append(options, r.leaseOptions(request, lease)...)
+	case layers.DHCPv6MsgTypeConfirm:
+		if lease == nil {
+			options = append(options, statusOption(dhcp6StatusNotOnLink, "not on link"))
+		} else {
+			options = append(options, statusOption(dhcp6StatusSuccess, "on link"))
+		}
+	case layers.DHCPv6MsgTypeRelease, layers.DHCPv6MsgTypeDecline:
+		options = append(options, statusOption(dhcp6StatusSuccess, "done"))
+	case layers.DHCPv6MsgTypeInformationRequest:
+	default:
+		return nil, fmt.Errorf("unsupported DHCPv6 message %s", request.MsgType)
+	}
+
+	if clientID := findOption(request.Options, layers.DHCPv6OptClientID); clientID != nil {
+		options = append(options, layers.NewDHCPv6Option(layers.DHCPv6OptClientID, clientID.Data))
+	}
+	options = append(options, layers.NewDHCPv6Option(layers.DHCPv6OptServerID, r.serverID))
+
+	ethReply := &layers.Ethernet{
+		SrcMAC:       r.routerMAC,
+		DstMAC:       eth.SrcMAC,
+		EthernetType: layers.EthernetTypeIPv6,
+	}
+	ipReply := &layers.IPv6{
+		Version:    6,
+		NextHeader: layers.IPProtocolUDP,
+		HopLimit:   ndpHopLimit,
+		SrcIP:      r.linkLocal,
+		DstIP:      ip6.SrcIP,
+	}
+	udp := &layers.UDP{SrcPort: dhcp6ServerPort, DstPort: dhcp6ClientPort}
+	if err := udp.SetNetworkLayerForChecksum(ipReply); err != nil {
+		return nil, err
+	}
+	return serialize(ethReply, ipReply, udp, &layers.DHCPv6{
+		MsgType:       msgType,
+		TransactionID: request.TransactionID,
+		Options:       options,
+	})
+}
+
+// leaseOptions returns an IA_NA option for each one of request, holding
+// lease, or a NoAddrsAvail status when the client has no lease.
+func (r *Responder) leaseOptions(request *layers.DHCPv6, lease net.IP) layers.DHCPv6Options {
+	var options layers.DHCPv6Options
+	for _, option := range request.Options {
+		if option.Code != layers.DHCPv6OptIANA || len(option.Data) < 12 {
+			continue
+		}
+		iana := make([]byte, 12)
+		copy(iana, option.Data[:4]) // IAID
+		if lease == nil {
+			iana = append(iana, encodeOption(statusOption(dhcp6StatusNoAddrs, "no addresses available"))...)
+		} else {
+			binary.BigEndian.PutUint32(iana[4:], uint32(preferredLifetime/2/time.Second))
+			binary.BigEndian.PutUint32(iana[8:], uint32(preferredLifetime*4/5/time.Second))
+			address := make([]byte, 24)
+			copy(address, lease.To16())
+			binary.BigEndian.PutUint32(address[16:], uint32(preferredLifetime/time.Second))
+			binary.BigEndian.PutUint32(address[20:], uint32(validLifetime/time.Second))
+			iana = append(iana, encodeOption(layers.NewDHCPv6Option(layers.DHCPv6OptIAAddr, address))...)
+		}
+		options = append(options, layers.NewDHCPv6Option(layers.DHCPv6OptIANA, iana))
+	}
+	return options
+}
+
+func statusOption(code uint16, message string) layers.DHCPv6Option {
+	data := binary.BigEndian.AppendUint16(nil, code)
+	return layers.NewDHCPv6Option(layers.DHCPv6OptStatusCode, append(data, message...))
+}
+
+// encodeOption encodes an option nested in another one.
+func encodeOption(option layers.DHCPv6Option) []byte {
+	data := binary.BigEndian.AppendUint16(nil, uint16(option.Code))
+	data = binary.BigEndian.AppendUint16(data, option.Length)
+	return append(data, option.Data...)
+}
+
+func findOption(options layers.DHCPv6Options, code layers.DHCPv6Opt) *layers.DHCPv6Option {
+	for i := range options {
+		if options[i].Code == code {
+			return &options[i]
+		}
+	}
+	return nil
+}
+
+func serialize(layersToSerialize ...gopacket.SerializableLayer) ([]byte, error) {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, layersToSerialize...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
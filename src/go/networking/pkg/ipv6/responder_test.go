@@ -0,0 +1,195 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ipv6_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/config"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/ipv6"
+)
+
+var (
+	tapMAC, _     = net.ParseMAC(config.TapDeviceMacAddr)
+	gatewayMAC, _ = net.ParseMAC(config.GatewayMacAddr)
+	tapLinkLocal  = net.ParseIP("fe80::5894:efff:fee4:cee")
+	routerIP      = net.ParseIP("fe80::5894:efff:fee4:cdd")
+)
+
+func newResponder(t *testing.T) *ipv6.Responder {
+	t.Helper()
+	subnet, err := config.ValidateDualStackSubnet(config.DefaultSubnet, "fd7a:6b8e:10ad::/64")
+	require.NoError(t, err)
+	responder, err := ipv6.NewResponder(subnet)
+	require.NoError(t, err)
+	return responder
+}
+
+func serialize(t *testing.T, srcMAC net.HardwareAddr, srcIP, dstIP net.IP, payload ...gopacket.SerializableLayer) []byte {
+	t.Helper()
+	eth := &layers.Ethernet{SrcMAC: srcMAC, DstMAC: net.HardwareAddr{0x33, 0x33, 0, 0, 0, 1}, EthernetType: layers.EthernetTypeIPv6}
+	ip6 := &layers.IPv6{Version: 6, HopLimit: 255, SrcIP: srcIP, DstIP: dstIP}
+	switch layer := payload[0].(type) {
+	case *layers.ICMPv6:
+		ip6.NextHeader = layers.IPProtocolICMPv6
+		require.NoError(t, layer.SetNetworkLayerForChecksum(ip6))
+	case *layers.UDP:
+		ip6.NextHeader = layers.IPProtocolUDP
+		require.NoError(t, layer.SetNetworkLayerForChecksum(ip6))
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, append([]gopacket.SerializableLayer{eth, ip6}, payload...)...))
+	return buf.Bytes()
+}
+
+func TestRouterAdvertisement(t *testing.T) {
+	responder := newResponder(t)
+	solicitation := serialize(t, tapMAC, tapLinkLocal, net.ParseIP("ff02::2"),
+		&layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeRouterSolicitation, 0)},
+		&layers.ICMPv6RouterSolicitation{})
+
+	reply, handled := responder.Reply(solicitation)
+	require.True(t, handled)
+	packet := gopacket.NewPacket(reply, layers.LayerTypeEthernet, gopacket.Default)
+	require.Nil(t, packet.ErrorLayer())
+	require.Equal(t, gatewayMAC, packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet).SrcMAC)
+	ip6 := packet.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+	require.Equal(t, routerIP, ip6.SrcIP)
+	require.Equal(t, net.ParseIP("ff02::1"), ip6.DstIP)
+	ra := packet.Layer(layers.LayerTypeICMPv6RouterAdvertisement).(*layers.ICMPv6RouterAdvertisement)
+	require.True(t, ra.ManagedAddressConfig())
+	require.Zero(t, ra.RouterLifetime)
+
+	var prefix []byte
+	for _, option := range ra.Options {
+		if option.Type == layers.ICMPv6OptPrefixInfo {
+			prefix = option.Data
+		}
+	}
+	require.Len(t, prefix, 30)
+	require.Equal(t, byte(64), prefix[0])
+	require.Equal(t, net.ParseIP("fd7a:6b8e:10ad::"), net.IP(prefix[14:]))
+}
+
+func TestNeighborAdvertisement(t *testing.T) {
+	responder := newResponder(t)
+	for _, target := range []string{"fd7a:6b8e:10ad::1", routerIP.String()} {
+		solicitation := serialize(t, tapMAC, tapLinkLocal, net.ParseIP("ff02::1:ff00:1"),
+			&layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborSolicitation, 0)},
+			&layers.ICMPv6NeighborSolicitation{TargetAddress: net.ParseIP(target)})
+
+		reply, handled := responder.Reply(solicitation)
+		require.True(t, handled)
+		packet := gopacket.NewPacket(reply, layers.LayerTypeEthernet, gopacket.Default)
+		require.Equal(t, tapMAC, packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet).DstMAC)
+		require.Equal(t, tapLinkLocal, packet.Layer(layers.LayerTypeIPv6).(*layers.IPv6).DstIP)
+		na := packet.Layer(layers.LayerTypeICMPv6NeighborAdvertisement).(*layers.ICMPv6NeighborAdvertisement)
+		require.Equal(t, net.ParseIP(target), na.TargetAddress)
+		require.True(t, na.Solicited())
+		require.Equal(t, []byte(gatewayMAC), na.Options[0].Data)
+	}
+
+	// The solicitations for the other addresses go on to the host.
+	solicitation := serialize(t, tapMAC, tapLinkLocal, net.ParseIP("ff02::1:ff00:5"),
+		&layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborSolicitation, 0)},
+		&layers.ICMPv6NeighborSolicitation{TargetAddress: net.ParseIP("fd7a:6b8e:10ad::5")})
+	_, handled := responder.Reply(solicitation)
+	require.False(t, handled)
+}
+
+func dhcp6Request(t *testing.T, srcMAC net.HardwareAddr, msgType layers.DHCPv6MsgType, options ...layers.DHCPv6Option) []byte {
+	t.Helper()
+	clientID := (&layers.DHCPv6DUID{Type: layers.DHCPv6DUIDTypeLL, HardwareType: []byte{0, 1}, LinkLayerAddress: srcMAC}).Encode()
+	return serialize(t, srcMAC, tapLinkLocal, net.ParseIP("ff02::1:2"),
+		&layers.UDP{SrcPort: 546, DstPort: 547},
+		&layers.DHCPv6{
+			MsgType:       msgType,
+			TransactionID: []byte{1, 2, 3},
+			Options:       append(options, layers.NewDHCPv6Option(layers.DHCPv6OptClientID, clientID)),
+		})
+}
+
+func dhcp6Reply(t *testing.T, responder *ipv6.Responder, request []byte) *layers.DHCPv6 {
+	t.Helper()
+	reply, handled := responder.Reply(request)
+	require.True(t, handled)
+	require.NotNil(t, reply)
+	packet := gopacket.NewPacket(reply, layers.LayerTypeEthernet, gopacket.Default)
+	udp := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	require.Equal(t, layers.UDPPort(546), udp.DstPort)
+	var dhcp layers.DHCPv6
+	require.NoError(t, dhcp.DecodeFromBytes(udp.Payload, gopacket.NilDecodeFeedback))
+	require.Equal(t, []byte{1, 2, 3}, dhcp.TransactionID)
+	return &dhcp
+}
+
+func findOption(t *testing.T, options layers.DHCPv6Options, code layers.DHCPv6Opt) []byte {
+	t.Helper()
+	for _, option := range options {
+		if option.Code == code {
+			return option.Data
+		}
+	}
+	require.Failf(t, "missing option", "no %s option in %s", code, options)
+	return nil
+}
+
+func TestDHCPv6(t *testing.T) {
+	responder := newResponder(t)
+	iana := layers.NewDHCPv6Option(layers.DHCPv6OptIANA, []byte{0, 0, 0, 7, 0, 0, 0, 0, 0, 0, 0, 0})
+
+	advertise := dhcp6Reply(t, responder, dhcp6Request(t, tapMAC, layers.DHCPv6MsgTypeSolicit, iana))
+	require.Equal(t, layers.DHCPv6MsgTypeAdverstise, advertise.MsgType)
+	findOption(t, advertise.Options, layers.DHCPv6OptServerID)
+	findOption(t, advertise.Options, layers.DHCPv6OptClientID)
+
+	reply := dhcp6Reply(t, responder, dhcp6Request(t, tapMAC, layers.DHCPv6MsgTypeRequest, iana))
+	require.Equal(t, layers.DHCPv6MsgTypeReply, reply.MsgType)
+	data := findOption(t, reply.Options, layers.DHCPv6OptIANA)
+	require.Equal(t, []byte{0, 0, 0, 7}, data[:4])
+	// The IA address option follows the IAID, T1 and T2.
+	require.Equal(t, uint16(layers.DHCPv6OptIAAddr), binary.BigEndian.Uint16(data[12:]))
+	require.Equal(t, net.ParseIP("fd7a:6b8e:10ad::2"), net.IP(data[16:32]))
+
+	// A client without a lease gets none.
+	otherMAC := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	reply = dhcp6Reply(t, responder, dhcp6Request(t, otherMAC, layers.DHCPv6MsgTypeRequest, iana))
+	data = findOption(t, reply.Options, layers.DHCPv6OptIANA)
+	require.Equal(t, uint16(layers.DHCPv6OptStatusCode), binary.BigEndian.Uint16(data[12:]))
+	require.Equal(t, uint16(2), binary.BigEndian.Uint16(data[16:])) // NoAddrsAvail
+
+	rapidCommit := layers.NewDHCPv6Option(layers.DHCPv6OptRapidCommit, nil)
+	reply = dhcp6Reply(t, responder, dhcp6Request(t, tapMAC, layers.DHCPv6MsgTypeSolicit, iana, rapidCommit))
+	require.Equal(t, layers.DHCPv6MsgTypeReply, reply.MsgType)
+}
+
+func TestReplyIgnoresOtherFrames(t *testing.T) {
+	responder := newResponder(t)
+	frame := serialize(t, tapMAC, tapLinkLocal, net.ParseIP("fd7a:6b8e:10ad::5"),
+		&layers.UDP{SrcPort: 5353, DstPort: 53}, gopacket.Payload("query"))
+	_, handled := responder.Reply(frame)
+	require.False(t, handled)
+
+	var nilResponder *ipv6.Responder
+	_, handled = nilResponder.Reply(frame)
+	require.False(t, handled)
+}
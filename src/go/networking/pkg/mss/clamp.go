@@ -0,0 +1,133 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mss keeps the TCP connections crossing the vm-switch within the
+// MTU of the virtual network, by clamping the maximum segment size their
+// SYN segments announce. The connections then never depend on path MTU
+// discovery, which silently fails when the ICMP messages it relies on are
+// filtered, e.g. by a corporate VPN.
+package mss
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	ethernetHeaderLength = 14
+	ethernetTypeIPv4     = 0x0800
+	ethernetTypeIPv6     = 0x86dd
+	ipv4MinHeaderLength  = 20
+	ipv6HeaderLength     = 40
+	protocolTCP          = 6
+	tcpMinHeaderLength   = 20
+	tcpFlagSYN           = 0x02
+	tcpOptionEnd         = 0
+	tcpOptionNop         = 1
+	tcpOptionMSS         = 2
+	tcpOptionMSSLength   = 4
+)
+
+// Clamp lowers, in place, the maximum segment size option of a TCP SYN
+// segment in the Ethernet frame to what fits in mtu, and updates the TCP
+// checksum. It reports whether the frame was changed; the other frames
+// are left as they are.
+func Clamp(frame []byte, mtu int) bool {
+	if len(frame) < ethernetHeaderLength {
+		return false
+	}
+	packet := frame[ethernetHeaderLength:]
+
+	var segment []byte
+	var maxSegmentSize int
+	switch binary.BigEndian.Uint16(frame[12:14]) {
+	case ethernetTypeIPv4:
+		if len(packet) < ipv4MinHeaderLength || packet[9] != protocolTCP {
+			return false
+		}
+		// Only the first fragment holds the TCP header.
+		if binary.BigEndian.Uint16(packet[6:8])&0x1fff != 0 {
+			return false
+		}
+		headerLength := int(packet[0]&0x0f) * 4
+		if headerLength < ipv4MinHeaderLength || len(packet) < headerLength {
+			return false
+		}
+		segment = packet[headerLength:]
+		maxSegmentSize = mtu - headerLength - tcpMinHeaderLength
+	case ethernetTypeIPv6:
+		// The segments behind extension headers are left as they are.
+		if len(packet) < ipv6HeaderLength || packet[6] != protocolTCP {
+			return false
+		}
+		segment = packet[ipv6HeaderLength:]
+		maxSegmentSize = mtu - ipv6HeaderLength - tcpMinHeaderLength
+	default:
+		return false
+	}
+
+	if len(segment) < tcpMinHeaderLength || segment[13]&tcpFlagSYN == 0 {
+		return false
+	}
+	dataOffset := int(segment[12]>>4) * 4
+	if dataOffset < tcpMinHeaderLength || len(segment) < dataOffset {
+		return false
+	}
+	options := segment[tcpMinHeaderLength:dataOffset]
+	// offset is the position of the MSS value in the segment.
+	offset := tcpMinHeaderLength + 2
+	for len(options) > 0 {
+		switch options[0] {
+		case tcpOptionEnd:
+			return false
+		case tcpOptionNop:
+			offset++
+			options = options[1:]
+			continue
+		}
+		if len(options) < 2 || options[1] < 2 || len(options) < int(options[1]) {
+			return false
+		}
+		if options[0] == tcpOptionMSS && options[1] == tcpOptionMSSLength {
+			mss := binary.BigEndian.Uint16(options[2:4])
+			if maxSegmentSize <= 0 || int(mss) <= maxSegmentSize {
+				return false
+			}
+			clamped := uint16(maxSegmentSize)
+			binary.BigEndian.PutUint16(options[2:4], clamped)
+			// The checksum sums 16-bit words from the start of the
+			// segment, so a value at an odd offset counts byte-swapped.
+			if offset%2 == 1 {
+				mss, clamped = bits.ReverseBytes16(mss), bits.ReverseBytes16(clamped)
+			}
+			checksum := binary.BigEndian.Uint16(segment[16:18])
+			binary.BigEndian.PutUint16(segment[16:18], updateChecksum(checksum, mss, clamped))
+			return true
+		}
+		offset += int(options[1])
+		options = options[options[1]:]
+	}
+	return false
+}
+
+// updateChecksum updates an Internet checksum for a 16-bit word changing
+// from old to updated, RFC 1624.
+func updateChecksum(checksum, old, updated uint16) uint16 {
+	sum := uint32(^checksum) + uint32(^old) + uint32(updated)
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
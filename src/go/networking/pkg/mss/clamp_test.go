@@ -0,0 +1,138 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package mss_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/mss"
+)
+
+var (
+	srcMAC = net.HardwareAddr{0x5a, 0x94, 0xef, 0xe4, 0x0c, 0xee}
+	dstMAC = net.HardwareAddr{0x5a, 0x94, 0xef, 0xe4, 0x0c, 0xdd}
+)
+
+func mssOption(value uint16) layers.TCPOption {
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, value)
+	return layers.TCPOption{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: data}
+}
+
+func tcpFrame(t *testing.T, ipv6 bool, tcp *layers.TCP) []byte {
+	t.Helper()
+	eth := &layers.Ethernet{SrcMAC: srcMAC, DstMAC: dstMAC}
+	var ip gopacket.SerializableLayer
+	if ipv6 {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolTCP,
+			SrcIP: net.ParseIP("fd7a:6b8e:10ad::2"), DstIP: net.ParseIP("fd7a:6b8e:10ad::1")}
+		require.NoError(t, tcp.SetNetworkLayerForChecksum(ip6))
+		ip = ip6
+	} else {
+		eth.EthernetType = layers.EthernetTypeIPv4
+		ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP,
+			SrcIP: net.ParseIP("192.168.127.2"), DstIP: net.ParseIP("192.168.127.1")}
+		require.NoError(t, tcp.SetNetworkLayerForChecksum(ip4))
+		ip = ip4
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, eth, ip, tcp))
+	return buf.Bytes()
+}
+
+func TestClamp(t *testing.T) {
+	tests := map[string]struct {
+		ipv6     bool
+		options  []layers.TCPOption
+		mtu      int
+		expected uint16
+	}{
+		"IPv4":         {mtu: 1400, expected: 1360},
+		"IPv6":         {ipv6: true, mtu: 1400, expected: 1340},
+		"minimum MTU":  {mtu: 1280, expected: 1240},
+		"aligned MSS":  {options: []layers.TCPOption{{OptionType: layers.TCPOptionKindSACKPermitted, OptionLength: 2}}, mtu: 1400, expected: 1360},
+		"odd position": {options: []layers.TCPOption{{OptionType: layers.TCPOptionKindNop, OptionLength: 1}}, mtu: 1400, expected: 1360},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			syn := func(value uint16) *layers.TCP {
+				return &layers.TCP{
+					SrcPort: 40000, DstPort: 443, Seq: 1, SYN: true, Window: 64240,
+					Options: append(append([]layers.TCPOption{}, tt.options...),
+						mssOption(value),
+						layers.TCPOption{OptionType: layers.TCPOptionKindWindowScale, OptionLength: 3, OptionData: []byte{7}},
+					),
+				}
+			}
+			frame := tcpFrame(t, tt.ipv6, syn(1460))
+			require.True(t, mss.Clamp(frame, tt.mtu))
+			// The clamped frame, checksum included, is the one the
+			// expected MSS would have been serialized into.
+			require.Equal(t, tcpFrame(t, tt.ipv6, syn(tt.expected)), frame)
+		})
+	}
+}
+
+func TestClampUnchanged(t *testing.T) {
+	tests := map[string]struct {
+		tcp *layers.TCP
+		mtu int
+	}{
+		"smaller MSS": {
+			tcp: &layers.TCP{SYN: true, Options: []layers.TCPOption{mssOption(1200)}},
+			mtu: 1500,
+		},
+		"not a SYN": {
+			tcp: &layers.TCP{ACK: true, Options: []layers.TCPOption{mssOption(1460)}},
+			mtu: 1400,
+		},
+		"no MSS option": {
+			tcp: &layers.TCP{SYN: true},
+			mtu: 1400,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tt.tcp.SrcPort, tt.tcp.DstPort, tt.tcp.Window = 40000, 443, 64240
+			frame := tcpFrame(t, false, tt.tcp)
+			original := append([]byte(nil), frame...)
+			require.False(t, mss.Clamp(frame, tt.mtu))
+			require.Equal(t, original, frame)
+		})
+	}
+}
+
+func TestClampNonTCP(t *testing.T) {
+	require.False(t, mss.Clamp(nil, 1400))
+	require.False(t, mss.Clamp(make([]byte, 10), 1400))
+
+	eth := &layers.Ethernet{SrcMAC: srcMAC, DstMAC: dstMAC, EthernetType: layers.EthernetTypeIPv4}
+	ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP,
+		SrcIP: net.ParseIP("192.168.127.2"), DstIP: net.ParseIP("192.168.127.1")}
+	udp := &layers.UDP{SrcPort: 40000, DstPort: 53}
+	require.NoError(t, udp.SetNetworkLayerForChecksum(ip4))
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, eth, ip4, udp, gopacket.Payload(make([]byte, 40))))
+	require.False(t, mss.Clamp(buf.Bytes(), 1400))
+}
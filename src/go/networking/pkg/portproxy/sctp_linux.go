@@ -0,0 +1,103 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package portproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenSCTP listens on addr with a one-to-one style SCTP socket. The
+// kernel presents it as a stream socket, so it is wrapped in a regular
+// net.Listener; the accepted connections carry the SCTP messages of the
+// default stream as a byte stream.
+func listenSCTP(addr string) (net.Listener, error) {
+	fd, sockaddr, err := sctpSocket(addr)
+	if err != nil {
+		return nil, err
+	}
+	file := os.NewFile(uintptr(fd), "sctp:"+addr)
+	defer file.Close()
+
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+		return nil, fmt.Errorf("setting SO_REUSEADDR on SCTP socket failed: %w", err)
+	}
+	if err := unix.Bind(fd, sockaddr); err != nil {
+		return nil, fmt.Errorf("binding SCTP socket to %s failed: %w", addr, err)
+	}
+	if err := unix.Listen(fd, unix.SOMAXCONN); err != nil {
+		return nil, fmt.Errorf("listening on SCTP socket %s failed: %w", addr, err)
+	}
+	// FileListener duplicates the descriptor.
+	return net.FileListener(file)
+}
+
+// dialSCTP connects a one-to-one style SCTP socket to addr.
+func dialSCTP(ctx context.Context, addr string) (net.Conn, error) {
+	fd, sockaddr, err := sctpSocket(addr)
+	if err != nil {
+		return nil, err
+	}
+	file := os.NewFile(uintptr(fd), "sctp:"+addr)
+	defer file.Close()
+
+	connected := make(chan error, 1)
+	go func() {
+		connected <- unix.Connect(fd, sockaddr)
+	}()
+	select {
+	case err = <-connected:
+	case <-ctx.Done():
+		// Shutting the socket down interrupts the pending connect.
+		_ = unix.Shutdown(fd, unix.SHUT_RDWR)
+		<-connected
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connecting SCTP socket to %s failed: %w", addr, err)
+	}
+	return net.FileConn(file)
+}
+
+// sctpSocket creates a blocking SCTP socket for the family of addr.
+func sctpSocket(addr string) (int, unix.Sockaddr, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	family := unix.AF_INET6
+	var sockaddr unix.Sockaddr
+	if ip4 := tcpAddr.IP.To4(); ip4 != nil {
+		family = unix.AF_INET
+		sa := &unix.SockaddrInet4{Port: tcpAddr.Port}
+		copy(sa.Addr[:], ip4)
+		sockaddr = sa
+	} else {
+		sa := &unix.SockaddrInet6{Port: tcpAddr.Port}
+		copy(sa.Addr[:], tcpAddr.IP.To16())
+		sockaddr = sa
+	}
+
+	fd, err := unix.Socket(family, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, unix.IPPROTO_SCTP)
+	if err != nil {
+		return -1, nil, fmt.Errorf("creating SCTP socket failed: %w", err)
+	}
+	return fd, sockaddr, nil
+}
@@ -0,0 +1,54 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package portproxy
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestSCTP(t *testing.T) {
+	listener, err := listenSCTP("127.0.0.1:0")
+	if errors.Is(err, unix.EPROTONOSUPPORT) {
+		t.Skip("SCTP is not supported by this kernel")
+	}
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	conn, err := dialSCTP(t.Context(), listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	expected := "this is what we expect"
+	_, err = conn.Write([]byte(expected))
+	require.NoError(t, err)
+	b := make([]byte, len(expected))
+	_, err = io.ReadFull(conn, b)
+	require.NoError(t, err)
+	require.Equal(t, expected, string(b))
+}
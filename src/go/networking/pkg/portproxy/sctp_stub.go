@@ -0,0 +1,33 @@
+//go:build !linux
+
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package portproxy
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+var errSCTPUnsupported = errors.New("SCTP is only supported on Linux")
+
+func listenSCTP(string) (net.Listener, error) {
+	return nil, errSCTPUnsupported
+}
+
+func dialSCTP(context.Context, string) (net.Conn, error) {
+	return nil, errSCTPUnsupported
+}
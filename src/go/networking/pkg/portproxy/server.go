@@ -22,18 +22,35 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"time"
 
 	gvisorTypes "github.com/containers/gvisor-tap-vsock/pkg/types"
 	"github.com/docker/go-connections/nat"
 	"github.com/sirupsen/logrus"
 
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/proxyproto"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/utils"
 )
 
+// sctp is the protocol of the published SCTP ports, which gvisor-tap-vsock
+// does not define.
+const sctp gvisorTypes.TransportProtocol = "sctp"
+
+// dialTimeout bounds connecting to the upstream address.
+const dialTimeout = 5 * time.Second
+
+// proxyHeaderTimeout bounds waiting for the PROXY protocol header of an
+// inbound connection.
+const proxyHeaderTimeout = 5 * time.Second
+
 type ProxyConfig struct {
 	UpstreamAddress string
 	UDPBufferSize   int
+	// AcceptProxyProtocol requires the inbound TCP connections to start
+	// with a PROXY protocol header, whose client address is passed on to
+	// the upstream header, if any.
+	AcceptProxyProtocol bool
 }
 
 type PortProxy struct {
@@ -42,13 +59,20 @@ type PortProxy struct {
 	listener       net.Listener
 	quit           chan struct{}
 	listenerConfig net.ListenConfig
-	// map of TCP port number as a key to associated listener
-	activeListeners map[int]net.Listener
-	listenerMutex   sync.Mutex
-	// map of UDP port number as a key to associated UDPConn
-	activeUDPConns map[int]*net.UDPConn
+	// map of TCP and SCTP listen address (protocol/host:port) as a key to
+	// associated listener
+	activeListeners map[string]net.Listener
+	// map of TCP listen address (protocol/host:port) as a key to the
+	// version of the PROXY protocol header sent upstream
+	proxyProtocols map[string]types.ProxyProtocolVersion
+	listenerMutex  sync.Mutex
+	// map of UDP listen address (host:port) as a key to associated UDPConn
+	activeUDPConns map[string]*net.UDPConn
 	udpConnMutex   sync.Mutex
-	wg             sync.WaitGroup
+	// connections of the guest agent sessions
+	sessions     map[net.Conn]struct{}
+	sessionMutex sync.Mutex
+	wg           sync.WaitGroup
 }
 
 func NewPortProxy(ctx context.Context, listener net.Listener, cfg *ProxyConfig) *PortProxy {
@@ -58,8 +82,10 @@ func NewPortProxy(ctx context.Context, listener net.Listener, cfg *ProxyConfig)
 		listener:        listener,
 		quit:            make(chan struct{}),
 		listenerConfig:  net.ListenConfig{},
-		activeListeners: make(map[int]net.Listener),
-		activeUDPConns:  make(map[int]*net.UDPConn),
+		activeListeners: make(map[string]net.Listener),
+		proxyProtocols:  make(map[string]types.ProxyProtocolVersion),
+		activeUDPConns:  make(map[string]*net.UDPConn),
+		sessions:        make(map[net.Conn]struct{}),
 	}
 	return portProxy
 }
@@ -82,7 +108,7 @@ func (p *PortProxy) Start() error {
 	}
 }
 
-func (p *PortProxy) UDPPortMappings() map[int]*net.UDPConn {
+func (p *PortProxy) UDPPortMappings() map[string]*net.UDPConn {
 	p.udpConnMutex.Lock()
 	defer p.udpConnMutex.Unlock()
 	return p.activeUDPConns
@@ -91,61 +117,112 @@ func (p *PortProxy) UDPPortMappings() map[int]*net.UDPConn {
 func (p *PortProxy) handleEvent(conn net.Conn) {
 	defer conn.Close()
 
+	decoder := json.NewDecoder(conn)
+	var first json.RawMessage
+	if err := decoder.Decode(&first); err != nil {
+		logrus.Errorf("port server decoding received payload error: %s", err)
+		return
+	}
+
+	// Requests of the session protocol have a type; a PortMapping does not.
+	var probe struct {
+		Type types.WSLProxyRequestType `json:"type"`
+	}
+	if err := json.Unmarshal(first, &probe); err == nil && probe.Type != "" {
+		p.serveSession(conn, decoder, first)
+		return
+	}
+
 	var pm types.PortMapping
-	if err := json.NewDecoder(conn).Decode(&pm); err != nil {
+	if err := json.Unmarshal(first, &pm); err != nil {
 		logrus.Errorf("port server decoding received payload error: %s", err)
 		return
 	}
 	p.exec(pm)
 }
 
-func (p *PortProxy) exec(pm types.PortMapping) {
-	for portProto, portBindings := range pm.Ports {
+// exec adds or removes the bindings of pm and returns their outcome.
+func (p *PortProxy) exec(pm types.PortMapping) []types.BindingResult {
+	var results []types.BindingResult
+	for portProto, portBindings := range pm.AllPorts() {
 		proto := strings.ToLower(portProto.Proto())
 		logrus.Debugf("received the following port: [%s] and protocol: [%s] from portMapping: %+v", portProto.Port(), proto, pm)
 
+		var errs []error
 		switch gvisorTypes.TransportProtocol(proto) {
 		case gvisorTypes.TCP:
-			p.handleTCP(portBindings, pm.Remove)
+			errs = p.handleStream(gvisorTypes.TCP, portBindings, pm.Remove, pm.ProxyProtocol)
 		case gvisorTypes.UDP:
-			p.handleUDP(portBindings, pm.Remove)
+			errs = p.handleUDP(portBindings, pm.Remove)
+		case sctp:
+			errs = p.handleStream(sctp, portBindings, pm.Remove, nil)
 		default:
 			logrus.Warnf("unsupported protocol: [%s]", proto)
+			errs = make([]error, len(portBindings))
+			for i := range errs {
+				errs[i] = fmt.Errorf("unsupported protocol: [%s]", proto)
+			}
+		}
+
+		for i, portBinding := range portBindings {
+			result := types.BindingResult{Port: portProto, Binding: portBinding}
+			if errs[i] != nil {
+				result.Error = errs[i].Error()
+			}
+			results = append(results, result)
 		}
 	}
+	return results
 }
 
-func (p *PortProxy) handleUDP(portBindings []nat.PortBinding, remove bool) {
-	for _, portBinding := range portBindings {
-		port, err := nat.ParsePort(portBinding.HostPort)
-		if err != nil {
+// handleUDP creates or closes the UDP sockets of the bindings, and returns
+// the error of each binding. Creating a socket that exists succeeds.
+func (p *PortProxy) handleUDP(portBindings []nat.PortBinding, remove bool) []error {
+	errs := make([]error, len(portBindings))
+	for i, portBinding := range portBindings {
+		if _, err := nat.ParsePort(portBinding.HostPort); err != nil {
 			logrus.Errorf("parsing port error: %s", err)
+			errs[i] = err
+			continue
+		}
+		if coveredByDualStack(portBinding, portBindings) {
+			logrus.Debugf("port binding %+v is served by the dual-stack listener", portBinding)
 			continue
 		}
+		// the localAddress IP section can be 0.0.0.0, 127.0.0.1, :: or ::1
+		localAddress := net.JoinHostPort(portBinding.HostIP, portBinding.HostPort)
 		if remove {
 			p.udpConnMutex.Lock()
-			if udpConn, exist := p.activeUDPConns[port]; exist {
+			if udpConn, exist := p.activeUDPConns[localAddress]; exist {
 				if err := udpConn.Close(); err != nil {
-					logrus.Errorf("error closing UDPConn for port [%s]: %s", portBinding.HostPort, err)
+					logrus.Errorf("error closing UDPConn for [%s]: %s", localAddress, err)
 				}
 			}
-			delete(p.activeUDPConns, port)
+			delete(p.activeUDPConns, localAddress)
 			p.udpConnMutex.Unlock()
-			logrus.Debugf("closing UDPConn for port: %d", port)
+			logrus.Debugf("closing UDPConn for: %s", localAddress)
+			continue
+		}
+
+		p.udpConnMutex.Lock()
+		_, exist := p.activeUDPConns[localAddress]
+		p.udpConnMutex.Unlock()
+		if exist {
+			logrus.Debugf("UDPConn for %s already exists", localAddress)
 			continue
 		}
 
-		// the localAddress IP section can either be 0.0.0.0 or 127.0.0.1
-		localAddress := net.JoinHostPort(portBinding.HostIP, portBinding.HostPort)
 		sourceAddr, err := net.ResolveUDPAddr("udp", localAddress)
 		if err != nil {
 			logrus.Errorf("failed to resolve UDP source address [%s]: %s", sourceAddr, err)
+			errs[i] = err
 			continue
 		}
 
 		c, err := net.ListenUDP("udp", sourceAddr)
 		if err != nil {
 			logrus.Errorf("failed creating listener for published port [%s]: %s", portBinding.HostPort, err)
+			errs[i] = err
 			continue
 		}
 
@@ -154,16 +231,18 @@ func (p *PortProxy) handleUDP(portBindings []nat.PortBinding, remove bool) {
 		if err != nil {
 			c.Close()
 			logrus.Errorf("failed to resolve UDP target address [%s]: %s", targetAddr, err)
+			errs[i] = err
 			continue
 		}
 
 		p.udpConnMutex.Lock()
-		p.activeUDPConns[port] = c
+		p.activeUDPConns[localAddress] = c
 		p.udpConnMutex.Unlock()
 		logrus.Debugf("created UDPConn for: %v", sourceAddr)
 
 		go p.acceptUDPConn(c, targetAddr)
 	}
+	return coverDualStack(portBindings, errs)
 }
 
 func (p *PortProxy) acceptUDPConn(sourceConn *net.UDPConn, targetAddr *net.UDPAddr) {
@@ -200,41 +279,134 @@ func (p *PortProxy) acceptUDPConn(sourceConn *net.UDPConn, targetAddr *net.UDPAd
 	}
 }
 
-func (p *PortProxy) handleTCP(portBindings []nat.PortBinding, remove bool) {
-	for _, portBinding := range portBindings {
-		port, err := nat.ParsePort(portBinding.HostPort)
-		if err != nil {
+// handleStream creates or closes the listeners of the bindings of a
+// connection oriented protocol, TCP or SCTP, and returns the error of each
+// binding. Creating a listener that exists succeeds, and updates the
+// version of the PROXY protocol header it sends upstream, taken from
+// proxyProtocols by host port.
+func (p *PortProxy) handleStream(
+	proto gvisorTypes.TransportProtocol,
+	portBindings []nat.PortBinding,
+	remove bool,
+	proxyProtocols map[string]types.ProxyProtocolVersion,
+) []error {
+	errs := make([]error, len(portBindings))
+	for i, portBinding := range portBindings {
+		if _, err := nat.ParsePort(portBinding.HostPort); err != nil {
 			logrus.Errorf("parsing port error: %s", err)
+			errs[i] = err
 			continue
 		}
+		if coveredByDualStack(portBinding, portBindings) {
+			logrus.Debugf("port binding %+v is served by the dual-stack listener", portBinding)
+			continue
+		}
+		addr := net.JoinHostPort(portBinding.HostIP, portBinding.HostPort)
+		key := string(proto) + "/" + addr
 		if remove {
 			p.listenerMutex.Lock()
-			if listener, exist := p.activeListeners[port]; exist {
-				logrus.Debugf("closing listener for port: %d", port)
+			if listener, exist := p.activeListeners[key]; exist {
+				logrus.Debugf("closing listener for: %s", key)
 				if err := listener.Close(); err != nil {
-					logrus.Errorf("error closing listener for port [%s]: %s", portBinding.HostPort, err)
+					logrus.Errorf("error closing listener for [%s]: %s", key, err)
 				}
 			}
-			delete(p.activeListeners, port)
+			delete(p.activeListeners, key)
+			delete(p.proxyProtocols, key)
 			p.listenerMutex.Unlock()
 			continue
 		}
-		addr := net.JoinHostPort(portBinding.HostIP, portBinding.HostPort)
-		l, err := p.listenerConfig.Listen(p.ctx, "tcp", addr)
+
+		proxyProtocol := proxyProtocols[portBinding.HostPort]
+		switch proxyProtocol {
+		case "", types.ProxyProtocolV1, types.ProxyProtocolV2:
+		default:
+			logrus.Errorf("unsupported PROXY protocol version for published port [%s]: %s", portBinding.HostPort, proxyProtocol)
+			errs[i] = fmt.Errorf("unsupported PROXY protocol version: %q", proxyProtocol)
+			continue
+		}
+
+		p.listenerMutex.Lock()
+		if proxyProtocol != "" {
+			p.proxyProtocols[key] = proxyProtocol
+		} else {
+			delete(p.proxyProtocols, key)
+		}
+		_, exist := p.activeListeners[key]
+		p.listenerMutex.Unlock()
+		if exist {
+			logrus.Debugf("listener for %s already exists", key)
+			continue
+		}
+
+		var l net.Listener
+		var err error
+		var dial utils.DialFunc
+		if proto == sctp {
+			l, err = listenSCTP(addr)
+			dial = dialSCTP
+		} else {
+			l, err = p.listenerConfig.Listen(p.ctx, "tcp", addr)
+			dialer := net.Dialer{}
+			dial = func(ctx context.Context, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "tcp", addr)
+			}
+		}
 		if err != nil {
-			logrus.Errorf("failed creating listener for published port [%s]: %s", portBinding.HostPort, err)
+			logrus.Errorf("failed creating %s listener for published port [%s]: %s", proto, portBinding.HostPort, err)
+			errs[i] = err
 			continue
 		}
 		p.listenerMutex.Lock()
-		p.activeListeners[port] = l
+		p.activeListeners[key] = l
 		p.listenerMutex.Unlock()
-		logrus.Debugf("created listener for: %s", addr)
-		go p.acceptTraffic(l, portBinding.HostPort)
+		logrus.Debugf("created listener for: %s", key)
+		go p.acceptTraffic(l, key, portBinding.HostPort, dial)
+	}
+	return coverDualStack(portBindings, errs)
+}
+
+// coveredByDualStack reports whether portBinding is an IPv4 wildcard binding
+// with an IPv6 wildcard binding for the same port. Listening on [::] accepts
+// IPv4 connections too, so a second 0.0.0.0 listener would only collide.
+func coveredByDualStack(portBinding nat.PortBinding, portBindings []nat.PortBinding) bool {
+	ip := net.ParseIP(portBinding.HostIP)
+	if ip == nil || !ip.Equal(net.IPv4zero) {
+		return false
+	}
+	for _, candidate := range portBindings {
+		if candidate.HostPort != portBinding.HostPort {
+			continue
+		}
+		if candidateIP := net.ParseIP(candidate.HostIP); candidateIP != nil && candidateIP.Equal(net.IPv6unspecified) {
+			return true
+		}
+	}
+	return false
+}
+
+// coverDualStack gives the IPv4 wildcard bindings served by the dual-stack
+// listener the outcome of that listener.
+func coverDualStack(portBindings []nat.PortBinding, errs []error) []error {
+	for i, portBinding := range portBindings {
+		if !coveredByDualStack(portBinding, portBindings) {
+			continue
+		}
+		for j, candidate := range portBindings {
+			if candidate.HostPort == portBinding.HostPort && net.ParseIP(candidate.HostIP).Equal(net.IPv6unspecified) {
+				errs[i] = errs[j]
+				break
+			}
+		}
 	}
+	return errs
 }
 
-func (p *PortProxy) acceptTraffic(listener net.Listener, port string) {
+// acceptTraffic pipes the connections accepted by the listener for key to
+// the upstream port.
+func (p *PortProxy) acceptTraffic(listener net.Listener, key, port string, dial utils.DialFunc) {
 	forwardAddr := net.JoinHostPort(p.config.UpstreamAddress, port)
+	isTCP := strings.HasPrefix(key, string(gvisorTypes.TCP)+"/")
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
@@ -251,7 +423,31 @@ func (p *PortProxy) acceptTraffic(listener net.Listener, port string) {
 		go func(conn net.Conn) {
 			defer p.wg.Done()
 			defer conn.Close()
-			utils.Pipe(p.ctx, conn, forwardAddr)
+			if isTCP && p.config.AcceptProxyProtocol {
+				proxyConn, err := proxyproto.Accept(conn, proxyHeaderTimeout)
+				if err != nil {
+					logrus.Errorf("reading PROXY protocol header from %s failed: %s", conn.RemoteAddr(), err)
+					return
+				}
+				conn = proxyConn
+			}
+			p.listenerMutex.Lock()
+			proxyProtocol := p.proxyProtocols[key]
+			p.listenerMutex.Unlock()
+
+			utils.PipeDial(p.ctx, conn, forwardAddr, func(ctx context.Context, addr string) (net.Conn, error) {
+				ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+				defer cancel()
+				upstream, err := dial(ctx, addr)
+				if err != nil || proxyProtocol == "" {
+					return upstream, err
+				}
+				if err := proxyproto.WriteHeader(upstream, proxyProtocol, conn.RemoteAddr(), conn.LocalAddr()); err != nil {
+					upstream.Close()
+					return nil, fmt.Errorf("writing PROXY protocol header failed: %w", err)
+				}
+				return upstream, nil
+			})
 		}(conn)
 	}
 }
@@ -263,6 +459,9 @@ func (p *PortProxy) Close() error {
 	// Close all active UDP connections
 	p.cleanupUDPConns()
 
+	// Close the guest agent sessions
+	p.cleanupSessions()
+
 	// Close the listener first to prevent new connections.
 	err := p.listener.Close()
 	if err != nil {
@@ -15,6 +15,7 @@ limitations under the License.
 package portproxy_test
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
@@ -32,6 +33,7 @@ import (
 
 	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
 	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/portproxy"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/proxyproto"
 )
 
 func TestNewPortProxyUDP(t *testing.T) {
@@ -109,6 +111,184 @@ func TestNewPortProxyUDP(t *testing.T) {
 	portProxy.Close()
 }
 
+func TestNewPortProxyUDPDualStack(t *testing.T) {
+	if !nettest.SupportsIPv6() {
+		t.Skip("IPv6 is not supported on this host")
+	}
+
+	probe, err := net.ListenPacket("udp", "[::]:0")
+	require.NoError(t, err)
+	_, testPort, err := net.SplitHostPort(probe.LocalAddr().String())
+	require.NoError(t, err)
+	probe.Close()
+
+	localListener, err := nettest.NewLocalListener("unix")
+	require.NoError(t, err)
+	defer localListener.Close()
+
+	proxyConfig := &portproxy.ProxyConfig{
+		UpstreamAddress: "127.0.0.1",
+		UDPBufferSize:   1024,
+	}
+	portProxy := portproxy.NewPortProxy(t.Context(), localListener, proxyConfig)
+	go portProxy.Start()
+
+	port, err := nat.NewPort("udp", testPort)
+	require.NoError(t, err)
+
+	portMapping := types.PortMapping{
+		Remove: false,
+		Ports: nat.PortMap{
+			port: []nat.PortBinding{
+				{
+					HostIP:   "0.0.0.0",
+					HostPort: testPort,
+				},
+				{
+					HostIP:   "::",
+					HostPort: testPort,
+				},
+			},
+		},
+	}
+	err = marshalAndSend(t.Context(), localListener, portMapping)
+	require.NoError(t, err)
+
+	for len(portProxy.UDPPortMappings()) == 0 {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// The IPv4 wildcard is served by the dual-stack [::] socket.
+	udpConns := portProxy.UDPPortMappings()
+	require.Len(t, udpConns, 1)
+	require.Contains(t, udpConns, net.JoinHostPort("::", testPort))
+
+	portProxy.Close()
+}
+
+func TestNewPortProxyUDPRange(t *testing.T) {
+	testPort := consecutiveUDPPorts(t, "127.0.0.1", 3)
+
+	localListener, err := nettest.NewLocalListener("unix")
+	require.NoError(t, err)
+	defer localListener.Close()
+
+	proxyConfig := &portproxy.ProxyConfig{
+		UpstreamAddress: "127.0.0.1",
+		UDPBufferSize:   1024,
+	}
+	portProxy := portproxy.NewPortProxy(t.Context(), localListener, proxyConfig)
+	go portProxy.Start()
+
+	portRange := types.PortRange{
+		Protocol: "udp",
+		HostIP:   "127.0.0.1",
+		Port:     testPort,
+		HostPort: testPort,
+		Count:    3,
+	}
+	err = marshalAndSend(t.Context(), localListener, types.PortMapping{Ranges: []types.PortRange{portRange}})
+	require.NoError(t, err)
+
+	for len(portproxy.ActiveUDPAddrs(portProxy)) < 3 {
+		time.Sleep(100 * time.Millisecond)
+	}
+	for i := range 3 {
+		require.Contains(t, portproxy.ActiveUDPAddrs(portProxy), net.JoinHostPort("127.0.0.1", fmt.Sprint(int(testPort)+i)))
+	}
+
+	err = marshalAndSend(t.Context(), localListener, types.PortMapping{Remove: true, Ranges: []types.PortRange{portRange}})
+	require.NoError(t, err)
+
+	for len(portproxy.ActiveUDPAddrs(portProxy)) != 0 {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	portProxy.Close()
+}
+
+func TestSession(t *testing.T) {
+	localListener, err := nettest.NewLocalListener("unix")
+	require.NoError(t, err)
+	defer localListener.Close()
+
+	proxyConfig := &portproxy.ProxyConfig{
+		UpstreamAddress: "127.0.0.1",
+		UDPBufferSize:   1024,
+	}
+	portProxy := portproxy.NewPortProxy(t.Context(), localListener, proxyConfig)
+	go portProxy.Start()
+	defer portProxy.Close()
+
+	conn, err := net.Dial(localListener.Addr().Network(), localListener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	encoder := json.NewEncoder(conn)
+	decoder := json.NewDecoder(conn)
+	roundTrip := func(req types.WSLProxyRequest) types.WSLProxyResponse {
+		require.NoError(t, encoder.Encode(req))
+		var resp types.WSLProxyResponse
+		require.NoError(t, decoder.Decode(&resp))
+		require.Equal(t, req.ID, resp.ID)
+		return resp
+	}
+	udpPortMapping := func(ports ...string) *types.PortMapping {
+		portMap := make(nat.PortMap)
+		for _, port := range ports {
+			portMap[nat.Port(port+"/udp")] = []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: port}}
+		}
+		return &types.PortMapping{Ports: portMap}
+	}
+	freePort := func() (string, net.PacketConn) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		_, port, err := net.SplitHostPort(conn.LocalAddr().String())
+		require.NoError(t, err)
+		return port, conn
+	}
+
+	// A newer guest agent is downgraded to the version of wsl-proxy.
+	resp := roundTrip(types.WSLProxyRequest{Type: types.WSLProxyHello, ID: 1, Version: 99})
+	require.Empty(t, resp.Error)
+	require.Equal(t, types.WSLProxyProtocolVersion, resp.Version)
+
+	port1, probe := freePort()
+	probe.Close()
+	taken, takenConn := freePort()
+	defer takenConn.Close()
+
+	resp = roundTrip(types.WSLProxyRequest{Type: types.WSLProxyUpdate, ID: 2, PortMapping: udpPortMapping(port1, taken)})
+	require.Len(t, resp.Results, 2)
+	for _, result := range resp.Results {
+		if result.Binding.HostPort == taken {
+			require.Contains(t, result.Error, "address already in use")
+		} else {
+			require.Empty(t, result.Error)
+		}
+	}
+	require.Contains(t, portProxy.UDPPortMappings(), net.JoinHostPort("127.0.0.1", port1))
+
+	// Adding a binding that exists succeeds.
+	resp = roundTrip(types.WSLProxyRequest{Type: types.WSLProxyUpdate, ID: 3, PortMapping: udpPortMapping(port1)})
+	require.Equal(t, []types.BindingResult{{
+		Port:    nat.Port(port1 + "/udp"),
+		Binding: nat.PortBinding{HostIP: "127.0.0.1", HostPort: port1},
+	}}, resp.Results)
+
+	// Resync drops the bindings that are not part of the state.
+	port2, probe := freePort()
+	probe.Close()
+	resp = roundTrip(types.WSLProxyRequest{Type: types.WSLProxyResync, ID: 4, PortMapping: udpPortMapping(port2)})
+	require.Len(t, resp.Results, 1)
+	require.Empty(t, resp.Results[0].Error)
+	udpConns := portProxy.UDPPortMappings()
+	require.Len(t, udpConns, 1)
+	require.Contains(t, udpConns, net.JoinHostPort("127.0.0.1", port2))
+
+	resp = roundTrip(types.WSLProxyRequest{Type: "unknown", ID: 5})
+	require.Contains(t, resp.Error, "unsupported request type")
+}
+
 func TestNewPortProxyTCP(t *testing.T) {
 	expectedResponse := "called the upstream server"
 
@@ -232,6 +412,35 @@ func marshalAndSend(ctx context.Context, listener net.Listener, portMapping type
 	return c.Close()
 }
 
+// consecutiveUDPPorts returns the first of count consecutive UDP ports
+// that are free on ip.
+func consecutiveUDPPorts(t *testing.T, ip string, count int) uint16 {
+	t.Helper()
+	for range 100 {
+		probe, err := net.ListenPacket("udp", net.JoinHostPort(ip, "0"))
+		require.NoError(t, err)
+		first := probe.LocalAddr().(*net.UDPAddr).Port
+		probe.Close()
+		if first+count > 65535 {
+			continue
+		}
+		free := true
+		for port := first; port < first+count; port++ {
+			conn, err := net.ListenPacket("udp", net.JoinHostPort(ip, fmt.Sprint(port)))
+			if err != nil {
+				free = false
+				break
+			}
+			conn.Close()
+		}
+		if free {
+			return uint16(first)
+		}
+	}
+	t.Fatalf("no %d consecutive free UDP ports on %s", count, ip)
+	return 0
+}
+
 func availableIP() (string, error) {
 	ifaces, err := net.Interfaces()
 	if err != nil {
@@ -268,3 +477,98 @@ func availableIP() (string, error) {
 	}
 	return "", errors.New("are you connected to the network?")
 }
+
+func TestProxyProtocol(t *testing.T) {
+	// The upstream server replies with the client address of the header
+	// it receives.
+	upstream, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("cannot listen on 127.0.0.2: %s", err)
+	}
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				source, _, err := proxyproto.ReadHeader(bufio.NewReader(conn))
+				if err != nil {
+					fmt.Fprintf(conn, "error: %s", err)
+					return
+				}
+				fmt.Fprint(conn, source.IP)
+			}()
+		}
+	}()
+	_, upstreamPort, err := net.SplitHostPort(upstream.Addr().String())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		accept  bool
+		version types.ProxyProtocolVersion
+		header  string
+		want    string
+	}{
+		{name: "v1", version: types.ProxyProtocolV1, want: "127.0.0.1"},
+		{name: "v2", version: types.ProxyProtocolV2, want: "127.0.0.1"},
+		{
+			name:    "accepted header",
+			accept:  true,
+			version: types.ProxyProtocolV2,
+			header:  "PROXY TCP4 203.0.113.7 127.0.0.1 4242 " + upstreamPort + "\r\n",
+			want:    "203.0.113.7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			localListener, err := nettest.NewLocalListener("unix")
+			require.NoError(t, err)
+			defer localListener.Close()
+
+			proxyConfig := &portproxy.ProxyConfig{
+				UpstreamAddress:     "127.0.0.2",
+				AcceptProxyProtocol: tt.accept,
+			}
+			portProxy := portproxy.NewPortProxy(t.Context(), localListener, proxyConfig)
+			go portProxy.Start()
+			defer portProxy.Close()
+
+			conn, err := net.Dial(localListener.Addr().Network(), localListener.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+			encoder := json.NewEncoder(conn)
+			decoder := json.NewDecoder(conn)
+			for i, req := range []types.WSLProxyRequest{
+				{Type: types.WSLProxyHello, Version: types.WSLProxyProtocolVersion},
+				{Type: types.WSLProxyUpdate, PortMapping: &types.PortMapping{
+					Ports: nat.PortMap{
+						nat.Port("80/tcp"): []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: upstreamPort}},
+					},
+					ProxyProtocol: map[string]types.ProxyProtocolVersion{upstreamPort: tt.version},
+				}},
+			} {
+				req.ID = uint64(i + 1)
+				require.NoError(t, encoder.Encode(req))
+				var resp types.WSLProxyResponse
+				require.NoError(t, decoder.Decode(&resp))
+				require.Empty(t, resp.Error)
+				for _, result := range resp.Results {
+					require.Empty(t, result.Error)
+				}
+			}
+
+			client, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", upstreamPort))
+			require.NoError(t, err)
+			defer client.Close()
+			_, err = client.Write([]byte(tt.header))
+			require.NoError(t, err)
+			reply, err := io.ReadAll(client)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, string(reply))
+		})
+	}
+}
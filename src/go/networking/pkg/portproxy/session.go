@@ -0,0 +1,161 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package portproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	gvisorTypes "github.com/containers/gvisor-tap-vsock/pkg/types"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
+)
+
+// serveSession answers the requests of a guest agent session, whose first
+// request has already been read, until the connection is closed. The
+// bindings are kept when the session ends, until the guest agent
+// reconnects and resyncs them.
+func (p *PortProxy) serveSession(conn net.Conn, decoder *json.Decoder, first json.RawMessage) {
+	p.sessionMutex.Lock()
+	p.sessions[conn] = struct{}{}
+	p.sessionMutex.Unlock()
+	defer func() {
+		p.sessionMutex.Lock()
+		delete(p.sessions, conn)
+		p.sessionMutex.Unlock()
+	}()
+
+	encoder := json.NewEncoder(conn)
+
+	var hello types.WSLProxyRequest
+	if err := json.Unmarshal(first, &hello); err != nil || hello.Type != types.WSLProxyHello {
+		logrus.Errorf("guest agent session did not start with a hello request: %s", first)
+		_ = encoder.Encode(types.WSLProxyResponse{ID: hello.ID, Error: "a session must start with a hello request"})
+		return
+	}
+	version := min(hello.Version, types.WSLProxyProtocolVersion)
+	if version < 1 {
+		logrus.Errorf("guest agent requested unsupported protocol version %d", hello.Version)
+		_ = encoder.Encode(types.WSLProxyResponse{
+			ID:    hello.ID,
+			Error: fmt.Sprintf("unsupported protocol version %d", hello.Version),
+		})
+		return
+	}
+	if err := encoder.Encode(types.WSLProxyResponse{ID: hello.ID, Version: version}); err != nil {
+		logrus.Errorf("replying to guest agent hello failed: %s", err)
+		return
+	}
+	logrus.Infof("guest agent session started with protocol version %d", version)
+
+	for {
+		var req types.WSLProxyRequest
+		if err := decoder.Decode(&req); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				logrus.Debug("guest agent session closed")
+			} else {
+				logrus.Errorf("decoding guest agent request failed: %s", err)
+			}
+			return
+		}
+		if err := encoder.Encode(p.handleRequest(req)); err != nil {
+			logrus.Errorf("replying to guest agent request failed: %s", err)
+			return
+		}
+	}
+}
+
+func (p *PortProxy) handleRequest(req types.WSLProxyRequest) types.WSLProxyResponse {
+	resp := types.WSLProxyResponse{ID: req.ID}
+	switch req.Type {
+	case types.WSLProxyUpdate:
+		if req.PortMapping == nil {
+			resp.Error = "update request without a port mapping"
+			break
+		}
+		resp.Results = p.exec(*req.PortMapping)
+	case types.WSLProxyResync:
+		if req.PortMapping == nil {
+			resp.Error = "resync request without a port mapping"
+			break
+		}
+		resp.Results = p.resync(*req.PortMapping)
+	case types.WSLProxyPing:
+	default:
+		resp.Error = fmt.Sprintf("unsupported request type: %s", req.Type)
+	}
+	return resp
+}
+
+// resync closes the listeners and the UDP sockets that are not bindings of
+// pm, and creates the missing ones.
+func (p *PortProxy) resync(pm types.PortMapping) []types.BindingResult {
+	pm.Remove = false
+	streams := make(map[string]bool)
+	datagrams := make(map[string]bool)
+	for portProto, portBindings := range pm.AllPorts() {
+		proto := strings.ToLower(portProto.Proto())
+		for _, portBinding := range portBindings {
+			addr := net.JoinHostPort(portBinding.HostIP, portBinding.HostPort)
+			if gvisorTypes.TransportProtocol(proto) == gvisorTypes.UDP {
+				datagrams[addr] = true
+			} else {
+				streams[proto+"/"+addr] = true
+			}
+		}
+	}
+
+	p.listenerMutex.Lock()
+	for key, listener := range p.activeListeners {
+		if streams[key] {
+			continue
+		}
+		logrus.Debugf("resync: closing listener for: %s", key)
+		if err := listener.Close(); err != nil {
+			logrus.Errorf("error closing listener for [%s]: %s", key, err)
+		}
+		delete(p.activeListeners, key)
+		delete(p.proxyProtocols, key)
+	}
+	p.listenerMutex.Unlock()
+
+	p.udpConnMutex.Lock()
+	for addr, udpConn := range p.activeUDPConns {
+		if datagrams[addr] {
+			continue
+		}
+		logrus.Debugf("resync: closing UDPConn for: %s", addr)
+		if err := udpConn.Close(); err != nil {
+			logrus.Errorf("error closing UDPConn for [%s]: %s", addr, err)
+		}
+		delete(p.activeUDPConns, addr)
+	}
+	p.udpConnMutex.Unlock()
+
+	return p.exec(pm)
+}
+
+func (p *PortProxy) cleanupSessions() {
+	p.sessionMutex.Lock()
+	defer p.sessionMutex.Unlock()
+	for conn := range p.sessions {
+		_ = conn.Close()
+	}
+}
@@ -0,0 +1,296 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package proxyproto reads and writes the headers of the PROXY protocol,
+// versions 1 and 2, which carry the addresses of the client of a proxied
+// TCP connection to the upstream server.
+// See https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
+)
+
+var (
+	ErrInvalidHeader = errors.New("invalid PROXY protocol header")
+	ErrNoHeader      = errors.New("no PROXY protocol header")
+)
+
+const (
+	// maxV1Length is the length of the longest version 1 header, CRLF
+	// included.
+	maxV1Length = 107
+	// v2HeaderLength is the length of the fixed part of a version 2
+	// header, before the addresses.
+	v2HeaderLength = 16
+
+	v2VersionCommandLocal = 0x20
+	v2VersionCommandProxy = 0x21
+	v2FamilyUnspec        = 0x00
+	v2FamilyTCP4          = 0x11
+	v2FamilyTCP6          = 0x21
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// WriteHeader writes the header of the given version for a connection from
+// source to destination.
+func WriteHeader(w io.Writer, version types.ProxyProtocolVersion, source, destination net.Addr) error {
+	var header []byte
+	var err error
+	switch version {
+	case types.ProxyProtocolV1:
+		header, err = v1Header(source, destination)
+	case types.ProxyProtocolV2:
+		header, err = v2Header(source, destination)
+	default:
+		err = fmt.Errorf("unsupported PROXY protocol version: %q", version)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(header)
+	return err
+}
+
+// tcpAddrs returns the IP addresses of source and destination in the same
+// family, and whether it is IPv4.
+func tcpAddrs(source, destination net.Addr) (*net.TCPAddr, *net.TCPAddr, bool, error) {
+	src, ok := source.(*net.TCPAddr)
+	if !ok {
+		return nil, nil, false, fmt.Errorf("not a TCP address: %s", source)
+	}
+	dst, ok := destination.(*net.TCPAddr)
+	if !ok {
+		return nil, nil, false, fmt.Errorf("not a TCP address: %s", destination)
+	}
+	// An IPv4 client of a dual-stack listener may be seen from an IPv6
+	// address; both ends are sent as IPv6 then.
+	ipv4 := src.IP.To4() != nil && dst.IP.To4() != nil
+	return src, dst, ipv4, nil
+}
+
+func v1Header(source, destination net.Addr) ([]byte, error) {
+	src, dst, ipv4, err := tcpAddrs(source, destination)
+	if err != nil {
+		return nil, err
+	}
+	family := "TCP4"
+	srcIP, dstIP := src.IP.String(), dst.IP.String()
+	if !ipv4 {
+		// Keep IPv4-mapped addresses in the IPv6 notation.
+		family = "TCP6"
+		srcIP, dstIP = ipv6String(src.IP), ipv6String(dst.IP)
+	}
+	return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", family, srcIP, dstIP, src.Port, dst.Port), nil
+}
+
+func ipv6String(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return "::ffff:" + ip4.String()
+	}
+	return ip.String()
+}
+
+func v2Header(source, destination net.Addr) ([]byte, error) {
+	src, dst, ipv4, err := tcpAddrs(source, destination)
+	if err != nil {
+		return nil, err
+	}
+	header := bytes.NewBuffer(slices.Clone(v2Signature))
+	header.WriteByte(v2VersionCommandProxy)
+	if ipv4 {
+		header.WriteByte(v2FamilyTCP4)
+		header.Write(binary.BigEndian.AppendUint16(nil, 2*net.IPv4len+4))
+		header.Write(src.IP.To4())
+		header.Write(dst.IP.To4())
+	} else {
+		header.WriteByte(v2FamilyTCP6)
+		header.Write(binary.BigEndian.AppendUint16(nil, 2*net.IPv6len+4))
+		header.Write(src.IP.To16())
+		header.Write(dst.IP.To16())
+	}
+	header.Write(binary.BigEndian.AppendUint16(nil, uint16(src.Port)))
+	header.Write(binary.BigEndian.AppendUint16(nil, uint16(dst.Port)))
+	return header.Bytes(), nil
+}
+
+// ReadHeader reads a version 1 or 2 header from r. The addresses are nil
+// when the header does not carry them, as for the LOCAL command or the
+// UNKNOWN protocol.
+func ReadHeader(r *bufio.Reader) (source, destination *net.TCPAddr, err error) {
+	// A version 1 header is longer than the version 2 signature.
+	prefix, err := r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrNoHeader, err)
+	}
+	switch {
+	case bytes.Equal(prefix, v2Signature):
+		return readV2(r)
+	case bytes.HasPrefix(prefix, v1Prefix):
+		return readV1(r)
+	}
+	return nil, nil, ErrNoHeader
+}
+
+func readV1(r *bufio.Reader) (*net.TCPAddr, *net.TCPAddr, error) {
+	// Read byte by byte, as the client may wait for the server to speak
+	// first after the header.
+	line := make([]byte, 0, maxV1Length)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == maxV1Length {
+			return nil, nil, fmt.Errorf("%w: header too long", ErrInvalidHeader)
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("%w: %q", ErrInvalidHeader, line)
+	}
+	source, err := parseV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	destination, err := parseV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return source, destination, nil
+}
+
+func parseV1Addr(ip, port string) (*net.TCPAddr, error) {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return nil, fmt.Errorf("%w: invalid address %q", ErrInvalidHeader, ip)
+	}
+	parsedPort, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid port %q", ErrInvalidHeader, port)
+	}
+	return &net.TCPAddr{IP: parsedIP, Port: int(parsedPort)}, nil
+}
+
+func readV2(r *bufio.Reader) (*net.TCPAddr, *net.TCPAddr, error) {
+	header := make([]byte, v2HeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+	versionCommand, family := header[12], header[13]
+	addrs := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, addrs); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+
+	switch versionCommand {
+	case v2VersionCommandLocal:
+		return nil, nil, nil
+	case v2VersionCommandProxy:
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported version and command 0x%02x", ErrInvalidHeader, versionCommand)
+	}
+
+	var ipLen int
+	switch family {
+	case v2FamilyTCP4:
+		ipLen = net.IPv4len
+	case v2FamilyTCP6:
+		ipLen = net.IPv6len
+	case v2FamilyUnspec:
+		return nil, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported family 0x%02x", ErrInvalidHeader, family)
+	}
+	// The addresses may be followed by TLVs, which are ignored.
+	if len(addrs) < 2*ipLen+4 {
+		return nil, nil, fmt.Errorf("%w: addresses too short", ErrInvalidHeader)
+	}
+	source := &net.TCPAddr{
+		IP:   net.IP(slices.Clone(addrs[:ipLen])),
+		Port: int(binary.BigEndian.Uint16(addrs[2*ipLen:])),
+	}
+	destination := &net.TCPAddr{
+		IP:   net.IP(slices.Clone(addrs[ipLen : 2*ipLen])),
+		Port: int(binary.BigEndian.Uint16(addrs[2*ipLen+2:])),
+	}
+	return source, destination, nil
+}
+
+// Conn is a connection whose PROXY protocol header has been read. Its
+// RemoteAddr and LocalAddr are the addresses of the header, when it
+// carries them.
+type Conn struct {
+	net.Conn
+	reader      *bufio.Reader
+	source      *net.TCPAddr
+	destination *net.TCPAddr
+}
+
+// Accept reads the header that conn starts with, waiting for it at most
+// timeout.
+func Accept(conn net.Conn, timeout time.Duration) (*Conn, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	source, destination, err := ReadHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn, reader: reader, source: source, destination: destination}, nil
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.source != nil {
+		return c.source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	if c.destination != nil {
+		return c.destination
+	}
+	return c.Conn.LocalAddr()
+}
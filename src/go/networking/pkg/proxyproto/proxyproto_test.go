@@ -0,0 +1,126 @@
+/*
+Copyright © 2026 SUSE LLC
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package proxyproto_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher-sandbox/rancher-desktop/src/go/guestagent/pkg/types"
+	"github.com/rancher-sandbox/rancher-desktop/src/go/networking/pkg/proxyproto"
+)
+
+func TestRoundTrip(t *testing.T) {
+	tests := map[string]struct {
+		source, destination         *net.TCPAddr
+		wantSource, wantDestination *net.TCPAddr
+	}{
+		"IPv4": {
+			source:      &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 4242},
+			destination: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8080},
+		},
+		"IPv6": {
+			source:      &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 4242},
+			destination: &net.TCPAddr{IP: net.ParseIP("::1"), Port: 8080},
+		},
+		"mixed": {
+			source:          &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 4242},
+			destination:     &net.TCPAddr{IP: net.ParseIP("::1"), Port: 8080},
+			wantSource:      &net.TCPAddr{IP: net.ParseIP("::ffff:203.0.113.7"), Port: 4242},
+			wantDestination: &net.TCPAddr{IP: net.ParseIP("::1"), Port: 8080},
+		},
+	}
+	for name, tt := range tests {
+		for _, version := range []types.ProxyProtocolVersion{types.ProxyProtocolV1, types.ProxyProtocolV2} {
+			t.Run(name+" "+string(version), func(t *testing.T) {
+				var buf bytes.Buffer
+				require.NoError(t, proxyproto.WriteHeader(&buf, version, tt.source, tt.destination))
+				buf.WriteString("payload")
+
+				reader := bufio.NewReader(&buf)
+				source, destination, err := proxyproto.ReadHeader(reader)
+				require.NoError(t, err)
+				wantSource, wantDestination := tt.source, tt.destination
+				if tt.wantSource != nil {
+					wantSource, wantDestination = tt.wantSource, tt.wantDestination
+				}
+				require.True(t, wantSource.IP.Equal(source.IP), "source %s", source)
+				require.Equal(t, wantSource.Port, source.Port)
+				require.True(t, wantDestination.IP.Equal(destination.IP), "destination %s", destination)
+				require.Equal(t, wantDestination.Port, destination.Port)
+
+				rest, err := io.ReadAll(reader)
+				require.NoError(t, err)
+				require.Equal(t, "payload", string(rest))
+			})
+		}
+	}
+}
+
+func TestReadHeader(t *testing.T) {
+	// The LOCAL command and the UNKNOWN protocol carry no addresses.
+	for _, header := range []string{
+		"PROXY UNKNOWN\r\n",
+		"PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n",
+		"\r\n\r\n\x00\r\nQUIT\n\x20\x00\x00\x00",
+	} {
+		source, destination, err := proxyproto.ReadHeader(bufio.NewReader(strings.NewReader(header)))
+		require.NoError(t, err, "%q", header)
+		require.Nil(t, source)
+		require.Nil(t, destination)
+	}
+
+	for header, wantErr := range map[string]error{
+		"GET / HTTP/1.1\r\n": proxyproto.ErrNoHeader,
+		"PROXY":              proxyproto.ErrNoHeader,
+		"PROXY TCP4 203.0.113.7 127.0.0.1 4242\r\n":              proxyproto.ErrInvalidHeader,
+		"PROXY TCP4 203.0.113.7 127.0.0.1 4242 65536\r\n":        proxyproto.ErrInvalidHeader,
+		"PROXY TCP4 " + strings.Repeat("1", 100) + "\r\n":        proxyproto.ErrInvalidHeader,
+		"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x04\x01\x02\x03\x04": proxyproto.ErrInvalidHeader,
+	} {
+		_, _, err := proxyproto.ReadHeader(bufio.NewReader(strings.NewReader(header)))
+		require.ErrorIs(t, err, wantErr, "%q", header)
+	}
+}
+
+func TestAccept(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		_, _ = client.Write([]byte("PROXY TCP4 203.0.113.7 127.0.0.1 4242 8080\r\nhello"))
+	}()
+
+	conn, err := proxyproto.Accept(server, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "203.0.113.7:4242", conn.RemoteAddr().String())
+	require.Equal(t, "127.0.0.1:8080", conn.LocalAddr().String())
+	b := make([]byte, len("hello"))
+	_, err = io.ReadFull(conn, b)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+
+	// A client that does not send a header is dropped.
+	_, err = proxyproto.Accept(client, 10*time.Millisecond)
+	require.ErrorIs(t, err, proxyproto.ErrNoHeader)
+}
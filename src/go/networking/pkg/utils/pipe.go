@@ -23,11 +23,21 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// DialFunc connects to the upstream address of a Pipe.
+type DialFunc func(ctx context.Context, addr string) (net.Conn, error)
+
 func Pipe(ctx context.Context, conn net.Conn, upstreamAddr string) {
 	dialer := net.Dialer{
 		Timeout: 5 * time.Second,
 	}
-	upstream, err := dialer.DialContext(ctx, "tcp", upstreamAddr)
+	PipeDial(ctx, conn, upstreamAddr, func(ctx context.Context, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "tcp", addr)
+	})
+}
+
+// PipeDial is like Pipe, connecting to the upstream address with dial.
+func PipeDial(ctx context.Context, conn net.Conn, upstreamAddr string, dial DialFunc) {
+	upstream, err := dial(ctx, upstreamAddr)
 	if err != nil {
 		logrus.Errorf("Failed to dial upstream %s: %s", upstreamAddr, err)
 		return